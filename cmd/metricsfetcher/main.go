@@ -23,6 +23,7 @@ type Config struct {
 	AppKey      string
 	PartnQuery  string
 	BrokerQuery string
+	TotalQuery  string
 	BrokerIDTag string
 	Span        int
 	ZKAddr      string
@@ -43,6 +44,7 @@ func init() {
 	flag.StringVar(&config.APIKey, "api-key", "", "Datadog API key")
 	flag.StringVar(&config.AppKey, "app-key", "", "Datadog app key")
 	bq := flag.String("broker-storage-query", "avg:system.disk.free{service:kafka,device:/data}", "Datadog metric query to get broker storage free")
+	tq := flag.String("broker-storage-total-query", "avg:system.disk.total{service:kafka,device:/data}", "Datadog metric query to get broker storage total (an empty value disables fetching storage totals)")
 	flag.StringVar(&config.BrokerIDTag, "broker-id-tag", "broker_id", "Datadog host tag for broker ID")
 	pq := flag.String("partition-size-query", "max:kafka.log.partition.size{service:kafka} by {topic,partition}", "Datadog metric query to get partition size by topic, partition")
 	flag.IntVar(&config.Span, "span", 3600, "Query range in seconds (now - span)")
@@ -63,6 +65,9 @@ func init() {
 	// Complete query string.
 	config.BrokerQuery = fmt.Sprintf("%s by {%s}.rollup(avg, %d)", *bq, config.BrokerIDTag, config.Span)
 	config.PartnQuery = fmt.Sprintf("%s.rollup(avg, %d)", *pq, config.Span)
+	if *tq != "" {
+		config.TotalQuery = fmt.Sprintf("%s by {%s}.rollup(avg, %d)", *tq, config.BrokerIDTag, config.Span)
+	}
 }
 
 func main() {
@@ -101,6 +106,9 @@ func main() {
 	exitOnErr(err)
 
 	fmt.Printf("Submitting %s\n", config.BrokerQuery)
+	if config.TotalQuery != "" {
+		fmt.Printf("Submitting %s\n", config.TotalQuery)
+	}
 	bm, err := brokerMetrics(config)
 	exitOnErr(err)
 	fmt.Println("success")
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -54,8 +55,7 @@ func partitionMetrics(c *Config) (map[string]map[string]map[string]float64, erro
 }
 
 func brokerMetrics(c *Config) (map[string]map[string]float64, error) {
-	start := time.Now().Add(-time.Duration(c.Span*2) * time.Second).Unix()
-	o, err := c.Client.QueryMetrics(start, time.Now().Unix(), c.BrokerQuery)
+	free, err := brokerPoints(c, c.BrokerQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +63,43 @@ func brokerMetrics(c *Config) (map[string]map[string]float64, error) {
 	// Populate.
 	d := map[string]map[string]float64{}
 
+	for broker, p := range free {
+		d[broker] = map[string]float64{}
+		d[broker]["StorageFree"] = p[1]
+		// Point timestamps are in ms.
+		d[broker]["MetricsTimestamp"] = math.Floor(p[0] / 1000)
+	}
+
+	// Storage totals are optional.
+	if c.TotalQuery == "" {
+		return d, nil
+	}
+
+	total, err := brokerPoints(c, c.TotalQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	for broker, p := range total {
+		if _, exists := d[broker]; exists {
+			d[broker]["StorageTotal"] = p[1]
+		}
+	}
+
+	return d, nil
+}
+
+// brokerPoints takes a broker metric query and returns the timestamp and value
+// of the latest point for each broker ID.
+func brokerPoints(c *Config, q string) (map[string][2]float64, error) {
+	start := time.Now().Add(-time.Duration(c.Span*2) * time.Second).Unix()
+	o, err := c.Client.QueryMetrics(start, time.Now().Unix(), q)
+	if err != nil {
+		return nil, err
+	}
+
+	d := map[string][2]float64{}
+
 	for _, ts := range o {
 		broker := tagValFromScope(ts.GetScope(), c.BrokerIDTag)
 
@@ -73,16 +110,12 @@ func brokerMetrics(c *Config) (map[string]map[string]float64, error) {
 		}
 
 		// Get the latest value.
-		val, err := latestValue(ts.Points)
+		t, val, err := latestPoint(ts.Points)
 		if err != nil {
 			continue
 		}
 
-		if _, exists := d[broker]; !exists {
-			d[broker] = map[string]float64{}
-		}
-
-		d[broker]["StorageFree"] = val
+		d[broker] = [2]float64{t, val}
 	}
 
 	return d, nil
@@ -91,14 +124,21 @@ func brokerMetrics(c *Config) (map[string]map[string]float64, error) {
 // latestValue takes a []dd.DataPoint and returns the most recent (in time)
 // non-nil datapoint.
 func latestValue(points []dd.DataPoint) (float64, error) {
+	_, val, err := latestPoint(points)
+	return val, err
+}
+
+// latestPoint takes a []dd.DataPoint and returns the timestamp and value of
+// the most recent (in time) non-nil datapoint.
+func latestPoint(points []dd.DataPoint) (float64, float64, error) {
 	for i := len(points) - 1; i >= 0; i-- {
 		val := points[i][1]
-		if val != nil {
-			return *val, nil
+		if val != nil && points[i][0] != nil {
+			return *points[i][0], *val, nil
 		}
 	}
 
-	return 0, fmt.Errorf("no value found")
+	return 0, 0, fmt.Errorf("no value found")
 }
 
 // tagValFromScope takes a metric scope string and a tag and returns that tag's value.
@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+
+	"github.com/spf13/cobra"
+)
+
+var brokersCmd = &cobra.Command{
+	Use:   "brokers",
+	Short: "Print a broker utilization table",
+	Long: `brokers prints every registered broker along with any broker holding
+partitions for the --topics, with its rack ID, partition and leader counts
+for the --topics, and storage free. The table is sorted by the --sort-by
+column; counts are sorted descending, storage free ascending and IDs and
+rack IDs ascending.`,
+	Run: printBrokers,
+}
+
+func init() {
+	rootCmd.AddCommand(brokersCmd)
+
+	brokersCmd.Flags().String("topics", ".*", "Topics (comma delim. list) to count partitions for")
+	brokersCmd.Flags().String("sort-by", "id", "Column to sort by: [id, locality, partitions, leaders, storage]")
+	brokersCmd.Flags().String("zk-metrics-prefix", "topicmappr", "ZooKeeper namespace prefix for Kafka metrics")
+	brokersCmd.Flags().Int("metrics-age", 60, "Kafka metrics age tolerance (in minutes)")
+}
+
+func printBrokers(cmd *cobra.Command, _ []string) {
+	sortBy, _ := cmd.Flags().GetString("sort-by")
+
+	// ZooKeeper init.
+	zk, err := initZooKeeper(cmd)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	defer zk.Close()
+
+	// Get broker metadata and the partition map.
+	brokerMeta := getBrokerMeta(cmd, zk, true)
+
+	var ids []int
+	for id := range brokerMeta {
+		ids = append(ids, id)
+	}
+	checkMetaAge(cmd, zk, ids, brokerMeta)
+
+	t, _ := cmd.Flags().GetString("topics")
+	partitionMap, err := kafkazk.PartitionMapFromZK(topicRegex(t), zk)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	rows := brokerUtilization(partitionMap, brokerMeta)
+
+	if err := sortBrokerUtilization(rows, sortBy); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n%s", formatBrokerUtilization(rows))
+}
+
+// brokerUtil describes the utilization of a broker.
+type brokerUtil struct {
+	id          int
+	locality    string
+	partitions  int
+	leaders     int
+	storageFree float64
+	// Whether the broker holds partitions
+	// but isn't registered.
+	missing bool
+}
+
+// brokerUtilization takes a *kafkazk.PartitionMap and kafkazk.BrokerMetaMap
+// and returns a []brokerUtil, sorted by ID, for all brokers in either.
+func brokerUtilization(pm *kafkazk.PartitionMap, bmm kafkazk.BrokerMetaMap) []brokerUtil {
+	stats := pm.UseStats()
+
+	ids := map[int]struct{}{}
+	for id := range bmm {
+		ids[id] = struct{}{}
+	}
+	for id := range stats {
+		ids[id] = struct{}{}
+	}
+
+	var rows []brokerUtil
+
+	for id := range ids {
+		if id == kafkazk.StubBrokerID {
+			continue
+		}
+
+		u := brokerUtil{id: id}
+
+		if s, exists := stats[id]; exists {
+			u.partitions = s.Leader + s.Follower
+			u.leaders = s.Leader
+		}
+
+		if meta, exists := bmm[id]; exists {
+			u.locality = meta.Rack
+			u.storageFree = meta.StorageFree
+		} else {
+			u.missing = true
+		}
+
+		rows = append(rows, u)
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].id < rows[j].id })
+
+	return rows
+}
+
+// sortBrokerUtilization sorts a []brokerUtil by the specified column. Ties
+// are broken by ID.
+func sortBrokerUtilization(rows []brokerUtil, by string) error {
+	var less func(a, b brokerUtil) bool
+
+	switch by {
+	case "id":
+		less = func(a, b brokerUtil) bool { return false }
+	case "locality":
+		less = func(a, b brokerUtil) bool { return a.locality < b.locality }
+	case "partitions":
+		less = func(a, b brokerUtil) bool { return a.partitions > b.partitions }
+	case "leaders":
+		less = func(a, b brokerUtil) bool { return a.leaders > b.leaders }
+	case "storage":
+		less = func(a, b brokerUtil) bool { return a.storageFree < b.storageFree }
+	default:
+		return fmt.Errorf("Invalid sort column: %s", by)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		switch {
+		case less(rows[i], rows[j]):
+			return true
+		case less(rows[j], rows[i]):
+			return false
+		}
+		return rows[i].id < rows[j].id
+	})
+
+	return nil
+}
+
+// formatBrokerUtilization takes a []brokerUtil and returns a table of
+// broker utilization.
+func formatBrokerUtilization(rows []brokerUtil) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s%-10s %-12s %-10s %-8s %s\n",
+		indent, "ID", "RACK", "PARTITIONS", "LEADERS", "STORAGE FREE")
+
+	for _, r := range rows {
+		locality := r.locality
+		if r.missing {
+			locality = "[missing]"
+		}
+
+		fmt.Fprintf(&b, "%s%-10d %-12s %-10d %-8d %.2fGB\n",
+			indent, r.id, locality, r.partitions, r.leaders, r.storageFree/div)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+)
+
+func TestBrokerUtilization(t *testing.T) {
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001,1004]},
+		{"topic":"test_topic","partition":3,"replicas":[1001,1003]}]}`)
+
+	// 1004 holds partitions but isn't registered;
+	// 1005 is registered but holds no partitions.
+	bmm := kafkazk.BrokerMetaMap{
+		1001: &kafkazk.BrokerMeta{Rack: "a", StorageFree: 1 << 30},
+		1002: &kafkazk.BrokerMeta{Rack: "b", StorageFree: 3 << 30},
+		1003: &kafkazk.BrokerMeta{Rack: "c", StorageFree: 2 << 30},
+		1005: &kafkazk.BrokerMeta{Rack: "a", StorageFree: 4 << 30},
+	}
+
+	rows := brokerUtilization(pm, bmm)
+
+	expected := []brokerUtil{
+		{id: 1001, locality: "a", partitions: 4, leaders: 3, storageFree: 1 << 30},
+		{id: 1002, locality: "b", partitions: 2, leaders: 1, storageFree: 3 << 30},
+		{id: 1003, locality: "c", partitions: 1, leaders: 0, storageFree: 2 << 30},
+		{id: 1004, partitions: 1, leaders: 0, missing: true},
+		{id: 1005, locality: "a", partitions: 0, leaders: 0, storageFree: 4 << 30},
+	}
+
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d", len(expected), len(rows))
+	}
+
+	for i := range expected {
+		if rows[i] != expected[i] {
+			t.Errorf("Expected row %+v, got %+v", expected[i], rows[i])
+		}
+	}
+
+	// Sorting.
+	tests := map[string][]int{
+		"id":         {1001, 1002, 1003, 1004, 1005},
+		"locality":   {1004, 1001, 1005, 1002, 1003},
+		"partitions": {1001, 1002, 1003, 1004, 1005},
+		"leaders":    {1001, 1002, 1003, 1004, 1005},
+		"storage":    {1004, 1001, 1003, 1002, 1005},
+	}
+
+	for by, ids := range tests {
+		if err := sortBrokerUtilization(rows, by); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, id := range ids {
+			if rows[i].id != id {
+				t.Errorf("[%s] Expected broker %d at row %d, got %d", by, id, i, rows[i].id)
+			}
+		}
+	}
+
+	if err := sortBrokerUtilization(rows, "bogus"); err == nil {
+		t.Error("Expected error for invalid sort column")
+	}
+
+	// Table output.
+	sortBrokerUtilization(rows, "id")
+	table := formatBrokerUtilization(rows)
+
+	lines := strings.Split(strings.TrimSpace(table), "\n")
+	if len(lines) != len(rows)+1 {
+		t.Fatalf("Expected %d lines, got %d", len(rows)+1, len(lines))
+	}
+
+	if f := strings.Fields(lines[1]); strings.Join(f, " ") != "1001 a 4 3 1.00GB" {
+		t.Errorf("Unexpected row: %s", lines[1])
+	}
+
+	if !strings.Contains(lines[4], "[missing]") {
+		t.Errorf("Expected broker 1004 to be marked missing: %s", lines[4])
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/DataDog/kafka-kit/v3/kafkaadmin"
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
 
 	"github.com/spf13/cobra"
@@ -95,10 +96,17 @@ func initZooKeeper(cmd *cobra.Command) (kafkazk.Handler, error) {
 	zkAddr := cmd.Parent().Flag("zk-addr").Value.String()
 	timeout := 250 * time.Millisecond
 
+	// Not all commands reference metrics.
+	var metricsPrefix string
+	if f := cmd.Flag("zk-metrics-prefix"); f != nil {
+		metricsPrefix = f.Value.String()
+	}
+
 	zk, err := kafkazk.NewHandler(&kafkazk.Config{
 		Connect:       zkAddr,
 		Prefix:        cmd.Parent().Flag("zk-prefix").Value.String(),
-		MetricsPrefix: cmd.Flag("zk-metrics-prefix").Value.String(),
+		MetricsPrefix: metricsPrefix,
+		ConsumerLag:   consumerLagSource(cmd),
 	})
 
 	if err != nil {
@@ -114,6 +122,31 @@ func initZooKeeper(cmd *cobra.Command) (kafkazk.Handler, error) {
 	return zk, nil
 }
 
+// consumerLagSource returns a kafkazk.ConsumerLagFunc that fetches consumer
+// group offsets from Kafka, or nil if the command doesn't reference consumer
+// groups.
+func consumerLagSource(cmd *cobra.Command) kafkazk.ConsumerLagFunc {
+	// Not all commands reference consumer lag.
+	f := cmd.Flag("consumer-groups")
+	if f == nil || f.Value.String() == "" {
+		return nil
+	}
+
+	groups := strings.Split(f.Value.String(), ",")
+	cfg := kafkaadmin.Config{
+		BootstrapServers: cmd.Flag("bootstrap-servers").Value.String(),
+	}
+
+	return func(t string) (map[int]int64, error) {
+		lag, err := kafkaadmin.ConsumerLag(cfg, groups, []string{t})
+		if err != nil {
+			return nil, err
+		}
+
+		return lag[t], nil
+	}
+}
+
 // containsRegex takes a topic name reference and returns whether or not
 // it should be interpreted as regex.
 func containsRegex(t string) bool {
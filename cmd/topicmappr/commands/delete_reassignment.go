@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+
+	"github.com/spf13/cobra"
+)
+
+var deleteReassignmentCmd = &cobra.Command{
+	Use:   "delete-reassignment",
+	Short: "Delete the pending partition reassignment from ZooKeeper",
+	Long: `delete-reassignment removes the reassign_partitions znode from ZooKeeper.
+This does not cancel the reassignment: the active controller holds the
+reassignment in memory and continues to run it. Deleting the znode only ensures
+that the reassignment isn't resumed once a controller failover occurs (e.g. by
+restarting the active controller). The partitions being reassigned are printed
+prior to deletion.`,
+	Run: deleteReassignment,
+}
+
+func init() {
+	rootCmd.AddCommand(deleteReassignmentCmd)
+}
+
+func deleteReassignment(cmd *cobra.Command, _ []string) {
+	// ZooKeeper init.
+	zk, err := initZooKeeper(cmd)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	defer zk.Close()
+
+	if err := deleteReassignmentZnode(zk); err != nil {
+		fmt.Printf("\n[ERROR] %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// deleteReassignmentZnode takes a kafkazk.Handler, prints the partitions
+// currently being reassigned and deletes the reassignment znode. An error is
+// returned if no reassignment is in progress or the deletion fails.
+func deleteReassignmentZnode(zk kafkazk.Handler) error {
+	reassignments := zk.GetReassignments()
+	if len(reassignments) == 0 {
+		return kafkazk.ErrNoReassignment
+	}
+
+	var topics []string
+	for t := range reassignments {
+		topics = append(topics, t)
+	}
+
+	sort.Strings(topics)
+
+	fmt.Printf("\nDeleting reassignment:\n")
+	for _, t := range topics {
+		var partitions []int
+		for p := range reassignments[t] {
+			partitions = append(partitions, p)
+		}
+
+		sort.Ints(partitions)
+
+		for _, p := range partitions {
+			fmt.Printf("%s%s p%d: %v\n", indent, t, p, reassignments[t][p])
+		}
+	}
+
+	if err := zk.DeleteReassignment(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s-\n%sReassignment znode deleted; the active controller continues the\n", indent, indent)
+	fmt.Printf("%sreassignment until a controller failover occurs\n", indent)
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+)
+
+func TestDeleteReassignmentZnode(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+
+	if err := deleteReassignmentZnode(zk); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if re := zk.GetReassignments(); len(re) != 0 {
+		t.Errorf("Expected no reassignments, got %v", re)
+	}
+
+	// Nothing is in progress.
+	if err := deleteReassignmentZnode(zk); err != kafkazk.ErrNoReassignment {
+		t.Errorf("Expected ErrNoReassignment, got %v", err)
+	}
+}
@@ -1,9 +1,12 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"regexp"
+	"sort"
 	"time"
 
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
@@ -11,23 +14,56 @@ import (
 	"github.com/spf13/cobra"
 )
 
-// checkMetaAge checks the age of the stored partition and broker storage
-// metrics data against the tolerated metrics age parameter.
-func checkMetaAge(cmd *cobra.Command, zk kafkazk.Handler) {
+// checkMetaAge checks the age of metrics data against the tolerated metrics
+// age parameter. The metrics of each participating broker are checked by the
+// broker's metrics timestamp; stale metrics for other brokers are tolerated.
+// If any participating broker lacks a metrics timestamp, the age of the stored
+// partition and broker storage metrics data as a whole is checked instead.
+func checkMetaAge(cmd *cobra.Command, zk kafkazk.Handler, ids []int, bmm kafkazk.BrokerMetaMap) {
+	tol, _ := cmd.Flags().GetInt("metrics-age")
+	ages := bmm.MetricsAge()
+
+	stale, untimed := staleBrokers(ids, ages, time.Duration(tol)*time.Minute)
+
+	if len(stale) > 0 {
+		for _, id := range stale {
+			fmt.Printf("Metrics for broker %d are older than allowed: %s\n", id, ages[id])
+		}
+		os.Exit(1)
+	}
+
+	if !untimed {
+		return
+	}
+
 	age, err := zk.MaxMetaAge()
 	if err != nil {
 		fmt.Printf("Error fetching metrics metadata: %s\n", err)
 		os.Exit(1)
 	}
 
-	tol, _ := cmd.Flags().GetInt("metrics-age")
-
 	if age > time.Duration(tol)*time.Minute {
 		fmt.Printf("Metrics metadata is older than allowed: %s\n", age)
 		os.Exit(1)
 	}
 }
 
+// participatingBrokers returns the sorted IDs of all non-missing brokers in
+// the BrokerMap.
+func participatingBrokers(bm kafkazk.BrokerMap) []int {
+	var ids []int
+	for id, b := range bm {
+		if b.Missing || id == kafkazk.StubBrokerID {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	return ids
+}
+
 // getBrokerMeta returns a map of brokers and broker metadata for those
 // registered in ZooKeeper. Optionally, metrics metadata persisted in ZooKeeper
 // (via an external mechanism*) can be merged into the metadata.
@@ -43,9 +79,46 @@ func getBrokerMeta(cmd *cobra.Command, zk kafkazk.Handler, m bool) kafkazk.Broke
 		os.Exit(1)
 	}
 
+	// Fill or override broker rack IDs from the --rack-map, if set.
+	if racks := getRackMap(cmd); racks != nil {
+		override, _ := cmd.Flags().GetBool("rack-map-override")
+		if updated := brokerMeta.SetRacks(racks, override); len(updated) > 0 {
+			fmt.Printf("Rack IDs set from rack map for brokers: %v\n", updated)
+		}
+	}
+
 	return brokerMeta
 }
 
+// getRackMap returns the broker rack IDs from the JSON file specified via the
+// --rack-map flag. The file is a mapping of broker IDs to rack IDs, e.g.
+// {"1001": "us-east-1a"}, typically generated from cloud instance tags. A nil
+// map is returned if the flag is unset or not defined for the command.
+func getRackMap(cmd *cobra.Command) map[int]string {
+	if cmd.Flags().Lookup("rack-map") == nil {
+		return nil
+	}
+
+	path, _ := cmd.Flags().GetString("rack-map")
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	racks := map[int]string{}
+	if err := json.Unmarshal(data, &racks); err != nil {
+		fmt.Printf("Error parsing rack map: %s\n", err)
+		os.Exit(1)
+	}
+
+	return racks
+}
+
 // ensureBrokerMetrics takes a map of reference brokers and a map of discovered
 // broker metadata. Any non-missing brokers in the broker map must be present
 // in the broker metadata map and have a non-true MetricsIncomplete value.
@@ -64,10 +137,47 @@ func ensureBrokerMetrics(cmd *cobra.Command, bm kafkazk.BrokerMap, bmm kafkazk.B
 	}
 }
 
+// staleBrokers takes a list of broker IDs, a map of broker IDs to metrics age
+// and a tolerated age. The sorted IDs of brokers with metrics older than the
+// tolerated age are returned, along with whether any broker has no known
+// metrics age.
+func staleBrokers(ids []int, ages map[int]time.Duration, tol time.Duration) ([]int, bool) {
+	var stale []int
+	var untimed bool
+
+	for _, id := range ids {
+		age, exists := ages[id]
+		switch {
+		case !exists:
+			untimed = true
+		case age > tol:
+			stale = append(stale, id)
+		}
+	}
+
+	sort.Ints(stale)
+
+	return stale, untimed
+}
+
 // getPartitionMeta returns a map of topic, partition metadata persisted in
 // ZooKeeper (via an external mechanism*). This is primarily partition size
-// metrics data used for the storage placement strategy.
+// metrics data used for the storage placement strategy. If the
+// --partition-meta-file flag is set, the metadata is read from the file
+// instead, e.g. for offline planning.
 func getPartitionMeta(cmd *cobra.Command, zk kafkazk.Handler) kafkazk.PartitionMetaMap {
+	if cmd.Flags().Lookup("partition-meta-file") != nil {
+		if path, _ := cmd.Flags().GetString("partition-meta-file"); path != "" {
+			partitionMeta, err := kafkazk.PartitionMetaMapFromFile(path)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			return partitionMeta
+		}
+	}
+
 	partitionMeta, err := zk.GetAllPartitionMeta()
 	if err != nil {
 		fmt.Println(err)
@@ -77,6 +187,117 @@ func getPartitionMeta(cmd *cobra.Command, zk kafkazk.Handler) kafkazk.PartitionM
 	return partitionMeta
 }
 
+// getConsumerLag returns a map of topic, partition consumer lag for all topics
+// in the provided partition map. Lag data is optional; if it's unavailable,
+// planning proceeds without it.
+func getConsumerLag(pm *kafkazk.PartitionMap, zk kafkazk.Handler) consumerLagMap {
+	lag := consumerLagMap{}
+
+	for _, t := range pm.Topics() {
+		l, err := zk.GetConsumerLag(t)
+		if err != nil {
+			fmt.Printf("Consumer lag unavailable, proceeding without it: %s\n", err)
+			return consumerLagMap{}
+		}
+
+		lag[t] = l
+	}
+
+	return lag
+}
+
+// getThrottleRates returns the replication throttle rates currently set on
+// brokers. Throttle data is optional; if it's unavailable, planning proceeds
+// without a duration estimate.
+func getThrottleRates(zk kafkazk.Handler) map[int]kafkazk.ThrottleRate {
+	rates, err := zk.GetThrottleRates()
+	if err != nil {
+		fmt.Printf("Throttle rates unavailable, proceeding without them: %s\n", err)
+		return nil
+	}
+
+	return rates
+}
+
+// getMaintenanceWindows returns the rack maintenance windows from the JSON
+// file specified via the --maintenance-windows flag. The file is a mapping of
+// rack IDs to windows, e.g. {"us-east-1a": "Mon 02:00-04:00 UTC"}. A nil
+// maintenanceWindows is returned if the flag is unset.
+func getMaintenanceWindows(cmd *cobra.Command) maintenanceWindows {
+	path, _ := cmd.Flags().GetString("maintenance-windows")
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	windows := maintenanceWindows{}
+	if err := json.Unmarshal(data, &windows); err != nil {
+		fmt.Printf("Error parsing maintenance windows: %s\n", err)
+		os.Exit(1)
+	}
+
+	return windows
+}
+
+// getMoveBudgets returns the per-topic move budgets from the JSON file
+// specified via the --move-budgets flag. The file is a mapping of topics to the
+// maximum number of partitions that may be relocated, e.g. {"orders": 5}. A nil
+// moveBudgets is returned if the flag is unset.
+func getMoveBudgets(cmd *cobra.Command) moveBudgets {
+	path, _ := cmd.Flags().GetString("move-budgets")
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	budgets := moveBudgets{}
+	if err := json.Unmarshal(data, &budgets); err != nil {
+		fmt.Printf("Error parsing move budgets: %s\n", err)
+		os.Exit(1)
+	}
+
+	return budgets
+}
+
+// getHeldPartitions takes a kafkazk.Handler and a PartitionMap and returns
+// the number of replicas held per broker for all topics in the cluster that
+// aren't in the PartitionMap.
+func getHeldPartitions(zk kafkazk.Handler, pm *kafkazk.PartitionMap) map[int]int {
+	all, err := kafkazk.PartitionMapFromZK([]*regexp.Regexp{regexp.MustCompile(".*")}, zk)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	topics := map[string]struct{}{}
+	for _, t := range pm.Topics() {
+		topics[t] = struct{}{}
+	}
+
+	held := map[int]int{}
+	for _, p := range all.Partitions {
+		if _, exists := topics[p.Topic]; exists {
+			continue
+		}
+
+		for _, id := range p.Replicas {
+			held[id]++
+		}
+	}
+
+	return held
+}
+
 // stripPendingDeletes takes a partition map and zk handler. It looks up any
 // topics in a pending delete state and removes them from the provided partition
 // map, returning a list of topics removed.
@@ -102,6 +323,31 @@ func stripPendingDeletes(pm *kafkazk.PartitionMap, zk kafkazk.Handler) []string
 	return removeTopics(pm, re)
 }
 
+// trimNonexistentTopics takes a partition map and zk handler. It returns a
+// copy of the partition map with any topics not found in ZooKeeper removed
+// along with a list of the topics removed. The partition map is returned
+// as-is if the zk handler is nil. An error is returned if the topics can't be
+// fetched from ZooKeeper.
+func trimNonexistentTopics(pm *kafkazk.PartitionMap, zk kafkazk.Handler) (*kafkazk.PartitionMap, []string, error) {
+	if zk == nil {
+		return pm, []string{}, nil
+	}
+
+	topics, err := zk.GetTopics([]*regexp.Regexp{regexp.MustCompile(".*")})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error fetching topics: %s", err)
+	}
+
+	existing := map[string]struct{}{}
+	for _, t := range topics {
+		existing[t] = struct{}{}
+	}
+
+	removed, trimmed := pm.Trim(existing)
+
+	return trimmed, removed, nil
+}
+
 // removeTopics takes a PartitionMap and []*regexp.Regexp of topic name patters.
 // Any topic names that match any provided pattern will be removed from the
 // PartitionMap and a []string of topics that were found and removed is returned.
@@ -121,19 +367,14 @@ func removeTopics(pm *kafkazk.PartitionMap, r []*regexp.Regexp) []string {
 
 	// Traverse the partition map.
 	for _, p := range pm.Partitions {
-		for i, re := range r {
-			// If the topic matches any regex pattern, add it to the removed set.
-			if re.MatchString(p.Topic) {
-				removed[p.Topic] = struct{}{}
-				break
-			}
-
-			// We've checked all patterns.
-			if i == len(r)-1 {
-				// Else, it wasn't marked for removal; add it to the new PartitionList.
-				newPL = append(newPL, p)
-			}
+		// If the topic matches any regex pattern, add it to the removed set.
+		if matchesAnyTopic(p.Topic, r) {
+			removed[p.Topic] = struct{}{}
+			continue
 		}
+
+		// Else, it wasn't marked for removal; add it to the new PartitionList.
+		newPL = append(newPL, p)
 	}
 
 	pm.Partitions = newPL
@@ -144,3 +385,15 @@ func removeTopics(pm *kafkazk.PartitionMap, r []*regexp.Regexp) []string {
 
 	return removedNames
 }
+
+// matchesAnyTopic returns whether the topic name matches any of the provided
+// []*regexp.Regexp patterns.
+func matchesAnyTopic(topic string, r []*regexp.Regexp) bool {
+	for _, re := range r {
+		if re.MatchString(topic) {
+			return true
+		}
+	}
+
+	return false
+}
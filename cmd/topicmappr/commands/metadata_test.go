@@ -4,6 +4,7 @@ import (
 	"regexp"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
 )
@@ -51,6 +52,32 @@ func TestRemoveTopics(t *testing.T) {
 	}
 }
 
+func TestTrimNonexistentTopics(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pm1, _ := zk.GetPartitionMap("test_topic")
+	pm2, _ := zk.GetPartitionMap("deleted_topic")
+
+	pm := mergePartitionMaps(pm1, pm2)
+
+	trimmed, removed, err := trimNonexistentTopics(pm, zk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != 1 || removed[0] != "deleted_topic" {
+		t.Errorf("Expected removed topics [deleted_topic], got %v", removed)
+	}
+
+	if topics := trimmed.Topics(); len(topics) != 1 || topics[0] != "test_topic" {
+		t.Errorf("Expected remaining topics [test_topic], got %v", topics)
+	}
+
+	// A nil handler is a no-op.
+	if _, removed, _ := trimNonexistentTopics(pm, nil); len(removed) != 0 {
+		t.Errorf("Expected no removed topics, got %v", removed)
+	}
+}
+
 func mergePartitionMaps(pms ...*kafkazk.PartitionMap) *kafkazk.PartitionMap {
 	pm := kafkazk.NewPartitionMap()
 
@@ -60,3 +87,59 @@ func mergePartitionMaps(pms ...*kafkazk.PartitionMap) *kafkazk.PartitionMap {
 
 	return pm
 }
+
+func TestGetHeldPartitions(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pm, _ := zk.GetPartitionMap("test_topic")
+
+	// Only test_topic2 is counted.
+	held := getHeldPartitions(zk, pm)
+
+	expected := map[int]int{1001: 3, 1002: 3, 1003: 2, 1004: 2}
+	if len(held) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, held)
+	}
+
+	for id, n := range expected {
+		if held[id] != n {
+			t.Errorf("Expected %d replicas held by broker %d, got %d", n, id, held[id])
+		}
+	}
+}
+
+func TestStaleBrokers(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	bmm, _ := zk.GetAllBrokerMeta(true)
+	pm, _ := zk.GetPartitionMap("test_topic")
+
+	// 1007 isn't in the map.
+	for id := range bmm {
+		bmm[id].MetricsTimestamp = time.Now().Add(-10 * time.Minute).Unix()
+	}
+	bmm[1007].MetricsTimestamp = time.Now().Add(-2 * time.Hour).Unix()
+
+	bm := kafkazk.BrokerMapFromPartitionMap(pm, bmm, false)
+	ids := participatingBrokers(bm)
+
+	// A stale broker not participating is tolerated.
+	stale, untimed := staleBrokers(ids, bmm.MetricsAge(), time.Hour)
+	if len(stale) != 0 || untimed {
+		t.Errorf("Expected no stale or untimed brokers, got %v, %v", stale, untimed)
+	}
+
+	// A stale participating broker fails.
+	bmm[1001].MetricsTimestamp = time.Now().Add(-2 * time.Hour).Unix()
+
+	stale, _ = staleBrokers(ids, bmm.MetricsAge(), time.Hour)
+	if len(stale) != 1 || stale[0] != 1001 {
+		t.Errorf("Expected stale broker 1001, got %v", stale)
+	}
+
+	// A participating broker without a timestamp
+	// defers to the stored metrics age.
+	bmm[1002].MetricsTimestamp = 0
+
+	if _, untimed := staleBrokers(ids, bmm.MetricsAge(), time.Hour); !untimed {
+		t.Error("Expected untimed brokers")
+	}
+}
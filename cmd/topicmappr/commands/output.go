@@ -2,11 +2,14 @@ package commands
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
 
@@ -150,6 +153,10 @@ func printBrokerAssignmentStats(cmd *cobra.Command, pm1, pm2 *kafkazk.PartitionM
 
 		mb1, mb2 := bm1.Filter(mapped), bm2.Filter(nonReplaced)
 
+		// Mean before/after.
+		m1, m2 := mb1.Mean(), mb2.Mean()
+		fmt.Printf("%smean: %.2fGB -> %.2fGB\n", indent, m1/div, m2/div)
+
 		// Range before/after.
 		r1, r2 := mb1.StorageRange(), mb2.StorageRange()
 		fmt.Printf("%srange: %.2fGB -> %.2fGB\n", indent, r1/div, r2/div)
@@ -293,6 +300,53 @@ func writeMaps(cmd *cobra.Command, pm *kafkazk.PartitionMap, phasedPM *kafkazk.P
 	}
 }
 
+// writeMapSizes takes a PartitionMap and PartitionMetaMap and writes a sizes
+// sidecar file for each per-topic map written by writeMaps, along with the
+// combined map if --out-file is set.
+func writeMapSizes(cmd *cobra.Command, pm *kafkazk.PartitionMap, pmm kafkazk.PartitionMetaMap) {
+	if len(pm.Partitions) == 0 {
+		return
+	}
+
+	outPath := cmd.Flag("out-path").Value.String()
+	outFile := cmd.Flag("out-file").Value.String()
+
+	// Break map up by topic.
+	tm := map[string]*kafkazk.PartitionMap{}
+	for _, p := range pm.Partitions {
+		if tm[p.Topic] == nil {
+			tm[p.Topic] = kafkazk.NewPartitionMap()
+		}
+		tm[p.Topic].Partitions = append(tm[p.Topic].Partitions, p)
+	}
+
+	fmt.Println("\nPartition sizes:")
+
+	if outFile != "" {
+		fullPath := outPath + outFile
+		if err := kafkazk.WriteMapSizes(pm, pmm, fullPath); err != nil {
+			fmt.Printf("%s%s\n", indent, err)
+		} else {
+			fmt.Printf("%s%s.sizes.json [combined map]\n", indent, fullPath)
+		}
+	}
+
+	// Sort the topics for consistent output.
+	var topics []string
+	for t := range tm {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+
+	for _, t := range topics {
+		if err := kafkazk.WriteMapSizes(tm[t], pmm, outPath+t); err != nil {
+			fmt.Printf("%s%s\n", indent, err)
+		} else {
+			fmt.Printf("%s%s%s.sizes.json\n", indent, outPath, t)
+		}
+	}
+}
+
 func printReassignmentParams(cmd *cobra.Command, results []reassignmentBundle, brokers kafkazk.BrokerMap, tol float64) {
 	subCmd := cmd.Name()
 
@@ -305,11 +359,31 @@ func printReassignmentParams(cmd *cobra.Command, results []reassignmentBundle, b
 	fmt.Printf("%sFree storage mean, harmonic mean: %.2fGB, %.2fGB\n",
 		indent, mean/div, hMean/div)
 
-	fmt.Printf("%sBroker free storage limits (with a %.2f%% tolerance from mean):\n",
-		indent, tol*100)
+	// Source and destination specific tolerances, if set, override tol.
+	sTol, dTol := tol, tol
+	if st, _ := cmd.Flags().GetFloat64("source-tolerance"); st != 0.00 {
+		sTol = st
+	}
+	if dt, _ := cmd.Flags().GetFloat64("destination-tolerance"); dt != 0.00 {
+		dTol = dt
+	}
 
-	fmt.Printf("%s%sSources limited to <= %.2fGB\n", indent, indent, mean*(1+tol)/div)
-	fmt.Printf("%s%sDestinations limited to >= %.2fGB\n", indent, indent, mean*(1-tol)/div)
+	if sTol == dTol {
+		fmt.Printf("%sBroker free storage limits (with a %.2f%% tolerance from mean):\n",
+			indent, sTol*100)
+	} else {
+		fmt.Printf("%sBroker free storage limits (with a %.2f%% source and %.2f%% destination tolerance from mean):\n",
+			indent, sTol*100, dTol*100)
+	}
+
+	// Limits are per broker if capacity weighted.
+	if cw, _ := cmd.Flags().GetBool("capacity-weighted"); cw {
+		fmt.Printf("%s%sRelative to each broker's storage free at the mean storage used of %.2f%%\n",
+			indent, indent, brokers.MeanStorageUsed()*100)
+	} else {
+		fmt.Printf("%s%sSources limited to <= %.2fGB\n", indent, indent, mean*(1+sTol)/div)
+		fmt.Printf("%s%sDestinations limited to >= %.2fGB\n", indent, indent, mean*(1-dTol)/div)
+	}
 
 	verbose, _ := cmd.Flags().GetBool("verbose")
 
@@ -326,6 +400,32 @@ func printReassignmentParams(cmd *cobra.Command, results []reassignmentBundle, b
 	}
 }
 
+// formatSelectionTrace takes a partition and the SelectionTrace of a broker
+// selection made for it and returns a one line summary listing the selected
+// broker and score, followed by each rejected candidate and the reason.
+func formatSelectionTrace(p kafkazk.Partition, t kafkazk.SelectionTrace) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s p%d: ", p.Topic, p.Partition)
+
+	if t.Selected < 0 {
+		b.WriteString("no broker selected")
+	} else {
+		fmt.Fprintf(&b, "selected %d (score %.2f)", t.Selected, t.Score)
+	}
+
+	for i, c := range t.Rejected() {
+		if i == 0 {
+			b.WriteString("; rejected ")
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%d (%s)", c.ID, c.Reason)
+	}
+
+	return b.String()
+}
+
 func printPlannedRelocations(targets []int, relos map[int][]relocation, pmm kafkazk.PartitionMetaMap) {
 	var total float64
 
@@ -340,14 +440,309 @@ func printPlannedRelocations(targets []int, relos map[int][]relocation, pmm kafk
 		for _, r := range relos[id] {
 			pSize, _ := pmm.Size(r.partition)
 			total += pSize / div
-			fmt.Printf("%s[%.2fGB] %s p%d -> %d\n",
+			fmt.Printf("%s[%.2fGB] %s p%d -> %d",
 				indent, pSize/div, r.partition.Topic, r.partition.Partition, r.destination)
+			if r.window != "" {
+				fmt.Printf(" [window: %s]", r.window)
+			}
+			fmt.Println()
 		}
 	}
 	fmt.Printf("%s-\n", indent)
 	fmt.Printf("%sTotal relocation volume: %.2fGB\n", indent, total)
 }
 
+// printDurationEstimate prints the estimated duration of the planned
+// relocations at the current broker throttle rates. If the estimate exceeds
+// the --duration-window, a warning is printed.
+func printDurationEstimate(cmd *cobra.Command, relos map[int][]relocation, pmm kafkazk.PartitionMetaMap, rates map[int]kafkazk.ThrottleRate) {
+	d, bound := estimateDuration(relos, pmm, rates)
+	if !bound {
+		return
+	}
+
+	fmt.Println("\nEstimated relocation duration at current throttle rates:")
+	fmt.Printf("%s%s\n", indent, d.Round(time.Second))
+
+	if w, _ := cmd.Flags().GetDuration("duration-window"); w > 0 && d > w {
+		fmt.Printf("%s[WARN] estimated duration exceeds the duration window of %s\n", indent, w)
+	}
+}
+
+// printThrottleRecommendations prints the replication throttle rates per
+// broker needed to complete the planned relocations within the
+// --throttle-target duration, if set.
+func printThrottleRecommendations(cmd *cobra.Command, relos map[int][]relocation, pmm kafkazk.PartitionMetaMap) {
+	target, _ := cmd.Flags().GetDuration("throttle-target")
+	if target <= 0 {
+		return
+	}
+
+	rates := recommendThrottles(relos, pmm, target)
+	if len(rates) == 0 {
+		return
+	}
+
+	var ids []int
+	for id := range rates {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	fmt.Printf("\nRecommended throttles to complete within %s:\n", target)
+
+	for _, id := range ids {
+		r := rates[id]
+		fmt.Printf("%sBroker %d: leader %.0fB/s (%.2fMB/s), follower %.0fB/s (%.2fMB/s)\n",
+			indent, id, r.Leader, r.Leader/(1<<20), r.Follower, r.Follower/(1<<20))
+	}
+}
+
+// printStuckPartitions prints partitions that couldn't be relocated from
+// offload targets along with the reason.
+func printStuckPartitions(stuck []stuckPartition) {
+	if len(stuck) == 0 {
+		return
+	}
+
+	fmt.Println("\nPartitions that couldn't be relocated:")
+
+	for _, s := range stuck {
+		fmt.Printf("%s%s p%d on %d: %s (%d attempts)\n",
+			indent, s.partition.Topic, s.partition.Partition, s.source, s.reason, s.attempts)
+	}
+}
+
+// printMoveBudgets prints the number of partitions planned for relocation
+// against the move budget for each topic with a budget.
+func printMoveBudgets(relos map[int][]relocation, budgets moveBudgets) {
+	if len(budgets) == 0 {
+		return
+	}
+
+	moves := topicMoves(relos)
+
+	var topics []string
+	for t := range budgets {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+
+	fmt.Println("\nTopic move budgets:")
+
+	for _, t := range topics {
+		fmt.Printf("%s%s: %d of %d partitions\n", indent, t, moves[t], budgets[t])
+	}
+}
+
+// printStorageForecast prints the projected per broker free storage after
+// applying the planned relocations.
+func printStorageForecast(before, after map[int]float64) {
+	fmt.Print(formatStorageForecast(before, after))
+}
+
+// formatStorageForecast takes broker free storage snapshots taken before and
+// after planning and returns a report of the free storage per broker along
+// with the mean and standard deviation, before and after.
+func formatStorageForecast(before, after map[int]float64) string {
+	var ids []int
+	for id := range after {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var b strings.Builder
+
+	b.WriteString("\nStorage free forecast:\n")
+
+	for _, id := range ids {
+		fmt.Fprintf(&b, "%sBroker %d: %.2fGB -> %.2fGB\n",
+			indent, id, before[id]/div, after[id]/div)
+	}
+
+	m1, sd1 := snapshotStats(before)
+	m2, sd2 := snapshotStats(after)
+
+	fmt.Fprintf(&b, "%s-\n", indent)
+	fmt.Fprintf(&b, "%smean: %.2fGB -> %.2fGB\n", indent, m1/div, m2/div)
+	fmt.Fprintf(&b, "%sstd. deviation: %.2fGB -> %.2fGB\n", indent, sd1/div, sd2/div)
+
+	return b.String()
+}
+
+// snapshotStats returns the mean and standard deviation of the free storage
+// values in a broker storage snapshot.
+func snapshotStats(s map[int]float64) (float64, float64) {
+	if len(s) == 0 {
+		return 0, 0
+	}
+
+	var t float64
+	for _, v := range s {
+		t += v
+	}
+
+	m := t / float64(len(s))
+
+	var sq float64
+	for _, v := range s {
+		sq += math.Pow(m-v, 2)
+	}
+
+	return m, math.Sqrt(sq / float64(len(s)))
+}
+
+// plannedRelocation is the relocation plan output
+// representation of a relocation.
+type plannedRelocation struct {
+	Topic       string `json:"topic"`
+	Partition   int    `json:"partition"`
+	Source      int    `json:"source"`
+	Destination int    `json:"destination"`
+	Window      string `json:"window,omitempty"`
+}
+
+// relocationPlanJSON takes a list of offload target broker IDs and the
+// planned relocations for each and returns the relocation plan as JSON.
+func relocationPlanJSON(targets []int, relos map[int][]relocation) ([]byte, error) {
+	plan := []plannedRelocation{}
+
+	for _, id := range targets {
+		for _, r := range relos[id] {
+			plan = append(plan, plannedRelocation{
+				Topic:       r.partition.Topic,
+				Partition:   r.partition.Partition,
+				Source:      id,
+				Destination: r.destination,
+				Window:      r.window,
+			})
+		}
+	}
+
+	return json.Marshal(plan)
+}
+
+// writeRelocationPlan writes the relocation plan to a relocation-plan.json
+// file in the --out-path.
+func writeRelocationPlan(cmd *cobra.Command, targets []int, relos map[int][]relocation) {
+	out, err := relocationPlanJSON(targets, relos)
+	if err != nil {
+		fmt.Printf("%s%s\n", indent, err)
+		return
+	}
+
+	path := cmd.Flag("out-path").Value.String() + "relocation-plan.json"
+
+	if err := ioutil.WriteFile(path, append(out, '\n'), 0644); err != nil {
+		fmt.Printf("%s%s\n", indent, err)
+		return
+	}
+
+	fmt.Printf("%s%s [relocation plan]\n", indent, path)
+}
+
+// auditRecord is the audit log output representation of a relocation. All
+// storage values are in bytes.
+type auditRecord struct {
+	Topic            string  `json:"topic"`
+	Partition        int     `json:"partition"`
+	Size             float64 `json:"size"`
+	Source           int     `json:"source"`
+	SourceFreePre    float64 `json:"source_storage_free_pre"`
+	SourceFreePost   float64 `json:"source_storage_free_post"`
+	SourceLimit      float64 `json:"source_storage_free_limit"`
+	Destination      int     `json:"destination"`
+	DestFreePre      float64 `json:"destination_storage_free_pre"`
+	DestFreePost     float64 `json:"destination_storage_free_post"`
+	DestinationLimit float64 `json:"destination_storage_free_limit"`
+}
+
+// relocationAuditJSON takes a list of offload target broker IDs and the
+// planned relocations for each and returns an audit record for each
+// relocation as JSON lines.
+func relocationAuditJSON(targets []int, relos map[int][]relocation) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+
+	for _, id := range targets {
+		for _, r := range relos[id] {
+			err := enc.Encode(auditRecord{
+				Topic:            r.partition.Topic,
+				Partition:        r.partition.Partition,
+				Size:             r.audit.size,
+				Source:           id,
+				SourceFreePre:    r.audit.sourceFreePre,
+				SourceFreePost:   r.audit.sourceFreePost,
+				SourceLimit:      r.audit.sourceLimit,
+				Destination:      r.destination,
+				DestFreePre:      r.audit.destFreePre,
+				DestFreePost:     r.audit.destFreePost,
+				DestinationLimit: r.audit.destLimit,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return b.Bytes(), nil
+}
+
+// writeRelocationAudit writes an audit record for each planned relocation
+// as JSON lines to a relocation-audit.jsonl file in the --out-path.
+func writeRelocationAudit(cmd *cobra.Command, targets []int, relos map[int][]relocation) {
+	out, err := relocationAuditJSON(targets, relos)
+	if err != nil {
+		fmt.Printf("%s%s\n", indent, err)
+		return
+	}
+
+	path := cmd.Flag("out-path").Value.String() + "relocation-audit.jsonl"
+
+	if err := ioutil.WriteFile(path, out, 0644); err != nil {
+		fmt.Printf("%s%s\n", indent, err)
+		return
+	}
+
+	fmt.Printf("%s%s [relocation audit log]\n", indent, path)
+}
+
+// formatPlanMarkdown takes a list of offload target broker IDs, the planned
+// relocations for each and a PartitionMetaMap and returns the relocations as
+// a GitHub-flavored Markdown table.
+func formatPlanMarkdown(targets []int, relos map[int][]relocation, pmm kafkazk.PartitionMetaMap) string {
+	var b strings.Builder
+
+	b.WriteString("| Source | Destination | Topic | Partition | Size |\n")
+	b.WriteString("|---|---|---|---|---|\n")
+
+	for _, id := range targets {
+		for _, r := range relos[id] {
+			pSize, _ := pmm.Size(r.partition)
+			// Escape pipes that would otherwise break the table.
+			topic := strings.ReplaceAll(r.partition.Topic, "|", "\\|")
+			fmt.Fprintf(&b, "| %d | %d | %s | %d | %.2fGB |\n",
+				id, r.destination, topic, r.partition.Partition, pSize/div)
+		}
+	}
+
+	return b.String()
+}
+
+// writePlanMarkdown writes the relocation plan as a Markdown table to a
+// relocation-plan.md file in the --out-path.
+func writePlanMarkdown(cmd *cobra.Command, targets []int, relos map[int][]relocation, pmm kafkazk.PartitionMetaMap) {
+	out := formatPlanMarkdown(targets, relos, pmm)
+	path := cmd.Flag("out-path").Value.String() + "relocation-plan.md"
+
+	if err := ioutil.WriteFile(path, []byte(out), 0644); err != nil {
+		fmt.Printf("%s%s\n", indent, err)
+		return
+	}
+
+	fmt.Printf("%s%s [relocation plan markdown]\n", indent, path)
+}
+
 // handleOverridableErrs handles errors that can be optionally ignored by the
 // user (hence being referred to as 'WARN' in the CLI). If --ignore-warns is
 // false (default), any errors passed here will cause an exit(1).
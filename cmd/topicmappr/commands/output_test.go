@@ -1,7 +1,11 @@
 package commands
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
 )
 
 func TestWhatChanged(t *testing.T) {
@@ -32,3 +36,155 @@ func TestWhatChanged(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatSelectionTrace(t *testing.T) {
+	p := kafkazk.Partition{Topic: "test_topic", Partition: 1}
+
+	trace := kafkazk.SelectionTrace{
+		Candidates: []kafkazk.CandidateTrace{
+			{ID: 1001, Reason: "locality collision"},
+			{ID: 1002, Reason: "insufficient storage"},
+			{ID: 1003},
+		},
+		Selected: 1003,
+		Score:    2,
+	}
+
+	expected := "test_topic p1: selected 1003 (score 2.00); rejected 1001 (locality collision), 1002 (insufficient storage)"
+	if s := formatSelectionTrace(p, trace); s != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, s)
+	}
+
+	trace = kafkazk.SelectionTrace{
+		Candidates: []kafkazk.CandidateTrace{{ID: 1001, Reason: "locality collision"}},
+		Selected:   -1,
+	}
+
+	expected = "test_topic p1: no broker selected; rejected 1001 (locality collision)"
+	if s := formatSelectionTrace(p, trace); s != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, s)
+	}
+}
+
+func TestFormatPlanMarkdown(t *testing.T) {
+	pmm := kafkazk.PartitionMetaMap{
+		"test_topic": map[int]*kafkazk.PartitionMeta{
+			0: {Size: 1 << 30},
+			1: {Size: 2 << 30},
+		},
+	}
+
+	relos := map[int][]relocation{
+		1001: {
+			{partition: kafkazk.Partition{Topic: "test_topic", Partition: 0}, destination: 1003},
+			{partition: kafkazk.Partition{Topic: "test_topic", Partition: 1}, destination: 1004},
+		},
+	}
+
+	out := formatPlanMarkdown([]int{1001, 1002}, relos, pmm)
+
+	expected := []string{
+		"| Source | Destination | Topic | Partition | Size |",
+		"|---|---|---|---|---|",
+		"| 1001 | 1003 | test_topic | 0 | 1.00GB |",
+		"| 1001 | 1004 | test_topic | 1 | 2.00GB |",
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d:\n%s", len(expected), len(lines), out)
+	}
+
+	for i, l := range lines {
+		if l != expected[i] {
+			t.Errorf("Expected line '%s', got '%s'", expected[i], l)
+		}
+	}
+}
+
+func TestFormatStorageForecast(t *testing.T) {
+	before := map[int]float64{1001: 1 << 30, 1002: 7 << 30}
+	after := map[int]float64{1001: 3.5 * (1 << 30), 1002: 4.5 * (1 << 30)}
+
+	out := formatStorageForecast(before, after)
+
+	expected := []string{
+		"",
+		"Storage free forecast:",
+		indent + "Broker 1001: 1.00GB -> 3.50GB",
+		indent + "Broker 1002: 7.00GB -> 4.50GB",
+		indent + "-",
+		indent + "mean: 4.00GB -> 4.00GB",
+		indent + "std. deviation: 3.00GB -> 0.50GB",
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d:\n%s", len(expected), len(lines), out)
+	}
+
+	for i, l := range lines {
+		if l != expected[i] {
+			t.Errorf("Expected line '%s', got '%s'", expected[i], l)
+		}
+	}
+}
+
+func TestRelocationAuditJSON(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":2,"replicas":[1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1001]}]}`)
+
+	params := computeReassignmentBundlesParams{
+		offloadTargets: []int{1001},
+		tolerance:      0.10,
+		partitionMap:   pm,
+		partitionMeta:  pmm,
+		brokerMap: kafkazk.BrokerMap{
+			1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+			1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 7000},
+		},
+		partitionLimit: 30,
+		localityScoped: true,
+	}
+
+	b := <-computeReassignmentBundles(params)
+
+	out, err := relocationAuditJSON([]int{1001}, b.relocations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != len(b.relocations[1001]) {
+		t.Fatalf("Expected %d audit records, got %d", len(b.relocations[1001]), len(lines))
+	}
+
+	var record auditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	// p3 (2500) moves from 1001 to 1002 with a mean
+	// storage free of 4000 and a tolerance of 10%.
+	expected := auditRecord{
+		Topic:            "test_topic",
+		Partition:        3,
+		Size:             2500,
+		Source:           1001,
+		SourceFreePre:    1000,
+		SourceFreePost:   3500,
+		SourceLimit:      4400,
+		Destination:      1002,
+		DestFreePre:      7000,
+		DestFreePost:     4500,
+		DestinationLimit: 3600,
+	}
+
+	if record != expected {
+		t.Errorf("Expected audit record %+v, got %+v", expected, record)
+	}
+}
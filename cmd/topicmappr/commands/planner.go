@@ -2,18 +2,82 @@ package commands
 
 import (
 	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
 
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
 )
 
-// Relocation is a kafakzk.Partition to destination broker ID.
+// Relocation is a kafakzk.Partition to destination broker ID. The window is
+// the maintenance window of the destination broker's rack, if one is set. The
+// audit holds the decision context that permitted the relocation.
 type relocation struct {
 	partition   kafkazk.Partition
 	destination int
+	window      string
+	audit       relocationAudit
+}
+
+// relocationAudit records the partition size, the source and destination
+// storage free before and after a relocation, and the storage free limits
+// the relocation was checked against.
+type relocationAudit struct {
+	size           float64
+	sourceFreePre  float64
+	sourceFreePost float64
+	destFreePre    float64
+	destFreePost   float64
+	sourceLimit    float64
+	destLimit      float64
+}
+
+// maintenanceWindows is a mapping of rack IDs to the maintenance window
+// during which relocations to brokers in the rack may be applied.
+type maintenanceWindows map[string]string
+
+// moveBudgets is a mapping of topics to the maximum number of partitions
+// that may be relocated. Topics without a budget aren't limited.
+type moveBudgets map[string]int
+
+// topicMoves takes planned relocations and returns the number of distinct
+// partitions relocated per topic.
+func topicMoves(relos map[int][]relocation) map[string]int {
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	moved := map[key]struct{}{}
+	for _, rl := range relos {
+		for _, r := range rl {
+			moved[key{r.partition.Topic, r.partition.Partition}] = struct{}{}
+		}
+	}
+
+	moves := map[string]int{}
+	for k := range moved {
+		moves[k.topic]++
+	}
+
+	return moves
 }
 
 // planRelocationsForBrokerParams are used to plan partition relocations from
-// source brokers to destination brokers.
+// source brokers to destination brokers. The sourceTolerance and
+// destinationTolerance fields optionally override tolerance for the source
+// and destination storage limits, respectively. If stdDevTarget is non-zero,
+// no relocations are planned once the broker storage free standard deviation
+// is at or below the value. If bestFit is set, all top partitions are
+// evaluated and the relocation leaving the source broker closest to the mean
+// storage free is planned, rather than the first feasible relocation. If
+// capacityWeighted is set, destinations are selected by storage used ratio
+// and storage limits are relative to the storage free at which each broker
+// would be at the mean storage used ratio, rather than the mean storage free.
+// Partitions smaller than partitionSizeThreshold (in megabytes) and partitions
+// of topics matching any pinnedTopics pattern are never selected as
+// relocation candidates.
 type planRelocationsForBrokerParams struct {
 	relos                  map[int][]relocation
 	mappings               kafkazk.Mappings
@@ -24,8 +88,19 @@ type planRelocationsForBrokerParams struct {
 	partitionSizeThreshold int
 	offloadTargetsMap      map[int]struct{}
 	tolerance              float64
+	sourceTolerance        float64
+	destinationTolerance   float64
 	localityScoped         bool
 	verbose                bool
+	consumerLag            consumerLagMap
+	lagThreshold           int64
+	windows                maintenanceWindows
+	budgets                moveBudgets
+	stdDevTarget           float64
+	bestFit                bool
+	pinnedTopics           []*regexp.Regexp
+	capacityWeighted       bool
+	stuck                  stuckPartitions
 	// These aren't specified by the user.
 	pass     int
 	sourceID int
@@ -62,6 +137,74 @@ func (r relocationPlan) isPlanned(p kafkazk.Partition) ([][2]int, bool) {
 	return r[p.Topic][p.Partition], true
 }
 
+// Reasons that a partition couldn't be relocated.
+const (
+	stuckNoDestination    = "no eligible destination"
+	stuckSourceLimit      = "source storage free would exceed tolerated threshold"
+	stuckDestinationLimit = "destination storage free would fall below tolerated threshold"
+	stuckMoveBudget       = "topic move budget exhausted"
+	stuckUnknownSize      = "partition size not found in partition metadata"
+)
+
+// stuckPartition is a partition that couldn't be relocated from a source
+// broker, along with the most recent reason and the number of attempts.
+type stuckPartition struct {
+	partition kafkazk.Partition
+	source    int
+	reason    string
+	attempts  int
+}
+
+// stuckPartitions tracks partitions that couldn't be relocated, keyed by
+// topic, partition and source broker ID.
+type stuckPartitions map[stuckKey]*stuckPartition
+
+type stuckKey struct {
+	topic     string
+	partition int
+	source    int
+}
+
+// add records a failed relocation attempt for the kafkazk.Partition from
+// the source broker ID with the reason.
+func (s stuckPartitions) add(p kafkazk.Partition, source int, reason string) {
+	k := stuckKey{p.Topic, p.Partition, source}
+
+	if _, exist := s[k]; !exist {
+		s[k] = &stuckPartition{partition: p, source: source}
+	}
+
+	s[k].reason = reason
+	s[k].attempts++
+}
+
+// remove clears any failed relocation attempts for the kafkazk.Partition from
+// the source broker ID.
+func (s stuckPartitions) remove(p kafkazk.Partition, source int) {
+	delete(s, stuckKey{p.Topic, p.Partition, source})
+}
+
+// list returns all stuck partitions sorted by topic, partition and
+// source broker ID.
+func (s stuckPartitions) list() []stuckPartition {
+	var l []stuckPartition
+	for _, sp := range s {
+		l = append(l, *sp)
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		switch {
+		case l[i].partition.Topic != l[j].partition.Topic:
+			return l[i].partition.Topic < l[j].partition.Topic
+		case l[i].partition.Partition != l[j].partition.Partition:
+			return l[i].partition.Partition < l[j].partition.Partition
+		}
+		return l[i].source < l[j].source
+	})
+
+	return l
+}
+
 // TODO(jamie): ...wow
 func planRelocationsForBroker(params planRelocationsForBrokerParams) int {
 	relos := params.relos
@@ -74,15 +217,61 @@ func planRelocationsForBroker(params planRelocationsForBrokerParams) int {
 	partitionSizeThreshold := float64(params.partitionSizeThreshold * 1 << 20)
 	offloadTargetsMap := params.offloadTargetsMap
 	tolerance := params.tolerance
+	sourceTolerance, destinationTolerance := tolerance, tolerance
+	if params.sourceTolerance != 0.00 {
+		sourceTolerance = params.sourceTolerance
+	}
+	if params.destinationTolerance != 0.00 {
+		destinationTolerance = params.destinationTolerance
+	}
 	localityScoped := params.localityScoped
 	verbose := params.verbose
+	consumerLag := params.consumerLag
+	lagThreshold := params.lagThreshold
+	stuck := params.stuck
+	if stuck == nil {
+		stuck = stuckPartitions{}
+	}
+
+	// Stop planning relocations once the storage free std.
+	// deviation target is met; further moves have marginal gains.
+	if params.stdDevTarget > 0 {
+		if sd := brokers.StorageStdDev(); sd <= params.stdDevTarget {
+			if verbose {
+				fmt.Printf("\n[pass %d with tolerance %.2f] Storage free std. deviation of %.2fGB meets the target of %.2fGB\n",
+					params.pass, tolerance, sd/div, params.stdDevTarget/div)
+			}
+			return 0
+		}
+	}
 
 	// Use the arithmetic mean for target
 	// thresholds.
 	meanStorageFree := brokers.Mean()
 
-	// Get the top partitions for the target broker.
-	topPartn, _ := mappings.LargestPartitions(sourceID, topPartitionsLimit, partitionMeta)
+	// storageTarget returns the target storage free for a broker. If capacity
+	// weighted, this is the storage free at the mean storage used ratio.
+	storageTarget := func(id int) float64 {
+		if params.capacityWeighted {
+			return brokers.StorageFreeTarget(id)
+		}
+		return meanStorageFree
+	}
+
+	// Get the top partitions for the target broker. Partitions of pinned
+	// topics are filtered out before applying the limit so that they don't
+	// displace eligible candidates.
+	var topPartn kafkazk.PartitionList
+	if len(params.pinnedTopics) > 0 {
+		all, _ := mappings.LargestPartitions(sourceID, int(^uint(0)>>1), partitionMeta)
+		for _, p := range all {
+			if len(topPartn) < topPartitionsLimit && !matchesAnyTopic(p.Topic, params.pinnedTopics) {
+				topPartn = append(topPartn, p)
+			}
+		}
+	} else {
+		topPartn, _ = mappings.LargestPartitions(sourceID, topPartitionsLimit, partitionMeta)
+	}
 
 	// Filter out partitions below the targeted size threshold.
 	for i, p := range topPartn {
@@ -93,6 +282,12 @@ func planRelocationsForBroker(params planRelocationsForBrokerParams) int {
 		}
 	}
 
+	// Defer partitions under active consumption to the end of the candidates
+	// list if consumer lag data is available.
+	if lagThreshold > 0 && consumerLag != nil {
+		deferConsumed(topPartn, consumerLag, lagThreshold)
+	}
+
 	if verbose {
 		fmt.Printf("\n[pass %d with tolerance %.2f] Broker %d has a storage free of %.2fGB. Top partitions:\n",
 			params.pass, tolerance, sourceID, brokers[sourceID].StorageFree/div)
@@ -106,15 +301,61 @@ func planRelocationsForBroker(params planRelocationsForBrokerParams) int {
 
 	targetLocality := brokers[sourceID].Locality
 
+	// schedule plans a relocation from the source broker.
+	schedule := func(r relocation) {
+		// Update StorageFree values. Partition sizes are
+		// verified when evaluating relocation candidates.
+		brokers.MoveStorage(r.partition, partitionMeta, sourceID, r.destination)
+
+		relos[sourceID] = append(relos[sourceID], r)
+
+		// Add to plan.
+		plan.add(r.partition, [2]int{sourceID, r.destination})
+
+		// Remove the partition as being mapped to the source broker.
+		mappings.Remove(sourceID, r.partition)
+
+		// The partition is no longer stuck.
+		stuck.remove(r.partition, sourceID)
+
+		if verbose {
+			fmt.Printf("%sPlanning relocation of %s p%d to broker %d\n",
+				indent, r.partition.Topic, r.partition.Partition, r.destination)
+		}
+	}
+
+	// The best fit relocation, if selecting by best fit.
+	var best *relocation
+	var bestDistance float64
+
 	// Plan partition movements. Each time a partition is planned to be moved, it's
 	// unmapped from the broker so that it's not retried the next iteration.
 	var reloCount int
 	for _, partn := range topPartn {
+		// Skip partitions for topics that have exhausted their move budget.
+		// Partitions already planned for relocation are within the budget.
+		if budget, limited := params.budgets[partn.Topic]; limited {
+			if _, planned := plan.isPlanned(partn); !planned && len(plan[partn.Topic]) >= budget {
+				stuck.add(partn, sourceID, stuckMoveBudget)
+				continue
+			}
+		}
+
 		// Get a storage sorted brokerList.
 		brokerList := brokers.List()
-		brokerList.SortByStorage()
+		selectBy := "storage"
+		if params.capacityWeighted {
+			selectBy = "storage-used"
+			brokerList.SortByStorageUsed()
+		} else {
+			brokerList.SortByStorage()
+		}
 
-		pSize, _ := partitionMeta.Size(partn)
+		pSize, err := partitionMeta.Size(partn)
+		if err != nil {
+			stuck.add(partn, sourceID, stuckUnknownSize)
+			continue
+		}
 
 		// Find a destination broker.
 		var dest *kafkazk.Broker
@@ -163,13 +404,14 @@ func planRelocationsForBroker(params planRelocationsForBrokerParams) int {
 			}
 
 			// Select the best candidate by storage.
-			dest, _ = brokerList.BestCandidate(c, "storage", 0)
+			dest, _ = brokerList.BestCandidate(c, selectBy, 0)
 		}
 
 		// If dest == nil, it's likely that the only available destination brokers
 		// that don't break placement constraints are already taking a replica for
 		// the partition. Continue to the next partition.
 		if dest == nil {
+			stuck.add(partn, sourceID, stuckNoDestination)
 			continue
 		}
 
@@ -186,7 +428,7 @@ func planRelocationsForBroker(params planRelocationsForBrokerParams) int {
 		// If the estimated storage change pushes either the target or destination
 		// beyond the threshold distance from the mean, try the next partition.
 
-		sLim := meanStorageFree * (1 + tolerance)
+		sLim := storageTarget(sourceID) * (1 + sourceTolerance)
 		if sourceFree > sLim {
 			if verbose {
 				fmt.Printf("%sCannot move partition from target: "+
@@ -194,10 +436,11 @@ func planRelocationsForBroker(params planRelocationsForBrokerParams) int {
 					indent, sourceFree/div, sLim/div)
 			}
 
+			stuck.add(partn, sourceID, stuckSourceLimit)
 			continue
 		}
 
-		dLim := meanStorageFree * (1 - tolerance)
+		dLim := storageTarget(dest.ID) * (1 - destinationTolerance)
 		if destFree < dLim {
 			if verbose {
 				fmt.Printf("%sCannot move partition to candidate: "+
@@ -205,32 +448,47 @@ func planRelocationsForBroker(params planRelocationsForBrokerParams) int {
 					indent, destFree/div, dLim/div)
 			}
 
+			stuck.add(partn, sourceID, stuckDestinationLimit)
 			continue
 		}
 
-		// Otherwise, schedule the relocation.
-
-		relos[sourceID] = append(relos[sourceID], relocation{partition: partn, destination: dest.ID})
-		reloCount++
-
-		// Add to plan.
-		plan.add(partn, [2]int{sourceID, dest.ID})
-
-		// Update StorageFree values.
-		brokers[sourceID].StorageFree = sourceFree
-		brokers[dest.ID].StorageFree = destFree
-
-		// Remove the partition as being mapped to the source broker.
-		mappings.Remove(sourceID, partn)
+		// Otherwise, the relocation is feasible.
+		relo := relocation{
+			partition:   partn,
+			destination: dest.ID,
+			window:      params.windows[dest.Locality],
+			audit: relocationAudit{
+				size:           pSize,
+				sourceFreePre:  brokers[sourceID].StorageFree,
+				sourceFreePost: sourceFree,
+				destFreePre:    dest.StorageFree,
+				destFreePost:   destFree,
+				sourceLimit:    sLim,
+				destLimit:      dLim,
+			},
+		}
 
-		if verbose {
-			fmt.Printf("%sPlanning relocation to candidate\n", indent)
+		// If selecting by best fit, keep the relocation leaving the source
+		// closest to the mean and evaluate the remaining partitions.
+		if params.bestFit {
+			if d := absDistance(sourceFree, storageTarget(sourceID)); best == nil || d < bestDistance {
+				best, bestDistance = &relo, d
+			}
+			continue
 		}
 
+		schedule(relo)
+		reloCount++
+
 		// Break at the first placement.
 		break
 	}
 
+	if best != nil {
+		schedule(*best)
+		reloCount++
+	}
+
 	if verbose && reloCount == 0 {
 		fmt.Printf("%s-\n", indent)
 		fmt.Printf("%sNo suitable relocation destinations were found for any partitions "+
@@ -242,6 +500,45 @@ func planRelocationsForBroker(params planRelocationsForBrokerParams) int {
 	return reloCount
 }
 
+// absDistance returns the absolute distance of the value v from the target t.
+func absDistance(v, t float64) float64 {
+	return math.Abs(v - t)
+}
+
+// consumerLagMap is a mapping of topic, partition to consumer lag.
+type consumerLagMap map[string]map[int]int64
+
+// lag takes a kafkazk.Partition and returns the consumer lag. A lag of 0 is
+// returned if no lag data exists for the partition.
+func (c consumerLagMap) lag(p kafkazk.Partition) int64 {
+	if _, exist := c[p.Topic]; !exist {
+		return 0
+	}
+
+	return c[p.Topic][p.Partition]
+}
+
+// deferConsumed takes a kafkazk.PartitionList of relocation candidates, a
+// consumerLagMap and lag threshold. Partitions with a consumer lag at or above
+// the threshold are moved to the end of the list, ordered by lag ascending.
+// The relative order of all other partitions is preserved.
+func deferConsumed(pl kafkazk.PartitionList, c consumerLagMap, t int64) {
+	sort.SliceStable(pl, func(i, j int) bool {
+		li, lj := c.lag(pl[i]), c.lag(pl[j])
+
+		switch {
+		case li < t && lj < t:
+			return false
+		case li < t:
+			return true
+		case lj < t:
+			return false
+		}
+
+		return li < lj
+	})
+}
+
 func applyRelocationPlan(pm *kafkazk.PartitionMap, plan relocationPlan) {
 	// Traverse the partition list.
 	for _, partn := range pm.Partitions {
@@ -283,3 +580,77 @@ func (o offloadTargetsBySize) Less(i, j int) bool {
 
 	return o.t[i] < o.t[j]
 }
+
+// estimateDuration takes a relocation mapping, a kafkazk.PartitionMetaMap and
+// broker replication throttle rates and returns the estimated time to complete
+// all relocations. Each broker's transfer time is the volume it sends divided
+// by its leader throttle rate or the volume it receives divided by its
+// follower throttle rate, whichever is longer; the slowest broker determines
+// the estimate. Transfers in a direction without a throttle set aren't rate
+// bound and don't contribute. A false bool is returned if no relocations are
+// throttle bound.
+func estimateDuration(relos map[int][]relocation, pmm kafkazk.PartitionMetaMap, rates map[int]kafkazk.ThrottleRate) (time.Duration, bool) {
+	out := map[int]float64{}
+	in := map[int]float64{}
+
+	for source, rs := range relos {
+		for _, r := range rs {
+			size, _ := pmm.Size(r.partition)
+			out[source] += size
+			in[r.destination] += size
+		}
+	}
+
+	var seconds float64
+	var bound bool
+
+	for id, bytes := range out {
+		if rate := rates[id].Leader; rate > 0 {
+			bound = true
+			if s := bytes / rate; s > seconds {
+				seconds = s
+			}
+		}
+	}
+
+	for id, bytes := range in {
+		if rate := rates[id].Follower; rate > 0 {
+			bound = true
+			if s := bytes / rate; s > seconds {
+				seconds = s
+			}
+		}
+	}
+
+	return time.Duration(seconds * float64(time.Second)), bound
+}
+
+// recommendThrottles takes a relocation mapping, a kafkazk.PartitionMetaMap
+// and a target completion time and returns the replication throttle rates in
+// bytes/sec for each broker involved in the relocations needed to complete
+// within the target. The leader rate bounds the volume a broker sends as a
+// source and the follower rate bounds the volume it receives as a destination.
+func recommendThrottles(relos map[int][]relocation, pmm kafkazk.PartitionMetaMap, target time.Duration) map[int]kafkazk.ThrottleRate {
+	rates := map[int]kafkazk.ThrottleRate{}
+
+	seconds := target.Seconds()
+	if seconds <= 0 {
+		return rates
+	}
+
+	for source, rs := range relos {
+		for _, r := range rs {
+			size, _ := pmm.Size(r.partition)
+
+			s := rates[source]
+			s.Leader += size / seconds
+			rates[source] = s
+
+			d := rates[r.destination]
+			d.Follower += size / seconds
+			rates[r.destination] = d
+		}
+	}
+
+	return rates
+}
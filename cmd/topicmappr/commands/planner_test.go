@@ -0,0 +1,771 @@
+package commands
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+)
+
+func TestDeferConsumed(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pm, _ := zk.GetPartitionMap("test_topic")
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	lag, _ := zk.GetConsumerLag("test_topic")
+	consumerLag := consumerLagMap{"test_topic": lag}
+
+	// Partitions by size descending: p3, p2, p1, p0.
+	pm.Partitions.SortBySize(pmm)
+
+	// With a threshold of 5000, p1 (120000) and p3 (8000) are deferred and
+	// ordered by lag ascending.
+	deferConsumed(pm.Partitions, consumerLag, 5000)
+
+	expected := []int{2, 0, 3, 1}
+	for i, p := range pm.Partitions {
+		if p.Partition != expected[i] {
+			t.Errorf("Expected partition %d at position %d, got %d", expected[i], i, p.Partition)
+		}
+	}
+
+	// Without lag data, the order is unchanged.
+	pm.Partitions.SortBySize(pmm)
+	deferConsumed(pm.Partitions, consumerLagMap{}, 5000)
+
+	expected = []int{3, 2, 1, 0}
+	for i, p := range pm.Partitions {
+		if p.Partition != expected[i] {
+			t.Errorf("Expected partition %d at position %d, got %d", expected[i], i, p.Partition)
+		}
+	}
+}
+
+func TestAsymmetricTolerance(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	// All test_topic partitions are held by 1001.
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1001]}]}`)
+
+	// The mean storage free is 4000.
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 7000},
+	}
+
+	tests := []struct {
+		tolerance, source, destination float64
+		expected                       int
+	}{
+		// Symmetric; only p3 (2500) can be moved.
+		{tolerance: 0.10, expected: 1},
+		// A loose source limit alone is bound by the destination limit.
+		{tolerance: 0.10, source: 0.50, expected: 1},
+		// A loose destination limit alone is bound by the source limit.
+		{tolerance: 0.10, destination: 0.60, expected: 1},
+		// Loose limits for both allows p3 and p2 (2000) to be moved.
+		{source: 0.50, destination: 0.60, expected: 2},
+	}
+
+	for i, test := range tests {
+		params := computeReassignmentBundlesParams{
+			offloadTargets:       []int{1001},
+			tolerance:            test.tolerance,
+			sourceTolerance:      test.source,
+			destinationTolerance: test.destination,
+			partitionMap:         pm,
+			partitionMeta:        pmm,
+			brokerMap:            bm,
+			partitionLimit:       30,
+			localityScoped:       true,
+		}
+
+		var bundles int
+		for b := range computeReassignmentBundles(params) {
+			bundles++
+			if n := len(b.relocations[1001]); n != test.expected {
+				t.Errorf("[test %d] Expected %d relocations, got %d", i, test.expected, n)
+			}
+		}
+
+		if bundles != 1 {
+			t.Errorf("[test %d] Expected 1 reassignment bundle, got %d", i, bundles)
+		}
+	}
+}
+
+func TestStdDevTarget(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1001]}]}`)
+
+	// The storage free std. deviation is 3000.
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 7000},
+	}
+
+	tests := []struct {
+		target   float64
+		expected int
+	}{
+		// p3 (2500) and p2 (2000) are moved without a target.
+		{target: 0, expected: 2},
+		// Moving p3 leaves a std. deviation of 500.
+		{target: 1000, expected: 1},
+		// The skew is already within the target.
+		{target: 3000, expected: 0},
+	}
+
+	for i, test := range tests {
+		params := computeReassignmentBundlesParams{
+			offloadTargets:       []int{1001},
+			sourceTolerance:      0.50,
+			destinationTolerance: 0.60,
+			partitionMap:         pm,
+			partitionMeta:        pmm,
+			brokerMap:            bm,
+			partitionLimit:       30,
+			localityScoped:       true,
+			stdDevTarget:         test.target,
+		}
+
+		for b := range computeReassignmentBundles(params) {
+			if n := len(b.relocations[1001]); n != test.expected {
+				t.Errorf("[test %d] Expected %d relocations, got %d", i, test.expected, n)
+			}
+		}
+	}
+}
+
+func TestBestFit(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	lag, _ := zk.GetConsumerLag("test_topic")
+	consumerLag := consumerLagMap{"test_topic": lag}
+
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1001]}]}`)
+
+	// The mean storage free is 4000.
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 7000},
+	}
+
+	for _, bestFit := range []bool{false, true} {
+		// Deferring p3 (2500) for consumer lag leaves p2 (2000)
+		// as the first feasible relocation.
+		params := planRelocationsForBrokerParams{
+			relos:              map[int][]relocation{},
+			mappings:           pm.Mappings(),
+			brokers:            bm.Copy(),
+			partitionMeta:      pmm,
+			plan:               relocationPlan{},
+			topPartitionsLimit: 30,
+			offloadTargetsMap:  map[int]struct{}{1001: {}},
+			tolerance:          0.20,
+			localityScoped:     true,
+			consumerLag:        consumerLag,
+			lagThreshold:       5000,
+			bestFit:            bestFit,
+			sourceID:           1001,
+		}
+
+		if n := planRelocationsForBroker(params); n != 1 {
+			t.Fatalf("[best fit %v] Expected 1 relocation, got %d", bestFit, n)
+		}
+
+		// Best fit selects the larger p3, leaving
+		// 1001 with 3500 rather than 3000 free.
+		expected := 2
+		if bestFit {
+			expected = 3
+		}
+
+		if p := params.relos[1001][0].partition.Partition; p != expected {
+			t.Errorf("[best fit %v] Expected relocation of p%d, got p%d", bestFit, expected, p)
+		}
+	}
+}
+
+func TestStorageSnapshotPostPlan(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1001]}]}`)
+
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 7000},
+	}
+
+	params := planRelocationsForBrokerParams{
+		relos:              map[int][]relocation{},
+		mappings:           pm.Mappings(),
+		brokers:            bm.Copy(),
+		partitionMeta:      pmm,
+		plan:               relocationPlan{},
+		topPartitionsLimit: 30,
+		offloadTargetsMap:  map[int]struct{}{1001: {}},
+		tolerance:          0.20,
+		localityScoped:     true,
+		sourceID:           1001,
+	}
+
+	before := params.brokers.StorageSnapshot()
+
+	if n := planRelocationsForBroker(params); n != 1 {
+		t.Fatalf("Expected 1 relocation, got %d", n)
+	}
+
+	after := params.brokers.StorageSnapshot()
+
+	// p3 (2500) is relocated from 1001 to 1002.
+	expectedBefore := map[int]float64{1001: 1000, 1002: 7000}
+	expectedAfter := map[int]float64{1001: 3500, 1002: 4500}
+
+	for id := range expectedAfter {
+		if before[id] != expectedBefore[id] {
+			t.Errorf("Expected broker %d storage free %.2f before, got %.2f", id, expectedBefore[id], before[id])
+		}
+		if after[id] != expectedAfter[id] {
+			t.Errorf("Expected broker %d storage free %.2f after, got %.2f", id, expectedAfter[id], after[id])
+		}
+	}
+
+	if m := params.brokers.Mean(); m != 4000 {
+		t.Errorf("Expected mean 4000, got %.2f", m)
+	}
+
+	if sd := params.brokers.StorageStdDev(); sd != 500 {
+		t.Errorf("Expected std. deviation 500, got %.2f", sd)
+	}
+}
+
+func TestCapacityWeighted(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1001]}]}`)
+
+	// 1001 is 85% used, 1002 15% and 1003 50%; the mean is 50%.
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1500, StorageTotal: 10000},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 3400, StorageTotal: 4000},
+		1003: &kafkazk.Broker{ID: 1003, Locality: "a", StorageFree: 500, StorageTotal: 1000},
+	}
+
+	for _, weighted := range []bool{false, true} {
+		params := planRelocationsForBrokerParams{
+			relos:              map[int][]relocation{},
+			mappings:           pm.Mappings(),
+			brokers:            bm.Copy(),
+			partitionMeta:      pmm,
+			plan:               relocationPlan{},
+			topPartitionsLimit: 30,
+			offloadTargetsMap:  map[int]struct{}{1001: {}},
+			tolerance:          0.10,
+			localityScoped:     true,
+			capacityWeighted:   weighted,
+			sourceID:           1001,
+		}
+
+		n := planRelocationsForBroker(params)
+
+		// By storage free, 1001 is already near the mean (1800)
+		// and any move exceeds the source limit.
+		if !weighted {
+			if n != 0 {
+				t.Errorf("Expected 0 relocations, got %d", n)
+			}
+			continue
+		}
+
+		// By percent used, 1001 (target 5000 free) offloads to the least used
+		// 1002 (target 2000 free); p1 (1500) is the largest partition that
+		// keeps 1002 above its limit of 1800.
+		if n != 1 {
+			t.Fatalf("Expected 1 relocation, got %d", n)
+		}
+
+		if r := params.relos[1001][0]; r.partition.Partition != 1 || r.destination != 1002 {
+			t.Errorf("Expected relocation of p1 to 1002, got p%d to %d", r.partition.Partition, r.destination)
+		}
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1001]}]}`)
+
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "b", StorageFree: 6000},
+		1003: &kafkazk.Broker{ID: 1003, Locality: "c", StorageFree: 6000},
+	}
+
+	windows := maintenanceWindows{
+		"b": "Mon 02:00-04:00 UTC",
+		"c": "Tue 02:00-04:00 UTC",
+	}
+
+	params := computeReassignmentBundlesParams{
+		offloadTargets: []int{1001},
+		tolerance:      0.90,
+		partitionMap:   pm,
+		partitionMeta:  pmm,
+		brokerMap:      bm,
+		partitionLimit: 30,
+		windows:        windows,
+	}
+
+	b := <-computeReassignmentBundles(params)
+	relos := b.relocations[1001]
+
+	if len(relos) < 2 {
+		t.Fatalf("Expected at least 2 relocations, got %d", len(relos))
+	}
+
+	for _, r := range relos {
+		expected := windows[bm[r.destination].Locality]
+		if r.window != expected {
+			t.Errorf("Expected window '%s' for %s p%d -> %d, got '%s'",
+				expected, r.partition.Topic, r.partition.Partition, r.destination, r.window)
+		}
+	}
+
+	// The window is included in the plan output.
+	out, _ := relocationPlanJSON([]int{1001}, b.relocations)
+
+	var plan []plannedRelocation
+	if err := json.Unmarshal(out, &plan); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan) != len(relos) {
+		t.Fatalf("Expected %d planned relocations, got %d", len(relos), len(plan))
+	}
+
+	for i, p := range plan {
+		if p.Source != 1001 || p.Destination != relos[i].destination || p.Window != relos[i].window {
+			t.Errorf("Unexpected planned relocation %+v", p)
+		}
+	}
+}
+
+func TestPinnedTopics(t *testing.T) {
+	pmm := kafkazk.PartitionMetaMap{
+		"__internal": {
+			0: &kafkazk.PartitionMeta{Size: 3000},
+			1: &kafkazk.PartitionMeta{Size: 3000},
+		},
+		"test_topic": {
+			0: &kafkazk.PartitionMeta{Size: 1000},
+			1: &kafkazk.PartitionMeta{Size: 1000},
+		},
+	}
+
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"__internal","partition":0,"replicas":[1001]},
+		{"topic":"__internal","partition":1,"replicas":[1001]},
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1001]}]}`)
+
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "b", StorageFree: 9000},
+	}
+
+	// A partition limit of 1 ensures that the larger pinned partitions
+	// don't displace eligible candidates.
+	params := computeReassignmentBundlesParams{
+		offloadTargets: []int{1001},
+		tolerance:      0.90,
+		partitionMap:   pm,
+		partitionMeta:  pmm,
+		brokerMap:      bm,
+		partitionLimit: 1,
+		pinnedTopics:   topicRegex("__.*"),
+	}
+
+	b := <-computeReassignmentBundles(params)
+
+	if len(b.relocations[1001]) == 0 {
+		t.Fatal("Expected relocations for test_topic")
+	}
+
+	for _, r := range b.relocations[1001] {
+		if r.partition.Topic == "__internal" {
+			t.Errorf("Unexpected relocation of pinned topic p%d", r.partition.Partition)
+		}
+	}
+
+	// Excluded topics remain on their original brokers.
+	for _, p := range b.partitionMap.Partitions {
+		if p.Topic == "__internal" && (len(p.Replicas) != 1 || p.Replicas[0] != 1001) {
+			t.Errorf("Expected __internal p%d on 1001, got %v", p.Partition, p.Replicas)
+		}
+	}
+}
+
+func TestPartitionSizeThreshold(t *testing.T) {
+	mb := float64(1 << 20)
+	pmm := kafkazk.PartitionMetaMap{
+		"test_topic": {
+			0: &kafkazk.PartitionMeta{Size: 2048 * mb},
+			1: &kafkazk.PartitionMeta{Size: 2048 * mb},
+			2: &kafkazk.PartitionMeta{Size: 4 * mb},
+			3: &kafkazk.PartitionMeta{Size: 4 * mb},
+		},
+	}
+
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1024 * mb},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 16384 * mb},
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected int
+	}{
+		{
+			name: "mixed",
+			input: `{"version":1,"partitions":[
+				{"topic":"test_topic","partition":0,"replicas":[1001]},
+				{"topic":"test_topic","partition":1,"replicas":[1001]},
+				{"topic":"test_topic","partition":2,"replicas":[1001]},
+				{"topic":"test_topic","partition":3,"replicas":[1001]}]}`,
+			expected: 1,
+		},
+		{
+			name: "all below threshold",
+			input: `{"version":1,"partitions":[
+				{"topic":"test_topic","partition":2,"replicas":[1001]},
+				{"topic":"test_topic","partition":3,"replicas":[1001]}]}`,
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		pm, _ := kafkazk.PartitionMapFromString(test.input)
+
+		params := planRelocationsForBrokerParams{
+			relos:                  map[int][]relocation{},
+			mappings:               pm.Mappings(),
+			brokers:                bm.Copy(),
+			partitionMeta:          pmm,
+			plan:                   relocationPlan{},
+			topPartitionsLimit:     30,
+			partitionSizeThreshold: 512,
+			offloadTargetsMap:      map[int]struct{}{1001: {}},
+			tolerance:              0.20,
+			localityScoped:         true,
+			sourceID:               1001,
+		}
+
+		if n := planRelocationsForBroker(params); n != test.expected {
+			t.Errorf("[%s] Expected %d relocations, got %d", test.name, test.expected, n)
+		}
+
+		// Partitions below the threshold are never relocated.
+		for _, r := range params.relos[1001] {
+			if r.partition.Partition > 1 {
+				t.Errorf("[%s] Unexpected relocation of sub-threshold p%d", test.name, r.partition.Partition)
+			}
+		}
+
+		if test.expected == 0 && len(params.relos) != 0 {
+			t.Errorf("[%s] Expected no planned relocations, got %v", test.name, params.relos)
+		}
+	}
+}
+
+func TestStuckPartitions(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1001]}]}`)
+
+	tests := []struct {
+		brokers  kafkazk.BrokerMap
+		reason   string
+		expected []int
+	}{
+		// No brokers in the source locality.
+		{
+			brokers: kafkazk.BrokerMap{
+				1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+				1002: &kafkazk.Broker{ID: 1002, Locality: "b", StorageFree: 7000},
+			},
+			reason:   stuckNoDestination,
+			expected: []int{0, 1, 2, 3},
+		},
+		// p3 (2500) is moved; all others push the source above the
+		// tolerated threshold.
+		{
+			brokers: kafkazk.BrokerMap{
+				1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+				1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 7000},
+			},
+			reason:   stuckSourceLimit,
+			expected: []int{0, 1, 2},
+		},
+	}
+
+	for i, test := range tests {
+		params := computeReassignmentBundlesParams{
+			offloadTargets: []int{1001},
+			tolerance:      0.10,
+			partitionMap:   pm,
+			partitionMeta:  pmm,
+			brokerMap:      test.brokers,
+			partitionLimit: 30,
+			localityScoped: true,
+		}
+
+		b := <-computeReassignmentBundles(params)
+
+		if len(b.stuck) != len(test.expected) {
+			t.Fatalf("[test %d] Expected %d stuck partitions, got %d", i, len(test.expected), len(b.stuck))
+		}
+
+		for j, s := range b.stuck {
+			if s.partition.Partition != test.expected[j] || s.source != 1001 {
+				t.Errorf("[test %d] Unexpected stuck partition %s p%d on %d",
+					i, s.partition.Topic, s.partition.Partition, s.source)
+			}
+			if s.reason != test.reason {
+				t.Errorf("[test %d] Expected reason '%s', got '%s'", i, test.reason, s.reason)
+			}
+			if s.attempts < 1 {
+				t.Errorf("[test %d] Expected at least 1 attempt, got %d", i, s.attempts)
+			}
+		}
+	}
+}
+
+func TestPlanUnknownPartitionSize(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	// p9 isn't in the partition metadata.
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":9,"replicas":[1001]}]}`)
+
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 7000},
+	}
+
+	params := planRelocationsForBrokerParams{
+		relos:              map[int][]relocation{},
+		mappings:           pm.Mappings(),
+		brokers:            bm.Copy(),
+		partitionMeta:      pmm,
+		plan:               relocationPlan{},
+		topPartitionsLimit: 30,
+		offloadTargetsMap:  map[int]struct{}{1001: {}},
+		tolerance:          0.90,
+		localityScoped:     true,
+		stuck:              stuckPartitions{},
+		sourceID:           1001,
+	}
+
+	if n := planRelocationsForBroker(params); n != 1 {
+		t.Fatalf("Expected 1 relocation, got %d", n)
+	}
+
+	// p0 (1000) is moved from 1001 to 1002.
+	if r := params.relos[1001][0]; r.partition.Partition != 0 || r.destination != 1002 {
+		t.Errorf("Unexpected relocation of p%d to %d", r.partition.Partition, r.destination)
+	}
+
+	if f1, f2 := params.brokers[1001].StorageFree, params.brokers[1002].StorageFree; f1 != 2000 || f2 != 6000 {
+		t.Errorf("Expected storage free of 2000 and 6000, got %.2f and %.2f", f1, f2)
+	}
+
+	// The remaining p9 can't be planned.
+	if n := planRelocationsForBroker(params); n != 0 {
+		t.Fatalf("Expected 0 relocations, got %d", n)
+	}
+
+	stuck := params.stuck.list()
+	if len(stuck) != 1 || stuck[0].partition.Partition != 9 || stuck[0].reason != stuckUnknownSize {
+		t.Errorf("Expected p9 stuck with an unknown size, got %+v", stuck)
+	}
+}
+
+func TestEstimateDuration(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	relos := map[int][]relocation{
+		1001: {
+			{partition: kafkazk.Partition{Topic: "test_topic", Partition: 0}, destination: 1003},
+			{partition: kafkazk.Partition{Topic: "test_topic", Partition: 1}, destination: 1002},
+		},
+	}
+
+	// No throttles.
+	if _, bound := estimateDuration(relos, pmm, nil); bound {
+		t.Error("Expected an unbound estimate without throttles")
+	}
+
+	// 1001 sends 2500B at 100B/s; 1002
+	// receives 1500B at 10B/s.
+	rates := map[int]kafkazk.ThrottleRate{
+		1001: {Leader: 100},
+		1002: {Follower: 10},
+	}
+
+	d, bound := estimateDuration(relos, pmm, rates)
+	if !bound {
+		t.Fatal("Expected a throttle bound estimate")
+	}
+
+	if d != 150*time.Second {
+		t.Errorf("Expected duration 2m30s, got %s", d)
+	}
+
+	// Lifting the 1002 throttle leaves
+	// 1001 as the bottleneck.
+	delete(rates, 1002)
+
+	if d, _ := estimateDuration(relos, pmm, rates); d != 25*time.Second {
+		t.Errorf("Expected duration 25s, got %s", d)
+	}
+}
+
+func TestRecommendThrottles(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	relos := map[int][]relocation{
+		1001: {
+			{partition: kafkazk.Partition{Topic: "test_topic", Partition: 0}, destination: 1003},
+			{partition: kafkazk.Partition{Topic: "test_topic", Partition: 1}, destination: 1002},
+		},
+	}
+
+	// 1001 sends 2500B, 1002 receives 1500B
+	// and 1003 receives 1000B in 10s.
+	rates := recommendThrottles(relos, pmm, 10*time.Second)
+
+	expected := map[int]kafkazk.ThrottleRate{
+		1001: {Leader: 250},
+		1002: {Follower: 150},
+		1003: {Follower: 100},
+	}
+
+	if len(rates) != len(expected) {
+		t.Fatalf("Expected %d broker rates, got %d", len(expected), len(rates))
+	}
+
+	for id, r := range expected {
+		if rates[id] != r {
+			t.Errorf("Expected broker %d rates %+v, got %+v", id, r, rates[id])
+		}
+	}
+
+	// The recommended rates complete the relocations in the target time.
+	if d, _ := estimateDuration(relos, pmm, rates); d != 10*time.Second {
+		t.Errorf("Expected duration 10s, got %s", d)
+	}
+
+	if r := recommendThrottles(relos, pmm, 0); len(r) != 0 {
+		t.Errorf("Expected no rates without a target, got %v", r)
+	}
+}
+
+func TestMoveBudgets(t *testing.T) {
+	pm, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001]},
+		{"topic":"test_topic2","partition":0,"replicas":[1001]},
+		{"topic":"test_topic2","partition":1,"replicas":[1001]},
+		{"topic":"test_topic2","partition":2,"replicas":[1001]}]}`)
+
+	pmm := kafkazk.NewPartitionMetaMap()
+	for _, p := range pm.Partitions {
+		if _, exists := pmm[p.Topic]; !exists {
+			pmm[p.Topic] = map[int]*kafkazk.PartitionMeta{}
+		}
+		pmm[p.Topic][p.Partition] = &kafkazk.PartitionMeta{Size: 1000}
+	}
+
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a", StorageFree: 1000},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "a", StorageFree: 30000},
+	}
+
+	params := computeReassignmentBundlesParams{
+		offloadTargets: []int{1001},
+		tolerance:      0.90,
+		partitionMap:   pm,
+		partitionMeta:  pmm,
+		brokerMap:      bm,
+		partitionLimit: 30,
+		localityScoped: true,
+		budgets:        moveBudgets{"test_topic": 1},
+	}
+
+	b := <-computeReassignmentBundles(params)
+	moves := topicMoves(b.relocations)
+
+	if moves["test_topic"] != 1 {
+		t.Errorf("Expected 1 test_topic move, got %d", moves["test_topic"])
+	}
+
+	if moves["test_topic2"] != 3 {
+		t.Errorf("Expected 3 test_topic2 moves, got %d", moves["test_topic2"])
+	}
+
+	// The remaining test_topic partitions are stuck on the budget.
+	var stuck int
+	for _, s := range b.stuck {
+		if s.partition.Topic == "test_topic" && s.reason == stuckMoveBudget {
+			stuck++
+		}
+	}
+
+	if stuck != 2 {
+		t.Errorf("Expected 2 test_topic partitions stuck on the move budget, got %d", stuck)
+	}
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"regexp"
 	"sync"
 
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
@@ -22,11 +23,15 @@ type reassignmentBundle struct {
 	relocations map[int][]relocation
 	// The brokers that the PartitionMap is assigning brokers to.
 	brokers kafkazk.BrokerMap
+	// Partitions that couldn't be relocated from offload targets.
+	stuck []stuckPartition
 }
 
 type computeReassignmentBundlesParams struct {
 	offloadTargets         []int
 	tolerance              float64
+	sourceTolerance        float64
+	destinationTolerance   float64
 	partitionMap           *kafkazk.PartitionMap
 	partitionMeta          kafkazk.PartitionMetaMap
 	brokerMap              kafkazk.BrokerMap
@@ -34,6 +39,14 @@ type computeReassignmentBundlesParams struct {
 	partitionSizeThreshold int
 	localityScoped         bool
 	verbose                bool
+	consumerLag            consumerLagMap
+	lagThreshold           int64
+	windows                maintenanceWindows
+	budgets                moveBudgets
+	stdDevTarget           float64
+	bestFit                bool
+	pinnedTopics           []*regexp.Regexp
+	capacityWeighted       bool
 }
 
 // computeReassignmentBundles takes computeReassignmentBundlesParams and returns
@@ -41,6 +54,9 @@ type computeReassignmentBundlesParams struct {
 // if a fixed computeReassignmentBundlesParams.tolerance value (non 0.00) is
 // specified, otherwise it will contain a series of reassignmentBundle for multiple
 // interval values. When generating a series, the results are computed in parallel.
+// The optional sourceTolerance and destinationTolerance values override the
+// tolerance for the source upper limit and destination lower limit, respectively;
+// if both are set, the output is a single reassignmentBundle.
 func computeReassignmentBundles(params computeReassignmentBundlesParams) chan reassignmentBundle {
 	otm := map[int]struct{}{}
 	for _, id := range params.offloadTargets {
@@ -57,11 +73,14 @@ func computeReassignmentBundles(params computeReassignmentBundlesParams) chan re
 		var tol float64
 		var fixedTolerance bool
 
-		if params.tolerance == 0.00 {
-			tol = i
-		} else {
+		switch {
+		case params.tolerance != 0.00:
 			tol = params.tolerance
 			fixedTolerance = true
+		case params.sourceTolerance != 0.00 && params.destinationTolerance != 0.00:
+			fixedTolerance = true
+		default:
+			tol = i
 		}
 
 		wg.Add(1)
@@ -82,8 +101,19 @@ func computeReassignmentBundles(params computeReassignmentBundlesParams) chan re
 				partitionSizeThreshold: params.partitionSizeThreshold,
 				offloadTargetsMap:      otm,
 				tolerance:              tol,
+				sourceTolerance:        params.sourceTolerance,
+				destinationTolerance:   params.destinationTolerance,
 				localityScoped:         params.localityScoped,
 				verbose:                params.verbose,
+				consumerLag:            params.consumerLag,
+				lagThreshold:           params.lagThreshold,
+				windows:                params.windows,
+				budgets:                params.budgets,
+				stdDevTarget:           params.stdDevTarget,
+				bestFit:                params.bestFit,
+				pinnedTopics:           params.pinnedTopics,
+				capacityWeighted:       params.capacityWeighted,
+				stuck:                  stuckPartitions{},
 			}
 
 			// Iterate over offload targets, planning at most one relocation per iteration.
@@ -114,6 +144,7 @@ func computeReassignmentBundles(params computeReassignmentBundlesParams) chan re
 				partitionMap: partitionMap,
 				relocations:  relocationParams.relos,
 				brokers:      relocationParams.brokers,
+				stuck:        relocationParams.stuck.list(),
 			}
 
 		}()
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"regexp"
 	"sort"
 
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
@@ -22,20 +23,41 @@ func init() {
 	rootCmd.AddCommand(rebalanceCmd)
 
 	rebalanceCmd.Flags().String("topics", "", "Rebuild topics (comma delim. list) by lookup in ZooKeeper")
-	rebalanceCmd.Flags().String("topics-exclude", "", "Exclude topics")
+	rebalanceCmd.Flags().String("topics-exclude", "", "Exclude topics (removed from the output map; see --pin-topics to keep topics in place)")
+	rebalanceCmd.Flags().String("pin-topics", "", "Topics (comma delim. list, regex supported) that remain in the map but are never selected for relocation (see --topics-exclude to remove topics from the map)")
 	rebalanceCmd.Flags().String("out-path", "", "Path to write output map files to")
 	rebalanceCmd.Flags().String("out-file", "", "If defined, write a combined map of all topics to a file")
 	rebalanceCmd.Flags().String("brokers", "", "Broker list to scope all partition placements to ('-1' for all currently mapped brokers, '-2' for all brokers in cluster)")
 	rebalanceCmd.Flags().Float64("storage-threshold", 0.20, "Percent below the harmonic mean storage free to target for partition offload (0 targets a brokers)")
 	rebalanceCmd.Flags().Float64("storage-threshold-gb", 0.00, "Storage free in gigabytes to target for partition offload (those below the specified value); 0 [default] defers target selection to --storage-threshold")
 	rebalanceCmd.Flags().Float64("tolerance", 0.0, "Percent distance from the mean storage free to limit storage scheduling (0 performs automatic tolerance selection)")
+	rebalanceCmd.Flags().Float64("source-tolerance", 0.0, "Percent distance above the mean storage free to limit source broker offloading (0 defers to --tolerance)")
+	rebalanceCmd.Flags().Float64("destination-tolerance", 0.0, "Percent distance below the mean storage free to limit destination broker filling (0 defers to --tolerance)")
 	rebalanceCmd.Flags().Int("partition-limit", 30, "Limit the number of top partitions by size eligible for relocation per broker")
 	rebalanceCmd.Flags().Int("partition-size-threshold", 512, "Size in megabytes where partitions below this value will not be moved in a rebalance")
 	rebalanceCmd.Flags().Bool("locality-scoped", false, "Ensure that all partition movements are scoped by rack.id")
+	rebalanceCmd.Flags().String("rack-map", "", "Path to a JSON file mapping broker IDs to rack IDs (e.g. availability zones from instance tags), used for brokers without a broker.rack")
+	rebalanceCmd.Flags().Bool("rack-map-override", false, "Override existing broker.rack values with those in the --rack-map")
 	rebalanceCmd.Flags().Bool("verbose", false, "Verbose output")
 	rebalanceCmd.Flags().String("zk-metrics-prefix", "topicmappr", "ZooKeeper namespace prefix for Kafka metrics")
+	rebalanceCmd.Flags().String("partition-meta-file", "", "Path to a JSON file of partition metadata (partition sizes) to use in place of the metadata in ZooKeeper")
 	rebalanceCmd.Flags().Int("metrics-age", 60, "Kafka metrics age tolerance (in minutes)")
+	rebalanceCmd.Flags().Bool("write-sizes", false, "Write a sidecar file with the size of each partition alongside each output map")
 	rebalanceCmd.Flags().Bool("optimize-leadership", false, "Rebalance all broker leader/follower ratios")
+	rebalanceCmd.Flags().Bool("optimize-leadership-size", false, "Rebalance the sum of leader partition sizes across brokers")
+	rebalanceCmd.Flags().String("maintenance-windows", "", "Path to a JSON file mapping rack IDs to maintenance windows; relocations are annotated with the destination rack's window and written to a relocation plan file")
+	rebalanceCmd.Flags().String("move-budgets", "", "Path to a JSON file mapping topics to the maximum number of partitions that may be relocated; topics without a budget aren't limited")
+	rebalanceCmd.Flags().Bool("plan-markdown", false, "Write planned relocations as a Markdown table to a relocation plan file")
+	rebalanceCmd.Flags().Bool("audit-log", false, "Write a JSON lines audit record of each planned relocation, with broker storage and threshold values, to a relocation audit file")
+	rebalanceCmd.Flags().Duration("throttle-target", 0, "Print the per broker replication throttle rates needed to complete the planned relocations within this duration, e.g. 4h (0 disables)")
+	rebalanceCmd.Flags().Duration("duration-window", 0, "Warn if the estimated relocation duration at current broker throttle rates exceeds this window, e.g. 4h (0 disables)")
+	rebalanceCmd.Flags().Float64("storage-stddev-target", 0.0, "Storage free standard deviation in gigabytes across brokers at which to stop planning relocations, avoiding moves with marginal gains (0 disables)")
+	rebalanceCmd.Flags().Bool("capacity-weighted", false, "Balance brokers of differing storage capacity by percent storage used rather than absolute storage free; requires StorageTotal values in broker metrics (see the metricsfetcher -broker-storage-total-query flag)")
+	rebalanceCmd.Flags().Bool("storage-forecast", false, "Print the projected free storage per broker, with the mean and standard deviation, after applying the planned relocations")
+	rebalanceCmd.Flags().Bool("best-fit", false, "Evaluate all top partitions of each broker per pass and plan the relocation leaving the broker closest to the mean storage free, rather than the first that fits")
+	rebalanceCmd.Flags().Int64("defer-lag-threshold", 0, "Consumer lag at or above which partitions are deferred in relocation ordering (0 disables)")
+	rebalanceCmd.Flags().String("bootstrap-servers", "", "Kafka bootstrap servers used to fetch consumer group offsets for --defer-lag-threshold")
+	rebalanceCmd.Flags().String("consumer-groups", "", "Consumer groups (comma delim. list) whose lag is considered for --defer-lag-threshold")
 
 	// Required.
 	rebalanceCmd.MarkFlagRequired("brokers")
@@ -55,7 +77,6 @@ func rebalance(cmd *cobra.Command, _ []string) {
 	defer zk.Close()
 
 	// Get broker and partition metadata.
-	checkMetaAge(cmd, zk)
 	brokerMeta := getBrokerMeta(cmd, zk, true)
 	partitionMeta := getPartitionMeta(cmd, zk)
 
@@ -85,18 +106,47 @@ func rebalance(cmd *cobra.Command, _ []string) {
 	// partition offloading.
 	offloadTargets := validateBrokersForRebalance(cmd, brokersIn, brokerMeta)
 
+	// Check the metrics age of participating brokers.
+	checkMetaAge(cmd, zk, participatingBrokers(brokersIn), brokerMeta)
+
 	// Sort offloadTargets by storage free ascending.
 	sort.Sort(offloadTargetsBySize{t: offloadTargets, bm: brokersIn})
 
 	partitionLimit, _ := cmd.Flags().GetInt("partition-limit")
 	partitionSizeThreshold, _ := cmd.Flags().GetInt("partition-size-threshold")
 	tolerance, _ := cmd.Flags().GetFloat64("tolerance")
+	sourceTolerance, _ := cmd.Flags().GetFloat64("source-tolerance")
+	destinationTolerance, _ := cmd.Flags().GetFloat64("destination-tolerance")
 	localityScoped, _ := cmd.Flags().GetBool("locality-scoped")
 	verbose, _ := cmd.Flags().GetBool("verbose")
+	lagThreshold, _ := cmd.Flags().GetInt64("defer-lag-threshold")
+	stdDevTarget, _ := cmd.Flags().GetFloat64("storage-stddev-target")
+	bestFit, _ := cmd.Flags().GetBool("best-fit")
+	capacityWeighted, _ := cmd.Flags().GetBool("capacity-weighted")
+
+	// Get any topics that are never relocated.
+	var pinnedTopics []*regexp.Regexp
+	if s, _ := cmd.Flags().GetString("pin-topics"); s != "" {
+		pinnedTopics = topicRegex(s)
+	}
+
+	// Fetch consumer lag if we're deferring actively consumed partitions.
+	var consumerLag consumerLagMap
+	if lagThreshold > 0 {
+		consumerLag = getConsumerLag(partitionMapIn, zk)
+	}
+
+	// Get any rack maintenance windows.
+	windows := getMaintenanceWindows(cmd)
+
+	// Get any per-topic move budgets.
+	budgets := getMoveBudgets(cmd)
 
 	params := computeReassignmentBundlesParams{
 		offloadTargets:         offloadTargets,
 		tolerance:              tolerance,
+		sourceTolerance:        sourceTolerance,
+		destinationTolerance:   destinationTolerance,
 		partitionMap:           partitionMapIn,
 		partitionMeta:          partitionMeta,
 		brokerMap:              brokersIn,
@@ -104,8 +154,19 @@ func rebalance(cmd *cobra.Command, _ []string) {
 		partitionSizeThreshold: partitionSizeThreshold,
 		localityScoped:         localityScoped,
 		verbose:                verbose,
+		consumerLag:            consumerLag,
+		lagThreshold:           lagThreshold,
+		windows:                windows,
+		budgets:                budgets,
+		stdDevTarget:           stdDevTarget * div,
+		bestFit:                bestFit,
+		pinnedTopics:           pinnedTopics,
+		capacityWeighted:       capacityWeighted,
 	}
 
+	// Snapshot broker storage ahead of planning.
+	storageBefore := brokersIn.StorageSnapshot()
+
 	// Generate reassignmentBundles for a rebalance.
 	results := computeReassignmentBundles(params)
 
@@ -139,9 +200,33 @@ func rebalance(cmd *cobra.Command, _ []string) {
 		partitionMapOut.OptimizeLeaderFollower()
 	}
 
+	if t, _ := cmd.Flags().GetBool("optimize-leadership-size"); t {
+		if err := partitionMapOut.LeaderOptimization(partitionMeta); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	// Print planned relocations.
 	printPlannedRelocations(offloadTargets, relos, partitionMeta)
 
+	// Print the estimated duration at any existing throttle rates.
+	printDurationEstimate(cmd, relos, partitionMeta, getThrottleRates(zk))
+
+	// Print recommended throttles for a target completion time.
+	printThrottleRecommendations(cmd, relos, partitionMeta)
+
+	// Print per-topic moves against budgets.
+	printMoveBudgets(relos, budgets)
+
+	// Print partitions that couldn't be relocated.
+	printStuckPartitions(m.stuck)
+
+	// Print the projected storage free per broker.
+	if sf, _ := cmd.Flags().GetBool("storage-forecast"); sf {
+		printStorageForecast(storageBefore, brokersOut.StorageSnapshot())
+	}
+
 	// Print map change results.
 	printMapChanges(partitionMapIn, partitionMapOut)
 
@@ -157,6 +242,26 @@ func rebalance(cmd *cobra.Command, _ []string) {
 
 	// Write maps.
 	writeMaps(cmd, partitionMapOut, nil)
+
+	// Write partition sizes if configured.
+	if ws, _ := cmd.Flags().GetBool("write-sizes"); ws {
+		writeMapSizes(cmd, partitionMapOut, partitionMeta)
+	}
+
+	// Write the relocation plan if maintenance windows were provided.
+	if windows != nil {
+		writeRelocationPlan(cmd, offloadTargets, relos)
+	}
+
+	// Write the Markdown relocation plan if requested.
+	if md, _ := cmd.Flags().GetBool("plan-markdown"); md {
+		writePlanMarkdown(cmd, offloadTargets, relos, partitionMeta)
+	}
+
+	// Write the relocation audit log if requested.
+	if al, _ := cmd.Flags().GetBool("audit-log"); al {
+		writeRelocationAudit(cmd, offloadTargets, relos)
+	}
 }
 
 func validateBrokersForRebalance(cmd *cobra.Command, brokers kafkazk.BrokerMap, bm kafkazk.BrokerMetaMap) []int {
@@ -191,6 +296,17 @@ func validateBrokersForRebalance(cmd *cobra.Command, brokers kafkazk.BrokerMap,
 
 	st, _ := cmd.Flags().GetFloat64("storage-threshold")
 	stg, _ := cmd.Flags().GetFloat64("storage-threshold-gb")
+	capacityWeighted, _ := cmd.Flags().GetBool("capacity-weighted")
+
+	// Capacity weighting requires the storage total for all brokers.
+	if capacityWeighted {
+		for _, b := range brokers.Filter(kafkazk.AllBrokersFn) {
+			if b.StorageTotal <= 0 {
+				fmt.Printf("%s[ERROR] --capacity-weighted requires storage totals; none found for broker %d\n", indent, b.ID)
+				os.Exit(1)
+			}
+		}
+	}
 
 	var selectorMethod bytes.Buffer
 	selectorMethod.WriteString("Brokers targeted for partition offloading ")
@@ -198,7 +314,8 @@ func validateBrokersForRebalance(cmd *cobra.Command, brokers kafkazk.BrokerMap,
 	var offloadTargets []int
 
 	// Switch on the target selection method. If a storage threshold in gigabytes
-	// is specified, prefer this. Otherwise, use the percentage below mean threshold.
+	// is specified, prefer this. Otherwise, use the percentage above mean storage
+	// used threshold if capacity weighted, else the percentage below mean threshold.
 	switch {
 	case stg > 0.00:
 		selectorMethod.WriteString(fmt.Sprintf("(< %.2fGB storage free)", stg))
@@ -217,6 +334,11 @@ func validateBrokersForRebalance(cmd *cobra.Command, brokers kafkazk.BrokerMap,
 		}
 
 		sort.Ints(offloadTargets)
+	case capacityWeighted && st > 0.00:
+		selectorMethod.WriteString(fmt.Sprintf("(>= %.2f%% threshold above mean percent storage used)", st*100))
+
+		// Find brokers where the storage used ratio is t % above the mean.
+		offloadTargets = brokers.AboveMeanStorageUsed(st)
 	default:
 		selectorMethod.WriteString(fmt.Sprintf("(>= %.2f%% threshold below hmean)", st*100))
 
@@ -17,7 +17,8 @@ target topics and a list of broker IDs to which those topics should be mapped.
 Target topics are provided as a comma delimited list of topic names and/or regex patterns
 via the --topics parameter, which discovers matching topics in ZooKeeper (additionally,
 the --zk-addr and --zk-prefix global flags should be set). Alternatively, a JSON map can be
-provided via the --map-string flag. Target broker IDs are provided via the --broker flag.`,
+provided via the --map-string flag or a kafka-reassign-partitions output file via the
+--from-reassignment flag. Target broker IDs are provided via the --broker flag.`,
 	Run: rebuild,
 }
 
@@ -27,22 +28,36 @@ func init() {
 	rebuildCmd.Flags().String("topics", "", "Rebuild topics (comma delim. list) by lookup in ZooKeeper")
 	rebuildCmd.Flags().String("topics-exclude", "", "Exclude topics")
 	rebuildCmd.Flags().String("map-string", "", "Rebuild a partition map provided as a string literal")
+	rebuildCmd.Flags().String("from-reassignment", "", "Rebuild a partition map from a kafka-reassign-partitions output file")
 	rebuildCmd.Flags().Bool("use-meta", true, "Use broker metadata in placement constraints")
 	rebuildCmd.Flags().String("out-path", "", "Path to write output map files to")
 	rebuildCmd.Flags().String("out-file", "", "If defined, write a combined map of all topics to a file")
 	rebuildCmd.Flags().Bool("force-rebuild", false, "Forces a complete map rebuild")
 	rebuildCmd.Flags().Int("replication", 0, "Normalize the topic replication factor across all replica sets (0 results in a no-op)")
 	rebuildCmd.Flags().Bool("sub-affinity", false, "Replacement broker substitution affinity")
-	rebuildCmd.Flags().String("placement", "count", "Partition placement strategy: [count, storage]")
+	rebuildCmd.Flags().String("placement", "count", "Partition placement strategy: [count, count-rackaware, storage]")
 	rebuildCmd.Flags().Int("min-rack-ids", 0, "Minimum number of required of unique rack IDs per replica set (0 requires that all are unique)")
 	rebuildCmd.Flags().String("optimize", "distribution", "Optimization priority for the storage placement strategy: [distribution, storage]")
 	rebuildCmd.Flags().Float64("partition-size-factor", 1.0, "Factor by which to multiply partition sizes when using storage placement")
 	rebuildCmd.Flags().String("brokers", "", "Broker list to scope all partition placements to ('-1' for all currently mapped brokers, '-2' for all brokers in cluster)")
 	rebuildCmd.Flags().String("zk-metrics-prefix", "topicmappr", "ZooKeeper namespace prefix for Kafka metrics (when using storage placement)")
+	rebuildCmd.Flags().String("partition-meta-file", "", "Path to a JSON file of partition metadata (partition sizes) to use in place of the metadata in ZooKeeper")
 	rebuildCmd.Flags().Int("metrics-age", 60, "Kafka metrics age tolerance (in minutes) (when using storage placement)")
 	rebuildCmd.Flags().Bool("skip-no-ops", false, "Skip no-op partition assigments")
 	rebuildCmd.Flags().Bool("optimize-leadership", false, "Rebalance all broker leader/follower ratios")
+	rebuildCmd.Flags().String("leader-preference", "", "Broker IDs to prefer as leaders, most preferred first; the most preferred broker in each replica set becomes the leader (comma delim. list)")
 	rebuildCmd.Flags().Bool("phased-reassignment", false, "Create two-phase output maps")
+	rebuildCmd.Flags().Bool("instance-groups", false, "Forbid multiple replicas of a partition in the same broker instance group (requires instance group metrics)")
+	rebuildCmd.Flags().String("rack-map", "", "Path to a JSON file mapping broker IDs to rack IDs (e.g. availability zones from instance tags), used for brokers without a broker.rack")
+	rebuildCmd.Flags().Bool("rack-map-override", false, "Override existing broker.rack values with those in the --rack-map")
+	rebuildCmd.Flags().Float64("movement-weight", 0.0, "Weight between 0 and 1 trading storage balance for fewer partition movements in storage placement rebuilds; rebuilt replica sets are reverted where the weighted objective improves (0 disables)")
+	rebuildCmd.Flags().Bool("retain-existing-replica", false, "Retain an existing replica for partitions that would otherwise be placed entirely on new brokers")
+	rebuildCmd.Flags().Int("max-partitions-per-broker", 0, "Maximum partition replicas per broker across all topics in the cluster, e.g. as derived from file handle or replica fetcher limits (0 disables)")
+	rebuildCmd.Flags().String("frozen-partitions", "", "Partitions to copy verbatim into the output map without replacing any brokers, e.g. those undergoing a separate reassignment (comma delim. list of topic:partition)")
+	rebuildCmd.Flags().Bool("reuse-freed-slots", false, "Prefer brokers losing replicas in the rebuild (e.g. from a --replication decrease) for new placements")
+	rebuildCmd.Flags().Bool("minimal-movement", false, "Minimize deviation from the current assignment; retained replicas keep their position and replacements prefer brokers already holding the topic")
+	rebuildCmd.Flags().Bool("verbose", false, "Verbose output; prints the candidates considered for each broker selection")
+	rebuildCmd.Flags().Bool("write-sizes", false, "Write a sidecar file with the size of each partition alongside each output map")
 
 	// Required.
 	rebuildCmd.MarkFlagRequired("brokers")
@@ -52,18 +67,23 @@ func rebuild(cmd *cobra.Command, _ []string) {
 	// Sanity check params.
 	t, _ := cmd.Flags().GetString("topics")
 	ms, _ := cmd.Flags().GetString("map-string")
+	fra, _ := cmd.Flags().GetString("from-reassignment")
 	p := cmd.Flag("placement").Value.String()
 	o := cmd.Flag("optimize").Value.String()
 	fr, _ := cmd.Flags().GetBool("force-rebuild")
 	sa, _ := cmd.Flags().GetBool("sub-affinity")
 	m, _ := cmd.Flags().GetBool("use-meta")
+	ws, _ := cmd.Flags().GetBool("write-sizes")
 
 	switch {
-	case ms == "" && t == "":
-		fmt.Println("\n[ERROR] must specify either --topics or --map-string")
+	case ms == "" && t == "" && fra == "":
+		fmt.Println("\n[ERROR] must specify either --topics, --map-string or --from-reassignment")
 		defaultsAndExit()
-	case p != "count" && p != "storage":
-		fmt.Println("\n[ERROR] --placement must be either 'count' or 'storage'")
+	case ms != "" && fra != "":
+		fmt.Println("\n[ERROR] --map-string and --from-reassignment are mutually exclusive")
+		defaultsAndExit()
+	case p != "count" && p != "count-rackaware" && p != "storage":
+		fmt.Println("\n[ERROR] --placement must be either 'count', 'count-rackaware' or 'storage'")
 		defaultsAndExit()
 	case o != "distribution" && o != "storage":
 		fmt.Println("\n[ERROR] --optimize must be either 'distribution' or 'storage'")
@@ -79,7 +99,7 @@ func rebuild(cmd *cobra.Command, _ []string) {
 
 	// ZooKeeper init.
 	var zk kafkazk.Handler
-	if m || len(Config.topics) > 0 || p == "storage" {
+	if m || len(Config.topics) > 0 || p == "storage" || ws {
 		var err error
 		zk, err = initZooKeeper(cmd)
 		if err != nil {
@@ -91,7 +111,8 @@ func rebuild(cmd *cobra.Command, _ []string) {
 
 	// General flow:
 	// 1) A PartitionMap is formed (either unmarshaled from the literal
-	//   map input via --rebuild-map or generated from ZooKeeper Metadata
+	//   map input via --map-string, a reassignment file via --from-reassignment
+	//   or generated from ZooKeeper Metadata
 	//   for topics matching --topics).
 	// 2) A BrokerMap is formed from brokers found in the PartitionMap
 	//   along with any new brokers provided via the --brokers param.
@@ -105,8 +126,8 @@ func rebuild(cmd *cobra.Command, _ []string) {
 
 	// Fetch broker metadata.
 	var withMetrics bool
-	if cmd.Flag("placement").Value.String() == "storage" {
-		checkMetaAge(cmd, zk)
+	ig, _ := cmd.Flags().GetBool("instance-groups")
+	if cmd.Flag("placement").Value.String() == "storage" || ig {
 		withMetrics = true
 	}
 
@@ -117,7 +138,7 @@ func rebuild(cmd *cobra.Command, _ []string) {
 
 	// Fetch partition metadata.
 	var partitionMeta kafkazk.PartitionMetaMap
-	if cmd.Flag("placement").Value.String() == "storage" {
+	if cmd.Flag("placement").Value.String() == "storage" || ws {
 		partitionMeta = getPartitionMeta(cmd, zk)
 	}
 
@@ -140,6 +161,11 @@ func rebuild(cmd *cobra.Command, _ []string) {
 		fmt.Printf("%s-\n", indent)
 	}
 
+	// Check the metrics age of participating brokers.
+	if withMetrics {
+		checkMetaAge(cmd, zk, participatingBrokers(brokers), brokerMeta)
+	}
+
 	// Check if any referenced brokers are marked as having
 	// missing/partial metrics data.
 	if m, _ := cmd.Flags().GetBool("use-meta"); m {
@@ -159,15 +185,42 @@ func rebuild(cmd *cobra.Command, _ []string) {
 	// Apply any replication factor settings.
 	updateReplicationFactor(cmd, partitionMapIn)
 
+	// Ensure that the brokers can support the placements required.
+	ensurePlacementSupport(cmd, partitionMapIn, brokers, bs)
+
 	// Build a new map using the provided list of brokers. This is OK to run even
 	// when a no-op is intended.
-	partitionMapOut, errs := buildMap(cmd, partitionMapIn, partitionMeta, brokers, affinities)
+	// Get replica counts for topics outside of the rebuild
+	// if the partitions per broker are limited.
+	var held map[int]int
+	if mp, _ := cmd.Flags().GetInt("max-partitions-per-broker"); mp > 0 && zk != nil {
+		held = getHeldPartitions(zk, partitionMapIn)
+	}
+
+	partitionMapOut, errs := buildMap(cmd, partitionMapIn, partitionMeta, brokers, affinities, held)
+
+	// Revert movements per the movement weight.
+	if mw, _ := cmd.Flags().GetFloat64("movement-weight"); mw > 0 && cmd.Flag("placement").Value.String() == "storage" {
+		partitionMapOut, brokers = minimizeMovement(originalMap, partitionMapOut, brokersOrig, partitionMeta, mw)
+	}
+
+	// Retain existing replicas or warn for partitions
+	// placed entirely on new brokers.
+	partitionMapOut, newErrs := checkNewBrokerReplicaSets(cmd, originalMap, partitionMapOut, partitionMeta, brokers)
+	errs = append(errs, newErrs...)
 
 	// Optimize leaders.
 	if t, _ := cmd.Flags().GetBool("optimize-leadership"); t {
 		partitionMapOut.OptimizeLeaderFollower()
 	}
 
+	// Promote preferred leaders. This follows any leadership
+	// optimization, taking precedence where preferred brokers
+	// are in the replica set.
+	if lp, _ := cmd.Flags().GetString("leader-preference"); lp != "" {
+		partitionMapOut.PreferLeaders(brokerStringToSlice(lp))
+	}
+
 	// Count missing brokers as a warning.
 	if bs.Missing > 0 {
 		errs = append(errs, fmt.Errorf("%d provided brokers not found in ZooKeeper", bs.Missing))
@@ -201,4 +254,9 @@ func rebuild(cmd *cobra.Command, _ []string) {
 	}
 
 	writeMaps(cmd, partitionMapOut, phasedMap)
+
+	// Write partition sizes if configured.
+	if ws {
+		writeMapSizes(cmd, partitionMapOut, partitionMeta)
+	}
 }
@@ -1,8 +1,12 @@
 package commands
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
 
@@ -17,13 +21,33 @@ import (
 // is either built from a string literal input (json from off-the-shelf Kafka
 // tools output) provided via the ---map-string flag, or, by building a map based
 // on topic config found in ZooKeeper for all topics matching input provided
-// via the --topics flag. Two []string are returned; topics excluded due to
+// via the --topics flag. A kafka-reassign-partitions output file can also be
+// provided via the --from-reassignment flag; the file is treated as the
+// authoritative current state and nothing is fetched from ZooKeeper. Two
+// []string are returned; topics excluded due to
 // pending deletion and topics explicitly excluded (via the --topics-exclude
 // flag), respectively.
 func getPartitionMap(cmd *cobra.Command, zk kafkazk.Handler) (*kafkazk.PartitionMap, []string, []string) {
 	ms := cmd.Flag("map-string").Value.String()
+	fra := cmd.Flag("from-reassignment").Value.String()
 
 	switch {
+	// The map was provided as a reassignment file.
+	case fra != "":
+		data, err := ioutil.ReadFile(fra)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		pm, err := partitionMapFromReassignment(data)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		// Exclude topics explicitly listed.
+		et := removeTopics(pm, Config.topicsExclude)
+		return pm, []string{}, et
 	// The map was provided as text.
 	case ms != "":
 		// Get a deserialized map.
@@ -34,7 +58,13 @@ func getPartitionMap(cmd *cobra.Command, zk kafkazk.Handler) (*kafkazk.Partition
 		}
 		// Exclude topics explicitly listed.
 		et := removeTopics(pm, Config.topicsExclude)
-		return pm, []string{}, et
+		// Exclude topics that no longer exist.
+		pm, nt, err := trimNonexistentTopics(pm, zk)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return pm, []string{}, append(et, nt...)
 	// The map needs to be fetched via ZooKeeper metadata for all specified topics.
 	case len(Config.topics) > 0:
 		pm, err := kafkazk.PartitionMapFromZK(Config.topics, zk)
@@ -55,6 +85,35 @@ func getPartitionMap(cmd *cobra.Command, zk kafkazk.Handler) (*kafkazk.Partition
 	return nil, nil, nil
 }
 
+// partitionMapFromReassignment takes the contents of a reassignment file and
+// returns a *kafkazk.PartitionMap. The contents may either be a reassignment
+// JSON file or the full output of kafka-reassign-partitions --generate, in
+// which case the proposed reassignment configuration (the last JSON map in
+// the output) is used.
+func partitionMapFromReassignment(data []byte) (*kafkazk.PartitionMap, error) {
+	data = bytes.TrimSpace(data)
+
+	// Use the last line that contains a JSON object if the input isn't
+	// purely a JSON map.
+	if !bytes.HasPrefix(data, []byte("{")) || !bytes.HasSuffix(data, []byte("}")) || bytes.Contains(data, []byte("\n\n")) {
+		var last []byte
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if bytes.HasPrefix(line, []byte("{")) {
+				last = line
+			}
+		}
+
+		if last == nil {
+			return nil, fmt.Errorf("No partition map found in reassignment file")
+		}
+
+		data = last
+	}
+
+	return kafkazk.PartitionMapFromString(string(data))
+}
+
 // getSubAffinities, if enabled via --sub-affinity, takes reference broker maps
 // and a partition map and attempts to return a complete SubstitutionAffinities.
 func getSubAffinities(cmd *cobra.Command, bm kafkazk.BrokerMap, bmo kafkazk.BrokerMap, pm *kafkazk.PartitionMap) kafkazk.SubstitutionAffinities {
@@ -177,27 +236,83 @@ func updateReplicationFactor(cmd *cobra.Command, pm *kafkazk.PartitionMap) {
 	}
 }
 
+// ensurePlacementSupport takes a PartitionMap, BrokerMap and BrokerStatus and
+// exits if the eligible brokers can't support the largest replica set along
+// with the --min-rack-ids setting. This is only checked if new placements are
+// required; no-op rebuilds of existing maps are unaffected. Rack aware
+// placement spans as many localities as are available and only requires one.
+func ensurePlacementSupport(cmd *cobra.Command, pm *kafkazk.PartitionMap, bm kafkazk.BrokerMap, bs *kafkazk.BrokerStatus) {
+	r, _ := cmd.Flags().GetInt("replication")
+	fr, _ := cmd.Flags().GetBool("force-rebuild")
+	mrrid, _ := cmd.Flags().GetInt("min-rack-ids")
+
+	if !fr && r == 0 && bs.Replace == 0 {
+		return
+	}
+
+	var rf int
+	for _, p := range pm.Partitions {
+		if len(p.Replicas) > rf {
+			rf = len(p.Replicas)
+		}
+	}
+
+	if cmd.Flag("placement").Value.String() == "count-rackaware" {
+		mrrid = 1
+	}
+
+	if err := bm.SupportsPlacement(rf, mrrid); err != nil {
+		fmt.Printf("\n[ERROR] %s\n", err)
+		os.Exit(1)
+	}
+}
+
 // buildMap takes an input PartitionMap, rebuild parameters, and all partition/broker
 // metadata structures required to generate the output PartitionMap. A []string of
 // warnings / advisories is returned if any are encountered.
-func buildMap(cmd *cobra.Command, pm *kafkazk.PartitionMap, pmm kafkazk.PartitionMetaMap, bm kafkazk.BrokerMap, af kafkazk.SubstitutionAffinities) (*kafkazk.PartitionMap, errors) {
+func buildMap(cmd *cobra.Command, pm *kafkazk.PartitionMap, pmm kafkazk.PartitionMetaMap, bm kafkazk.BrokerMap, af kafkazk.SubstitutionAffinities, held map[int]int) (*kafkazk.PartitionMap, errors) {
 	placement := cmd.Flag("placement").Value.String()
 	psf, _ := cmd.Flags().GetFloat64("partition-size-factor")
 	mrrid, _ := cmd.Flags().GetInt("min-rack-ids")
+	ig, _ := cmd.Flags().GetBool("instance-groups")
+	rfs, _ := cmd.Flags().GetBool("reuse-freed-slots")
+	mm, _ := cmd.Flags().GetBool("minimal-movement")
+	mp, _ := cmd.Flags().GetInt("max-partitions-per-broker")
+
+	fps, _ := cmd.Flags().GetString("frozen-partitions")
+	frozen, err := parseFrozenPartitions(fps)
+	if err != nil {
+		fmt.Printf("\n[ERROR] %s\n", err)
+		os.Exit(1)
+	}
 
 	rebuildParams := kafkazk.RebuildParams{
-		PMM:              pmm,
-		BM:               bm,
-		Strategy:         placement,
-		Optimization:     cmd.Flag("optimize").Value.String(),
-		PartnSzFactor:    psf,
-		MinUniqueRackIDs: mrrid,
+		PMM:                    pmm,
+		BM:                     bm,
+		Strategy:               placement,
+		Optimization:           cmd.Flag("optimize").Value.String(),
+		PartnSzFactor:          psf,
+		MinUniqueRackIDs:       mrrid,
+		InstanceGroups:         ig,
+		ReuseFreedSlots:        rfs,
+		MinimalMovement:        mm,
+		MaxPartitionsPerBroker: mp,
+		HeldPartitions:         held,
+		FrozenPartitions:       frozen,
 	}
 
 	if af != nil {
 		rebuildParams.Affinities = af
 	}
 
+	// Print the rationale for each broker selection in verbose.
+	if v, _ := cmd.Flags().GetBool("verbose"); v {
+		fmt.Printf("\nBroker selections:\n")
+		rebuildParams.PlacementTrace = func(p kafkazk.Partition, t kafkazk.SelectionTrace) {
+			fmt.Printf("%s%s\n", indent, formatSelectionTrace(p, t))
+		}
+	}
+
 	// If we're doing a force rebuild, the input map must have all brokers stripped out.
 	// A few notes about doing force rebuilds:
 	// - Map rebuilds should always be called on a stripped PartitionMap copy.
@@ -212,8 +327,14 @@ func buildMap(cmd *cobra.Command, pm *kafkazk.PartitionMap, pmm kafkazk.Partitio
 	//   the size of the partition, is referenced from the PartitionMetaMap.
 
 	if fr, _ := cmd.Flags().GetBool("force-rebuild"); fr {
-		// Get a stripped map that we'll call rebuild on.
+		// Get a stripped map that we'll call rebuild on. Frozen
+		// partitions retain their replica sets.
 		partitionMapInStripped := pm.Strip()
+		for i, p := range pm.Partitions {
+			if isFrozen(frozen, p) {
+				partitionMapInStripped.Partitions[i] = p
+			}
+		}
 		// If the storage placement strategy is being used,
 		// update the broker StorageFree values.
 		if placement == "storage" {
@@ -269,6 +390,11 @@ func phasedReassignment(pm1, pm2 *kafkazk.PartitionMap) *kafkazk.PartitionMap {
 				leader := rs[partn.Partition][0]
 				if notInReplicaSet(leader, partn.Replicas) {
 					phase1pm.Partitions[i].Replicas = append([]int{leader}, partn.Replicas...)
+					// Keep any log dirs aligned with the replicas. The leader
+					// replica already exists and remains in its current log dir.
+					if partn.LogDirs != nil {
+						phase1pm.Partitions[i].LogDirs = append([]string{kafkazk.LogDirAny}, partn.LogDirs...)
+					}
 				}
 			}
 		}
@@ -286,3 +412,89 @@ func notInReplicaSet(id int, rs []int) bool {
 
 	return true
 }
+
+// minimizeMovement takes the original and rebuilt PartitionMap, the original
+// BrokerMap, PartitionMetaMap and a movement weight and returns the rebuilt
+// PartitionMap with replica sets reverted per kafkazk.MinimizeMovement, along
+// with the BrokerMap reflecting the resulting storage placements.
+func minimizeMovement(pm1, pm2 *kafkazk.PartitionMap, bm kafkazk.BrokerMap, pmm kafkazk.PartitionMetaMap, w float64) (*kafkazk.PartitionMap, kafkazk.BrokerMap) {
+	pm, projected, err := kafkazk.MinimizeMovement(pm1, pm2, bm, pmm, w)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return pm, projected
+}
+
+// checkNewBrokerReplicaSets takes the original and rebuilt PartitionMap, a
+// PartitionMetaMap and BrokerMap. If --retain-existing-replica is set, a copy
+// of the rebuilt map is returned retaining an existing replica for any
+// partitions placed entirely on new brokers. Otherwise, the rebuilt map is
+// returned along with a warning if any such partitions could have retained an
+// existing replica; e.g. partitions where all brokers are replaced aren't
+// counted.
+func checkNewBrokerReplicaSets(cmd *cobra.Command, pm1, pm2 *kafkazk.PartitionMap, pmm kafkazk.PartitionMetaMap, bm kafkazk.BrokerMap) (*kafkazk.PartitionMap, errors) {
+	psf, _ := cmd.Flags().GetFloat64("partition-size-factor")
+	mrrid, _ := cmd.Flags().GetInt("min-rack-ids")
+
+	params := kafkazk.RebuildParams{
+		PMM:              pmm,
+		BM:               bm,
+		Strategy:         cmd.Flag("placement").Value.String(),
+		PartnSzFactor:    psf,
+		MinUniqueRackIDs: mrrid,
+	}
+
+	if rer, _ := cmd.Flags().GetBool("retain-existing-replica"); rer {
+		return pm1.RetainExistingReplicas(pm2, params)
+	}
+
+	// Check against a copy of the BrokerMap.
+	params.BM = bm.Copy()
+	_, errs := pm1.RetainExistingReplicas(pm2, params)
+
+	if n := len(pm2.NewBrokerReplicaSets(bm)) - len(errs); n > 0 {
+		return pm2, errors{fmt.Errorf("%d partition(s) placed entirely on new brokers; see --retain-existing-replica", n)}
+	}
+
+	return pm2, nil
+}
+
+// parseFrozenPartitions takes a comma delimited list of topic:partition
+// pairs, e.g. "test_topic:0,test_topic:1", and returns a map of topic names
+// to partition numbers.
+func parseFrozenPartitions(s string) (map[string][]int, error) {
+	frozen := map[string][]int{}
+
+	if s == "" {
+		return frozen, nil
+	}
+
+	for _, tp := range strings.Split(s, ",") {
+		i := strings.LastIndex(tp, ":")
+		if i < 1 {
+			return nil, fmt.Errorf("Invalid frozen partition '%s', expected topic:partition", tp)
+		}
+
+		n, err := strconv.Atoi(tp[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid frozen partition '%s', expected topic:partition", tp)
+		}
+
+		frozen[tp[:i]] = append(frozen[tp[:i]], n)
+	}
+
+	return frozen, nil
+}
+
+// isFrozen returns whether the partition is in the frozen partitions map.
+func isFrozen(frozen map[string][]int, p kafkazk.Partition) bool {
+	for _, n := range frozen[p.Topic] {
+		if n == p.Partition {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
@@ -53,3 +54,100 @@ func TestPhasedReassignment(t *testing.T) {
 		}
 	}
 }
+
+func TestPhasedReassignmentLogDirs(t *testing.T) {
+	pm1, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]}]}`)
+	pm2, _ := kafkazk.PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1003,1004],"log_dirs":["/d1003","/d1004"]}]}`)
+
+	phased := phasedReassignment(pm1, pm2)
+
+	p := phased.Partitions[0]
+	if len(p.Replicas) != 3 || p.Replicas[0] != 1001 {
+		t.Fatalf("Expected leader 1001 prepended, got %v", p.Replicas)
+	}
+
+	expected := []string{kafkazk.LogDirAny, "/d1003", "/d1004"}
+	for i, d := range expected {
+		if i >= len(p.LogDirs) || p.LogDirs[i] != d {
+			t.Fatalf("Expected log dirs %v, got %v", expected, p.LogDirs)
+		}
+	}
+}
+
+func TestPartitionMapFromReassignment(t *testing.T) {
+	proposed := `{"version":1,"partitions":[{"topic":"test_topic","partition":0,"replicas":[1001,1002]},{"topic":"test_topic","partition":1,"replicas":[1001,1002]},{"topic":"test_topic","partition":2,"replicas":[1001,1002]},{"topic":"test_topic","partition":3,"replicas":[1001,1002]}]}`
+
+	// The full output of kafka-reassign-partitions --generate.
+	output := "Current partition replica assignment\n" +
+		`{"version":1,"partitions":[{"topic":"test_topic","partition":0,"replicas":[1003,1004]}]}` +
+		"\n\nProposed partition reassignment configuration\n" +
+		proposed + "\n"
+
+	expected, _ := kafkazk.PartitionMapFromString(proposed)
+
+	for _, input := range []string{proposed, output} {
+		pm, err := partitionMapFromReassignment([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if eq, _ := pm.Equal(expected); !eq {
+			t.Errorf("Unexpected PartitionMap inequality")
+		}
+	}
+
+	if _, err := partitionMapFromReassignment([]byte("no map here")); err == nil {
+		t.Errorf("Expected non-nil error")
+	}
+
+	// Apply a leadership optimization on the imported map.
+	pm, _ := partitionMapFromReassignment([]byte(output))
+	pm.OptimizeLeaderFollower()
+
+	leaders := map[int]int{}
+	for _, p := range pm.Partitions {
+		leaders[p.Replicas[0]]++
+	}
+
+	if leaders[1001] != 2 || leaders[1002] != 2 {
+		t.Errorf("Expected leadership evenly split between 1001 and 1002, got %v", leaders)
+	}
+}
+
+func TestParseFrozenPartitions(t *testing.T) {
+	frozen, err := parseFrozenPartitions("test_topic:0,test_topic:3,ns:topic:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]int{
+		"test_topic": {0, 3},
+		"ns:topic":   {1},
+	}
+
+	if len(frozen) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, frozen)
+	}
+
+	for topic, partitions := range expected {
+		if !reflect.DeepEqual(frozen[topic], partitions) {
+			t.Errorf("Expected %s partitions %v, got %v", topic, partitions, frozen[topic])
+		}
+	}
+
+	if !isFrozen(frozen, kafkazk.Partition{Topic: "test_topic", Partition: 3}) {
+		t.Error("Expected test_topic p3 to be frozen")
+	}
+
+	if isFrozen(frozen, kafkazk.Partition{Topic: "test_topic", Partition: 1}) {
+		t.Error("Expected test_topic p1 not to be frozen")
+	}
+
+	for _, s := range []string{"test_topic", "test_topic:a", ":1"} {
+		if _, err := parseFrozenPartitions(s); err == nil {
+			t.Errorf("Expected error for '%s'", s)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+
+	"github.com/spf13/cobra"
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Rebuild under-replicated partitions to restore replication",
+	Long: `repair fetches all under-replicated partitions from ZooKeeper and builds
+a map containing only those partitions. Replicas not in the ISR are replaced
+with brokers from the --brokers list; in-sync replicas are retained in place.
+The --brokers list should only include healthy brokers.`,
+	Run: repair,
+}
+
+func init() {
+	rootCmd.AddCommand(repairCmd)
+
+	repairCmd.Flags().String("brokers", "", "Broker list to scope replacement placements to ('-1' for all currently mapped brokers, '-2' for all brokers in cluster)")
+	repairCmd.Flags().Int("min-rack-ids", 0, "Minimum number of required of unique rack IDs per replica set (0 requires that all are unique)")
+	repairCmd.Flags().String("out-path", "", "Path to write output map files to")
+	repairCmd.Flags().String("out-file", "", "If defined, write a combined map of all topics to a file")
+
+	// Required.
+	repairCmd.MarkFlagRequired("brokers")
+}
+
+func repair(cmd *cobra.Command, _ []string) {
+	bootstrap(cmd)
+
+	// ZooKeeper init.
+	zk, err := initZooKeeper(cmd)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	defer zk.Close()
+
+	// Get the under-replicated partitions with
+	// out of sync replicas stubbed out.
+	partitionMapIn, err := getRepairMap(zk)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(partitionMapIn.Partitions) == 0 {
+		fmt.Println("\nNo under-replicated partitions found")
+		return
+	}
+
+	printTopics(partitionMapIn)
+
+	// Get a broker map.
+	brokerMeta := getBrokerMeta(cmd, zk, false)
+	brokers := kafkazk.BrokerMapFromPartitionMap(partitionMapIn, brokerMeta, false)
+
+	fmt.Printf("\nBroker change summary:\n")
+	_, msgs := brokers.Update(Config.brokers, brokerMeta)
+	for m := range msgs {
+		fmt.Printf("%s%s\n", indent, m)
+	}
+
+	mrrid, _ := cmd.Flags().GetInt("min-rack-ids")
+
+	partitionMapOut, errs := repairPartitionMap(partitionMapIn, brokers, mrrid)
+	if errs != nil {
+		fmt.Println("\nRepair errors:")
+		for _, e := range errs {
+			fmt.Printf("%s%s\n", indent, e)
+		}
+		os.Exit(1)
+	}
+
+	// Print map change results.
+	printMapChanges(partitionMapIn, partitionMapOut)
+
+	// Write maps.
+	writeMaps(cmd, partitionMapOut, nil)
+}
+
+// getRepairMap takes a kafkazk.Handler and returns a *kafkazk.PartitionMap of
+// all under-replicated partitions. Replicas not in the ISR are replaced with
+// the kafkazk.StubBrokerID so that they're assigned new brokers in a rebuild.
+func getRepairMap(zk kafkazk.Handler) (*kafkazk.PartitionMap, error) {
+	urp, err := zk.GetUnderReplicatedPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	pm := kafkazk.NewPartitionMap()
+	isr := map[string]kafkazk.TopicStateISR{}
+
+	for _, p := range urp {
+		// Fetch the ISR for each topic once.
+		if _, exists := isr[p.Topic]; !exists {
+			state, err := zk.GetTopicStateISR(p.Topic)
+			if err != nil {
+				return nil, err
+			}
+			isr[p.Topic] = state
+		}
+
+		state, exists := isr[p.Topic][fmt.Sprint(p.Partition)]
+		if !exists {
+			return nil, fmt.Errorf("No ISR state for %s p%d", p.Topic, p.Partition)
+		}
+
+		partn := kafkazk.Partition{Topic: p.Topic, Partition: p.Partition}
+		for _, id := range p.Replicas {
+			if notInReplicaSet(id, state.ISR) {
+				id = kafkazk.StubBrokerID
+			}
+			partn.Replicas = append(partn.Replicas, id)
+		}
+
+		pm.Partitions = append(pm.Partitions, partn)
+	}
+
+	return pm, nil
+}
+
+// repairPartitionMap takes a repair map from getRepairMap, a BrokerMap and a
+// minimum unique rack ID count and returns the map with all stubbed replicas
+// assigned to brokers, restoring the replication factor.
+func repairPartitionMap(pm *kafkazk.PartitionMap, bm kafkazk.BrokerMap, mrrid int) (*kafkazk.PartitionMap, []error) {
+	params := kafkazk.NewRebuildParams()
+	params.BM = bm
+	params.Strategy = "count"
+	params.Optimization = "distribution"
+	params.MinUniqueRackIDs = mrrid
+
+	return pm.Rebuild(params)
+}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+)
+
+func TestRepairMap(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+
+	// underreplicated_topic p0 has replicas [1000,1001]
+	// with an ISR of [1000,1002].
+	pm, err := getRepairMap(zk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The out of sync replica is stubbed out.
+	expected := kafkazk.NewPartitionMap()
+	expected.Partitions = kafkazk.PartitionList{
+		{Topic: "underreplicated_topic", Partition: 0, Replicas: []int{1000, kafkazk.StubBrokerID}},
+	}
+
+	if same, err := pm.Equal(expected); !same {
+		t.Fatalf("Unexpected repair map: %s", err)
+	}
+
+	// Repair using healthy brokers.
+	bm := kafkazk.BrokerMapFromPartitionMap(pm, nil, false)
+	bm.Update([]int{1000, 1002, 1003}, nil)
+
+	out, errs := repairPartitionMap(pm, bm, 0)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	p := out.Partitions[0]
+	if len(p.Replicas) != 2 {
+		t.Fatalf("Expected a replication factor of 2, got %d", len(p.Replicas))
+	}
+
+	// The in-sync leader is retained.
+	if p.Replicas[0] != 1000 {
+		t.Errorf("Expected leader 1000, got %d", p.Replicas[0])
+	}
+
+	if p.Replicas[1] != 1002 && p.Replicas[1] != 1003 {
+		t.Errorf("Expected replacement 1002 or 1003, got %d", p.Replicas[1])
+	}
+}
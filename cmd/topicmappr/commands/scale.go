@@ -29,9 +29,13 @@ func init() {
 	scaleCmd.Flags().Int("partition-limit", 30, "Limit the number of top partitions by size eligible for relocation per broker")
 	scaleCmd.Flags().Int("partition-size-threshold", 512, "Size in megabytes where partitions below this value will not be moved in a scale")
 	scaleCmd.Flags().Bool("locality-scoped", false, "Ensure that all partition movements are scoped by rack.id")
+	scaleCmd.Flags().String("rack-map", "", "Path to a JSON file mapping broker IDs to rack IDs (e.g. availability zones from instance tags), used for brokers without a broker.rack")
+	scaleCmd.Flags().Bool("rack-map-override", false, "Override existing broker.rack values with those in the --rack-map")
 	scaleCmd.Flags().Bool("verbose", false, "Verbose output")
 	scaleCmd.Flags().String("zk-metrics-prefix", "topicmappr", "ZooKeeper namespace prefix for Kafka metrics")
+	scaleCmd.Flags().String("partition-meta-file", "", "Path to a JSON file of partition metadata (partition sizes) to use in place of the metadata in ZooKeeper")
 	scaleCmd.Flags().Int("metrics-age", 60, "Kafka metrics age tolerance (in minutes)")
+	scaleCmd.Flags().Bool("write-sizes", false, "Write a sidecar file with the size of each partition alongside each output map")
 	scaleCmd.Flags().Bool("optimize-leadership", false, "Scale all broker leader/follower ratios")
 
 	// Required.
@@ -52,7 +56,6 @@ func scale(cmd *cobra.Command, _ []string) {
 	defer zk.Close()
 
 	// Get broker and partition metadata.
-	checkMetaAge(cmd, zk)
 	brokerMeta := getBrokerMeta(cmd, zk, true)
 	partitionMeta := getPartitionMeta(cmd, zk)
 
@@ -82,6 +85,9 @@ func scale(cmd *cobra.Command, _ []string) {
 	// broker IDs targeted for partition offloading.
 	offloadTargets := validateBrokersForScale(cmd, brokersIn, brokerMeta)
 
+	// Check the metrics age of participating brokers.
+	checkMetaAge(cmd, zk, participatingBrokers(brokersIn), brokerMeta)
+
 	// Sort offloadTargets by storage free ascending.
 	sort.Sort(offloadTargetsBySize{t: offloadTargets, bm: brokersIn})
 
@@ -156,6 +162,11 @@ func scale(cmd *cobra.Command, _ []string) {
 
 	// Write maps.
 	writeMaps(cmd, partitionMapOut, nil)
+
+	// Write partition sizes if configured.
+	if ws, _ := cmd.Flags().GetBool("write-sizes"); ws {
+		writeMapSizes(cmd, partitionMapOut, partitionMeta)
+	}
 }
 
 func validateBrokersForScale(cmd *cobra.Command, brokers kafkazk.BrokerMap, bm kafkazk.BrokerMetaMap) []int {
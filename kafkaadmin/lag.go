@@ -0,0 +1,103 @@
+package kafkaadmin
+
+import (
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const offsetsTimeoutMs = 10000
+
+// offsetsClient is the subset of *kafka.Consumer methods used to compute
+// consumer lag.
+type offsetsClient interface {
+	GetMetadata(*string, bool, int) (*kafka.Metadata, error)
+	Committed([]kafka.TopicPartition, int) ([]kafka.TopicPartition, error)
+	QueryWatermarkOffsets(string, int32, int) (int64, int64, error)
+	Close() error
+}
+
+// ConsumerLag takes a list of consumer groups and topics and returns the
+// consumer lag for each topic as a map of topic, partition number to lag. Lag
+// is the difference between a partition's log-end offset and the offset
+// committed by a group. Where multiple groups consume a topic, the greatest
+// lag of any group is returned. Partitions without a committed offset for any
+// of the groups are omitted.
+func ConsumerLag(cfg Config, groups []string, topics []string) (map[string]map[int]int64, error) {
+	lag := map[string]map[int]int64{}
+
+	for _, g := range groups {
+		cfg.GroupId = g
+		c, err := NewConsumer(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		groupLag, err := consumerLag(c, topics)
+		c.Close()
+		if err != nil {
+			return nil, fmt.Errorf("[group %s] %s", g, err)
+		}
+
+		for t, partns := range groupLag {
+			if _, exists := lag[t]; !exists {
+				lag[t] = map[int]int64{}
+			}
+			for p, l := range partns {
+				if l > lag[t][p] {
+					lag[t][p] = l
+				}
+			}
+		}
+	}
+
+	return lag, nil
+}
+
+// consumerLag returns the lag of the group that the offsetsClient is
+// configured with for each partition of the provided topics.
+func consumerLag(c offsetsClient, topics []string) (map[string]map[int]int64, error) {
+	lag := map[string]map[int]int64{}
+
+	for _, t := range topics {
+		topic := t
+		md, err := c.GetMetadata(&topic, false, offsetsTimeoutMs)
+		if err != nil {
+			return nil, fmt.Errorf("[librdkafka] %s", err)
+		}
+
+		var partns []kafka.TopicPartition
+		for _, p := range md.Topics[t].Partitions {
+			partns = append(partns, kafka.TopicPartition{Topic: &topic, Partition: p.ID})
+		}
+
+		committed, err := c.Committed(partns, offsetsTimeoutMs)
+		if err != nil {
+			return nil, fmt.Errorf("[librdkafka] %s", err)
+		}
+
+		lag[t] = map[int]int64{}
+
+		for _, p := range committed {
+			// A negative offset indicates that the group
+			// has no committed offset for the partition.
+			if p.Offset < 0 {
+				continue
+			}
+
+			_, high, err := c.QueryWatermarkOffsets(t, p.Partition, offsetsTimeoutMs)
+			if err != nil {
+				return nil, fmt.Errorf("[librdkafka] %s", err)
+			}
+
+			l := high - int64(p.Offset)
+			if l < 0 {
+				l = 0
+			}
+
+			lag[t][int(p.Partition)] = l
+		}
+	}
+
+	return lag, nil
+}
@@ -0,0 +1,59 @@
+package kafkaadmin
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOffsetsClient implements offsetsClient with fixed committed and
+// log-end offsets for a single topic.
+type fakeOffsetsClient struct {
+	committed map[int32]kafka.Offset
+	high      map[int32]int64
+}
+
+func (f fakeOffsetsClient) GetMetadata(t *string, _ bool, _ int) (*kafka.Metadata, error) {
+	tm := kafka.TopicMetadata{Topic: *t}
+	for p := range f.high {
+		tm.Partitions = append(tm.Partitions, kafka.PartitionMetadata{ID: p})
+	}
+
+	return &kafka.Metadata{Topics: map[string]kafka.TopicMetadata{*t: tm}}, nil
+}
+
+func (f fakeOffsetsClient) Committed(partns []kafka.TopicPartition, _ int) ([]kafka.TopicPartition, error) {
+	for i := range partns {
+		o, exists := f.committed[partns[i].Partition]
+		if !exists {
+			o = kafka.OffsetInvalid
+		}
+		partns[i].Offset = o
+	}
+
+	return partns, nil
+}
+
+func (f fakeOffsetsClient) QueryWatermarkOffsets(_ string, p int32, _ int) (int64, int64, error) {
+	return 0, f.high[p], nil
+}
+
+func (f fakeOffsetsClient) Close() error { return nil }
+
+func TestConsumerLag(t *testing.T) {
+	c := fakeOffsetsClient{
+		committed: map[int32]kafka.Offset{0: 100, 1: 500, 2: 1000},
+		high:      map[int32]int64{0: 100, 1: 2500, 2: 900, 3: 4000},
+	}
+
+	lag, err := consumerLag(c, []string{"test_topic"})
+	assert.Nil(t, err)
+
+	// p2 is clamped to 0; p3 has no committed offset and is omitted.
+	expected := map[string]map[int]int64{
+		"test_topic": {0: 0, 1: 2000, 2: 0},
+	}
+
+	assert.Equal(t, expected, lag)
+}
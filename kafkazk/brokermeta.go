@@ -1,5 +1,10 @@
 package kafkazk
 
+import (
+	"sort"
+	"time"
+)
+
 // BrokerMetaMap is a map of broker IDs to BrokerMeta
 // metadata fetched from ZooKeeper. Currently, just
 // the rack field is retrieved.
@@ -8,7 +13,11 @@ type BrokerMetaMap map[int]*BrokerMeta
 // BrokerMeta holds metadata that describes a broker,
 // used in satisfying constraints.
 type BrokerMeta struct {
-	StorageFree       float64 // In bytes.
+	StorageFree       float64            // In bytes.
+	StorageTotal      float64            // In bytes, if known.
+	LogDirStorageFree map[string]float64 // In bytes, per log dir.
+	InstanceGroup     string             // From broker metrics, if set.
+	MetricsTimestamp  int64              // Unix epoch seconds, if known.
 	MetricsIncomplete bool
 	// Metadata from ZooKeeper.
 	ListenerSecurityProtocolMap map[string]string `json:"listener_security_protocol_map"`
@@ -37,6 +46,10 @@ func (bmm BrokerMetaMap) Copy() BrokerMetaMap {
 func (bm BrokerMeta) Copy() BrokerMeta {
 	cp := BrokerMeta{
 		StorageFree:                 bm.StorageFree,
+		StorageTotal:                bm.StorageTotal,
+		LogDirStorageFree:           copyLogDirStorageFree(bm.LogDirStorageFree),
+		InstanceGroup:               bm.InstanceGroup,
+		MetricsTimestamp:            bm.MetricsTimestamp,
 		MetricsIncomplete:           bm.MetricsIncomplete,
 		ListenerSecurityProtocolMap: map[string]string{},
 		Rack:                        bm.Rack,
@@ -55,3 +68,51 @@ func (bm BrokerMeta) Copy() BrokerMeta {
 
 	return cp
 }
+
+// SetRacks takes a map of broker IDs to rack IDs, e.g. availability zones
+// sourced from cloud instance tags, and sets the Rack for each broker in the
+// BrokerMetaMap. Only brokers with an empty Rack are updated unless override
+// is true. Mappings for brokers not in the BrokerMetaMap are ignored. The
+// sorted IDs of all updated brokers are returned.
+func (bmm BrokerMetaMap) SetRacks(racks map[int]string, override bool) []int {
+	var updated []int
+
+	for id, rack := range racks {
+		meta, exists := bmm[id]
+		if !exists || rack == "" || meta.Rack == rack {
+			continue
+		}
+
+		if meta.Rack != "" && !override {
+			continue
+		}
+
+		meta.Rack = rack
+		updated = append(updated, id)
+	}
+
+	sort.Ints(updated)
+
+	return updated
+}
+
+// MetricsAge returns a map of broker IDs to the age of each broker's metrics
+// per the MetricsTimestamp. Brokers without a MetricsTimestamp are excluded.
+// Unlike MaxMetaAge, this allows staleness to be checked for only the brokers
+// relevant to an operation.
+func (bmm BrokerMetaMap) MetricsAge() map[int]time.Duration {
+	return bmm.metricsAge(time.Now())
+}
+
+func (bmm BrokerMetaMap) metricsAge(now time.Time) map[int]time.Duration {
+	ages := map[int]time.Duration{}
+
+	for id, meta := range bmm {
+		if meta.MetricsTimestamp <= 0 {
+			continue
+		}
+		ages[id] = now.Sub(time.Unix(meta.MetricsTimestamp, 0))
+	}
+
+	return ages
+}
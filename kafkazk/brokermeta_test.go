@@ -2,11 +2,13 @@ package kafkazk
 
 import (
 	"testing"
+	"time"
 )
 
 func TestBrokerMetaCopy(t *testing.T) {
 	orig := BrokerMeta{
 		StorageFree:                 100.5,
+		LogDirStorageFree:           map[string]float64{"/data": 100.5},
 		MetricsIncomplete:           false,
 		ListenerSecurityProtocolMap: map[string]string{"fake": "fake"},
 		Endpoints:                   []string{"localhost:9092"},
@@ -51,6 +53,12 @@ func TestBrokerMetaCopy(t *testing.T) {
 		}
 	}
 
+	for k, v := range orig.LogDirStorageFree {
+		if cp.LogDirStorageFree[k] != v {
+			equal = false
+		}
+	}
+
 	if !equal {
 		t.Log("BrokerMeta invalid copy")
 		t.Logf("Have:\n%+v\n", cp)
@@ -63,11 +71,107 @@ func TestBrokerMetaCopy(t *testing.T) {
 
 	orig.ListenerSecurityProtocolMap["fake"] = "fake2"
 	orig.Endpoints[0] = "127.0.0.1"
+	orig.LogDirStorageFree["/data"] = 0
 
 	switch {
 	case
 		cp.ListenerSecurityProtocolMap["fake"] != "fake",
-		cp.Endpoints[0] != "localhost:9092":
+		cp.Endpoints[0] != "localhost:9092",
+		cp.LogDirStorageFree["/data"] != 100.5:
 		t.Errorf("The copy shares memory with the original")
 	}
 }
+
+func TestSetRacks(t *testing.T) {
+	bmm := BrokerMetaMap{
+		1001: &BrokerMeta{Rack: "a"},
+		1002: &BrokerMeta{},
+		1003: &BrokerMeta{},
+		1004: &BrokerMeta{},
+	}
+
+	racks := map[int]string{
+		1001: "b",
+		1002: "a",
+		1003: "b",
+		1004: "c",
+		1006: "a",
+	}
+
+	// Only empty racks are filled.
+	updated := bmm.SetRacks(racks, false)
+
+	expected := []int{1002, 1003, 1004}
+	if len(updated) != len(expected) {
+		t.Fatalf("Expected updated brokers %v, got %v", expected, updated)
+	}
+
+	for i, id := range expected {
+		if updated[i] != id {
+			t.Errorf("Expected updated brokers %v, got %v", expected, updated)
+		}
+	}
+
+	if bmm[1001].Rack != "a" {
+		t.Errorf("Expected rack 'a' for broker 1001, got '%s'", bmm[1001].Rack)
+	}
+
+	if _, exists := bmm[1006]; exists {
+		t.Error("Unexpected broker 1006")
+	}
+
+	// The populated racks are used in placement. Replacing 1005 would
+	// otherwise favor the least used broker, 1002, which now shares
+	// rack 'a' with 1001.
+	bmm[1005] = &BrokerMeta{}
+
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1005]},
+		{"topic":"test_topic","partition":1,"replicas":[1003,1004]}]}`)
+
+	bm := BrokerMapFromPartitionMap(pm, bmm, false)
+	bm.Update([]int{1001, 1002, 1003, 1004}, bmm)
+
+	out, errs := pm.Rebuild(RebuildParams{PMM: NewPartitionMetaMap(), BM: bm, Strategy: "count"})
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	if r := out.Partitions[0].Replicas; r[0] != 1001 || r[1] == 1002 || r[1] == 1005 {
+		t.Errorf("Unexpected replicas for test_topic p0: %v", r)
+	}
+
+	// Existing racks are overwritten with override.
+	updated = bmm.SetRacks(racks, true)
+
+	if len(updated) != 1 || updated[0] != 1001 || bmm[1001].Rack != "b" {
+		t.Errorf("Expected broker 1001 updated to rack 'b', got %v", updated)
+	}
+}
+
+func TestBrokerMetaMapMetricsAge(t *testing.T) {
+	now := time.Unix(10000, 0)
+
+	bmm := BrokerMetaMap{
+		1001: &BrokerMeta{MetricsTimestamp: 9940},
+		1002: &BrokerMeta{MetricsTimestamp: 6400},
+		1003: &BrokerMeta{},
+	}
+
+	ages := bmm.metricsAge(now)
+
+	expected := map[int]time.Duration{
+		1001: time.Minute,
+		1002: time.Hour,
+	}
+
+	if len(ages) != len(expected) {
+		t.Fatalf("Expected %d ages, got %v", len(expected), ages)
+	}
+
+	for id, age := range expected {
+		if ages[id] != age {
+			t.Errorf("Expected broker %d age %s, got %s", id, age, ages[id])
+		}
+	}
+}
@@ -2,8 +2,10 @@ package kafkazk
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"sort"
+	"strings"
 )
 
 const (
@@ -19,6 +21,16 @@ type BrokerMetricsMap map[int]*BrokerMetrics
 // data fetched from ZK.
 type BrokerMetrics struct {
 	StorageFree float64
+	// Total storage capacity, in bytes, if known.
+	StorageTotal float64
+	// Storage free per log dir, in bytes.
+	LogDirStorageFree map[string]float64
+	// The instance group (e.g. a cloud availability set)
+	// that the broker belongs to, if any.
+	InstanceGroup string
+	// The time of the broker's metrics as unix epoch
+	// seconds, if known.
+	MetricsTimestamp int64
 }
 
 // BrokerUseStats holds counts
@@ -73,15 +85,22 @@ func (bs BrokerStatus) Changes() bool {
 	return false
 }
 
-// Broker associates metadata with a real broker by ID.
+// Broker associates metadata with a real broker by ID. LogDirStorageFree
+// optionally holds the storage free per log dir for brokers configured with
+// multiple log dirs (JBOD). Tags holds arbitrary key/value attributes, such as
+// a broker version, that can be referenced by placement constraints.
 type Broker struct {
-	ID          int
-	Locality    string
-	Used        int
-	StorageFree float64
-	Replace     bool
-	Missing     bool
-	New         bool
+	ID                int
+	Locality          string
+	Used              int
+	StorageFree       float64
+	StorageTotal      float64
+	LogDirStorageFree map[string]float64
+	Tags              map[string]string
+	InstanceGroup     string
+	Replace           bool
+	Missing           bool
+	New               bool
 }
 
 // BrokerMap holds a mapping of broker IDs to *Broker.
@@ -100,6 +119,7 @@ type BrokerList []*Broker
 // Wrapper types for sort by methods.
 type brokersByCount BrokerList
 type brokersByStorage BrokerList
+type brokersByStorageUsed BrokerList
 type brokersByID BrokerList
 
 // Satisfy the sort interface for BrokerList types.
@@ -118,7 +138,8 @@ func (b brokersByCount) Less(i, j int) bool {
 	return b[i].ID < b[j].ID
 }
 
-// By StorageFree value.
+// By StorageFree value descending. Ties are broken by
+// Used value ascending, then by ID ascending.
 func (b brokersByStorage) Len() int      { return len(b) }
 func (b brokersByStorage) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
 func (b brokersByStorage) Less(i, j int) bool {
@@ -129,6 +150,36 @@ func (b brokersByStorage) Less(i, j int) bool {
 		return false
 	}
 
+	if b[i].Used < b[j].Used {
+		return true
+	}
+	if b[i].Used > b[j].Used {
+		return false
+	}
+
+	return b[i].ID < b[j].ID
+}
+
+// By storage used ratio ascending. Ties are broken by
+// Used value ascending, then by ID ascending.
+func (b brokersByStorageUsed) Len() int      { return len(b) }
+func (b brokersByStorageUsed) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b brokersByStorageUsed) Less(i, j int) bool {
+	u1, u2 := b[i].StorageUsed(), b[j].StorageUsed()
+	if u1 < u2 {
+		return true
+	}
+	if u1 > u2 {
+		return false
+	}
+
+	if b[i].Used < b[j].Used {
+		return true
+	}
+	if b[i].Used > b[j].Used {
+		return false
+	}
+
 	return b[i].ID < b[j].ID
 }
 
@@ -144,11 +195,19 @@ func (b BrokerList) SortByCount() {
 	sort.Sort(brokersByCount(b))
 }
 
-// SortByStorage sorts the BrokerList by StorageFree values.
+// SortByStorage sorts the BrokerList by StorageFree values descending. Brokers
+// with equal StorageFree values are ordered by Used values ascending, then by
+// ID, making the order independent of the input order.
 func (b BrokerList) SortByStorage() {
 	sort.Sort(brokersByStorage(b))
 }
 
+// SortByStorageUsed sorts the BrokerList by storage used ratios ascending,
+// for balancing brokers of differing storage capacity.
+func (b BrokerList) SortByStorageUsed() {
+	sort.Sort(brokersByStorageUsed(b))
+}
+
 // SortByID sorts the BrokerList by ID values.
 func (b BrokerList) SortByID() {
 	sort.Sort(brokersByID(b))
@@ -161,16 +220,34 @@ type BrokerFilterFn func(*Broker) bool
 // AllBrokersFn returns all brokers.
 var AllBrokersFn BrokerFilterFn = func(b *Broker) bool { return true }
 
+// DefaultShuffleStrength is the strength used by SortPseudoShuffle.
+const DefaultShuffleStrength = 1.0
+
 // SortPseudoShuffle takes a BrokerList and performs a sort by count.
 // For each sequence of brokers with equal counts, the sub-slice is
 // pseudo random shuffled using the provided seed value s.
 func (b BrokerList) SortPseudoShuffle(seed int64) {
+	b.SortPseudoShuffleStrength(seed, DefaultShuffleStrength)
+}
+
+// SortPseudoShuffleStrength is SortPseudoShuffle with a strength value
+// between 0 and 1 that limits how far brokers are perturbed from the sorted
+// order. Each sequence of brokers with equal counts is split into consecutive
+// windows sized as a fraction (the strength) of the sequence length, and each
+// window is shuffled. A strength of 0 leaves the BrokerList sorted by count
+// while a strength of 1 shuffles each sequence in full, equal to the output
+// of SortPseudoShuffle.
+func (b BrokerList) SortPseudoShuffleStrength(seed int64, strength float64) {
 	sort.Sort(brokersByCount(b))
 
-	if len(b) <= 2 {
+	if len(b) <= 2 || strength <= 0 {
 		return
 	}
 
+	if strength > 1 {
+		strength = 1
+	}
+
 	rand.Seed(seed)
 
 	s := 0
@@ -184,18 +261,32 @@ func (b BrokerList) SortPseudoShuffle(seed int64) {
 		switch {
 		case b[k].Used != currVal:
 			currVal = b[k].Used
-			rand.Shuffle(len(b[s:k]), func(i, j int) {
-				b[s:k][i], b[s:k][j] = b[s:k][j], b[s:k][i]
-			})
+			b[s:k].shuffleWindows(strength)
 			s = k
 		case k == stop:
-			rand.Shuffle(len(b[s:]), func(i, j int) {
-				b[s:][i], b[s:][j] = b[s:][j], b[s:][i]
-			})
+			b[s:].shuffleWindows(strength)
 		}
 	}
 }
 
+// shuffleWindows splits the BrokerList into consecutive windows sized as
+// the strength fraction of the BrokerList length and shuffles each window.
+func (b BrokerList) shuffleWindows(strength float64) {
+	w := int(math.Ceil(strength * float64(len(b))))
+
+	for i := 0; i < len(b); i += w {
+		end := i + w
+		if end > len(b) {
+			end = len(b)
+		}
+
+		window := b[i:end]
+		rand.Shuffle(len(window), func(i, j int) {
+			window[i], window[j] = window[j], window[i]
+		})
+	}
+}
+
 // Update takes a []int of broker IDs and BrokerMetaMap then adds them to the
 // BrokerMap, returning the count of marked for replacement, newly included,
 // and brokers that weren't found in ZooKeeper. Additionally, a channel
@@ -297,12 +388,15 @@ func (b BrokerMap) Update(bl []int, bm BrokerMetaMap) (*BrokerStatus, <-chan str
 			// the broker metadata map.
 			if meta, exists := bm[id]; exists {
 				b[id] = &Broker{
-					Used:        0,
-					ID:          id,
-					Replace:     false,
-					Locality:    meta.Rack,
-					StorageFree: meta.StorageFree,
-					New:         true,
+					Used:              0,
+					ID:                id,
+					Replace:           false,
+					Locality:          meta.Rack,
+					StorageFree:       meta.StorageFree,
+					StorageTotal:      meta.StorageTotal,
+					LogDirStorageFree: copyLogDirStorageFree(meta.LogDirStorageFree),
+					InstanceGroup:     meta.InstanceGroup,
+					New:               true,
 				}
 				bs.New++
 			} else {
@@ -367,6 +461,29 @@ func (b BrokerMap) SubStorage(pm *PartitionMap, pmm PartitionMetaMap, f func(*Br
 	return nil
 }
 
+// MoveStorage takes a Partition, PartitionMetaMap and the IDs of brokers that
+// the partition is relocating from and to. The partition size is added back
+// to the StorageFree of the from broker and subtracted from the StorageFree of
+// the to broker. An error is returned, with no values modified, if the
+// partition size or either broker isn't found.
+func (b BrokerMap) MoveStorage(p Partition, pmm PartitionMetaMap, fromID, toID int) error {
+	size, err := pmm.Size(p)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range []int{fromID, toID} {
+		if _, exists := b[id]; !exists {
+			return fmt.Errorf("Broker %d not found in broker map", id)
+		}
+	}
+
+	b[fromID].StorageFree += size
+	b[toID].StorageFree -= size
+
+	return nil
+}
+
 // Filter returns a BrokerMap of brokers that return
 // true as an input to function f.
 func (b BrokerMap) Filter(f BrokerFilterFn) BrokerMap {
@@ -403,6 +520,46 @@ func (b BrokerList) Filter(f BrokerFilterFn) BrokerList {
 	return bl
 }
 
+// SupportsPlacement takes a replication factor and minimum rack spread and
+// returns an error if the eligible brokers in the BrokerMap can't support
+// replica sets meeting both. Brokers marked for replacement aren't eligible.
+// A minRackSpread of 0 requires that all replicas are in unique localities,
+// consistent with the MinUniqueRackIDs RebuildParams field. Brokers with no
+// locality aren't subject to rack constraints and each count toward the
+// spread.
+func (b BrokerMap) SupportsPlacement(rf, minRackSpread int) error {
+	f := func(b *Broker) bool { return !b.Replace }
+	eligible := b.Filter(f).List()
+
+	if len(eligible) < rf {
+		return fmt.Errorf("Replication factor %d requires %d brokers, %d eligible",
+			rf, rf, len(eligible))
+	}
+
+	// The number of distinct localities required.
+	required := rf
+	if minRackSpread > 0 && minRackSpread < rf {
+		required = minRackSpread
+	}
+
+	localities := map[string]struct{}{}
+	var unlocalized int
+	for _, broker := range eligible {
+		if broker.Locality == "" {
+			unlocalized++
+			continue
+		}
+		localities[broker.Locality] = struct{}{}
+	}
+
+	if n := len(localities) + unlocalized; n < required {
+		return fmt.Errorf("Replication factor %d with a minimum rack spread of %d requires %d localities, %d eligible",
+			rf, minRackSpread, required, n)
+	}
+
+	return nil
+}
+
 // List take a BrokerMap and returns a BrokerList.
 func (b BrokerMap) List() BrokerList {
 	bl := BrokerList{}
@@ -448,6 +605,9 @@ func BrokerMapFromPartitionMap(pm *PartitionMap, bm BrokerMetaMap, force bool) B
 			if meta, exists := bm[id]; exists {
 				bmap[id].Locality = meta.Rack
 				bmap[id].StorageFree = meta.StorageFree
+				bmap[id].StorageTotal = meta.StorageTotal
+				bmap[id].LogDirStorageFree = copyLogDirStorageFree(meta.LogDirStorageFree)
+				bmap[id].InstanceGroup = meta.InstanceGroup
 			}
 		}
 	}
@@ -472,12 +632,115 @@ func (b BrokerMap) Copy() BrokerMap {
 // Copy returns a copy of a Broker.
 func (b Broker) Copy() Broker {
 	return Broker{
-		ID:          b.ID,
-		Locality:    b.Locality,
-		Used:        b.Used,
-		StorageFree: b.StorageFree,
-		Replace:     b.Replace,
-		Missing:     b.Missing,
-		New:         b.New,
+		ID:                b.ID,
+		Locality:          b.Locality,
+		Used:              b.Used,
+		StorageFree:       b.StorageFree,
+		StorageTotal:      b.StorageTotal,
+		LogDirStorageFree: copyLogDirStorageFree(b.LogDirStorageFree),
+		Tags:              copyTags(b.Tags),
+		InstanceGroup:     b.InstanceGroup,
+		Replace:           b.Replace,
+		Missing:           b.Missing,
+		New:               b.New,
+	}
+}
+
+// hasTag takes a tag as a "key=value" pair or a key and returns whether the
+// broker has the tag. If only a key is specified, any value matches. An
+// empty tag always matches.
+func (b *Broker) hasTag(tag string) bool {
+	if tag == "" {
+		return true
+	}
+
+	kv := strings.SplitN(tag, "=", 2)
+	v, exists := b.Tags[kv[0]]
+
+	if len(kv) == 1 {
+		return exists
+	}
+
+	return exists && v == kv[1]
+}
+
+// hasAnyTag takes a map of tag keys to values and returns whether the broker
+// has any of the tags set to the specified value.
+func (b *Broker) hasAnyTag(tags map[string]string) bool {
+	for k, v := range tags {
+		if bv, exists := b.Tags[k]; exists && bv == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fitsStorage takes a size in bytes and returns whether the broker has
+// sufficient storage free to hold it. If the broker has per log dir storage
+// data, at least one log dir must be able to hold the full size; a partition
+// can't span log dirs. Otherwise, the aggregate StorageFree is used.
+func (b *Broker) fitsStorage(s float64) bool {
+	if len(b.LogDirStorageFree) == 0 {
+		return b.StorageFree-s >= 0
+	}
+
+	for _, free := range b.LogDirStorageFree {
+		if free-s >= 0 {
+			return true
+		}
 	}
+
+	return false
+}
+
+// subStorage takes a size in bytes and subtracts it from the broker
+// StorageFree. If the broker has per log dir storage data, the size is
+// additionally subtracted from the log dir with the most storage free.
+func (b *Broker) subStorage(s float64) {
+	b.StorageFree -= s
+
+	if len(b.LogDirStorageFree) == 0 {
+		return
+	}
+
+	var target string
+	var max float64 = -1
+
+	for dir, free := range b.LogDirStorageFree {
+		// Break ties by dir name for a predictable selection.
+		if free > max || (free == max && dir < target) {
+			target, max = dir, free
+		}
+	}
+
+	b.LogDirStorageFree[target] -= s
+}
+
+// copyLogDirStorageFree returns a copy of a log dir to storage free map.
+func copyLogDirStorageFree(m map[string]float64) map[string]float64 {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]float64, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
+
+// copyTags returns a copy of a tags map.
+func copyTags(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
 }
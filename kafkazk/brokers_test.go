@@ -120,6 +120,47 @@ func TestSortPseudoShuffle(t *testing.T) {
 	}
 }
 
+func TestSortPseudoShuffleStrength(t *testing.T) {
+	b := newStubBrokerMap2()
+
+	// Strength 0 is sorted by count.
+	bl := b.Filter(func(b *Broker) bool { return true }).List()
+	bl.SortPseudoShuffleStrength(1, 0)
+
+	for i := 1; i < len(bl); i++ {
+		if bl[i].Used < bl[i-1].Used {
+			t.Errorf("Expected sort by count, got broker %d (%d) after %d (%d)",
+				bl[i].ID, bl[i].Used, bl[i-1].ID, bl[i-1].Used)
+		}
+	}
+
+	// Strength 0 is deterministic irrespective of the seed.
+	bl2 := b.Filter(func(b *Broker) bool { return true }).List()
+	bl2.SortPseudoShuffleStrength(3, 0)
+	bl.SortPseudoShuffleStrength(1, 0)
+
+	for i := range bl {
+		if bl[i].ID != bl2[i].ID {
+			t.Errorf("Expected broker %d, got %d", bl[i].ID, bl2[i].ID)
+		}
+	}
+
+	// Strength 1 matches SortPseudoShuffle.
+	for _, seed := range []int64{1, 3} {
+		bl = b.Filter(func(b *Broker) bool { return true }).List()
+		bl2 = b.Filter(func(b *Broker) bool { return true }).List()
+
+		bl.SortPseudoShuffle(seed)
+		bl2.SortPseudoShuffleStrength(seed, 1)
+
+		for i := range bl {
+			if bl[i].ID != bl2[i].ID {
+				t.Errorf("Seed %d: expected broker %d, got %d", seed, bl[i].ID, bl2[i].ID)
+			}
+		}
+	}
+}
+
 func TestUpdate(t *testing.T) {
 	zk := NewZooKeeperStub()
 	bmm, _ := zk.GetAllBrokerMeta(false)
@@ -341,6 +382,70 @@ func TestListFilter(t *testing.T) {
 	}
 }
 
+func TestSupportsPlacement(t *testing.T) {
+	// 7 brokers in 3 localities.
+	bm := newStubBrokerMap2()
+
+	tests := []struct {
+		rf, spread int
+		ok         bool
+	}{
+		{rf: 3, spread: 0, ok: true},
+		{rf: 4, spread: 0, ok: false},
+		{rf: 4, spread: 2, ok: true},
+		{rf: 4, spread: 5, ok: false},
+		{rf: 7, spread: 3, ok: true},
+		{rf: 8, spread: 1, ok: false},
+	}
+
+	for _, test := range tests {
+		err := bm.SupportsPlacement(test.rf, test.spread)
+		if (err == nil) != test.ok {
+			t.Errorf("[rf %d, spread %d] Expected ok=%v, got error: %v", test.rf, test.spread, test.ok, err)
+		}
+	}
+
+	// Brokers marked for replacement aren't eligible;
+	// this leaves 2 localities.
+	bm[1003].Replace = true
+	bm[1006].Replace = true
+
+	if err := bm.SupportsPlacement(3, 0); err == nil {
+		t.Error("Expected error for 2 eligible localities")
+	}
+
+	if err := bm.SupportsPlacement(3, 2); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// Brokers without a locality each count toward the spread.
+	bm[1008] = &Broker{ID: 1008}
+
+	if err := bm.SupportsPlacement(3, 0); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestBrokerMapFromPartitionMapStorageTotal(t *testing.T) {
+	zk := NewZooKeeperStub()
+	bmm, _ := zk.GetAllBrokerMeta(true)
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+
+	brokers := BrokerMapFromPartitionMap(pm, bmm, false)
+
+	// Storage totals are populated where known.
+	expected := map[int]float64{1001: 16000, 1002: 16000, 1003: 0, 1004: 0}
+	for id, v := range expected {
+		if brokers[id].StorageTotal != v {
+			t.Errorf("Expected broker %d storage total %.2f, got %.2f", id, v, brokers[id].StorageTotal)
+		}
+	}
+
+	if c := brokers.Copy(); c[1001].StorageTotal != 16000 {
+		t.Errorf("Expected copied storage total 16000, got %.2f", c[1001].StorageTotal)
+	}
+}
+
 func TestBrokerMapFromPartitionMap(t *testing.T) {
 	zk := NewZooKeeperStub()
 	bmm, _ := zk.GetAllBrokerMeta(false)
@@ -421,6 +526,67 @@ func TestBrokerCopy(t *testing.T) {
 	}
 }
 
+func TestMoveStorage(t *testing.T) {
+	bm := newStubBrokerMap()
+	pmm := NewPartitionMetaMap()
+
+	pmm["test_topic"] = map[int]*PartitionMeta{
+		0: {Size: 30},
+		1: {Size: 35},
+	}
+
+	// Incremental moves accumulate.
+	moves := []struct {
+		partition int
+		from, to  int
+	}{
+		{0, 1001, 1004},
+		{1, 1001, 1003},
+		{0, 1004, 1002},
+	}
+
+	for _, m := range moves {
+		p := Partition{Topic: "test_topic", Partition: m.partition}
+		if err := bm.MoveStorage(p, pmm, m.from, m.to); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[int]float64{
+		1001: 165,
+		1002: 170,
+		1003: 265,
+		1004: 400,
+	}
+
+	for id, v := range expected {
+		if bm[id].StorageFree != v {
+			t.Errorf("Expected '%f' StorageFree for ID %d, got '%f'", v, id, bm[id].StorageFree)
+		}
+	}
+
+	// Errors leave values unmodified.
+	errMoves := []struct {
+		p        Partition
+		from, to int
+	}{
+		{Partition{Topic: "test_topic", Partition: 2}, 1001, 1002},
+		{Partition{Topic: "test_topic", Partition: 0}, 1001, 1005},
+	}
+
+	for _, m := range errMoves {
+		if err := bm.MoveStorage(m.p, pmm, m.from, m.to); err == nil {
+			t.Errorf("Expected error moving p%d from %d to %d", m.p.Partition, m.from, m.to)
+		}
+	}
+
+	for id, v := range expected {
+		if bm[id].StorageFree != v {
+			t.Errorf("Expected '%f' StorageFree for ID %d, got '%f'", v, id, bm[id].StorageFree)
+		}
+	}
+}
+
 func newStubBrokerMap() BrokerMap {
 	return BrokerMap{
 		StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
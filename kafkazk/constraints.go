@@ -2,6 +2,7 @@ package kafkazk
 
 import (
 	"errors"
+	"sort"
 )
 
 var (
@@ -14,16 +15,20 @@ var (
 // Constraints holds a map of
 // IDs and locality key-values.
 type Constraints struct {
-	requestSize float64
-	locality    map[string]bool
-	id          map[int]bool
+	requestSize   float64
+	locality      map[string]bool
+	instanceGroup map[string]bool
+	tags          map[string]map[string]bool
+	id            map[int]bool
 }
 
 // NewConstraints returns an empty *Constraints.
 func NewConstraints() *Constraints {
 	return &Constraints{
-		locality: make(map[string]bool),
-		id:       make(map[int]bool),
+		locality:      make(map[string]bool),
+		instanceGroup: make(map[string]bool),
+		tags:          make(map[string]map[string]bool),
+		id:            make(map[int]bool),
 	}
 }
 
@@ -34,12 +39,120 @@ type ConstraintsParams struct {
 	MinUniqueRackIDs int
 	RequestSize      float64
 	SeedVal          int64
+	// ForbidTags excludes brokers having any of the
+	// specified tag key/value pairs.
+	ForbidTags map[string]string
+	// PinLocality, if set, requires that candidates are in the specified
+	// locality. Rack ID uniqueness constraints aren't applied.
+	PinLocality string
+	// PreferLocality, if set, selects candidates in the specified
+	// locality ahead of all others where constraints allow.
+	PreferLocality string
+	// PreferBrokers, if set, selects candidates in the set ahead of all
+	// others where constraints allow; PreferLocality ordering takes
+	// precedence if also set.
+	PreferBrokers map[int]struct{}
+	// LeaderCounts, if set, is a mapping of broker IDs to the number of
+	// leaderships held. Candidates in localities holding the fewest
+	// leaderships are selected first, followed by the candidates holding the
+	// fewest leaderships; ties retain the selector method sort order.
+	LeaderCounts map[int]int
+	// BrokerLeaderCounts, if set, is a mapping of broker IDs to the number
+	// of leaderships held. Candidates are ordered by Used, then by the fewest
+	// leaderships held; leaderships only break ties in Used. It's intended for
+	// the count selector method. All other count based orderings take
+	// precedence if also set.
+	BrokerLeaderCounts map[int]int
+	// LocalityCounts, if set, is a mapping of localities to the number of
+	// replicas held in the role (leader or follower) being placed.
+	// Candidates in localities holding the fewest are selected first;
+	// GroupCounts and LeaderCounts ordering take precedence if also set.
+	LocalityCounts map[string]int
+	// MaxRackSpread, if non-zero, limits the number of distinct localities
+	// in the replica set. Once the limit is reached, candidates must be in a
+	// locality already in the replica set; rack ID uniqueness constraints
+	// aren't applied beyond that point.
+	MaxRackSpread int
+	// GroupCounts, if set, is a mapping of broker IDs to the number of
+	// replicas held for a spread group. Candidates holding the fewest are
+	// selected first; LeaderCounts ordering takes precedence if also set.
+	GroupCounts map[int]int
+	// InstanceGroups, if set, excludes candidates in an instance group
+	// already holding a replica. This applies in addition to rack ID
+	// constraints.
+	InstanceGroups bool
+	// AntiAffinityTag, if set, is a broker tag key; candidates sharing a
+	// value for the key with a broker already holding a replica are excluded.
+	// This applies in addition to rack ID constraints. Brokers without the
+	// tag aren't constrained.
+	AntiAffinityTag string
+	// MaxLeaderBytes, if non-zero, excludes candidates where the LeaderSize
+	// added to the summed size of partitions led, per LeaderBytes, would
+	// exceed the value.
+	MaxLeaderBytes float64
+	LeaderBytes    map[int]float64
+	LeaderSize     float64
+	// MaxPartitions, if non-zero, excludes candidates holding at least the
+	// specified number of replicas, counted as the candidate's Used value
+	// along with any replicas held per HeldPartitions.
+	MaxPartitions  int
+	HeldPartitions map[int]int
+	// RackSpread, if non-zero, is the number of distinct localities required
+	// in the replica set. Until reached, candidates must be in a locality not
+	// already in the replica set; brokers without a locality are excluded.
+	// MinUniqueRackIDs isn't applied.
+	RackSpread int
+}
+
+// SelectionTrace describes a SelectBroker selection. Candidates lists each
+// broker evaluated, in the order considered, along with the reason it was
+// rejected. The Reason is empty for the selected broker, which is the last
+// candidate listed. Selected is the ID of the selected broker and Score is
+// the value it was ranked by per the selector method: StorageFree for
+// storage, the storage used ratio for storage-used and Used for count.
+// Selected is -1 if no broker was selected.
+type SelectionTrace struct {
+	Candidates []CandidateTrace
+	Selected   int
+	Score      float64
+}
+
+// CandidateTrace holds a broker ID and
+// the reason it failed constraints, if any.
+type CandidateTrace struct {
+	ID     int
+	Reason string
+}
+
+// Rejected returns the CandidateTraces that failed constraints.
+func (t SelectionTrace) Rejected() []CandidateTrace {
+	var r []CandidateTrace
+	for _, c := range t.Candidates {
+		if c.Reason != "" {
+			r = append(r, c)
+		}
+	}
+
+	return r
 }
 
 // SelectBroker takes a BrokerList and a ConstraintsParams and
 // selects the most suitable broker that passes all specified
-// constraints.
+// constraints. With the storage selector method, ties in storage
+// free are broken by the fewest partitions held, then the lowest ID.
 func (c *Constraints) SelectBroker(b BrokerList, p ConstraintsParams) (*Broker, error) {
+	return c.selectBroker(b, p, nil)
+}
+
+// SelectBrokerTrace is SelectBroker, additionally returning
+// a SelectionTrace describing how the broker was selected.
+func (c *Constraints) SelectBrokerTrace(b BrokerList, p ConstraintsParams) (*Broker, SelectionTrace, error) {
+	t := SelectionTrace{Selected: -1}
+	b2, err := c.selectBroker(b, p, &t)
+	return b2, t, err
+}
+
+func (c *Constraints) selectBroker(b BrokerList, p ConstraintsParams, t *SelectionTrace) (*Broker, error) {
 	// Sort type based on the
 	// desired placement criteria.
 	switch p.SelectorMethod {
@@ -49,16 +162,93 @@ func (c *Constraints) SelectBroker(b BrokerList, p ConstraintsParams) (*Broker,
 		b.SortPseudoShuffle(p.SeedVal)
 	case "storage":
 		b.SortByStorage()
+	case "storage-used":
+		b.SortByStorageUsed()
 	default:
 		return nil, ErrInvalidSelectionMethod
 	}
 
 	var candidate *Broker
 
+	candidates := b.Filter(AllBrokersFn)
+
+	// Among candidates with equal Used values, move
+	// those with the fewest leaderships to the front.
+	if p.BrokerLeaderCounts != nil {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].Used != candidates[j].Used {
+				return candidates[i].Used < candidates[j].Used
+			}
+			return p.BrokerLeaderCounts[candidates[i].ID] < p.BrokerLeaderCounts[candidates[j].ID]
+		})
+	}
+
+	// Move candidates in localities with the
+	// fewest replicas in the role to the front.
+	if p.LocalityCounts != nil {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return p.LocalityCounts[candidates[i].Locality] < p.LocalityCounts[candidates[j].Locality]
+		})
+	}
+
+	// Move candidates with the fewest spread
+	// group replicas to the front.
+	if p.GroupCounts != nil {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return p.GroupCounts[candidates[i].ID] < p.GroupCounts[candidates[j].ID]
+		})
+	}
+
+	// Move candidates with the fewest leaderships,
+	// by locality then broker, to the front.
+	if p.LeaderCounts != nil {
+		localityLeaders := map[string]int{}
+		for _, b := range candidates {
+			localityLeaders[b.Locality] += p.LeaderCounts[b.ID]
+		}
+
+		sort.SliceStable(candidates, func(i, j int) bool {
+			li, lj := localityLeaders[candidates[i].Locality], localityLeaders[candidates[j].Locality]
+			if li != lj {
+				return li < lj
+			}
+			return p.LeaderCounts[candidates[i].ID] < p.LeaderCounts[candidates[j].ID]
+		})
+	}
+
+	// Move preferred candidates to the
+	// front, retaining the sort order.
+	if p.PreferBrokers != nil {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			_, pi := p.PreferBrokers[candidates[i].ID]
+			_, pj := p.PreferBrokers[candidates[j].ID]
+			return pi && !pj
+		})
+	}
+
+	// Move candidates in the preferred locality
+	// to the front, retaining the sort order.
+	if p.PreferLocality != "" {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Locality == p.PreferLocality && candidates[j].Locality != p.PreferLocality
+		})
+	}
+
 	// Iterate over candidates.
-	for _, candidate = range b.Filter(AllBrokersFn) {
+	for _, candidate = range candidates {
+		reason := c.rejectReason(candidate, p)
+
+		if t != nil {
+			t.Candidates = append(t.Candidates, CandidateTrace{ID: candidate.ID, Reason: reason})
+		}
+
 		// Candidate passes, return.
-		if c.passesWithParams(candidate, p) {
+		if reason == "" {
+			if t != nil {
+				t.Selected = candidate.ID
+				t.Score = selectionScore(candidate, p.SelectorMethod)
+			}
+
 			c.requestSize = p.RequestSize
 			c.Add(candidate)
 			candidate.Used++
@@ -110,15 +300,30 @@ func (b BrokerList) BestCandidate(c *Constraints, by string, p int64) (*Broker,
 // Add takes a *Broker and adds its attributes to the *Constraints.
 // The requestSize is also subtracted from the *Broker.StorageFree.
 func (c *Constraints) Add(b *Broker) {
-	b.StorageFree -= c.requestSize
+	b.subStorage(c.requestSize)
 
 	if b.Locality != "" {
 		c.locality[b.Locality] = true
 	}
 
+	if b.InstanceGroup != "" {
+		c.instanceGroup[b.InstanceGroup] = true
+	}
+
+	c.addTags(b)
 	c.id[b.ID] = true
 }
 
+// addTags adds the tag key/value pairs of a *Broker to the *Constraints.
+func (c *Constraints) addTags(b *Broker) {
+	for k, v := range b.Tags {
+		if c.tags[k] == nil {
+			c.tags[k] = map[string]bool{}
+		}
+		c.tags[k][v] = true
+	}
+}
+
 // MergeConstraints takes a brokerlist and updates the
 // *Constraints by merging the attributes of all brokers
 // from the supplied list.
@@ -137,6 +342,11 @@ func (c *Constraints) MergeConstraints(bl BrokerList) {
 			c.locality[b.Locality] = true
 		}
 
+		if b.InstanceGroup != "" {
+			c.instanceGroup[b.InstanceGroup] = true
+		}
+
+		c.addTags(b)
 		c.id[b.ID] = true
 	}
 }
@@ -156,7 +366,7 @@ func (c *Constraints) passes(b *Broker) bool {
 		return false
 	// Fail if the candidate would run
 	// out of storage.
-	case b.StorageFree-c.requestSize < 0:
+	case !b.fitsStorage(c.requestSize):
 		return false
 	}
 
@@ -164,6 +374,95 @@ func (c *Constraints) passes(b *Broker) bool {
 }
 
 func (c *Constraints) passesWithParams(b *Broker, p ConstraintsParams) bool {
+	return c.rejectReason(b, p) == ""
+}
+
+// Reasons a candidate fails constraints.
+const (
+	reasonInReplicaSet    = "already in replica set"
+	reasonLocality        = "locality collision"
+	reasonStorage         = "insufficient storage"
+	reasonForbiddenTag    = "forbidden tag"
+	reasonInstanceGroup   = "instance group collision"
+	reasonAntiAffinityTag = "anti-affinity tag collision"
+	reasonMaxLeaderBytes  = "leader bytes limit"
+	reasonMaxPartitions   = "partition limit"
+	reasonPinnedLocality  = "outside pinned locality"
+	reasonMaxRackSpread   = "rack spread limit"
+)
+
+// rejectReason takes a *Broker and ConstraintsParams and returns
+// the reason the broker fails constraints, or an empty string if
+// it passes.
+func (c *Constraints) rejectReason(b *Broker, p ConstraintsParams) string {
+	// Check the candidate against forbidden tags.
+	if b.hasAnyTag(p.ForbidTags) {
+		return reasonForbiddenTag
+	}
+
+	// Check the candidate against instance groups
+	// already holding a replica.
+	if p.InstanceGroups && c.instanceGroup[b.InstanceGroup] {
+		return reasonInstanceGroup
+	}
+
+	// Check the candidate against anti-affinity
+	// tag values already holding a replica.
+	if p.AntiAffinityTag != "" {
+		if v, exists := b.Tags[p.AntiAffinityTag]; exists && c.tags[p.AntiAffinityTag][v] {
+			return reasonAntiAffinityTag
+		}
+	}
+
+	// Check the candidate against the leader bytes cap.
+	if p.MaxLeaderBytes > 0 && p.LeaderBytes[b.ID]+p.LeaderSize > p.MaxLeaderBytes {
+		return reasonMaxLeaderBytes
+	}
+
+	// Check the candidate against the partition limit.
+	if p.MaxPartitions > 0 && b.Used+p.HeldPartitions[b.ID] >= p.MaxPartitions {
+		return reasonMaxPartitions
+	}
+
+	// Check the candidate against a pinned locality.
+	if p.PinLocality != "" {
+		switch {
+		case c.id[b.ID]:
+			return reasonInReplicaSet
+		case b.Locality != p.PinLocality:
+			return reasonPinnedLocality
+		case !b.fitsStorage(p.RequestSize):
+			return reasonStorage
+		}
+		return ""
+	}
+
+	// Check the candidate against the max rack spread.
+	if p.MaxRackSpread > 0 && len(c.locality) >= p.MaxRackSpread {
+		switch {
+		case !c.locality[b.Locality]:
+			return reasonMaxRackSpread
+		case c.id[b.ID]:
+			return reasonInReplicaSet
+		case !b.fitsStorage(p.RequestSize):
+			return reasonStorage
+		}
+		return ""
+	}
+
+	// Check the candidate against the required rack spread.
+	if p.RackSpread > 0 {
+		switch {
+		case len(c.locality) < p.RackSpread && (b.Locality == "" || c.locality[b.Locality]):
+			return reasonLocality
+		case c.id[b.ID]:
+			return reasonInReplicaSet
+		case !b.fitsStorage(p.RequestSize):
+			return reasonStorage
+		}
+		return ""
+	}
+
 	var uniqueRackIDsSatisfied bool
 	if len(c.locality) >= p.MinUniqueRackIDs {
 		uniqueRackIDsSatisfied = true
@@ -172,23 +471,37 @@ func (c *Constraints) passesWithParams(b *Broker, p ConstraintsParams) bool {
 	switch {
 	// Check the candidate against already used IDs.
 	case c.id[b.ID]:
-		return false
+		return reasonInReplicaSet
 	// Check the candidate against rack ID constraints
 	// where all rack IDs must be unique.
 	case c.locality[b.Locality] && p.MinUniqueRackIDs == 0:
-		return false
+		return reasonLocality
 	// Check the candidate against rack ID constraints
 	// where a non-zero MinUniqueRackIDs is set.
 	case c.locality[b.Locality] && p.MinUniqueRackIDs > 0:
 		if !uniqueRackIDsSatisfied {
-			return false
+			return reasonLocality
 		}
-	// Check the candidate against storage capacity.
-	case b.StorageFree-p.RequestSize < 0:
-		return false
+	// Check the candidate against storage capacity. If per log dir
+	// storage data is available, a single log dir must fit the request.
+	case !b.fitsStorage(p.RequestSize):
+		return reasonStorage
 	}
 
-	return true
+	return ""
+}
+
+// selectionScore returns the value a *Broker
+// is ranked by for the selector method.
+func selectionScore(b *Broker, method string) float64 {
+	switch method {
+	case "storage":
+		return b.StorageFree
+	case "storage-used":
+		return b.StorageUsed()
+	}
+
+	return float64(b.Used)
 }
 
 // TODO deprecate.
@@ -209,6 +522,11 @@ func MergeConstraints(bl BrokerList) *Constraints {
 			c.locality[b.Locality] = true
 		}
 
+		if b.InstanceGroup != "" {
+			c.instanceGroup[b.InstanceGroup] = true
+		}
+
+		c.addTags(b)
 		c.id[b.ID] = true
 	}
 
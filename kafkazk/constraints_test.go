@@ -101,6 +101,145 @@ func TestSelectBrokerByStorage(t *testing.T) {
 	}
 }
 
+func TestSelectBrokerByStorageTieBreak(t *testing.T) {
+	// All brokers have equal storage free.
+	bl := BrokerList{
+		&Broker{ID: 1004, Locality: "a", Used: 1, StorageFree: 400.00},
+		&Broker{ID: 1003, Locality: "b", Used: 2, StorageFree: 400.00},
+		&Broker{ID: 1002, Locality: "c", Used: 1, StorageFree: 400.00},
+		&Broker{ID: 1001, Locality: "d", Used: 2, StorageFree: 400.00},
+	}
+
+	p := ConstraintsParams{SelectorMethod: "storage"}
+
+	// Ties are broken by the lowest Used, then the lowest ID. Selected
+	// brokers are excluded from subsequent selections by the constraints.
+	expected := []int{1002, 1004, 1001, 1003}
+
+	c := NewConstraints()
+	for _, id := range expected {
+		b, err := c.SelectBroker(bl, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b.ID != id {
+			t.Errorf("Expected candidate with ID %d, got %d", id, b.ID)
+		}
+	}
+
+	// The selection is independent of the input order.
+	for i := range bl {
+		bl[i].Used = 0
+	}
+	bl[0], bl[3] = bl[3], bl[0]
+
+	b, _ := NewConstraints().SelectBroker(bl, p)
+	if b.ID != 1001 {
+		t.Errorf("Expected candidate with ID 1001, got %d", b.ID)
+	}
+}
+
+func TestSelectBrokerTrace(t *testing.T) {
+	bl := BrokerList{
+		&Broker{ID: 1001, Locality: "a", StorageFree: 9000.00, StorageTotal: 10000.00},
+		&Broker{ID: 1002, Locality: "b", StorageFree: 800.00, StorageTotal: 1000.00},
+		&Broker{ID: 1003, Locality: "c", StorageFree: 7000.00, StorageTotal: 10000.00},
+		&Broker{ID: 1004, Locality: "d", StorageFree: 6000.00, StorageTotal: 10000.00},
+	}
+
+	c := NewConstraints()
+	c.locality["a"] = true
+
+	p := ConstraintsParams{
+		SelectorMethod: "storage-used",
+		RequestSize:    1000.00,
+	}
+
+	b, trace, err := c.SelectBrokerTrace(bl, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.ID != 1003 || trace.Selected != 1003 {
+		t.Errorf("Expected candidate with ID 1003, got %d (trace: %d)", b.ID, trace.Selected)
+	}
+
+	if trace.Score < 0.299 || trace.Score > 0.301 {
+		t.Errorf("Expected score of 0.30, got %.2f", trace.Score)
+	}
+
+	// Candidates are listed in the order considered,
+	// ending with the selected broker.
+	expected := []CandidateTrace{
+		{ID: 1001, Reason: reasonLocality},
+		{ID: 1002, Reason: reasonStorage},
+		{ID: 1003},
+	}
+
+	if len(trace.Candidates) != len(expected) {
+		t.Fatalf("Expected %d candidates, got %v", len(expected), trace.Candidates)
+	}
+
+	for i := range expected {
+		if trace.Candidates[i] != expected[i] {
+			t.Errorf("Expected candidate %v, got %v", expected[i], trace.Candidates[i])
+		}
+	}
+
+	if r := trace.Rejected(); len(r) != 2 {
+		t.Errorf("Expected 2 rejected candidates, got %v", r)
+	}
+
+	// An exhausted candidate list lists all brokers
+	// as rejected with no broker selected.
+	c.locality["b"], c.locality["c"], c.locality["d"] = true, true, true
+
+	_, trace, err = c.SelectBrokerTrace(bl, p)
+	if err != ErrNoBrokers {
+		t.Errorf("Expected error '%s', got '%v'", ErrNoBrokers, err)
+	}
+
+	if trace.Selected != -1 {
+		t.Errorf("Expected no selected broker, got %d", trace.Selected)
+	}
+
+	if r := trace.Rejected(); len(r) != len(bl) {
+		t.Errorf("Expected %d rejected candidates, got %v", len(bl), r)
+	}
+}
+
+func TestSelectBrokerPreferBrokers(t *testing.T) {
+	bl := BrokerList{
+		&Broker{ID: 1001, Locality: "a", StorageFree: 400},
+		&Broker{ID: 1002, Locality: "b", StorageFree: 300},
+		&Broker{ID: 1003, Locality: "c", StorageFree: 200},
+		&Broker{ID: 1004, Locality: "a", StorageFree: 100},
+	}
+
+	p := ConstraintsParams{
+		SelectorMethod: "storage",
+		PreferBrokers:  map[int]struct{}{1003: {}, 1004: {}},
+	}
+
+	c := NewConstraints()
+
+	// Preferred brokers are selected ahead of those with more storage free.
+	b, _ := c.SelectBroker(bl, p)
+	if b.ID != 1003 {
+		t.Errorf("Expected candidate with ID 1003, got %d", b.ID)
+	}
+
+	// Locality "a" is excluded once 1004 is selected, then
+	// non-preferred brokers are selected by storage free.
+	for _, expected := range []int{1004, 1002} {
+		b, _ = c.SelectBroker(bl, p)
+		if b.ID != expected {
+			t.Errorf("Expected candidate with ID %d, got %d", expected, b.ID)
+		}
+	}
+}
+
 func TestBestCandidateByCount(t *testing.T) {
 	localities := []string{"a", "b", "c"}
 	bl := BrokerList{}
@@ -304,6 +443,87 @@ func TestConstraintsPassesWithParams(t *testing.T) {
 	}
 }
 
+func TestConstraintsPassesWithParamsAntiAffinityTag(t *testing.T) {
+	c := NewConstraints()
+	c.Add(&Broker{ID: 1001, Locality: "a", Tags: map[string]string{"power": "p1"}})
+
+	// Passes rack constraints but shares a power zone.
+	b1 := &Broker{ID: 1002, Locality: "b", Tags: map[string]string{"power": "p1"}}
+	// Passes.
+	b2 := &Broker{ID: 1003, Locality: "c", Tags: map[string]string{"power": "p2"}}
+	// Passes; brokers without the tag aren't constrained.
+	b3 := &Broker{ID: 1004, Locality: "d"}
+
+	p := ConstraintsParams{}
+
+	for _, b := range []*Broker{b1, b2, b3} {
+		if !c.passesWithParams(b, p) {
+			t.Errorf("Expected broker %d to pass without an anti-affinity tag", b.ID)
+		}
+	}
+
+	p.AntiAffinityTag = "power"
+	expected := []bool{false, true, true}
+
+	for i, b := range []*Broker{b1, b2, b3} {
+		if r := c.passesWithParams(b, p); r != expected[i] {
+			t.Errorf("Expected broker %d constraint check %v, got %v", b.ID, expected[i], r)
+		}
+	}
+}
+
+func TestConstraintsPassesWithParamsLogDirs(t *testing.T) {
+	c := NewConstraints()
+
+	// Ample aggregate storage free, but one log dir is full and no
+	// single log dir can hold the request.
+	b := &Broker{
+		ID:          1001,
+		Locality:    "a",
+		StorageFree: 1000,
+		LogDirStorageFree: map[string]float64{
+			"/data/1": 0,
+			"/data/2": 400,
+			"/data/3": 600,
+		},
+	}
+
+	p := ConstraintsParams{RequestSize: 500}
+
+	// The /data/3 log dir can hold the request.
+	if !c.passesWithParams(b, p) {
+		t.Errorf("Expected broker to pass constraints")
+	}
+
+	// Adding the broker should subtract the request
+	// size from the log dir with the most storage free.
+	c.requestSize = p.RequestSize
+	c.Add(b)
+
+	if b.LogDirStorageFree["/data/3"] != 100 {
+		t.Errorf("Expected /data/3 storage free of 100, got %.2f", b.LogDirStorageFree["/data/3"])
+	}
+
+	if b.StorageFree != 500 {
+		t.Errorf("Expected aggregate storage free of 500, got %.2f", b.StorageFree)
+	}
+
+	// The aggregate StorageFree satisfies the request, but no
+	// single log dir does; the broker should fail.
+	c = NewConstraints()
+
+	if c.passesWithParams(b, p) {
+		t.Errorf("Expected broker to fail constraints")
+	}
+
+	// Without log dir data, the aggregate StorageFree is used.
+	b.LogDirStorageFree = nil
+
+	if !c.passesWithParams(b, p) {
+		t.Errorf("Expected broker to pass constraints")
+	}
+}
+
 func TestMergeConstraints(t *testing.T) {
 	localities := []string{"a", "b", "c"}
 	bl := BrokerList{}
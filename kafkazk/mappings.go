@@ -2,6 +2,7 @@ package kafkazk
 
 import (
 	"fmt"
+	"sort"
 )
 
 // Mappings is a mapping of broker IDs to currently held
@@ -100,3 +101,53 @@ func (m Mappings) Remove(id int, p Partition) error {
 
 	return nil
 }
+
+// UntouchedBrokers takes a *PartitionMap before and after a change along with
+// a BrokerMap and returns the IDs of brokers in the BrokerMap that host the
+// exact same set of partitions in both maps. Changes in replica position
+// (e.g. leadership) aren't considered.
+func UntouchedBrokers(before, after *PartitionMap, bm BrokerMap) []int {
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	hosted := func(pm *PartitionMap) map[int]map[key]struct{} {
+		h := map[int]map[key]struct{}{}
+		for _, p := range pm.Partitions {
+			for _, id := range p.Replicas {
+				if _, exists := h[id]; !exists {
+					h[id] = map[key]struct{}{}
+				}
+				h[id][key{p.Topic, p.Partition}] = struct{}{}
+			}
+		}
+		return h
+	}
+
+	hb, ha := hosted(before), hosted(after)
+
+	var ids []int
+
+	for id := range bm {
+		if id == StubBrokerID || len(hb[id]) != len(ha[id]) {
+			continue
+		}
+
+		same := true
+		for k := range hb[id] {
+			if _, exists := ha[id][k]; !exists {
+				same = false
+				break
+			}
+		}
+
+		if same {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Ints(ids)
+
+	return ids
+}
@@ -104,3 +104,21 @@ func TestRemove(t *testing.T) {
 		}
 	}
 }
+
+func TestUntouchedBrokers(t *testing.T) {
+	bm := newStubBrokerMap()
+	before, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	after := before.Copy()
+
+	// Replace 1003 with 1001 for p3; 1001 and 1003 are involved.
+	after.Partitions[3].Replicas = []int{1004, 1001, 1002}
+	// A leadership change on p1 doesn't count as a change in hosted partitions.
+	after.Partitions[1].Replicas = []int{1001, 1002}
+
+	untouched := UntouchedBrokers(before, after, bm)
+
+	expected := []int{1002, 1004}
+	if !intsEqual(untouched, expected) {
+		t.Errorf("Expected untouched brokers %v, got %v", expected, untouched)
+	}
+}
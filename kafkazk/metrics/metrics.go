@@ -0,0 +1,90 @@
+// Package metrics exports kafkazk partition placement stats as Prometheus
+// metrics.
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	brokerPartitionsDesc = prometheus.NewDesc(
+		"kafkazk_broker_partitions",
+		"Number of partition replicas assigned to the broker.",
+		[]string{"broker_id", "locality"}, nil,
+	)
+	brokerLeadersDesc = prometheus.NewDesc(
+		"kafkazk_broker_leaders",
+		"Number of partition leaders assigned to the broker.",
+		[]string{"broker_id", "locality"}, nil,
+	)
+	brokerStorageDesc = prometheus.NewDesc(
+		"kafkazk_broker_partition_storage_bytes",
+		"Sum of the sizes of partition replicas assigned to the broker.",
+		[]string{"broker_id", "locality"}, nil,
+	)
+)
+
+// placementCollector is a prometheus.Collector that reports
+// broker placement stats for a PartitionMap.
+type placementCollector struct {
+	pm  *kafkazk.PartitionMap
+	bm  kafkazk.BrokerMap
+	pmm kafkazk.PartitionMetaMap
+}
+
+// Register takes a PartitionMap, BrokerMap, PartitionMetaMap and
+// prometheus.Registerer and registers a collector reporting per broker
+// partition counts, leader counts and partition storage for the PartitionMap.
+// Values are computed from the PartitionMap at scrape time. Metrics are labeled
+// with the broker ID and the broker locality from the BrokerMap. Partition
+// storage is only reported if a PartitionMetaMap is provided.
+func Register(pm *kafkazk.PartitionMap, bm kafkazk.BrokerMap, pmm kafkazk.PartitionMetaMap, reg prometheus.Registerer) error {
+	return reg.Register(&placementCollector{pm: pm, bm: bm, pmm: pmm})
+}
+
+// Describe satisfies the prometheus.Collector interface.
+func (c *placementCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- brokerPartitionsDesc
+	ch <- brokerLeadersDesc
+	ch <- brokerStorageDesc
+}
+
+// Collect satisfies the prometheus.Collector interface.
+func (c *placementCollector) Collect(ch chan<- prometheus.Metric) {
+	storage := map[int]float64{}
+
+	if c.pmm != nil {
+		for _, p := range c.pm.Partitions {
+			s, err := c.pmm.Size(p)
+			if err != nil {
+				continue
+			}
+			for _, id := range p.Replicas {
+				storage[id] += s
+			}
+		}
+	}
+
+	for id, stats := range c.pm.UseStats() {
+		var locality string
+		if b, exists := c.bm[id]; exists {
+			locality = b.Locality
+		}
+
+		labels := []string{strconv.Itoa(id), locality}
+
+		ch <- prometheus.MustNewConstMetric(brokerPartitionsDesc,
+			prometheus.GaugeValue, float64(stats.Leader+stats.Follower), labels...)
+		ch <- prometheus.MustNewConstMetric(brokerLeadersDesc,
+			prometheus.GaugeValue, float64(stats.Leader), labels...)
+
+		if c.pmm != nil {
+			ch <- prometheus.MustNewConstMetric(brokerStorageDesc,
+				prometheus.GaugeValue, storage[id], labels...)
+		}
+	}
+}
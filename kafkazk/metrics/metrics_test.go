@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegister(t *testing.T) {
+	zk := kafkazk.NewZooKeeperStub()
+	pm, _ := zk.GetPartitionMap("test_topic")
+	pmm, _ := zk.GetAllPartitionMeta()
+	bm := kafkazk.BrokerMap{
+		1001: &kafkazk.Broker{ID: 1001, Locality: "a"},
+		1002: &kafkazk.Broker{ID: 1002, Locality: "b"},
+		1003: &kafkazk.Broker{ID: 1003, Locality: ""},
+		1004: &kafkazk.Broker{ID: 1004, Locality: "a"},
+	}
+
+	reg := prometheus.NewRegistry()
+	if err := Register(pm, bm, pmm, reg); err != nil {
+		t.Fatal(err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Broker 1001 holds p0 (leader, 1000), p1 (follower, 1500),
+	// and p2 (follower, 2000).
+	expected := map[string]float64{
+		"kafkazk_broker_partitions":              3,
+		"kafkazk_broker_leaders":                 1,
+		"kafkazk_broker_partition_storage_bytes": 4500,
+	}
+
+	if len(mfs) != len(expected) {
+		t.Errorf("Expected %d metric families, got %d", len(expected), len(mfs))
+	}
+
+	for _, mf := range mfs {
+		v, exists := expected[mf.GetName()]
+		if !exists {
+			t.Errorf("Unexpected metric family %s", mf.GetName())
+			continue
+		}
+
+		// One series per broker.
+		if len(mf.GetMetric()) != 4 {
+			t.Errorf("Expected 4 series for %s, got %d", mf.GetName(), len(mf.GetMetric()))
+		}
+
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+
+			if labels["broker_id"] != "1001" {
+				continue
+			}
+
+			if labels["locality"] != "a" {
+				t.Errorf("Expected locality a for broker 1001, got %s", labels["locality"])
+			}
+
+			if got := m.GetGauge().GetValue(); got != v {
+				t.Errorf("Expected %s value %f for broker 1001, got %f", mf.GetName(), v, got)
+			}
+		}
+	}
+}
@@ -1,22 +1,38 @@
 package kafkazk
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
+	"os"
 	"regexp"
 	"sort"
+	"strings"
 )
 
-// Partition represents the Kafka partition structure.
+// Partition represents the Kafka partition structure. LogDirs optionally
+// specifies the log dir for each replica, by replica set position, as
+// allowed in the Kafka reassignment format; LogDirAny leaves the selection to
+// the broker.
 type Partition struct {
-	Topic     string `json:"topic"`
-	Partition int    `json:"partition"`
-	Replicas  []int  `json:"replicas"`
+	Topic     string   `json:"topic"`
+	Partition int      `json:"partition"`
+	Replicas  []int    `json:"replicas"`
+	LogDirs   []string `json:"log_dirs,omitempty"`
 }
 
+// LogDirAny is the log dir value leaving the log dir
+// selection for a replica to the broker.
+const LogDirAny = "any"
+
+// SupportedPartitionMapVersion is the partition map
+// version supported by the Kafka reassignment format.
+const SupportedPartitionMapVersion = 1
+
 // PartitionList is a []Partition.
 type PartitionList []Partition
 
@@ -78,8 +94,9 @@ func (p PartitionList) Less(i, j int) bool {
 	return p[i].Partition < p[j].Partition
 }
 
-// PartitionMap sort by partition size.
-
+// PartitionMap sort by partition size descending. Partitions of equal size
+// are ordered by topic name, then by partition number, ascending. This is a
+// total order, making the sort independent of the input order.
 type partitionsBySize struct {
 	pl PartitionList
 	pm PartitionMetaMap
@@ -98,19 +115,26 @@ func (p partitionsBySize) Less(i, j int) bool {
 		return false
 	}
 
+	if p.pl[i].Topic != p.pl[j].Topic {
+		return p.pl[i].Topic < p.pl[j].Topic
+	}
+
 	return p.pl[i].Partition < p.pl[j].Partition
 }
 
 // SortBySize takes a PartitionMetaMap and sorts the PartitionList
-// by partition size.
+// by partition size descending. Ties are broken by topic name,
+// then by partition number.
 func (p PartitionList) SortBySize(m PartitionMetaMap) {
 	sort.Sort(partitionsBySize{pl: p, pm: m})
 }
 
 // replicasByLeaderFollowerRatio is used to shuffle replica
-// sets according to the broker leader to follower ratio.
+// sets according to the broker leader to follower ratio. Any
+// log dirs are moved along with the replicas.
 type replicasByLeaderFollowerRatio struct {
 	replicas []int
+	logDirs  []string
 	stats    BrokerUseStatsMap
 }
 
@@ -118,6 +142,9 @@ func (r replicasByLeaderFollowerRatio) Len() int { return len(r.replicas) }
 
 func (r replicasByLeaderFollowerRatio) Swap(i, j int) {
 	r.replicas[i], r.replicas[j] = r.replicas[j], r.replicas[i]
+	if len(r.logDirs) == len(r.replicas) {
+		r.logDirs[i], r.logDirs[j] = r.logDirs[j], r.logDirs[i]
+	}
 }
 
 func (r replicasByLeaderFollowerRatio) Less(i, j int) bool {
@@ -156,6 +183,30 @@ func NewPartitionMetaMap() PartitionMetaMap {
 	return map[string]map[int]*PartitionMeta{}
 }
 
+// PartitionMetaMapFromFile takes a path to a json encoded PartitionMetaMap,
+// e.g. {"topic": {"0": {"Size": 1000}}}, and returns the PartitionMetaMap.
+// This is the format stored in ZooKeeper (see GetAllPartitionMeta), allowing
+// storage based planning without metrics in ZooKeeper. As with ZooKeeper,
+// compressed data is accepted.
+func PartitionMetaMapFromFile(path string) (PartitionMetaMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the data is compressed.
+	if out, compressed := uncompress(data); compressed {
+		data = out
+	}
+
+	pmm := NewPartitionMetaMap()
+	if err := json.Unmarshal(data, &pmm); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling partition meta: %s", err.Error())
+	}
+
+	return pmm, nil
+}
+
 // ReplicaSets is a mapping of partition number to Partition.Replicas.
 // Take note that there is no topic identifier and that partition
 // numbers from two different topics can overwrite one another.
@@ -182,14 +233,92 @@ func (pmm PartitionMetaMap) Size(p Partition) (float64, error) {
 // RebuildParams holds required parameters to call the Rebuild
 // method on a *PartitionMap.
 type RebuildParams struct {
-	pm               *PartitionMap
-	PMM              PartitionMetaMap
-	BM               BrokerMap
-	Strategy         string
-	Optimization     string
-	Affinities       SubstitutionAffinities
-	PartnSzFactor    float64
+	pm *PartitionMap
+	// Partition sizes, required for storage based placements.
+	PMM PartitionMetaMap
+	// Brokers available for placements.
+	BM BrokerMap
+	// Placement strategy; "count", "count-rackaware" or "storage".
+	Strategy string
+	// Storage strategy optimization; "distribution" or "storage".
+	Optimization string
+	// Replacement broker affinities.
+	Affinities SubstitutionAffinities
+	// Multiplier applied to partition sizes.
+	PartnSzFactor float64
+	// Minimum distinct rack IDs per replica set; 0 requires all unique.
 	MinUniqueRackIDs int
+	// ForbidLeaderTags is a map of broker tag keys to values; brokers having
+	// any of these tags never lead a partition but may hold followers.
+	ForbidLeaderTags map[string]string
+	// LeaderPools is a map of topic names to broker IDs; leaders for a topic
+	// listed are selected only from its pool.
+	LeaderPools map[string][]int
+	// PreserveOrder, if set, retains the partition order of the input map
+	// rather than sorting by topic and partition.
+	PreserveOrder bool
+	// RackPinnedTopics is a set of topic names where all replicas of each
+	// partition are placed in a single locality; that of any retained
+	// replicas, otherwise the least utilized locality that fits them.
+	RackPinnedTopics map[string]struct{}
+	// MinimizeCrossRack, if set, places followers in the leader's locality
+	// where MinUniqueRackIDs allows.
+	MinimizeCrossRack bool
+	// DataRoleTag, if set, limits placements to brokers with the tag,
+	// specified as a "key=value" pair or a key matching any value.
+	DataRoleTag string
+	// LeaderFirstBalance, if set, places leaders in the locality, then on the
+	// broker, holding the fewest leaderships regardless of strategy.
+	LeaderFirstBalance bool
+	// SpreadGroups is a map of group names to topic names; placements for
+	// topics in a group prefer brokers holding the fewest replicas of the group.
+	SpreadGroups map[string][]string
+	// InstanceGroups, if set, places no two replicas of a partition on brokers
+	// in the same instance group.
+	InstanceGroups bool
+	// MaxLeaderBytesPerBroker, if non-zero, skips leader placements on brokers
+	// where the size of partitions led would exceed the value.
+	MaxLeaderBytesPerBroker float64
+	// RackBalanceByPosition, if set, places replicas in the locality holding
+	// the fewest replicas in the role (leader or follower) being placed.
+	RackBalanceByPosition bool
+	// TenantTags is a map of topic name prefixes to broker tags, in the
+	// DataRoleTag format; topics are confined to brokers with the tag of the
+	// longest matching prefix.
+	TenantTags map[string]string
+	// ReuseFreedSlots, if set, projects broker Used counts to the replicas
+	// retained from the input map so that freed capacity is reused first.
+	// This assumes that BM was built from the same topics as the input map.
+	ReuseFreedSlots bool
+	// MaxRackSpread, if non-zero, limits the distinct rack IDs per replica set.
+	MaxRackSpread int
+	// MaxPartitionsPerBroker, if non-zero, is the most replicas a broker may
+	// hold, counting its Used value and any HeldPartitions. Rebuild returns
+	// an error stating any capacity shortfall.
+	MaxPartitionsPerBroker int
+	// HeldPartitions is a map of broker IDs to replicas held for other
+	// topics, counted toward MaxPartitionsPerBroker.
+	HeldPartitions map[int]int
+	// RackAware, if set, requires each replica set to span as many distinct
+	// localities as its replication factor, or all eligible localities if
+	// fewer, or returns an error. MinUniqueRackIDs isn't applied.
+	RackAware bool
+	// ShuffleSeed, if non-zero, seeds the replica set shuffle following
+	// storage optimized placements with a single source, making rebuilds
+	// reproducible and idempotent. 0 retains the original per-partition shuffle.
+	ShuffleSeed int64
+	// AntiAffinityTag, if set, is a broker tag key; no two replicas of a
+	// partition are placed on brokers sharing a value for the tag.
+	AntiAffinityTag string
+	// FrozenPartitions is a map of topic names to partition numbers that are
+	// copied verbatim into the rebuilt map.
+	FrozenPartitions map[string][]int
+	// PlacementTrace, if set, is called with the partition and SelectionTrace
+	// of each constraints based broker selection.
+	PlacementTrace func(Partition, SelectionTrace)
+	// MinimalMovement, if set, skips the replica set shuffle and prefers
+	// replacement brokers already holding replicas of the topic.
+	MinimalMovement bool
 }
 
 // NewRebuildParams initializes a RebuildParams.
@@ -199,6 +328,19 @@ func NewRebuildParams() RebuildParams {
 	}
 }
 
+// selectBroker calls SelectBroker on the *Constraints, passing the
+// SelectionTrace to the PlacementTrace func if set.
+func (params RebuildParams) selectBroker(c *Constraints, bl BrokerList, cp ConstraintsParams, p Partition) (*Broker, error) {
+	if params.PlacementTrace == nil {
+		return c.SelectBroker(bl, cp)
+	}
+
+	b, t, err := c.SelectBrokerTrace(bl, cp)
+	params.PlacementTrace(Partition{Topic: p.Topic, Partition: p.Partition}, t)
+
+	return b, err
+}
+
 // OptimizeLeaderFollower is a simple leadership optimization algorithm
 // that iterates over each partition's replica set and sorts brokers
 // according to their leader/follower position ratio, ascending. The idea
@@ -210,22 +352,199 @@ func (pm *PartitionMap) OptimizeLeaderFollower() {
 		for _, partn := range pm.Partitions {
 			sort.Sort(replicasByLeaderFollowerRatio{
 				replicas: partn.Replicas,
+				logDirs:  partn.LogDirs,
 				stats:    pm.UseStats(),
 			})
 		}
 	}
 }
 
+// LeaderOptimization is a leadership optimization algorithm that balances
+// the sum of leader partition sizes per broker, rather than leader counts.
+// Partitions are visited in descending size order and the replica with the
+// lowest leader size sum accumulated so far is promoted to leader; ties keep
+// the existing leader. An error is returned, with the PartitionMap left
+// unmodified, if any partition size is missing from the PartitionMetaMap.
+func (pm *PartitionMap) LeaderOptimization(pmm PartitionMetaMap) error {
+	sizes := make(map[*Partition]float64, len(pm.Partitions))
+	partitions := make([]*Partition, 0, len(pm.Partitions))
+
+	for i := range pm.Partitions {
+		p := &pm.Partitions[i]
+		s, err := pmm.Size(*p)
+		if err != nil {
+			return err
+		}
+		sizes[p] = s
+		partitions = append(partitions, p)
+	}
+
+	sort.SliceStable(partitions, func(i, j int) bool {
+		return sizes[partitions[i]] > sizes[partitions[j]]
+	})
+
+	leaderSizes := map[int]float64{}
+
+	for _, p := range partitions {
+		best := -1
+		for i, id := range p.Replicas {
+			if id == StubBrokerID {
+				continue
+			}
+			if best < 0 || leaderSizes[id] < leaderSizes[p.Replicas[best]] {
+				best = i
+			}
+		}
+
+		if best < 0 {
+			continue
+		}
+
+		p.promote(best)
+		leaderSizes[p.Replicas[0]] += sizes[p]
+	}
+
+	return nil
+}
+
+// PreferLeaders takes an ordered list of broker IDs, most preferred first.
+// For each partition, the most preferred broker in the replica set is promoted
+// to leader, with the remaining replicas retaining their relative order.
+// Partitions with no preferred brokers are unchanged. Replica set membership
+// is unchanged, so rack constraints satisfied by the replica set still hold.
+func (pm *PartitionMap) PreferLeaders(ids []int) {
+	rank := make(map[int]int, len(ids))
+	for i, id := range ids {
+		if _, exists := rank[id]; !exists {
+			rank[id] = i
+		}
+	}
+
+	for _, p := range pm.Partitions {
+		best := -1
+		for i, id := range p.Replicas {
+			r, exists := rank[id]
+			if !exists {
+				continue
+			}
+			if best < 0 || r < rank[p.Replicas[best]] {
+				best = i
+			}
+		}
+
+		if best > 0 {
+			p.promote(best)
+		}
+	}
+}
+
+// RebalanceTopicLeaders takes a topic name and returns a copy of the
+// *PartitionMap where the replica sets for the topic are reordered to balance
+// leadership among the brokers already holding the topic's partitions. Replica
+// set membership is unchanged and all other topics are left untouched. For
+// each partition, the replica with the fewest leaderships assigned so far is
+// selected as the leader; ties are broken by the existing replica order.
+func (pm *PartitionMap) RebalanceTopicLeaders(topic string) *PartitionMap {
+	cpy := pm.Copy()
+	leaders := map[int]int{}
+
+	for _, partn := range cpy.Partitions {
+		if partn.Topic != topic || len(partn.Replicas) == 0 {
+			continue
+		}
+
+		// Find the replica with the lowest leader count.
+		var idx int
+		for i, id := range partn.Replicas {
+			if leaders[id] < leaders[partn.Replicas[idx]] {
+				idx = i
+			}
+		}
+
+		leaders[partn.Replicas[idx]]++
+
+		// Move the selected leader to the head of the
+		// replica set, preserving the follower order.
+		partn.promote(idx)
+	}
+
+	return cpy
+}
+
 // Rebuild takes a BrokerMap and rebuild strategy. It then traverses the
 // partition map, replacing brokers marked removal with the best available
 // candidate based on the selected rebuild strategy. A rebuilt *PartitionMap
-// and []error of errors is returned.
+// and []error of errors is returned. With a non-zero ShuffleSeed, rebuilds are
+// idempotent; rebuilding a rebuilt map with the same parameters returns an
+// identical map. The rebuilt map is checked with Validate and any errors are
+// included.
 func (pm *PartitionMap) Rebuild(params RebuildParams) (*PartitionMap, []error) {
 	var newMap *PartitionMap
 	var errs []error
 
 	params.pm = pm
 
+	// Record the input order. Placement strategies
+	// sort the input partitions.
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	order := map[key]int{}
+	for i, p := range pm.Partitions {
+		order[key{p.Topic, p.Partition}] = i
+	}
+
+	if params.MaxRackSpread > 0 && params.MinUniqueRackIDs > params.MaxRackSpread {
+		return nil, []error{fmt.Errorf("MinUniqueRackIDs %d exceeds MaxRackSpread %d",
+			params.MinUniqueRackIDs, params.MaxRackSpread)}
+	}
+
+	// The count-rackaware strategy is the count
+	// strategy with rack aware placement.
+	if params.Strategy == "count-rackaware" {
+		params.Strategy = "count"
+		params.RackAware = true
+	}
+
+	// Project broker usage to the
+	// retained replicas if configured.
+	if params.ReuseFreedSlots {
+		projectFreedSlots(params)
+	}
+
+	// Set aside any frozen partitions; these are
+	// copied verbatim into the rebuilt map.
+	var frozen PartitionList
+	if len(params.FrozenPartitions) > 0 {
+		frozenSet := map[key]struct{}{}
+		for topic, partitions := range params.FrozenPartitions {
+			for _, n := range partitions {
+				frozenSet[key{topic, n}] = struct{}{}
+			}
+		}
+
+		rebuild := &PartitionMap{Version: pm.Version}
+		for _, p := range pm.Copy().Partitions {
+			if _, f := frozenSet[key{p.Topic, p.Partition}]; f {
+				frozen = append(frozen, p)
+				continue
+			}
+			rebuild.Partitions = append(rebuild.Partitions, p)
+		}
+
+		params.pm = rebuild
+	}
+
+	// Ensure that the eligible brokers have the
+	// capacity for all placements under the limit.
+	if params.MaxPartitionsPerBroker > 0 {
+		if err := partitionCapacity(params); err != nil {
+			return nil, []error{err}
+		}
+	}
+
 	switch params.Strategy {
 	case "count":
 		// Standard sort
@@ -251,7 +570,12 @@ func (pm *PartitionMap) Rebuild(params RebuildParams) (*PartitionMap, []error) {
 			// brokers for each partition at a time (in contrast to placeByPosition).
 			// Shuffling has proven so far to distribute leadership even though
 			// it's purely by probability. Eventually, write a real optimizer.
-			newMap.shuffle(func(_ Partition) bool { return true })
+			// Nothing is shuffled if minimizing movement.
+			if params.MinimalMovement {
+				break
+			}
+
+			newMap.shuffle(params.ShuffleSeed, func(_ Partition) bool { return true })
 		// Invalid optimization.
 		default:
 			return nil, []error{fmt.Errorf("Invalid optimization '%s'", params.Optimization)}
@@ -261,192 +585,618 @@ func (pm *PartitionMap) Rebuild(params RebuildParams) (*PartitionMap, []error) {
 		return nil, []error{fmt.Errorf("Invalid rebuild strategy '%s'", params.Strategy)}
 	}
 
-	// Final sort.
-	sort.Sort(newMap.Partitions)
+	// Ensure that topics with leader pools are led
+	// by pool brokers where the replica set allows.
+	if len(params.LeaderPools) > 0 {
+		newMap.promotePoolLeaders(params.LeaderPools)
+	}
 
-	return newMap, errs
-}
+	// Ensure that no brokers with forbidden
+	// leader tags are in the leader position.
+	if len(params.ForbidLeaderTags) > 0 {
+		errs = append(errs, newMap.demoteForbiddenLeaders(params.BM, params.ForbidLeaderTags)...)
+	}
 
-// placeByPosition builds a PartitionMap by doing placements for all
-// partitions, one broker index at a time. For instance, if all partitions
-// required a broker set length of 3 (aka a replication factor of 3), we'd
-// do all placements in 3 passes. The first pass would be leaders for all
-// partitions, the second pass would be the first follower, and the third
-// pass would be the second follower. This placement pattern is optimal
-// for the count strategy.
-func placeByPosition(params RebuildParams) (*PartitionMap, []error) {
-	newMap := NewPartitionMap()
+	// Include any frozen partitions.
+	newMap.Partitions = append(newMap.Partitions, frozen...)
 
-	// We need a filtered list for usage sorting and exclusion
-	// of nodes marked for removal.
-	f := func(b *Broker) bool {
-		if b.Replace {
-			return false
+	// Validate the rebuilt replica sets. Stub brokers remain where
+	// placements failed, which are already reported.
+	for _, err := range newMap.Validate() {
+		if len(errs) > 0 && errors.Is(err, ErrStubReplica) {
+			continue
 		}
-		return true
+		errs = append(errs, err)
 	}
 
-	bl := params.BM.Filter(f).List()
+	// Final sort.
+	if params.PreserveOrder {
+		// Restore the input order on both
+		// the input and rebuilt map.
+		for _, pl := range []PartitionList{pm.Partitions, newMap.Partitions} {
+			sort.SliceStable(pl, func(i, j int) bool {
+				return order[key{pl[i].Topic, pl[i].Partition}] < order[key{pl[j].Topic, pl[j].Partition}]
+			})
+		}
+	} else {
+		sort.Sort(newMap.Partitions)
+	}
 
-	var errs []error
-	var pass int
+	return newMap, errs
+}
 
-	// Check if we need more passes.
-	// If we've just counted as many skips
-	// as there are partitions to handle,
-	// we have nothing left to do.
-	for skipped := 0; skipped < len(params.pm.Partitions); {
-		for n, partn := range params.pm.Partitions {
-			// If this is the first pass, create
-			// the new partition.
-			if pass == 0 {
-				newPartn := Partition{Partition: partn.Partition, Topic: partn.Topic}
-				newMap.Partitions = append(newMap.Partitions, newPartn)
+// RebuildByTopic takes a BrokerMap, PartitionMetaMap and rebuild strategy and
+// rebuilds each topic in the PartitionMap independently. A map of topic names
+// to rebuilt PartitionMaps is returned, along with a map of topic names to any
+// errors encountered. Each topic is rebuilt against a copy of the BrokerMap
+// where broker Used counts reflect only the replicas held for that topic, so
+// that replica balancing isn't influenced by other topics, e.g. those with a
+// different replication factor. Storage placements use the distribution
+// optimization. Topics are rebuilt in name order and StorageFree changes carry
+// forward to subsequent topics so that storage placements don't overcommit
+// brokers.
+func (pm *PartitionMap) RebuildByTopic(bm BrokerMap, pmm PartitionMetaMap, strategy string) (map[string]*PartitionMap, map[string][]string) {
+	maps := map[string]*PartitionMap{}
+	errs := map[string][]string{}
+
+	params := NewRebuildParams()
+	params.PMM = pmm
+	params.Strategy = strategy
+	params.Optimization = "distribution"
+
+	bm = bm.Copy()
+
+	for _, topic := range pm.Topics() {
+		tm := &PartitionMap{Version: pm.Version}
+		for _, p := range pm.Copy().Partitions {
+			if p.Topic == topic {
+				tm.Partitions = append(tm.Partitions, p)
 			}
+		}
 
-			// The number of needed passes may vary;
-			// e.g. if most replica sets have a len
-			// of 2 and a few with a len of 3, we have
-			// to do 3 passes while skipping some
-			// on final passes.
-			if pass > len(partn.Replicas)-1 {
-				skipped++
-				continue
+		// Count replicas held for the topic.
+		topicBM := bm.Copy()
+		for _, b := range topicBM {
+			b.Used = 0
+		}
+
+		for _, p := range tm.Partitions {
+			for _, id := range p.Replicas {
+				if b, exists := topicBM[id]; exists && id != StubBrokerID {
+					b.Used++
+				}
 			}
+		}
 
-			// Get the current Broker ID
-			// for the current pass.
-			bid := partn.Replicas[pass]
+		params.BM = topicBM
 
-			// If the current broker isn't
-			// marked for removal, just add it
-			// to the same position in the new map.
-			if !params.BM[bid].Replace {
-				newMap.Partitions[n].Replicas = append(newMap.Partitions[n].Replicas, bid)
-			} else {
-				// Otherwise, we need to find a replacement.
+		out, e := tm.Rebuild(params)
+		for _, err := range e {
+			errs[topic] = append(errs[topic], err.Error())
+		}
+		if out != nil {
+			maps[topic] = out
+		}
 
-				// Build a BrokerList from the
-				// IDs in the old replica set to
-				// get a *constraints.
-				replicaSet := BrokerList{}
-				for _, bid := range partn.Replicas {
-					replicaSet = append(replicaSet, params.BM[bid])
-				}
-				// Add existing brokers in the
-				// new replica set as well.
-				for _, bid := range newMap.Partitions[n].Replicas {
-					replicaSet = append(replicaSet, params.BM[bid])
-				}
+		// Carry forward storage changes.
+		for id, b := range topicBM {
+			bm[id].StorageFree = b.StorageFree
+		}
+	}
 
-				// Populate a Constraints.
-				constraints := NewConstraints()
-				constraintsParams := ConstraintsParams{
-					SelectorMethod:   params.Strategy,
-					MinUniqueRackIDs: params.MinUniqueRackIDs,
-				}
-				constraints.MergeConstraints(replicaSet)
+	return maps, errs
+}
 
-				// Add any necessary meta from current partition
-				// to the constraints.
-				if params.Strategy == "storage" {
-					s, err := params.PMM.Size(partn)
-					if err != nil {
-						e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
-						errs = append(errs, e)
-						continue
-					}
+// promotePoolLeaders takes a map of topic names to leader pool broker IDs.
+// Any partition for a listed topic with a leader outside of the pool has the
+// first pool broker in the replica set moved to the leader position.
+func (pm *PartitionMap) promotePoolLeaders(pools map[string][]int) {
+	for _, partn := range pm.Partitions {
+		pool, pooled := pools[partn.Topic]
+		if !pooled || len(partn.Replicas) == 0 || inReplicaSet(partn.Replicas[0], pool) {
+			continue
+		}
 
-					constraintsParams.RequestSize = s * params.PartnSzFactor
-				}
+		for i, id := range partn.Replicas {
+			if inReplicaSet(id, pool) {
+				partn.promote(i)
+				break
+			}
+		}
+	}
+}
 
-				// Fetch the best candidate and append.
-				var replacement *Broker
-				var err error
+// demoteForbiddenLeaders takes a BrokerMap and a map of forbidden tags. Any
+// partition with a leader having a forbidden tag has the first eligible
+// follower moved to the leader position. An error is returned for each
+// partition without an eligible replica.
+func (pm *PartitionMap) demoteForbiddenLeaders(bm BrokerMap, tags map[string]string) []error {
+	var errs []error
 
-				// If we're using the count method, check if a
-				// substitution affinity is set for this broker.
-				affinity := params.Affinities.Get(bid)
-				if params.Strategy == "count" && affinity != nil {
-					replacement = affinity
-					// Ensure the replacement passes constraints.
-					// This is usually checked at the time of building
-					// a substitution affinities map, but in scenarios
-					// where the replacement broker was completely missing
-					// from ZooKeeper, its rack ID is unknown and a suitable
-					// sub has to be inferred. We're checking that it passes
-					// here in case the inference logic is faulty.
-					if passes := constraints.passesWithParams(replacement, constraintsParams); !passes {
-						err = ErrNoBrokers
-					}
-				} else {
-					// Otherwise, use the standard
-					// constraints based selector.
-					constraintsParams.SeedVal = int64(pass*n + 1)
-					replacement, err = constraints.SelectBroker(bl, constraintsParams)
-				}
+	forbidden := func(id int) bool {
+		b, exists := bm[id]
+		return exists && b.hasAnyTag(tags)
+	}
 
-				if err != nil {
-					// Append any caught errors.
-					e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
-					errs = append(errs, e)
-					continue
-				}
+	for _, partn := range pm.Partitions {
+		if len(partn.Replicas) == 0 || !forbidden(partn.Replicas[0]) {
+			continue
+		}
 
-				// Add the replacement to the map.
-				newMap.Partitions[n].Replicas = append(newMap.Partitions[n].Replicas, replacement.ID)
+		idx := -1
+		for i, id := range partn.Replicas {
+			if !forbidden(id) {
+				idx = i
+				break
 			}
 		}
 
-		// Increment the pass.
-		pass++
+		if idx < 0 {
+			e := fmt.Errorf("%s p%d: no replicas eligible for leadership", partn.Topic, partn.Partition)
+			errs = append(errs, e)
+			continue
+		}
 
+		// Move the eligible replica to the head of
+		// the replica set, preserving the remaining order.
+		partn.promote(idx)
 	}
 
-	// Final check to ensure that no
-	// replica sets were somehow set to 0.
-	for _, partn := range newMap.Partitions {
-		if len(partn.Replicas) == 0 {
-			e := fmt.Errorf("%s p%d: configured to zero replicas", partn.Topic, partn.Partition)
-			errs = append(errs, e)
+	return errs
+}
+
+// LocalityViolations takes a BrokerMap and minimum unique rack IDs value and
+// returns a PartitionList of partitions with replica sets violating locality
+// constraints according to the broker localities in the BrokerMap. A replica
+// set violates constraints if it has multiple replicas in the same non-empty
+// locality and either the minUniqueRackIDs is 0 (all localities must be
+// unique) or fewer than minUniqueRackIDs unique localities are referenced.
+func (pm *PartitionMap) LocalityViolations(bm BrokerMap, minUniqueRackIDs int) PartitionList {
+	var pl PartitionList
+
+	for _, p := range pm.Partitions {
+		if localityViolation(p.Replicas, bm, minUniqueRackIDs) {
+			pl = append(pl, p)
 		}
 	}
 
-	// Return map, errors.
-	return newMap, errs
+	return pl
 }
 
-func placeByPartition(params RebuildParams) (*PartitionMap, []error) {
-	newMap := NewPartitionMap()
+// localityViolation returns whether the replica set violates locality
+// constraints. See LocalityViolations.
+func localityViolation(replicas []int, bm BrokerMap, minUniqueRackIDs int) bool {
+	seen := map[string]bool{}
+	var duplicate bool
 
-	// We need a filtered list for usage sorting and exclusion
-	// of nodes marked for removal.
-	f := func(b *Broker) bool {
-		if b.Replace {
-			return false
+	for _, id := range replicas {
+		b, exists := bm[id]
+		if !exists || b.Locality == "" {
+			continue
 		}
-		return true
+
+		if seen[b.Locality] {
+			duplicate = true
+		}
+
+		seen[b.Locality] = true
 	}
 
-	bl := params.BM.Filter(f).List()
+	return duplicate && (minUniqueRackIDs == 0 || len(seen) < minUniqueRackIDs)
+}
 
-	var errs []error
+// DegradedPartitions takes an expected replication factor and returns a
+// PartitionList of partitions with fewer replicas than expected or holding a
+// stub broker, e.g. following a Rebuild where placements couldn't satisfy
+// constraints. An expectedRF of 0 checks for stub brokers only.
+func (pm *PartitionMap) DegradedPartitions(expectedRF int) PartitionList {
+	var pl PartitionList
 
-	for _, partn := range params.pm.Partitions {
-		// Create the partition in
-		// the new map.
-		newPartn := Partition{Partition: partn.Partition, Topic: partn.Topic}
+	for _, p := range pm.Partitions {
+		if len(p.Replicas) < expectedRF || inReplicaSet(StubBrokerID, p.Replicas) {
+			pl = append(pl, p)
+		}
+	}
+
+	return pl
+}
+
+// ReplicaFactorHistogram returns a mapping of replication factors to the
+// number of partitions in the PartitionMap with that replication factor.
+// Partitions holding a stub broker are counted separately under the key 0,
+// since their replica set length doesn't reflect a real replication factor;
+// see DegradedPartitions.
+func (pm *PartitionMap) ReplicaFactorHistogram() map[int]int {
+	h := map[int]int{}
+
+	for _, p := range pm.Partitions {
+		if inReplicaSet(StubBrokerID, p.Replicas) {
+			h[0]++
+			continue
+		}
+		h[len(p.Replicas)]++
+	}
+
+	return h
+}
+
+// NewBrokerReplicaSets takes a BrokerMap and returns a PartitionList of
+// partitions with all replicas on brokers marked as new, e.g. following a
+// storage rebuild after a scale-out. These partitions hold no replica with
+// existing data and are replicated in full from the current replicas.
+func (pm *PartitionMap) NewBrokerReplicaSets(bm BrokerMap) PartitionList {
+	var pl PartitionList
+
+	for _, p := range pm.Partitions {
+		if allNewBrokers(p.Replicas, bm) {
+			pl = append(pl, p)
+		}
+	}
+
+	return pl
+}
+
+// allNewBrokers returns whether all replicas are
+// on brokers marked as new. See NewBrokerReplicaSets.
+func allNewBrokers(replicas []int, bm BrokerMap) bool {
+	if len(replicas) == 0 {
+		return false
+	}
+
+	for _, id := range replicas {
+		if b, exists := bm[id]; !exists || !b.New {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RebuildLocalityViolations takes a RebuildParams and returns a copy of the
+// *PartitionMap where only partitions violating locality constraints (e.g.
+// following changes to broker localities in params.BM) are rebuilt. Within a
+// violating partition, only replicas that share a locality with a preceding
+// replica are replaced, minimizing movement. A []error of any partitions that
+// couldn't be fixed is returned.
+func (pm *PartitionMap) RebuildLocalityViolations(params RebuildParams) (*PartitionMap, []error) {
+	newMap := pm.Copy()
+	var errs []error
+
+	// We need a filtered list for usage sorting and exclusion
+	// of nodes marked for removal.
+	f := func(b *Broker) bool {
+		if b.Replace || !b.hasTag(params.DataRoleTag) {
+			return false
+		}
+		return true
+	}
+
+	bl := params.BM.Filter(f).List()
+
+	for n, partn := range newMap.Partitions {
+		if !localityViolation(partn.Replicas, params.BM, params.MinUniqueRackIDs) {
+			continue
+		}
+
+		// Populate a Constraints with the current replica set.
+		replicaSet := BrokerList{}
+		for _, id := range partn.Replicas {
+			if b, exists := params.BM[id]; exists {
+				replicaSet = append(replicaSet, b)
+			}
+		}
+
+		constraints := NewConstraints()
+		constraints.MergeConstraints(replicaSet)
+
+		constraintsParams := ConstraintsParams{
+			SelectorMethod:   params.Strategy,
+			MinUniqueRackIDs: params.MinUniqueRackIDs,
+			SeedVal:          int64(n + 1),
+			InstanceGroups:   params.InstanceGroups,
+			AntiAffinityTag:  params.AntiAffinityTag,
+		}
+
+		if params.Strategy == "storage" {
+			s, err := params.PMM.Size(partn)
+			if err != nil {
+				e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+				errs = append(errs, e)
+				continue
+			}
+
+			constraintsParams.RequestSize = s * params.PartnSzFactor
+		}
+
+		// All localities referenced by the replica set.
+		localities := map[string]bool{}
+		for _, b := range replicaSet {
+			if b.Locality != "" {
+				localities[b.Locality] = true
+			}
+		}
+
+		seen := map[string]bool{}
+
+		for i, id := range partn.Replicas {
+			b, exists := params.BM[id]
+			if !exists || b.Locality == "" {
+				continue
+			}
+
+			// Retain the first replica seen in a locality and any duplicates
+			// once the MinUniqueRackIDs is satisfied.
+			satisfied := params.MinUniqueRackIDs > 0 && len(localities) >= params.MinUniqueRackIDs
+			if !seen[b.Locality] || satisfied {
+				seen[b.Locality] = true
+				continue
+			}
+
+			replacement, err := constraints.SelectBroker(bl, constraintsParams)
+			if err != nil {
+				e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+				errs = append(errs, e)
+				continue
+			}
+
+			b.Used--
+			newMap.Partitions[n].Replicas[i] = replacement.ID
+
+			if replacement.Locality != "" {
+				localities[replacement.Locality] = true
+			}
+		}
+	}
+
+	return newMap, errs
+}
+
+// RetainExistingReplicas takes a rebuilt *PartitionMap and RebuildParams and
+// returns a copy of the rebuilt map where each partition with all replicas on
+// new brokers (see NewBrokerReplicaSets) retains one broker from its replica
+// set in the original map, where that broker isn't new or marked for
+// replacement. Replicas in the retained broker's locality are replaced first,
+// then followers ahead of the leader, such that locality constraints are met
+// per the MinUniqueRackIDs. Broker usage in params.BM is updated, as is
+// StorageFree with the storage strategy. A []error of any partitions where no
+// replica could be retained is returned.
+func (pm *PartitionMap) RetainExistingReplicas(rebuilt *PartitionMap, params RebuildParams) (*PartitionMap, []error) {
+	newMap := rebuilt.Copy()
+	var errs []error
+
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	original := map[key][]int{}
+	for _, p := range pm.Partitions {
+		original[key{p.Topic, p.Partition}] = p.Replicas
+	}
+
+	for _, partn := range newMap.Partitions {
+		if !allNewBrokers(partn.Replicas, params.BM) {
+			continue
+		}
+
+		var size float64
+		if params.Strategy == "storage" {
+			s, err := params.PMM.Size(partn)
+			if err != nil {
+				e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+				errs = append(errs, e)
+				continue
+			}
+			size = s * params.PartnSzFactor
+		}
+
+		var retained bool
+
+		for _, id := range original[key{partn.Topic, partn.Partition}] {
+			b, exists := params.BM[id]
+			if !exists || b.Replace || b.New || inReplicaSet(id, partn.Replicas) {
+				continue
+			}
+
+			pos := retainPosition(partn.Replicas, b, params)
+			if pos < 0 {
+				continue
+			}
+
+			replaced := params.BM[partn.Replicas[pos]]
+			replaced.Used--
+			replaced.StorageFree += size
+			b.Used++
+			b.StorageFree -= size
+
+			partn.Replicas[pos] = id
+			retained = true
+			break
+		}
+
+		if !retained {
+			e := fmt.Errorf("%s p%d: no existing replica can be retained", partn.Topic, partn.Partition)
+			errs = append(errs, e)
+		}
+	}
+
+	return newMap, errs
+}
+
+// retainPosition returns the position in the replica set where the broker can
+// be substituted without violating locality constraints, or -1 if there's none.
+// See RetainExistingReplicas.
+func retainPosition(replicas []int, b *Broker, params RebuildParams) int {
+	for _, sameLocality := range []bool{true, false} {
+		for pos := len(replicas) - 1; pos >= 0; pos-- {
+			if (params.BM[replicas[pos]].Locality == b.Locality) != sameLocality {
+				continue
+			}
+
+			candidate := append([]int{}, replicas...)
+			candidate[pos] = b.ID
+
+			if !localityViolation(candidate, params.BM, params.MinUniqueRackIDs) {
+				return pos
+			}
+		}
+	}
+
+	return -1
+}
+
+// VerifyReproducible takes a *PartitionMap, BrokerMap, PartitionMetaMap,
+// RebuildParams and a number of runs and calls Rebuild the specified number
+// of times, each on copies of the input map and BrokerMap. An error is returned
+// at the first run where the output map or rebuild errors aren't identical to
+// those of the first run, describing the first partition that differs.
+func VerifyReproducible(pm *PartitionMap, bm BrokerMap, pmm PartitionMetaMap, params RebuildParams, runs int) error {
+	params.PMM = pmm
+
+	var first []byte
+	var firstErrs string
+	var firstMap *PartitionMap
+
+	for run := 1; run <= runs; run++ {
+		params.BM = bm.Copy()
+
+		out, errs := pm.Copy().Rebuild(params)
+
+		data, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+
+		es := fmt.Sprint(errs)
+
+		if run == 1 {
+			first, firstErrs, firstMap = data, es, out
+			continue
+		}
+
+		if es != firstErrs {
+			return fmt.Errorf("run %d: errors diverged: %s -> %s", run, firstErrs, es)
+		}
+
+		if !bytes.Equal(data, first) {
+			return fmt.Errorf("run %d: output diverged: %s", run, firstDifference(firstMap, out))
+		}
+	}
+
+	return nil
+}
+
+// firstDifference returns a description of the
+// first partition that differs between two maps.
+func firstDifference(pm1, pm2 *PartitionMap) string {
+	for i, p1 := range pm1.Partitions {
+		if i >= len(pm2.Partitions) {
+			return fmt.Sprintf("%s p%d missing", p1.Topic, p1.Partition)
+		}
+
+		if p2 := pm2.Partitions[i]; !p1.Equal(p2) {
+			return fmt.Sprintf("%s p%d %v -> %s p%d %v",
+				p1.Topic, p1.Partition, p1.Replicas, p2.Topic, p2.Partition, p2.Replicas)
+		}
+	}
+
+	if len(pm2.Partitions) > len(pm1.Partitions) {
+		p := pm2.Partitions[len(pm1.Partitions)]
+		return fmt.Sprintf("%s p%d added", p.Topic, p.Partition)
+	}
+
+	return "map version"
+}
+
+// placeByPosition builds a PartitionMap by doing placements for all
+// partitions, one broker index at a time. For instance, if all partitions
+// required a broker set length of 3 (aka a replication factor of 3), we'd
+// do all placements in 3 passes. The first pass would be leaders for all
+// partitions, the second pass would be the first follower, and the third
+// pass would be the second follower. This placement pattern is optimal
+// for the count strategy. With the count strategy, leader placements between
+// brokers with equal Used values select those holding the fewest leaderships
+// first so that leadership is balanced along with replica counts.
+func placeByPosition(params RebuildParams) (*PartitionMap, []error) {
+	newMap := NewPartitionMap()
+
+	// We need a filtered list for usage sorting and exclusion
+	// of nodes marked for removal.
+	f := func(b *Broker) bool {
+		if b.Replace || !b.hasTag(params.DataRoleTag) {
+			return false
+		}
+		return true
+	}
+
+	bl := params.BM.Filter(f).List()
+
+	// Track leaderships held if we're balancing leaders or
+	// using the count strategy; retained leaders are counted
+	// upfront.
+	var leaders map[int]int
+	if params.LeaderFirstBalance || params.Strategy == "count" {
+		leaders = map[int]int{}
+		for _, partn := range params.pm.Partitions {
+			if len(partn.Replicas) > 0 && !params.BM[partn.Replicas[0]].Replace {
+				leaders[partn.Replicas[0]]++
+			}
+		}
+	}
+
+	// Track spread group replica counts.
+	groups := newSpreadGroups(params)
+
+	// Track brokers holding each topic if
+	// minimizing movement.
+	held := newTopicBrokers(params)
+
+	// Track leader bytes if capped.
+	lb := newLeaderBytes(params)
+
+	// Track per locality leader and follower
+	// counts if balancing racks by position.
+	racks := newRackPositions(params)
+
+	var errs []error
+	var pass int
+
+	// Check if we need more passes.
+	// If we've just counted as many skips
+	// as there are partitions to handle,
+	// we have nothing left to do.
+	for skipped := 0; skipped < len(params.pm.Partitions); {
+		for n, partn := range params.pm.Partitions {
+			// If this is the first pass, create
+			// the new partition.
+			if pass == 0 {
+				newPartn := Partition{Partition: partn.Partition, Topic: partn.Topic}
+				newMap.Partitions = append(newMap.Partitions, newPartn)
+			}
+
+			// The number of needed passes may vary;
+			// e.g. if most replica sets have a len
+			// of 2 and a few with a len of 3, we have
+			// to do 3 passes while skipping some
+			// on final passes.
+			if pass > len(partn.Replicas)-1 {
+				skipped++
+				continue
+			}
+
+			// Get the current Broker ID
+			// for the current pass.
+			bid := partn.Replicas[pass]
 
-		// Map over each broker from the original
-		// partition replica list to the new,
-		// selecting replacemnt for those marked
-		// for replacement.
-		for _, bid := range partn.Replicas {
 			// If the current broker isn't
 			// marked for removal, just add it
 			// to the same position in the new map.
 			if !params.BM[bid].Replace {
-				newPartn.Replicas = append(newPartn.Replicas, bid)
+				newMap.Partitions[n].Replicas = append(newMap.Partitions[n].Replicas, bid)
+				// Retain any log dir.
+				if partn.LogDirs != nil {
+					newMap.Partitions[n].LogDirs = append(newMap.Partitions[n].LogDirs, partn.logDir(pass))
+				}
 			} else {
 				// Otherwise, we need to find a replacement.
 
@@ -459,7 +1209,7 @@ func placeByPartition(params RebuildParams) (*PartitionMap, []error) {
 				}
 				// Add existing brokers in the
 				// new replica set as well.
-				for _, bid := range newPartn.Replicas {
+				for _, bid := range newMap.Partitions[n].Replicas {
 					replicaSet = append(replicaSet, params.BM[bid])
 				}
 
@@ -468,10 +1218,48 @@ func placeByPartition(params RebuildParams) (*PartitionMap, []error) {
 				constraintsParams := ConstraintsParams{
 					SelectorMethod:   params.Strategy,
 					MinUniqueRackIDs: params.MinUniqueRackIDs,
-					SeedVal:          1,
+					InstanceGroups:   params.InstanceGroups,
+					AntiAffinityTag:  params.AntiAffinityTag,
+					MaxRackSpread:    params.MaxRackSpread,
+					MaxPartitions:    params.MaxPartitionsPerBroker,
+					HeldPartitions:   params.HeldPartitions,
+				}
+				// Leader placements exclude brokers with
+				// forbidden leader tags.
+				// Leader placements are balanced by locality
+				// then broker leaderships if configured,
+				// otherwise by broker leaderships.
+				if pass == 0 {
+					constraintsParams.ForbidTags = params.ForbidLeaderTags
+					if params.LeaderFirstBalance {
+						constraintsParams.LeaderCounts = leaders
+					} else {
+						constraintsParams.BrokerLeaderCounts = leaders
+					}
 				}
+				constraintsParams.GroupCounts = groups.counts(partn.Topic)
+				constraintsParams.LocalityCounts = racks.counts(pass)
+				constraintsParams.PreferBrokers = held[partn.Topic]
 				constraints.MergeConstraints(replicaSet)
 
+				// Prefer the leader locality for followers
+				// if minimizing cross-rack replicas.
+				if params.MinimizeCrossRack && pass > 0 {
+					constraintsParams.PreferLocality = leaderLocality(newMap.Partitions[n].Replicas, params.BM)
+				}
+
+				// Rack-pinned topics are placed in a single locality.
+				_, pinned := params.RackPinnedTopics[partn.Topic]
+				if pinned {
+					l, err := pinnedLocality(newMap.Partitions[n].Replicas, partn.Replicas, params.BM, bl)
+					if err != nil {
+						e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+						errs = append(errs, e)
+						continue
+					}
+					constraintsParams.PinLocality = l
+				}
+
 				// Add any necessary meta from current partition
 				// to the constraints.
 				if params.Strategy == "storage" {
@@ -485,225 +1273,2382 @@ func placeByPartition(params RebuildParams) (*PartitionMap, []error) {
 					constraintsParams.RequestSize = s * params.PartnSzFactor
 				}
 
-				// Fetch the best candidate and append.
-				replacement, err := constraints.SelectBroker(bl, constraintsParams)
+				// Leader placements are capped by
+				// the summed leader bytes per broker.
+				var leaderSize float64
+				if pass == 0 && lb != nil {
+					s, err := params.PMM.Size(partn)
+					if err != nil {
+						e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+						errs = append(errs, e)
+						continue
+					}
 
-				if err != nil {
-					// Append any caught errors.
-					e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
-					errs = append(errs, e)
-					continue
+					leaderSize = s
+					lb.constrain(&constraintsParams, params.MaxLeaderBytesPerBroker, s)
 				}
 
-				newPartn.Replicas = append(newPartn.Replicas, replacement.ID)
-			}
-		}
+				// Fetch the best candidate and append.
+				var replacement *Broker
+				var err error
 
-		// Add the partition to the
-		// new map.
-		newMap.Partitions = append(newMap.Partitions, newPartn)
-	}
+				// Topics with a tenant tag are placed
+				// on brokers with the tag only.
+				candidates := bl
+				tag, tenant := tenantTag(params.TenantTags, partn.Topic)
+				if tenant {
+					candidates = candidates.Filter(func(b *Broker) bool { return b.hasTag(tag) })
+				}
 
-	// Final check to ensure that no
-	// replica sets were somehow set to 0.
-	for _, partn := range newMap.Partitions {
-		if len(partn.Replicas) == 0 {
-			e := fmt.Errorf("%s p%d: configured to zero replicas", partn.Topic, partn.Partition)
-			errs = append(errs, e)
-		}
-	}
+				// Rack aware placements require a
+				// spread across the eligible localities.
+				if params.RackAware && !pinned {
+					constraintsParams.RackSpread = rackSpread(candidates, len(partn.Replicas), params.MaxRackSpread)
+				}
 
-	// Return map, errors.
-	return newMap, errs
+				// Leader placements for topics with a leader
+				// pool are selected from the pool only.
+				pool, pooled := params.LeaderPools[partn.Topic]
+				if pass == 0 && pooled {
+					candidates = candidates.Filter(func(b *Broker) bool { return inReplicaSet(b.ID, pool) })
+				}
+
+				// If we're using the count method, check if a
+				// substitution affinity is set for this broker.
+				affinity := params.Affinities.Get(bid)
+				if affinity != nil && (pinned || pass == 0 && pooled && !inReplicaSet(affinity.ID, pool) || !affinity.hasTag(tag)) {
+					affinity = nil
+				}
+
+				if params.Strategy == "count" && affinity != nil {
+					replacement = affinity
+					// Ensure the replacement passes constraints.
+					// This is usually checked at the time of building
+					// a substitution affinities map, but in scenarios
+					// where the replacement broker was completely missing
+					// from ZooKeeper, its rack ID is unknown and a suitable
+					// sub has to be inferred. We're checking that it passes
+					// here in case the inference logic is faulty.
+					if passes := constraints.passesWithParams(replacement, constraintsParams); !passes {
+						err = ErrNoBrokers
+					}
+				} else {
+					// Otherwise, use the standard
+					// constraints based selector.
+					constraintsParams.SeedVal = int64(pass*n + 1)
+					replacement, err = params.selectBroker(constraints, candidates, constraintsParams, partn)
+				}
+
+				if err != nil && constraintsParams.RackSpread > 0 {
+					err = constraints.rackSpreadErr(candidates, constraintsParams.RackSpread, err)
+				}
+
+				if err != nil && pass == 0 && pooled {
+					err = fmt.Errorf("leader pool %v: %s", pool, err)
+				}
+
+				if err != nil && tenant {
+					err = fmt.Errorf("tenant tag %s: %s", tag, err)
+				}
+
+				if err != nil {
+					// Append any caught errors.
+					e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+					errs = append(errs, e)
+					continue
+				}
+
+				// Count the new leadership.
+				if pass == 0 && leaders != nil {
+					leaders[replacement.ID]++
+				}
+
+				if pass == 0 && lb != nil {
+					lb[replacement.ID] += leaderSize
+				}
+
+				racks.add(pass, replacement.Locality)
+
+				groups.add(partn.Topic, replacement.ID)
+
+				// Add the replacement to the map.
+				newMap.Partitions[n].Replicas = append(newMap.Partitions[n].Replicas, replacement.ID)
+				if partn.LogDirs != nil {
+					newMap.Partitions[n].LogDirs = append(newMap.Partitions[n].LogDirs, LogDirAny)
+				}
+			}
+		}
+
+		// Increment the pass.
+		pass++
+
+	}
+
+	// Final check to ensure that no
+	// replica sets were somehow set to 0.
+	for _, partn := range newMap.Partitions {
+		if len(partn.Replicas) == 0 {
+			e := fmt.Errorf("%s p%d: configured to zero replicas", partn.Topic, partn.Partition)
+			errs = append(errs, e)
+		}
+	}
+
+	// Check that rack aware replica sets
+	// span the required localities.
+	if params.RackAware {
+		errs = append(errs, rackSpreadViolations(params, newMap, bl)...)
+	}
+
+	// Return map, errors.
+	return newMap, errs
+}
+
+// rackPositions tracks per locality leader
+// and follower counts, respectively.
+type rackPositions []map[string]int
+
+// newRackPositions takes a RebuildParams and returns a rackPositions
+// populated with all retained replicas. A nil rackPositions is returned if
+// RackBalanceByPosition isn't set.
+func newRackPositions(params RebuildParams) rackPositions {
+	if !params.RackBalanceByPosition {
+		return nil
+	}
+
+	r := rackPositions{map[string]int{}, map[string]int{}}
+	for _, partn := range params.pm.Partitions {
+		for pos, id := range partn.Replicas {
+			if b := params.BM[id]; !b.Replace {
+				r.add(pos, b.Locality)
+			}
+		}
+	}
+
+	return r
+}
+
+// counts returns the locality counts for
+// the role at the replica set position.
+func (r rackPositions) counts(pos int) map[string]int {
+	if r == nil {
+		return nil
+	}
+
+	if pos == 0 {
+		return r[0]
+	}
+
+	return r[1]
+}
+
+// add records a replica in the locality
+// for the role at the replica set position.
+func (r rackPositions) add(pos int, locality string) {
+	if c := r.counts(pos); c != nil {
+		c[locality]++
+	}
+}
+
+// leaderBytes tracks the summed size of partitions led per broker.
+type leaderBytes map[int]float64
+
+// newLeaderBytes takes a RebuildParams and returns a leaderBytes populated
+// with the sizes of all retained leaders. A nil leaderBytes is returned if
+// MaxLeaderBytesPerBroker isn't set. Partitions missing from the PMM are
+// counted as zero bytes.
+func newLeaderBytes(params RebuildParams) leaderBytes {
+	if params.MaxLeaderBytesPerBroker <= 0 {
+		return nil
+	}
+
+	lb := leaderBytes{}
+	for _, partn := range params.pm.Partitions {
+		if len(partn.Replicas) == 0 || params.BM[partn.Replicas[0]].Replace {
+			continue
+		}
+
+		s, _ := params.PMM.Size(partn)
+		lb[partn.Replicas[0]] += s
+	}
+
+	return lb
+}
+
+// constrain applies the leader bytes cap max for a leader
+// placement of size s to the *ConstraintsParams.
+func (l leaderBytes) constrain(p *ConstraintsParams, max, s float64) {
+	p.MaxLeaderBytes = max
+	p.LeaderBytes = l
+	p.LeaderSize = s
+}
+
+// spreadGroups tracks per broker replica counts for spread groups.
+type spreadGroups struct {
+	// Topic name to group name.
+	topics map[string]string
+	// Group name to broker replica counts.
+	replicas map[string]map[int]int
+}
+
+// newTopicBrokers takes a RebuildParams and returns a map of topic names to
+// the set of brokers not marked for replacement holding replicas of the topic
+// in the input map. A nil map is returned if MinimalMovement isn't set.
+func newTopicBrokers(params RebuildParams) map[string]map[int]struct{} {
+	if !params.MinimalMovement {
+		return nil
+	}
+
+	held := map[string]map[int]struct{}{}
+
+	for _, partn := range params.pm.Partitions {
+		if held[partn.Topic] == nil {
+			held[partn.Topic] = map[int]struct{}{}
+		}
+		for _, id := range partn.Replicas {
+			if b, exists := params.BM[id]; exists && !b.Replace {
+				held[partn.Topic][id] = struct{}{}
+			}
+		}
+	}
+
+	return held
+}
+
+// newSpreadGroups takes a RebuildParams and returns a spreadGroups
+// populated with the retained replicas of all grouped topics.
+func newSpreadGroups(params RebuildParams) spreadGroups {
+	s := spreadGroups{
+		topics:   map[string]string{},
+		replicas: map[string]map[int]int{},
+	}
+
+	for group, topics := range params.SpreadGroups {
+		s.replicas[group] = map[int]int{}
+		for _, t := range topics {
+			s.topics[t] = group
+		}
+	}
+
+	for _, partn := range params.pm.Partitions {
+		for _, id := range partn.Replicas {
+			if b, exists := params.BM[id]; exists && !b.Replace {
+				s.add(partn.Topic, id)
+			}
+		}
+	}
+
+	return s
+}
+
+// counts takes a topic name and returns the broker replica counts for the
+// topic's spread group. A nil map is returned if the topic isn't grouped.
+func (s spreadGroups) counts(topic string) map[int]int {
+	group, exists := s.topics[topic]
+	if !exists {
+		return nil
+	}
+
+	return s.replicas[group]
+}
+
+// add takes a topic name and broker ID and counts a
+// replica for the topic's spread group, if any.
+func (s spreadGroups) add(topic string, id int) {
+	if group, exists := s.topics[topic]; exists {
+		s.replicas[group][id]++
+	}
+}
+
+func placeByPartition(params RebuildParams) (*PartitionMap, []error) {
+	newMap := NewPartitionMap()
+
+	// We need a filtered list for usage sorting and exclusion
+	// of nodes marked for removal.
+	f := func(b *Broker) bool {
+		if b.Replace || !b.hasTag(params.DataRoleTag) {
+			return false
+		}
+		return true
+	}
+
+	bl := params.BM.Filter(f).List()
+
+	// Track spread group replica counts.
+	groups := newSpreadGroups(params)
+
+	// Track brokers holding each topic if
+	// minimizing movement.
+	held := newTopicBrokers(params)
+
+	// Track leader bytes if capped.
+	lb := newLeaderBytes(params)
+
+	var errs []error
+
+	for _, partn := range params.pm.Partitions {
+		// Create the partition in
+		// the new map.
+		newPartn := Partition{Partition: partn.Partition, Topic: partn.Topic}
+
+		// Map over each broker from the original
+		// partition replica list to the new,
+		// selecting replacemnt for those marked
+		// for replacement.
+		for i, bid := range partn.Replicas {
+			// If the current broker isn't
+			// marked for removal, just add it
+			// to the same position in the new map.
+			if !params.BM[bid].Replace {
+				newPartn.Replicas = append(newPartn.Replicas, bid)
+				// Retain any log dir.
+				if partn.LogDirs != nil {
+					newPartn.LogDirs = append(newPartn.LogDirs, partn.logDir(i))
+				}
+			} else {
+				// Otherwise, we need to find a replacement.
+
+				// Build a BrokerList from the
+				// IDs in the old replica set to
+				// get a *constraints.
+				replicaSet := BrokerList{}
+				for _, bid := range partn.Replicas {
+					replicaSet = append(replicaSet, params.BM[bid])
+				}
+				// Add existing brokers in the
+				// new replica set as well.
+				for _, bid := range newPartn.Replicas {
+					replicaSet = append(replicaSet, params.BM[bid])
+				}
+
+				// Populate a Constraints.
+				constraints := NewConstraints()
+				constraintsParams := ConstraintsParams{
+					SelectorMethod:   params.Strategy,
+					MinUniqueRackIDs: params.MinUniqueRackIDs,
+					SeedVal:          1,
+					InstanceGroups:   params.InstanceGroups,
+					AntiAffinityTag:  params.AntiAffinityTag,
+					MaxRackSpread:    params.MaxRackSpread,
+					MaxPartitions:    params.MaxPartitionsPerBroker,
+					HeldPartitions:   params.HeldPartitions,
+				}
+				// Leader placements exclude brokers with
+				// forbidden leader tags.
+				if len(newPartn.Replicas) == 0 {
+					constraintsParams.ForbidTags = params.ForbidLeaderTags
+				}
+				constraintsParams.GroupCounts = groups.counts(partn.Topic)
+				constraintsParams.PreferBrokers = held[partn.Topic]
+				constraints.MergeConstraints(replicaSet)
+
+				// Prefer the leader locality for followers
+				// if minimizing cross-rack replicas.
+				if params.MinimizeCrossRack && len(newPartn.Replicas) > 0 {
+					constraintsParams.PreferLocality = leaderLocality(newPartn.Replicas, params.BM)
+				}
+
+				// Rack-pinned topics are placed in a single locality.
+				_, pinned := params.RackPinnedTopics[partn.Topic]
+				if pinned {
+					l, err := pinnedLocality(newPartn.Replicas, partn.Replicas, params.BM, bl)
+					if err != nil {
+						e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+						errs = append(errs, e)
+						continue
+					}
+					constraintsParams.PinLocality = l
+				}
+
+				// Add any necessary meta from current partition
+				// to the constraints.
+				if params.Strategy == "storage" {
+					s, err := params.PMM.Size(partn)
+					if err != nil {
+						e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+						errs = append(errs, e)
+						continue
+					}
+
+					constraintsParams.RequestSize = s * params.PartnSzFactor
+				}
+
+				// Topics with a tenant tag are placed
+				// on brokers with the tag only.
+				candidates := bl
+				tag, tenant := tenantTag(params.TenantTags, partn.Topic)
+				if tenant {
+					candidates = candidates.Filter(func(b *Broker) bool { return b.hasTag(tag) })
+				}
+
+				// Rack aware placements require a
+				// spread across the eligible localities.
+				if params.RackAware && !pinned {
+					constraintsParams.RackSpread = rackSpread(candidates, len(partn.Replicas), params.MaxRackSpread)
+				}
+
+				// Leader placements for topics with a leader
+				// pool are selected from the pool only.
+				pool, pooled := params.LeaderPools[partn.Topic]
+				if len(newPartn.Replicas) == 0 && pooled {
+					candidates = candidates.Filter(func(b *Broker) bool { return inReplicaSet(b.ID, pool) })
+				}
+
+				// Leader placements are capped by
+				// the summed leader bytes per broker.
+				leader := len(newPartn.Replicas) == 0
+				var leaderSize float64
+				if leader && lb != nil {
+					s, err := params.PMM.Size(partn)
+					if err != nil {
+						e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+						errs = append(errs, e)
+						continue
+					}
+
+					leaderSize = s
+					lb.constrain(&constraintsParams, params.MaxLeaderBytesPerBroker, s)
+				}
+
+				// Fetch the best candidate and append.
+				replacement, err := params.selectBroker(constraints, candidates, constraintsParams, partn)
+
+				if err != nil && constraintsParams.RackSpread > 0 {
+					err = constraints.rackSpreadErr(candidates, constraintsParams.RackSpread, err)
+				}
+
+				if err != nil && len(newPartn.Replicas) == 0 && pooled {
+					err = fmt.Errorf("leader pool %v: %s", pool, err)
+				}
+
+				if err != nil && tenant {
+					err = fmt.Errorf("tenant tag %s: %s", tag, err)
+				}
+
+				if err != nil {
+					// Append any caught errors.
+					e := fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+					errs = append(errs, e)
+					continue
+				}
+
+				if leader && lb != nil {
+					lb[replacement.ID] += leaderSize
+				}
+
+				groups.add(partn.Topic, replacement.ID)
+				newPartn.Replicas = append(newPartn.Replicas, replacement.ID)
+				if partn.LogDirs != nil {
+					newPartn.LogDirs = append(newPartn.LogDirs, LogDirAny)
+				}
+			}
+		}
+
+		// Add the partition to the
+		// new map.
+		newMap.Partitions = append(newMap.Partitions, newPartn)
+	}
+
+	// Final check to ensure that no
+	// replica sets were somehow set to 0.
+	for _, partn := range newMap.Partitions {
+		if len(partn.Replicas) == 0 {
+			e := fmt.Errorf("%s p%d: configured to zero replicas", partn.Topic, partn.Partition)
+			errs = append(errs, e)
+		}
+	}
+
+	// Check that rack aware replica sets
+	// span the required localities.
+	if params.RackAware {
+		errs = append(errs, rackSpreadViolations(params, newMap, bl)...)
+	}
+
+	// Return map, errors.
+	return newMap, errs
+}
+
+// projectFreedSlots sets the Used count of each broker not marked for
+// replacement in the RebuildParams BrokerMap to the number of replicas held in
+// the input map, freeing the slots of any replicas dropped from the input map.
+func projectFreedSlots(params RebuildParams) {
+	retained := map[int]int{}
+	for _, partn := range params.pm.Partitions {
+		for _, id := range partn.Replicas {
+			retained[id]++
+		}
+	}
+
+	for id, b := range params.BM {
+		if id != StubBrokerID && !b.Replace {
+			b.Used = retained[id]
+		}
+	}
+}
+
+// partitionCapacity returns an error if the brokers eligible for placements
+// can't hold all replicas requiring placement without exceeding the
+// MaxPartitionsPerBroker.
+func partitionCapacity(params RebuildParams) error {
+	var required, available int
+
+	for _, partn := range params.pm.Partitions {
+		for _, id := range partn.Replicas {
+			if params.BM[id].Replace {
+				required++
+			}
+		}
+	}
+
+	for _, b := range params.BM {
+		if b.Replace || !b.hasTag(params.DataRoleTag) {
+			continue
+		}
+
+		if free := params.MaxPartitionsPerBroker - b.Used - params.HeldPartitions[b.ID]; free > 0 {
+			available += free
+		}
+	}
+
+	if required > available {
+		return fmt.Errorf("Insufficient partition capacity: %d replicas to place with %d available under a limit of %d per broker, short by %d",
+			required, available, params.MaxPartitionsPerBroker, required-available)
+	}
+
+	return nil
+}
+
+// tenantTag takes a map of topic name prefixes to broker tags and a topic
+// name and returns the tag for the longest matching prefix, if any.
+func tenantTag(tags map[string]string, topic string) (string, bool) {
+	var tag, prefix string
+	var matched bool
+
+	for p, t := range tags {
+		if strings.HasPrefix(topic, p) && (!matched || len(p) > len(prefix)) {
+			tag, prefix, matched = t, p, true
+		}
+	}
+
+	return tag, matched
+}
+
+// leaderLocality returns the locality of the
+// leader in the replica set, if any.
+func leaderLocality(replicas []int, bm BrokerMap) string {
+	if len(replicas) == 0 {
+		return ""
+	}
+
+	if b, exists := bm[replicas[0]]; exists {
+		return b.Locality
+	}
+
+	return ""
+}
+
+// pinnedLocality takes the replicas placed so far and the original replicas
+// for a rack-pinned partition, a BrokerMap and a BrokerList of placement
+// candidates. It returns the locality that all replicas must be placed in:
+// that of the first placed or retained replica with a locality, otherwise the
+// locality with the lowest average broker utilization that has at least as
+// many candidates as the original replica count.
+func pinnedLocality(placed, original []int, bm BrokerMap, bl BrokerList) (string, error) {
+	// Check placed replicas, then any
+	// retained replicas from the original set.
+	for _, ids := range [][]int{placed, original} {
+		for _, id := range ids {
+			if b, exists := bm[id]; exists && !b.Replace && b.Locality != "" {
+				return b.Locality, nil
+			}
+		}
+	}
+
+	count, used := map[string]int{}, map[string]int{}
+	for _, b := range bl {
+		if b.ID == StubBrokerID || b.Locality == "" {
+			continue
+		}
+		count[b.Locality]++
+		used[b.Locality] += b.Used
+	}
+
+	var localities []string
+	for l := range count {
+		localities = append(localities, l)
+	}
+
+	sort.Strings(localities)
+
+	var selected string
+	var min float64
+	for _, l := range localities {
+		if count[l] < len(original) {
+			continue
+		}
+
+		avg := float64(used[l]) / float64(count[l])
+		if selected == "" || avg < min {
+			selected, min = l, avg
+		}
+	}
+
+	if selected == "" {
+		return "", fmt.Errorf("No single locality with %d brokers available for rack-pinned placement", len(original))
+	}
+
+	return selected, nil
+}
+
+// rackSpread takes a BrokerList of eligible brokers, a replication factor and
+// a max rack spread and returns the number of distinct localities required of
+// a replica set under rack aware placement: the lesser of the replication
+// factor and the number of localities in the BrokerList, bounded by a
+// non-zero maxRackSpread.
+func rackSpread(bl BrokerList, rf, maxRackSpread int) int {
+	localities := map[string]struct{}{}
+	for _, b := range bl {
+		if b.ID != StubBrokerID && b.Locality != "" {
+			localities[b.Locality] = struct{}{}
+		}
+	}
+
+	spread := rf
+	if len(localities) < spread {
+		spread = len(localities)
+	}
+
+	if maxRackSpread > 0 && maxRackSpread < spread {
+		spread = maxRackSpread
+	}
+
+	return spread
+}
+
+// rackSpreadErr takes a BrokerList of candidates, the required rack spread and
+// a placement error. If the replica set hasn't reached the spread, an error
+// naming the candidate localities not in the replica set is returned;
+// otherwise, the placement error is returned as is.
+func (c *Constraints) rackSpreadErr(bl BrokerList, spread int, err error) error {
+	if len(c.locality) >= spread {
+		return err
+	}
+
+	var unsatisfied []string
+	seen := map[string]struct{}{}
+	for _, b := range bl {
+		if _, dupe := seen[b.Locality]; dupe || b.Locality == "" || c.locality[b.Locality] {
+			continue
+		}
+		seen[b.Locality] = struct{}{}
+		unsatisfied = append(unsatisfied, b.Locality)
+	}
+
+	if len(unsatisfied) == 0 {
+		return err
+	}
+
+	sort.Strings(unsatisfied)
+
+	return fmt.Errorf("rack spread of %d unsatisfiable: no eligible brokers in localities %v", spread, unsatisfied)
+}
+
+// rackSpreadViolations takes rebuild params, the rebuilt PartitionMap and a
+// BrokerList of eligible brokers and returns an error for each rebuilt
+// replica set with placements that spans fewer localities than required under
+// rack aware placement, e.g. where retained replicas share a locality.
+// Replica sets with failed placements are skipped as they've already been
+// reported.
+func rackSpreadViolations(params RebuildParams, pm *PartitionMap, bl BrokerList) []error {
+	var errs []error
+
+	for n, partn := range pm.Partitions {
+		orig := params.pm.Partitions[n]
+		if _, pinned := params.RackPinnedTopics[partn.Topic]; pinned || len(partn.Replicas) != len(orig.Replicas) {
+			continue
+		}
+
+		var replaced bool
+		for _, id := range orig.Replicas {
+			replaced = replaced || params.BM[id].Replace
+		}
+
+		if !replaced {
+			continue
+		}
+
+		candidates := bl
+		if tag, tenant := tenantTag(params.TenantTags, partn.Topic); tenant {
+			candidates = candidates.Filter(func(b *Broker) bool { return b.hasTag(tag) })
+		}
+
+		replicaSet := BrokerList{}
+		for _, id := range partn.Replicas {
+			replicaSet = append(replicaSet, params.BM[id])
+		}
+
+		c := NewConstraints()
+		c.MergeConstraints(replicaSet)
+
+		spread := rackSpread(candidates, len(partn.Replicas), params.MaxRackSpread)
+		if len(c.locality) >= spread {
+			continue
+		}
+
+		var localities []string
+		for l := range c.locality {
+			localities = append(localities, l)
+		}
+		sort.Strings(localities)
+
+		e := fmt.Errorf("%s p%d: rack spread of %d unsatisfiable: replica set spans localities %v",
+			partn.Topic, partn.Partition, spread, localities)
+		errs = append(errs, e)
+	}
+
+	return errs
+}
+
+// LocalitiesAvailable takes a broker map and broker and returns a []string
+// of localities that are unused by any of the brokers in any replica sets that
+// the reference broker was found in. This is done by building a set of all
+// localities observed across all replica sets and a set of all localities
+// observed in replica sets containing the reference broker,
+// then returning the diff.
+func (pm *PartitionMap) LocalitiesAvailable(bm BrokerMap, b *Broker) []string {
+	all := map[string]struct{}{}
+	reference := map[string]struct{}{}
+
+	// Traverse the partition map and
+	// gather localities.
+	for _, partn := range pm.Partitions {
+
+		localities := map[string]struct{}{}
+		var containsRef bool
+
+		for _, replica := range partn.Replicas {
+			// Check if this is a replica set
+			// that contains the reference
+			if replica == b.ID {
+				containsRef = true
+				// We shouldn't have the reference
+				// broker's locality since it's
+				// missing; the purpose of this
+				// entire method is to infer it
+				// or a compatible locality. Skip.
+				continue
+			}
+
+			// Add the locality.
+			l := bm[replica].Locality
+			if l != "" {
+				localities[l] = struct{}{}
+			}
+		}
+
+		// Populate into the appropriate
+		// set.
+		if containsRef {
+			for k := range localities {
+				reference[k] = struct{}{}
+			}
+		} else {
+			for k := range localities {
+				all[k] = struct{}{}
+			}
+		}
+	}
+
+	// Get the diff between the all set
+	// and the reference set.
+	diff := []string{}
+	for l := range all {
+		if _, used := reference[l]; !used {
+			diff = append(diff, l)
+		}
+	}
+
+	sort.Strings(diff)
+
+	return diff
+}
+
+// Shuffle takes a seed value and shuffles the replica order of every
+// partition using a single pseudo-random source seeded with the value. Replica
+// sets are sorted by broker ID prior to shuffling, so the same seed always
+// yields the same replica orderings regardless of the input order; shuffling
+// a shuffled map with the same seed is a no-op. A seed of 0 performs
+// the original shuffle, where each partition is shuffled with a source seeded
+// by its position among the shuffled partitions.
+func (pm *PartitionMap) Shuffle(seed int64) {
+	pm.shuffle(seed, func(_ Partition) bool { return true })
+}
+
+// shuffle takes a seed value and a filter func and shuffles the replica order
+// of each partition passing the filter. A non-zero seed uses a single
+// pseudo-random source seeded with the value and sorts replica sets prior
+// to shuffling, while a seed of 0 uses the original per-partition sources.
+func (pm *PartitionMap) shuffle(seed int64, f func(Partition) bool) {
+	var s int
+	r := rand.New(rand.NewSource(seed))
+	for n := range pm.Partitions {
+		if f(pm.Partitions[n]) {
+			if seed == 0 {
+				r = rand.New(rand.NewSource(int64(s << 20)))
+				s++
+			}
+			p := pm.Partitions[n]
+			// Any log dirs are shuffled along with the replicas.
+			dirs := len(p.LogDirs) == len(p.Replicas)
+			if seed != 0 {
+				sort.Sort(replicasByID{replicas: p.Replicas, logDirs: p.LogDirs, dirs: dirs})
+			}
+			r.Shuffle(len(p.Replicas), func(i, j int) {
+				p.Replicas[i], p.Replicas[j] = p.Replicas[j], p.Replicas[i]
+				if dirs {
+					p.LogDirs[i], p.LogDirs[j] = p.LogDirs[j], p.LogDirs[i]
+				}
+			})
+		}
+	}
+}
+
+// replicasByID sorts a replica set by broker ID,
+// moving any log dirs along with the replicas.
+type replicasByID struct {
+	replicas []int
+	logDirs  []string
+	dirs     bool
+}
+
+func (r replicasByID) Len() int           { return len(r.replicas) }
+func (r replicasByID) Less(i, j int) bool { return r.replicas[i] < r.replicas[j] }
+func (r replicasByID) Swap(i, j int) {
+	r.replicas[i], r.replicas[j] = r.replicas[j], r.replicas[i]
+	if r.dirs {
+		r.logDirs[i], r.logDirs[j] = r.logDirs[j], r.logDirs[i]
+	}
+}
+
+var (
+	// ErrDuplicateReplica error.
+	ErrDuplicateReplica = errors.New("Duplicate broker ID in replica set")
+	// ErrEmptyReplicaSet error.
+	ErrEmptyReplicaSet = errors.New("Empty replica set")
+	// ErrStubReplica error.
+	ErrStubReplica = errors.New("Stub broker ID in replica set")
+)
+
+// ReplicaSetError is an error for an invalid partition replica set.
+type ReplicaSetError struct {
+	Topic     string
+	Partition int
+	Err       error
+}
+
+func (e ReplicaSetError) Error() string {
+	return fmt.Sprintf("%s p%d: %s", e.Topic, e.Partition, e.Err)
+}
+
+// Unwrap returns the underlying error, e.g. ErrDuplicateReplica.
+func (e ReplicaSetError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks each replica set in the PartitionMap for duplicate broker
+// IDs, zero length replica sets and the StubBrokerID. A ReplicaSetError is
+// returned for each violation found, in partition order; a partition with
+// multiple violations has an error for each.
+func (pm *PartitionMap) Validate() []error {
+	var errs []error
+
+	for _, p := range pm.Partitions {
+		if len(p.Replicas) == 0 {
+			errs = append(errs, ReplicaSetError{p.Topic, p.Partition, ErrEmptyReplicaSet})
+			continue
+		}
+
+		seen := map[int]struct{}{}
+		var dupe, stub bool
+
+		for _, id := range p.Replicas {
+			if id == StubBrokerID {
+				stub = true
+				continue
+			}
+			if _, exists := seen[id]; exists {
+				dupe = true
+			}
+			seen[id] = struct{}{}
+		}
+
+		if dupe {
+			errs = append(errs, ReplicaSetError{p.Topic, p.Partition, ErrDuplicateReplica})
+		}
+
+		if stub {
+			errs = append(errs, ReplicaSetError{p.Topic, p.Partition, ErrStubReplica})
+		}
+	}
+
+	return errs
+}
+
+// ParseOpt configures partition map parsing.
+type ParseOpt func(*parseConfig)
+
+type parseConfig struct {
+	preserveOrder bool
+}
+
+// PreserveOrder returns a ParseOpt that retains the partition order of the
+// input rather than sorting partitions by topic and partition. Note that
+// Equal requires the same partition order for equality.
+func PreserveOrder() ParseOpt {
+	return func(c *parseConfig) {
+		c.preserveOrder = true
+	}
+}
+
+// PartitionMapFromString takes a json encoded string and optional ParseOpts
+// and returns a *PartitionMap. An error is returned if the version isn't the
+// SupportedPartitionMapVersion, defaulted where unspecified, if any
+// partition specifies log_dirs that don't correspond to its replicas, or
+// for the first replica set with duplicate broker IDs or no replicas (see
+// Validate).
+func PartitionMapFromString(s string, opts ...ParseOpt) (*PartitionMap, error) {
+	cfg := &parseConfig{}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	pm := NewPartitionMap()
+
+	err := json.Unmarshal([]byte(s), &pm)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing partition map: %s", err.Error())
+	}
+
+	if pm.Version != SupportedPartitionMapVersion {
+		return nil, fmt.Errorf("Unsupported partition map version %d, expected version %d",
+			pm.Version, SupportedPartitionMapVersion)
+	}
+
+	for _, p := range pm.Partitions {
+		if len(p.LogDirs) > 0 && len(p.LogDirs) != len(p.Replicas) {
+			return nil, fmt.Errorf("%s p%d: %d log_dirs specified for %d replicas",
+				p.Topic, p.Partition, len(p.LogDirs), len(p.Replicas))
+		}
+	}
+
+	// Stub brokers are permitted, e.g. in stripped maps.
+	for _, err := range pm.Validate() {
+		if !errors.Is(err, ErrStubReplica) {
+			return nil, err
+		}
+	}
+
+	if !cfg.preserveOrder {
+		sort.Sort(pm.Partitions)
+	}
+
+	return pm, nil
+}
+
+// PartitionMapFromFiles takes one or more paths to json encoded partition map
+// files and returns a merged *PartitionMap. Files are applied in the order
+// given; where a topic and partition appears in multiple files, the replicas
+// from the last file take precedence. This allows a base map to be layered
+// with one or more overlay maps. Partitions appearing in any one file are
+// included in the result.
+func PartitionMapFromFiles(paths ...string) (*PartitionMap, error) {
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	merged := NewPartitionMap()
+	idx := map[key]int{}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		pm, err := PartitionMapFromString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+
+		for _, p := range pm.Partitions {
+			k := key{p.Topic, p.Partition}
+			if i, exists := idx[k]; exists {
+				merged.Partitions[i] = p
+				continue
+			}
+
+			idx[k] = len(merged.Partitions)
+			merged.Partitions = append(merged.Partitions, p)
+		}
+	}
+
+	sort.Sort(merged.Partitions)
+
+	return merged, nil
+}
+
+// PartitionMapFromZK takes a slice of regexp and finds all matching topics for
+// each. A merged *PartitionMap of all matching topic maps is returned. Topics
+// are merged in name order so that the result doesn't depend on the order
+// topics are returned by the Handler. Topics returned multiple times, e.g.
+// when matched by overlapping regexps, are only included once.
+func PartitionMapFromZK(t []*regexp.Regexp, zk Handler) (*PartitionMap, error) {
+	// Get a list of topic names from Handler
+	// matching the provided list.
+	topicsToRebuild, err := zk.GetTopics(t)
+	if err != nil {
+		return nil, err
+	}
+
+	// Err if no matching topics were found.
+	if len(topicsToRebuild) == 0 {
+		return nil, fmt.Errorf("No topics found matching: %s", t)
+	}
+
+	sort.Strings(topicsToRebuild)
+
+	// Get a partition map for each topic.
+	pmapMerged := NewPartitionMap()
+	for _, t := range topicsToRebuild {
+		pmap, err := zk.GetPartitionMap(t)
+		if err != nil {
+			return nil, err
+		}
+
+		// Merge multiple maps. Overlapping
+		// topics aren't duplicated.
+		if err := pmapMerged.Merge(pmap); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Sort(pmapMerged.Partitions)
+
+	return pmapMerged, nil
+}
+
+// RebuildToTargets takes a mapping of broker IDs to a target replica count
+// and a BrokerMap and returns a copy of the *PartitionMap where replicas are
+// moved from brokers above their target to brokers below their target. Moves
+// retain the replica set position and require that the replica set rack IDs
+// remain unique; brokers marked for replacement or missing from the BrokerMap
+// aren't selected as destinations. Destinations with the largest deficit are
+// selected first, ties broken by the lowest ID. Brokers without a target are
+// left unchanged. A []string describing any targets that couldn't be met is
+// returned.
+func (pm *PartitionMap) RebuildToTargets(targets map[int]int, bm BrokerMap) (*PartitionMap, []string) {
+	out := pm.Copy()
+
+	counts := map[int]int{}
+	for _, p := range out.Partitions {
+		for _, id := range p.Replicas {
+			counts[id]++
+		}
+	}
+
+	// Brokers eligible to receive replicas.
+	var destinations []int
+	for id := range targets {
+		if b, exists := bm[id]; exists && !b.Replace {
+			destinations = append(destinations, id)
+		}
+	}
+
+	sort.Ints(destinations)
+
+	surplus := func(id int) int {
+		t, exists := targets[id]
+		if !exists {
+			return 0
+		}
+		return counts[id] - t
+	}
+
+	// Each move reduces the total distance from
+	// the targets; iterate until no moves remain.
+	for moved := true; moved; {
+		moved = false
+
+		for _, p := range out.Partitions {
+			for pos, id := range p.Replicas {
+				if surplus(id) <= 0 {
+					continue
+				}
+
+				// Get constraints for the replica set
+				// excluding the broker being moved.
+				replicaSet := BrokerList{}
+				for _, r := range p.Replicas {
+					if b, exists := bm[r]; exists && r != id {
+						replicaSet = append(replicaSet, b)
+					}
+				}
+
+				c := MergeConstraints(replicaSet)
+				for _, r := range p.Replicas {
+					c.id[r] = true
+				}
+
+				var dest int
+				for _, d := range destinations {
+					if surplus(d) < 0 && c.passes(bm[d]) && (dest == 0 || surplus(d) < surplus(dest)) {
+						dest = d
+					}
+				}
+
+				if dest == 0 {
+					continue
+				}
+
+				p.Replicas[pos] = dest
+				counts[id]--
+				counts[dest]++
+				moved = true
+			}
+		}
+	}
+
+	var unmet []string
+
+	var ids []int
+	for id := range targets {
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		if counts[id] != targets[id] {
+			unmet = append(unmet, fmt.Sprintf("broker %d: target %d, got %d", id, targets[id], counts[id]))
+		}
+	}
+
+	return out, unmet
+}
+
+// DecommissionOrder takes a *PartitionMap, BrokerMap, the IDs of brokers to
+// be removed, a replication factor and minimum rack spread and returns an order
+// in which the brokers can be removed one at a time such that each intermediate
+// state is satisfiable. The brokers remaining once all are removed must support
+// the replication factor and rack spread (see SupportsPlacement). At each step,
+// every replica held by the removed broker must be replaceable by a broker not
+// pending removal while meeting rack constraints against the transient replica
+// set, which still includes the removed broker until the new replica is in
+// sync. Because of the latter, the order can matter. Orders are searched depth
+// first, preferring the input order, and an error is returned if no safe order
+// exists.
+func DecommissionOrder(pm *PartitionMap, bm BrokerMap, removing []int, rf, minRackSpread int) ([]int, error) {
+	bm = bm.Copy()
+
+	for _, id := range removing {
+		if _, exists := bm[id]; !exists {
+			return nil, fmt.Errorf("Broker %d not found in the broker map", id)
+		}
+		bm[id].Replace = true
+	}
+
+	if err := bm.SupportsPlacement(rf, minRackSpread); err != nil {
+		return nil, err
+	}
+
+	// The most recent reason a removal failed.
+	var failure error
+
+	var search func(pm *PartitionMap, order, remaining []int) []int
+	search = func(pm *PartitionMap, order, remaining []int) []int {
+		if len(remaining) == 0 {
+			return order
+		}
+
+		for i, id := range remaining {
+			next, err := decommissionStep(pm, bm.Copy(), id, minRackSpread)
+			if err != nil {
+				failure = fmt.Errorf("removing %d: %s", id, err)
+				continue
+			}
+
+			o := append(append([]int{}, order...), id)
+			r := append(append([]int{}, remaining[:i]...), remaining[i+1:]...)
+
+			if found := search(next, o, r); found != nil {
+				return found
+			}
+		}
+
+		return nil
+	}
+
+	order := search(pm, []int{}, removing)
+	if order == nil {
+		return nil, fmt.Errorf("No safe decommission order for brokers %v: %s", removing, failure)
+	}
+
+	return order, nil
+}
+
+// DrainBrokers takes a BrokerMap, PartitionMetaMap and the IDs of brokers to
+// drain and returns a copy of the *PartitionMap where every replica held by
+// the brokers is relocated to the remaining brokers. Destinations are selected
+// with the storage strategy, visiting partitions in descending size order so
+// that the broker with the most storage free receives each, keeping storage
+// free balanced across the receiving brokers. Destinations must meet rack ID
+// uniqueness against the replica set excluding the drained brokers. A
+// []string of errors is returned for partitions missing a size or where no
+// destination meets the constraints; such replicas remain on the drained
+// broker. The BrokerMap isn't modified.
+func (pm *PartitionMap) DrainBrokers(bm BrokerMap, pmm PartitionMetaMap, ids []int) (*PartitionMap, []string) {
+	newMap := pm.Copy()
+	var errs []string
+
+	draining := map[int]struct{}{}
+	for _, id := range ids {
+		draining[id] = struct{}{}
+	}
+
+	bm = bm.Copy()
+	bl := bm.Filter(func(b *Broker) bool {
+		_, d := draining[b.ID]
+		return !d && !b.Replace && b.ID != StubBrokerID
+	}).List()
+
+	// Visit partitions held by the
+	// drained brokers, largest first.
+	var partitions PartitionList
+	for _, p := range newMap.Partitions {
+		for _, id := range p.Replicas {
+			if _, d := draining[id]; d {
+				partitions = append(partitions, p)
+				break
+			}
+		}
+	}
+
+	for _, p := range partitions {
+		if _, err := pmm.Size(p); err != nil {
+			errs = append(errs, fmt.Sprintf("%s p%d: %s", p.Topic, p.Partition, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return newMap, errs
+	}
+
+	partitions.SortBySize(pmm)
+
+	for _, p := range partitions {
+		size, _ := pmm.Size(p)
+
+		// Populate a Constraints with the
+		// replicas that aren't drained.
+		constraints := NewConstraints()
+		for _, id := range p.Replicas {
+			if _, d := draining[id]; d {
+				continue
+			}
+			if b, exists := bm[id]; exists {
+				constraints.Add(b)
+			}
+		}
+
+		constraintsParams := ConstraintsParams{
+			SelectorMethod: "storage",
+			RequestSize:    size,
+		}
+
+		// The replica slices are shared with newMap.
+		for i, id := range p.Replicas {
+			if _, d := draining[id]; !d {
+				continue
+			}
+
+			replacement, err := constraints.SelectBroker(bl, constraintsParams)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s p%d: %s", p.Topic, p.Partition, err))
+				continue
+			}
+
+			if b, exists := bm[id]; exists {
+				b.StorageFree += size
+				b.Used--
+			}
+
+			p.Replicas[i] = replacement.ID
+		}
+	}
+
+	return newMap, errs
+}
+
+// decommissionStep takes a *PartitionMap, a BrokerMap where brokers pending
+// removal are marked for replacement, the ID of the broker being removed and a
+// minimum rack spread. A copy of the *PartitionMap is returned where each
+// replica held by the broker is replaced, or an error for the first replica
+// that can't be.
+func decommissionStep(pm *PartitionMap, bm BrokerMap, id, minRackSpread int) (*PartitionMap, error) {
+	newMap := pm.Copy()
+	bl := bm.Filter(func(b *Broker) bool { return !b.Replace }).List()
+
+	for n, partn := range newMap.Partitions {
+		for i, bid := range partn.Replicas {
+			if bid != id {
+				continue
+			}
+
+			replicaSet := BrokerList{}
+			for _, r := range partn.Replicas {
+				if b, exists := bm[r]; exists && r != id {
+					replicaSet = append(replicaSet, b)
+				}
+			}
+
+			// Brokers pending removal are still in the replica set,
+			// as is the removed broker until the replacement is in sync.
+			constraints := NewConstraints()
+			for _, b := range replicaSet {
+				constraints.Add(b)
+			}
+			constraints.Add(bm[id])
+
+			constraintsParams := ConstraintsParams{
+				SelectorMethod:   "count",
+				MinUniqueRackIDs: minRackSpread,
+				SeedVal:          int64(n + 1),
+			}
+
+			replacement, err := constraints.SelectBroker(bl, constraintsParams)
+			if err != nil {
+				return nil, fmt.Errorf("%s p%d: %s", partn.Topic, partn.Partition, err.Error())
+			}
+
+			bm[id].Used--
+			newMap.Partitions[n].Replicas[i] = replacement.ID
+		}
+	}
+
+	return newMap, nil
+}
+
+// MinimizeMovement takes an original and rebuilt *PartitionMap, a BrokerMap
+// with StorageFree values reflecting the original map, a PartitionMetaMap and a
+// movement weight between 0 and 1. Rebuilt replica sets are reverted to the
+// original where doing so lowers the weighted sum of the resulting storage
+// imbalance (the standard deviation of storage free, relative to that of the
+// original map) and replica movements (relative to those of the rebuilt map).
+// A weight of 0 reverts only where storage balance improves, while a weight of
+// 1 reverts all replica sets possible. Replica sets are only reverted if the
+// replication factor is unchanged and no original brokers are marked for
+// replacement; reverts are attempted smallest partition first. The resulting
+// map is returned along with a copy of the BrokerMap with projected StorageFree
+// values.
+func MinimizeMovement(before, after *PartitionMap, bm BrokerMap, pmm PartitionMetaMap, weight float64) (*PartitionMap, BrokerMap, error) {
+	if weight < 0 || weight > 1 {
+		return nil, nil, fmt.Errorf("Invalid movement weight %.2f", weight)
+	}
+
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	original := map[key][]int{}
+	for _, p := range before.Partitions {
+		original[key{p.Topic, p.Partition}] = p.Replicas
+	}
+
+	out := after.Copy()
+	projected := bm.Copy()
+
+	// Project StorageFree values for the rebuilt map.
+	for _, m := range []struct {
+		pm   *PartitionMap
+		sign float64
+	}{{before, 1}, {out, -1}} {
+		for _, p := range m.pm.Partitions {
+			size, err := pmm.Size(p)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			for _, id := range p.Replicas {
+				b, exists := projected[id]
+				if !exists {
+					return nil, nil, fmt.Errorf("Broker %d not found in broker map", id)
+				}
+				b.StorageFree += m.sign * size
+			}
+		}
+	}
+
+	// moved returns the number of replicas
+	// not in the original replica set.
+	moved := func(p Partition) int {
+		var n int
+		for _, id := range p.Replicas {
+			if !inReplicaSet(id, original[key{p.Topic, p.Partition}]) {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Find the revertible replica sets.
+	var moves int
+	var candidates []int
+	sizes := map[int]float64{}
+
+	for n, p := range out.Partitions {
+		m := moved(p)
+		moves += m
+
+		orig, exists := original[key{p.Topic, p.Partition}]
+		if m == 0 || !exists || len(orig) != len(p.Replicas) {
+			continue
+		}
+
+		revertible := true
+		for _, id := range orig {
+			if b, exists := projected[id]; !exists || b.Replace {
+				revertible = false
+			}
+		}
+
+		if revertible {
+			candidates = append(candidates, n)
+			sizes[n], _ = pmm.Size(p)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return sizes[candidates[i]] < sizes[candidates[j]]
+	})
+
+	// Both terms are normalized by their worst case.
+	eligible := projected.Filter(func(b *Broker) bool { return !b.Replace })
+	imbalance0 := bm.Filter(func(b *Broker) bool { return !b.Replace }).StorageStdDev()
+	moves0 := moves
+
+	score := func(imbalance float64, moves int) float64 {
+		var s float64
+		if imbalance0 > 0 {
+			s += (1 - weight) * imbalance / imbalance0
+		}
+		if moves0 > 0 {
+			s += weight * float64(moves) / float64(moves0)
+		}
+		return s
+	}
+
+	// Revert replica sets until no
+	// revert improves the score.
+	for improved := true; improved; {
+		improved = false
+		current := score(eligible.StorageStdDev(), moves)
+
+		for _, n := range candidates {
+			p := out.Partitions[n]
+			orig := original[key{p.Topic, p.Partition}]
+
+			m := moved(p)
+			if m == 0 {
+				continue
+			}
+
+			apply := func(sign float64) {
+				for _, id := range p.Replicas {
+					projected[id].StorageFree += sign * sizes[n]
+				}
+				for _, id := range orig {
+					projected[id].StorageFree -= sign * sizes[n]
+				}
+			}
+
+			apply(1)
+
+			if s := score(eligible.StorageStdDev(), moves-m); s < current {
+				out.Partitions[n].Replicas = append([]int{}, orig...)
+				moves -= m
+				current = s
+				improved = true
+				continue
+			}
+
+			apply(-1)
+		}
+	}
+
+	return out, projected, nil
+}
+
+// AddPartitions takes a topic name, a new partition count, a BrokerMap,
+// PartitionMetaMap and placement strategy ("count" or "storage") and returns a
+// copy of the *PartitionMap with partitions appended to the topic from the
+// current max partition number up to the new count. New partitions take the
+// replication factor of the topic's first partition and replicas are placed
+// on the best candidate per the strategy, with unique rack IDs per replica
+// set. If a new partition has a size in the PartitionMetaMap, candidates must
+// have the storage free to hold it. Placements update the Used and StorageFree
+// values of brokers in the provided BrokerMap. Existing partitions are
+// unchanged. A
+// []string of any errors encountered is returned; partitions that couldn't be
+// fully placed aren't added. New partitions are inserted following the topic's
+// existing partitions.
+func (pm *PartitionMap) AddPartitions(topic string, newCount int, bm BrokerMap, pmm PartitionMetaMap, strategy string) (*PartitionMap, []string) {
+	out := pm.Copy()
+	var errs []string
+
+	// Find the topic replication factor, current
+	// partition count and last partition index.
+	rf, max, last := -1, -1, -1
+	for i, p := range pm.Partitions {
+		if p.Topic != topic {
+			continue
+		}
+
+		if rf < 0 {
+			rf = len(p.Replicas)
+		}
+
+		if p.Partition > max {
+			max = p.Partition
+		}
+
+		last = i
+	}
+
+	if rf < 0 {
+		return out, []string{fmt.Sprintf("%s: topic not found", topic)}
+	}
+
+	if newCount <= max+1 {
+		return out, []string{fmt.Sprintf("%s: new partition count %d doesn't exceed the current count %d", topic, newCount, max+1)}
+	}
+
+	// Exclude brokers marked for replacement.
+	bl := bm.Filter(func(b *Broker) bool {
+		return !b.Replace && b.ID != StubBrokerID
+	}).List()
+
+	var added PartitionList
+
+	for n := max + 1; n < newCount; n++ {
+		partn := Partition{Topic: topic, Partition: n}
+		size, _ := pmm.Size(partn)
+
+		c := NewConstraints()
+		c.requestSize = size
+
+		var err error
+		for i := 0; i < rf; i++ {
+			var b *Broker
+			b, err = bl.BestCandidate(c, strategy, int64(n*rf+i))
+			if err != nil {
+				break
+			}
+
+			partn.Replicas = append(partn.Replicas, b.ID)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s p%d: %s", topic, n, err))
+			continue
+		}
+
+		added = append(added, partn)
+	}
+
+	// Insert the new partitions following the
+	// topic's last existing partition.
+	tail := append(added, out.Partitions[last+1:]...)
+	out.Partitions = append(out.Partitions[:last+1], tail...)
+
+	return out, errs
+}
+
+// SetReplication ensures that replica sets is reset to the replication
+// factor r. Sets exceeding r are truncated, sets below r are extended
+// with stub brokers.
+func (pm *PartitionMap) SetReplication(r int) {
+	// 0 is a no-op.
+	if r == 0 {
+		return
+	}
+
+	for n, p := range pm.Partitions {
+		l := len(p.Replicas)
+
+		switch {
+		// Truncate replicas beyond r.
+		case l > r:
+			pm.Partitions[n].Replicas = p.Replicas[:r]
+		// Add stub brokers to meet r.
+		case l < r:
+			r := make([]int, r-l)
+			for i := 0; i < len(r); i++ {
+				r[i] = StubBrokerID
+			}
+			pm.Partitions[n].Replicas = append(p.Replicas, r...)
+		}
+	}
+}
+
+// SetReplicationWithLeaderPreservation resets replica sets to the replication
+// factor r as SetReplication does, with truncation that never drops the
+// leader. Replica sets exceeding r retain the leader, followed by the
+// remaining replicas in order; stub brokers are dropped ahead of any other
+// replicas. A stub broker in the leader position is treated as a dropped
+// replica and the first non-stub replica is moved to the leader position.
+// Sets below r are extended with stub brokers, retaining existing replicas
+// in place. Any log dirs are reordered and truncated along with the replicas.
+func (pm *PartitionMap) SetReplicationWithLeaderPreservation(r int) {
+	// 0 is a no-op.
+	if r == 0 {
+		return
+	}
+
+	for n, p := range pm.Partitions {
+		if len(p.Replicas) <= r {
+			continue
+		}
+
+		dirs := len(p.LogDirs) == len(p.Replicas)
+
+		// Order the replica set by non-stub
+		// replicas ahead of stub brokers.
+		var replicas, stubs []int
+		var replicaDirs, stubDirs []string
+		for i, id := range p.Replicas {
+			if id == StubBrokerID {
+				stubs = append(stubs, id)
+				if dirs {
+					stubDirs = append(stubDirs, p.LogDirs[i])
+				}
+				continue
+			}
+			replicas = append(replicas, id)
+			if dirs {
+				replicaDirs = append(replicaDirs, p.LogDirs[i])
+			}
+		}
+
+		pm.Partitions[n].Replicas = append(replicas, stubs...)[:r]
+		if dirs {
+			pm.Partitions[n].LogDirs = append(replicaDirs, stubDirs...)[:r]
+		}
+	}
+
+	pm.SetReplication(r)
 }
 
-// LocalitiesAvailable takes a broker map and broker and returns a []string
-// of localities that are unused by any of the brokers in any replica sets that
-// the reference broker was found in. This is done by building a set of all
-// localities observed across all replica sets and a set of all localities
-// observed in replica sets containing the reference broker,
-// then returning the diff.
-func (pm *PartitionMap) LocalitiesAvailable(bm BrokerMap, b *Broker) []string {
-	all := map[string]struct{}{}
-	reference := map[string]struct{}{}
+// Topics returns a []string of topic names held in the PartitionMap.
+func (pm *PartitionMap) Topics() []string {
+	// Set.
+	m := map[string]struct{}{}
+	// List.
+	ts := []string{}
 
-	// Traverse the partition map and
-	// gather localities.
-	for _, partn := range pm.Partitions {
+	for _, p := range pm.Partitions {
+		if _, seen := m[p.Topic]; !seen {
+			ts = append(ts, p.Topic)
+			m[p.Topic] = struct{}{}
+		}
+	}
 
-		localities := map[string]struct{}{}
-		var containsRef bool
+	sort.Strings(ts)
 
-		for _, replica := range partn.Replicas {
-			// Check if this is a replica set
-			// that contains the reference
-			if replica == b.ID {
-				containsRef = true
-				// We shouldn't have the reference
-				// broker's locality since it's
-				// missing; the purpose of this
-				// entire method is to infer it
-				// or a compatible locality. Skip.
-				continue
+	return ts
+}
+
+// Trim takes a set of existing topic names and returns a []string of topic
+// names that were removed along with a copy of the *PartitionMap containing
+// only the topics found in the existing set.
+func (pm *PartitionMap) Trim(existing map[string]struct{}) ([]string, *PartitionMap) {
+	trimmed := NewPartitionMap()
+	removed := map[string]struct{}{}
+
+	for _, p := range pm.Partitions {
+		if _, exists := existing[p.Topic]; !exists {
+			removed[p.Topic] = struct{}{}
+			continue
+		}
+
+		part := Partition{
+			Topic:     p.Topic,
+			Partition: p.Partition,
+			Replicas:  make([]int, len(p.Replicas)),
+		}
+
+		copy(part.Replicas, p.Replicas)
+		trimmed.Partitions = append(trimmed.Partitions, part)
+	}
+
+	var names []string
+	for t := range removed {
+		names = append(names, t)
+	}
+
+	sort.Strings(names)
+
+	return names, trimmed
+}
+
+// ReplicaSets takes a topic name and returns a ReplicaSets.
+func (pm *PartitionMap) ReplicaSets(t string) ReplicaSets {
+	rs := ReplicaSets{}
+
+	for _, p := range pm.Partitions {
+		if p.Topic == t {
+			rs[p.Partition] = p.Replicas
+		}
+	}
+
+	return rs
+}
+
+// Merge takes a *PartitionMap and merges its partitions into the
+// *PartitionMap, keyed on topic and partition. Partitions already present with
+// an identical replica set are skipped; all others are appended. An error is
+// returned, with no partitions merged, if any partition is present in both
+// maps with conflicting replica sets.
+func (pm *PartitionMap) Merge(other *PartitionMap) error {
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	existing := map[key]Partition{}
+	for _, p := range pm.Partitions {
+		existing[key{p.Topic, p.Partition}] = p
+	}
+
+	var add PartitionList
+	for _, p := range other.Partitions {
+		k := key{p.Topic, p.Partition}
+		if e, exists := existing[k]; exists {
+			if !e.Equal(p) {
+				return fmt.Errorf("%s p%d: conflicting replica sets %v and %v",
+					p.Topic, p.Partition, e.Replicas, p.Replicas)
+			}
+			continue
+		}
+
+		existing[k] = p
+		add = append(add, p)
+	}
+
+	pm.Partitions = append(pm.Partitions, add...)
+
+	return nil
+}
+
+// Copy returns a copy of a *PartitionMap.
+func (pm *PartitionMap) Copy() *PartitionMap {
+	cpy := NewPartitionMap()
+
+	for _, p := range pm.Partitions {
+		part := Partition{
+			Topic:     p.Topic,
+			Partition: p.Partition,
+			Replicas:  make([]int, len(p.Replicas)),
+		}
+
+		copy(part.Replicas, p.Replicas)
+
+		if p.LogDirs != nil {
+			part.LogDirs = make([]string, len(p.LogDirs))
+			copy(part.LogDirs, p.LogDirs)
+		}
+
+		cpy.Partitions = append(cpy.Partitions, part)
+	}
+
+	return cpy
+}
+
+// Equal checks the ity betwee two partition maps. Equality requires
+// that the total order is exactly the same.
+func (pm *PartitionMap) Equal(pm2 *PartitionMap) (bool, error) {
+	// Crude checks.
+	switch {
+	case len(pm.Partitions) != len(pm2.Partitions):
+		return false, errors.New("partitions len")
+	case pm.Version != pm2.Version:
+		return false, errors.New("version")
+	}
+
+	// Iterative comparison.
+	for i, p1 := range pm.Partitions {
+		p2 := pm2.Partitions[i]
+		switch {
+		case p1.Topic != p2.Topic:
+			return false, errors.New("topic order")
+		case p1.Partition != p2.Partition:
+			return false, errors.New("partition order")
+		case len(p1.Replicas) != len(p2.Replicas):
+			return false, errors.New("replica list")
+		}
+
+		for n := range p1.Replicas {
+			if p1.Replicas[n] != p2.Replicas[n] {
+				return false, errors.New("replica")
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// PartitionChange describes the change in a partition's replica set between
+// two PartitionMaps. OldReplicas is nil for partitions only in the new map
+// and NewReplicas is nil for partitions only in the old map. LeaderChange is
+// set if the leader differs.
+type PartitionChange struct {
+	Topic        string
+	Partition    int
+	OldReplicas  []int
+	NewReplicas  []int
+	LeaderChange bool
+}
+
+// Added returns the brokers in the new replica set but not the old.
+func (c PartitionChange) Added() []int {
+	var ids []int
+	for _, id := range c.NewReplicas {
+		if !inReplicaSet(id, c.OldReplicas) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Removed returns the brokers in the old replica set but not the new.
+func (c PartitionChange) Removed() []int {
+	var ids []int
+	for _, id := range c.OldReplicas {
+		if !inReplicaSet(id, c.NewReplicas) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Diff takes another *PartitionMap and returns a PartitionChange for each
+// partition with a different replica set or replica order in the other map,
+// sorted by topic and partition. Partitions without changes aren't included.
+// The partition order of either map doesn't affect the result.
+func (pm *PartitionMap) Diff(other *PartitionMap) []PartitionChange {
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	after := map[key][]int{}
+	for _, p := range other.Partitions {
+		after[key{p.Topic, p.Partition}] = p.Replicas
+	}
+
+	var changes []PartitionChange
+
+	for _, p := range pm.Partitions {
+		k := key{p.Topic, p.Partition}
+		replicas, exists := after[k]
+		delete(after, k)
+
+		if exists && p.Equal(Partition{Topic: p.Topic, Partition: p.Partition, Replicas: replicas}) {
+			continue
+		}
+
+		changes = append(changes, newPartitionChange(p.Topic, p.Partition, p.Replicas, replicas))
+	}
+
+	// Partitions only in the other map.
+	for k, replicas := range after {
+		changes = append(changes, newPartitionChange(k.topic, k.partition, nil, replicas))
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Topic != changes[j].Topic {
+			return changes[i].Topic < changes[j].Topic
+		}
+		return changes[i].Partition < changes[j].Partition
+	})
+
+	return changes
+}
+
+// newPartitionChange returns a PartitionChange
+// for the old and new replica sets.
+func newPartitionChange(topic string, partition int, before, after []int) PartitionChange {
+	leaderChange := len(before) != len(after)
+	if len(before) > 0 && len(after) > 0 {
+		leaderChange = before[0] != after[0]
+	}
+
+	return PartitionChange{
+		Topic:        topic,
+		Partition:    partition,
+		OldReplicas:  before,
+		NewReplicas:  after,
+		LeaderChange: leaderChange,
+	}
+}
+
+// ThrottledReplicas takes a before and after *PartitionMap describing a
+// reassignment and returns mappings of topic names to the values for the
+// leader.replication.throttled.replicas and
+// follower.replication.throttled.replicas topic configs, respectively. For
+// each partition with a replica set change, the leader value lists all
+// replicas in the before map and the follower value lists the replicas being
+// added. Values are comma delimited partition:broker ID pairs, e.g.
+// "0:1001,0:1002", in partition then replica set order. Topics without
+// changes aren't included.
+func ThrottledReplicas(before, after *PartitionMap) (map[string]string, map[string]string) {
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	current := map[key][]int{}
+	for _, p := range before.Partitions {
+		current[key{p.Topic, p.Partition}] = p.Replicas
+	}
+
+	partitions := after.Copy().Partitions
+	sort.Sort(partitions)
+
+	leaders := map[string][]string{}
+	followers := map[string][]string{}
+
+	for _, p := range partitions {
+		replicas := current[key{p.Topic, p.Partition}]
+
+		var added []int
+		for _, id := range p.Replicas {
+			if !inReplicaSet(id, replicas) {
+				added = append(added, id)
+			}
+		}
+
+		if len(added) == 0 {
+			continue
+		}
+
+		for _, id := range replicas {
+			leaders[p.Topic] = append(leaders[p.Topic], fmt.Sprintf("%d:%d", p.Partition, id))
+		}
+
+		for _, id := range added {
+			followers[p.Topic] = append(followers[p.Topic], fmt.Sprintf("%d:%d", p.Partition, id))
+		}
+	}
+
+	leader, follower := map[string]string{}, map[string]string{}
+
+	for t, l := range leaders {
+		leader[t] = strings.Join(l, ",")
+	}
+
+	for t, f := range followers {
+		follower[t] = strings.Join(f, ",")
+	}
+
+	return leader, follower
+}
+
+// EqualMembership returns whether two partition maps hold the same partitions
+// with the same replica set membership. Unlike Equal, the order of partitions
+// and the order of brokers within replica sets isn't considered; maps that
+// differ only in leadership are membership-equal.
+func (pm *PartitionMap) EqualMembership(pm2 *PartitionMap) bool {
+	if len(pm.Partitions) != len(pm2.Partitions) {
+		return false
+	}
+
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	replicas := map[key][]int{}
+	for _, p := range pm.Partitions {
+		rs := make([]int, len(p.Replicas))
+		copy(rs, p.Replicas)
+		sort.Ints(rs)
+		replicas[key{p.Topic, p.Partition}] = rs
+	}
+
+	for _, p := range pm2.Partitions {
+		rs1, exists := replicas[key{p.Topic, p.Partition}]
+		if !exists || len(rs1) != len(p.Replicas) {
+			return false
+		}
+
+		rs2 := make([]int, len(p.Replicas))
+		copy(rs2, p.Replicas)
+		sort.Ints(rs2)
+
+		for i := range rs1 {
+			if rs1[i] != rs2[i] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Strip takes a PartitionMap and returns a copy where all broker ID
+// references are replaced with the stub broker (ID == StubBrokerID) with
+// the replace field is set to true. This ensures that the entire map is
+// rebuilt, even if the provided broker list matches what's already in the map.
+// Any log dirs are reset to LogDirAny.
+func (pm *PartitionMap) Strip() *PartitionMap {
+	Stripped := NewPartitionMap()
+
+	// Copy each partition sans the replicas list.
+	// The new replica list is all stub brokers.
+	for _, p := range pm.Partitions {
+		var stubs = make([]int, len(p.Replicas))
+		for i := range stubs {
+			stubs[i] = StubBrokerID
+		}
+
+		part := Partition{
+			Topic:     p.Topic,
+			Partition: p.Partition,
+			Replicas:  stubs,
+		}
+
+		// Log dirs are reset with the replicas.
+		if p.LogDirs != nil {
+			part.LogDirs = make([]string, len(p.LogDirs))
+			for i := range part.LogDirs {
+				part.LogDirs[i] = LogDirAny
+			}
+		}
+
+		Stripped.Partitions = append(Stripped.Partitions, part)
+	}
+
+	return Stripped
+}
+
+// SplitByDestinationLocality takes the *PartitionMap prior to a change and
+// a BrokerMap and returns a map of locality to *PartitionMap, grouping each
+// changed partition by the locality of the brokers it's being moved to. This
+// allows changes to be applied one locality at a time. A partition with
+// destination brokers in multiple localities is split per replica change;
+// the map for a given locality includes only the changes destined for that
+// locality and any localities sorted before it, so applying the maps in
+// sorted locality order converges on the receiver. Changed partitions that
+// don't have any new replicas (e.g. leadership reorders) are grouped under
+// the empty locality, as are destination brokers with no locality.
+func (pm *PartitionMap) SplitByDestinationLocality(before *PartitionMap, bm BrokerMap) map[string]*PartitionMap {
+	out := map[string]*PartitionMap{}
+
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	prev := map[key][]int{}
+	for _, p := range before.Partitions {
+		prev[key{p.Topic, p.Partition}] = p.Replicas
+	}
+
+	locality := func(id int) string {
+		if b, ok := bm[id]; ok {
+			return b.Locality
+		}
+		return ""
+	}
+
+	for _, p := range pm.Partitions {
+		old := prev[key{p.Topic, p.Partition}]
+		if p.Equal(Partition{Topic: p.Topic, Partition: p.Partition, Replicas: old}) {
+			continue
+		}
+
+		// Get the incoming and outgoing replicas.
+		var incoming, outgoing []int
+		for _, id := range p.Replicas {
+			if !inReplicaSet(id, old) {
+				incoming = append(incoming, id)
+			}
+		}
+		for _, id := range old {
+			if !inReplicaSet(id, p.Replicas) {
+				outgoing = append(outgoing, id)
 			}
+		}
 
-			// Add the locality.
-			l := bm[replica].Locality
-			if l != "" {
-				localities[l] = struct{}{}
+		// Pair each incoming replica with the
+		// outgoing replica that it's replacing.
+		replaces := map[int]int{}
+		for i, id := range incoming {
+			if i < len(outgoing) {
+				replaces[id] = outgoing[i]
 			}
 		}
 
-		// Populate into the appropriate
-		// set.
-		if containsRef {
-			for k := range localities {
-				reference[k] = struct{}{}
-			}
-		} else {
-			for k := range localities {
-				all[k] = struct{}{}
+		var unpaired []int
+		if len(outgoing) > len(incoming) {
+			unpaired = outgoing[len(incoming):]
+		}
+
+		// Get the destination localities.
+		var localities []string
+		seen := map[string]bool{}
+		for _, id := range incoming {
+			if l := locality(id); !seen[l] {
+				seen[l] = true
+				localities = append(localities, l)
 			}
 		}
-	}
 
-	// Get the diff between the all set
-	// and the reference set.
-	diff := []string{}
-	for l := range all {
-		if _, used := reference[l]; !used {
-			diff = append(diff, l)
+		if len(localities) == 0 {
+			localities = []string{""}
 		}
-	}
 
-	sort.Strings(diff)
+		sort.Strings(localities)
 
-	return diff
-}
+		for n, l := range localities {
+			var replicas []int
+			for _, id := range p.Replicas {
+				// Changes destined for later localities
+				// retain the original replica.
+				if inReplicaSet(id, incoming) && locality(id) > l {
+					if o, ok := replaces[id]; ok {
+						replicas = append(replicas, o)
+					}
+					continue
+				}
+				replicas = append(replicas, id)
+			}
 
-func (pm *PartitionMap) shuffle(f func(Partition) bool) {
-	var s int
-	for n := range pm.Partitions {
-		if f(pm.Partitions[n]) {
-			rand.Seed(int64(s << 20))
-			s++
-			rand.Shuffle(len(pm.Partitions[n].Replicas), func(i, j int) {
-				pm.Partitions[n].Replicas[i], pm.Partitions[n].Replicas[j] = pm.Partitions[n].Replicas[j], pm.Partitions[n].Replicas[i]
+			// Removals are applied with the last locality.
+			if n < len(localities)-1 {
+				replicas = append(replicas, unpaired...)
+			}
+
+			if _, exists := out[l]; !exists {
+				out[l] = NewPartitionMap()
+			}
+
+			out[l].Partitions = append(out[l].Partitions, Partition{
+				Topic:     p.Topic,
+				Partition: p.Partition,
+				Replicas:  replicas,
 			})
 		}
 	}
+
+	return out
 }
 
-// PartitionMapFromString takes a json encoded string and returns a *PartitionMap.
-func PartitionMapFromString(s string) (*PartitionMap, error) {
-	pm := NewPartitionMap()
+// CheckPolicy takes a BrokerMap and a policy function that is evaluated for
+// each partition in the PartitionMap. A []error of all policy violations is
+// returned, each prefixed with the topic and partition.
+func (pm *PartitionMap) CheckPolicy(bm BrokerMap, policy func(Partition, BrokerMap) error) []error {
+	var errs []error
 
-	err := json.Unmarshal([]byte(s), &pm)
-	if err != nil {
-		return nil, fmt.Errorf("Error parsing partition map: %s", err.Error())
+	for _, p := range pm.Partitions {
+		if err := policy(p, bm); err != nil {
+			e := fmt.Errorf("%s p%d: %s", p.Topic, p.Partition, err.Error())
+			errs = append(errs, e)
+		}
 	}
 
-	sort.Sort(pm.Partitions)
+	return errs
+}
 
-	return pm, nil
+// LeaderlessOnLoss takes a []int of failing broker IDs and a BrokerMap and
+// returns a PartitionList of partitions that would be left without a leader
+// if the failing brokers were lost; that is, partitions where every replica
+// able to assume leadership is in the failing set. Replicas referencing
+// brokers that are missing from the BrokerMap or marked as missing aren't
+// considered able to assume leadership.
+func (pm *PartitionMap) LeaderlessOnLoss(failing []int, bm BrokerMap) PartitionList {
+	var pl PartitionList
+
+	for _, p := range pm.Partitions {
+		leaderless := true
+
+		for _, id := range p.Replicas {
+			b, exists := bm[id]
+			if !exists || b.Missing || inReplicaSet(id, failing) {
+				continue
+			}
+
+			leaderless = false
+			break
+		}
+
+		if leaderless {
+			pl = append(pl, p)
+		}
+	}
+
+	return pl
 }
 
-// PartitionMapFromZK takes a slice of regexp and finds all matching topics for
-// each. A merged *PartitionMap of all matching topic maps is returned.
-func PartitionMapFromZK(t []*regexp.Regexp, zk Handler) (*PartitionMap, error) {
-	// Get a list of topic names from Handler
-	// matching the provided list.
-	topicsToRebuild, err := zk.GetTopics(t)
-	if err != nil {
-		return nil, err
+// NextStep takes a current and target *PartitionMap and a maximum number of
+// partition changes. It returns a copy of the current map with up to
+// maxChanges partitions updated to their target replica sets, along with
+// whether the returned map has reached the target. Partitions in the target
+// that aren't in the current map, and partitions in the current map that
+// aren't in the target (which are dropped), count as changes. Calling NextStep
+// repeatedly with its output converges on the target map. A maxChanges of 0
+// or less applies all changes.
+func NextStep(current, target *PartitionMap, maxChanges int) (*PartitionMap, bool) {
+	type key struct {
+		topic     string
+		partition int
 	}
 
-	// Err if no matching topics were found.
-	if len(topicsToRebuild) == 0 {
-		return nil, fmt.Errorf("No topics found matching: %s", t)
+	targets := map[key]Partition{}
+	for _, p := range target.Partitions {
+		targets[key{p.Topic, p.Partition}] = p
 	}
 
-	// Get a partition map for each topic.
-	pmapMerged := NewPartitionMap()
-	for _, t := range topicsToRebuild {
-		pmap, err := zk.GetPartitionMap(t)
-		if err != nil {
-			return nil, err
-		}
+	next := current.Copy()
+	seen := map[key]struct{}{}
 
-		// Merge multiple maps.
-		pmapMerged.Partitions = append(pmapMerged.Partitions, pmap.Partitions...)
+	var changes int
+	reached := true
+
+	// Returns whether another change can be made.
+	budget := func() bool {
+		return maxChanges <= 0 || changes < maxChanges
 	}
 
-	sort.Sort(pmapMerged.Partitions)
+	dropped := map[key]struct{}{}
 
-	return pmapMerged, nil
-}
+	for i, p := range next.Partitions {
+		k := key{p.Topic, p.Partition}
+		seen[k] = struct{}{}
 
-// SetReplication ensures that replica sets is reset to the replication
-// factor r. Sets exceeding r are truncated, sets below r are extended
-// with stub brokers.
-func (pm *PartitionMap) SetReplication(r int) {
-	// 0 is a no-op.
-	if r == 0 {
-		return
-	}
+		t, exists := targets[k]
+		if exists && p.Equal(t) {
+			continue
+		}
 
-	for n, p := range pm.Partitions {
-		l := len(p.Replicas)
+		if !budget() {
+			reached = false
+			continue
+		}
 
-		switch {
-		// Truncate replicas beyond r.
-		case l > r:
-			pm.Partitions[n].Replicas = p.Replicas[:r]
-		// Add stub brokers to meet r.
-		case l < r:
-			r := make([]int, r-l)
-			for i := 0; i < len(r); i++ {
-				r[i] = StubBrokerID
+		changes++
+
+		// Drop partitions not in the target.
+		if !exists {
+			dropped[k] = struct{}{}
+			continue
+		}
+
+		next.Partitions[i].Replicas = make([]int, len(t.Replicas))
+		copy(next.Partitions[i].Replicas, t.Replicas)
+	}
+
+	if len(dropped) > 0 {
+		var kept PartitionList
+		for _, p := range next.Partitions {
+			if _, drop := dropped[key{p.Topic, p.Partition}]; !drop {
+				kept = append(kept, p)
 			}
-			pm.Partitions[n].Replicas = append(p.Replicas, r...)
 		}
+		next.Partitions = kept
 	}
-}
 
-// Topics returns a []string of topic names held in the PartitionMap.
-func (pm *PartitionMap) Topics() []string {
-	// Set.
-	m := map[string]struct{}{}
-	// List.
-	ts := []string{}
+	// Add partitions only found in the target.
+	for _, t := range target.Partitions {
+		if _, exists := seen[key{t.Topic, t.Partition}]; exists {
+			continue
+		}
 
-	for _, p := range pm.Partitions {
-		if _, seen := m[p.Topic]; !seen {
-			ts = append(ts, p.Topic)
-			m[p.Topic] = struct{}{}
+		if !budget() {
+			reached = false
+			continue
+		}
+
+		part := Partition{
+			Topic:     t.Topic,
+			Partition: t.Partition,
+			Replicas:  make([]int, len(t.Replicas)),
 		}
+
+		copy(part.Replicas, t.Replicas)
+		next.Partitions = append(next.Partitions, part)
+		changes++
 	}
 
-	sort.Strings(ts)
+	sort.Sort(next.Partitions)
 
-	return ts
+	return next, reached
 }
 
-// ReplicaSets takes a topic name and returns a ReplicaSets.
-func (pm *PartitionMap) ReplicaSets(t string) ReplicaSets {
-	rs := ReplicaSets{}
+// StepPlan takes the *PartitionMap that the receiver is to be applied to and
+// returns a sequence of maps where each differs from the previous (or the
+// before map, for the first) by exactly one partition's replica set. Each step
+// is a complete map that can be applied in turn and the final step is the
+// receiver; partitions only found in the before map are dropped. Changes are
+// ordered by topic and partition. An empty sequence is returned if there are no
+// changes. See NextStep.
+func (pm *PartitionMap) StepPlan(before *PartitionMap) []*PartitionMap {
+	var steps []*PartitionMap
+
+	current := before.Copy()
+	sort.Sort(current.Partitions)
+
+	for {
+		next, reached := NextStep(current, pm, 1)
+		if eq, _ := next.Equal(current); eq {
+			break
+		}
 
-	for _, p := range pm.Partitions {
-		if p.Topic == t {
-			rs[p.Partition] = p.Replicas
+		steps = append(steps, next)
+		current = next
+
+		if reached {
+			break
 		}
 	}
 
-	return rs
+	return steps
 }
 
-// Copy returns a copy of a *PartitionMap.
-func (pm *PartitionMap) Copy() *PartitionMap {
-	cpy := NewPartitionMap()
+// Reconcile takes a live and desired *PartitionMap and returns a
+// *PartitionMap holding only the desired partitions that differ from (or are
+// missing in) the live map, along with whether any changes are needed. The
+// returned map can be applied as a reassignment to transform the live map
+// into the desired map.
+func Reconcile(live, desired *PartitionMap) (*PartitionMap, bool) {
+	type key struct {
+		topic     string
+		partition int
+	}
+
+	current := map[key]Partition{}
+	for _, p := range live.Partitions {
+		current[key{p.Topic, p.Partition}] = p
+	}
+
+	changes := NewPartitionMap()
+
+	for _, p := range desired.Partitions {
+		if l, exists := current[key{p.Topic, p.Partition}]; exists && l.Equal(p) {
+			continue
+		}
 
-	for _, p := range pm.Partitions {
 		part := Partition{
 			Topic:     p.Topic,
 			Partition: p.Partition,
@@ -711,90 +3656,184 @@ func (pm *PartitionMap) Copy() *PartitionMap {
 		}
 
 		copy(part.Replicas, p.Replicas)
-		cpy.Partitions = append(cpy.Partitions, part)
+		changes.Partitions = append(changes.Partitions, part)
 	}
 
-	return cpy
+	sort.Sort(changes.Partitions)
+
+	return changes, len(changes.Partitions) > 0
 }
 
-// Equal checks the ity betwee two partition maps. Equality requires
-// that the total order is exactly the same.
-func (pm *PartitionMap) Equal(pm2 *PartitionMap) (bool, error) {
-	// Crude checks.
-	switch {
-	case len(pm.Partitions) != len(pm2.Partitions):
-		return false, errors.New("partitions len")
-	case pm.Version != pm2.Version:
-		return false, errors.New("version")
+// WriteMap takes a *PartitionMap and writes a JSON
+// text file to the provided path.
+func WriteMap(pm *PartitionMap, path string) error {
+	f, err := os.OpenFile(path+".json", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
 	}
 
-	// Iterative comparison.
-	for i, p1 := range pm.Partitions {
-		p2 := pm2.Partitions[i]
-		switch {
-		case p1.Topic != p2.Topic:
-			return false, errors.New("topic order")
-		case p1.Partition != p2.Partition:
-			return false, errors.New("partition order")
-		case len(p1.Replicas) != len(p2.Replicas):
-			return false, errors.New("replica list")
+	if err := WriteMapTo(pm, f, true); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// WriteMapBatched takes a *PartitionMap and writes it as a series of JSON text
+// files, each containing at most batchSize partitions, to the provided path
+// suffixed with the batch number, e.g. path.1.json, path.2.json. This allows a
+// large reassignment to be submitted incrementally. The partitions of a topic
+// are kept within a single batch where the topic fits, and concatenating the
+// batches in order yields the original partition list.
+func WriteMapBatched(pm *PartitionMap, path string, batchSize int) error {
+	if batchSize < 1 {
+		return fmt.Errorf("Invalid batch size %d", batchSize)
+	}
+
+	for i, batch := range pm.batches(batchSize) {
+		if err := WriteMap(batch, fmt.Sprintf("%s.%d", path, i+1)); err != nil {
+			return err
 		}
+	}
 
-		for n := range p1.Replicas {
-			if p1.Replicas[n] != p2.Replicas[n] {
-				return false, errors.New("replica")
+	return nil
+}
+
+// batches splits the *PartitionMap into a []*PartitionMap of at most size
+// partitions each, preserving partition order and the map version. Each run
+// of partitions for a topic starts a new batch if it doesn't fit in the
+// current batch but would fit in an empty one; runs larger than size are
+// split across batches.
+func (pm *PartitionMap) batches(size int) []*PartitionMap {
+	var batches []*PartitionMap
+	var current *PartitionMap
+
+	newBatch := func() {
+		current = &PartitionMap{Version: pm.Version, Partitions: PartitionList{}}
+		batches = append(batches, current)
+	}
+
+	for start := 0; start < len(pm.Partitions); {
+		// Find the run of partitions for this topic.
+		end := start + 1
+		for end < len(pm.Partitions) && pm.Partitions[end].Topic == pm.Partitions[start].Topic {
+			end++
+		}
+		run := pm.Partitions[start:end]
+
+		if current == nil || (len(current.Partitions)+len(run) > size && len(run) <= size) {
+			newBatch()
+		}
+
+		for _, p := range run {
+			if len(current.Partitions) == size {
+				newBatch()
 			}
+			current.Partitions = append(current.Partitions, p)
 		}
+
+		start = end
 	}
 
-	return true, nil
+	return batches
 }
 
-// Strip takes a PartitionMap and returns a copy where all broker ID
-// references are replaced with the stub broker (ID == StubBrokerID) with
-// the replace field is set to true. This ensures that the entire map is
-// rebuilt, even if the provided broker list matches what's already in the map.
-func (pm *PartitionMap) Strip() *PartitionMap {
-	Stripped := NewPartitionMap()
+// WriteMapTo takes a *PartitionMap and writes it as JSON
+// followed by a newline to the provided io.Writer, e.g. os.Stdout. If compact
+// is true, the JSON is written on a single line; otherwise it's indented.
+// Partitions with log dirs are written with a log dir for each replica;
+// positions without one, e.g. following a replication factor increase, are
+// written as LogDirAny.
+func WriteMapTo(pm *PartitionMap, w io.Writer, compact bool) error {
+	// Align any log dirs with the replicas.
+	for _, p := range pm.Partitions {
+		if p.LogDirs != nil && len(p.LogDirs) != len(p.Replicas) {
+			pm = pm.alignLogDirs()
+			break
+		}
+	}
+
+	// Marshal.
+	var out []byte
+	var err error
+
+	if compact {
+		out, err = json.Marshal(pm)
+	} else {
+		out, err = json.MarshalIndent(pm, "", "  ")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(out, '\n'))
+
+	return err
+}
+
+// alignLogDirs returns a copy of the *PartitionMap where the log dirs of
+// each partition with log dirs are truncated or extended with LogDirAny to
+// the length of the replica set.
+func (pm *PartitionMap) alignLogDirs() *PartitionMap {
+	cpy := pm.Copy()
+
+	for n, p := range cpy.Partitions {
+		if p.LogDirs == nil {
+			continue
+		}
+
+		dirs := make([]string, len(p.Replicas))
+		for i := range dirs {
+			dirs[i] = p.logDir(i)
+		}
+
+		cpy.Partitions[n].LogDirs = dirs
+	}
+
+	return cpy
+}
+
+// PartitionSizes takes a PartitionMetaMap and returns a mapping of topic and
+// partition number to size for all partitions in the PartitionMap. An error is
+// returned if any partition isn't in the PartitionMetaMap.
+func (pm *PartitionMap) PartitionSizes(pmm PartitionMetaMap) (map[string]map[int]float64, error) {
+	sizes := map[string]map[int]float64{}
 
-	// Copy each partition sans the replicas list.
-	// The new replica list is all stub brokers.
 	for _, p := range pm.Partitions {
-		var stubs = make([]int, len(p.Replicas))
-		for i := range stubs {
-			stubs[i] = StubBrokerID
+		s, err := pmm.Size(p)
+		if err != nil {
+			return nil, err
 		}
 
-		part := Partition{
-			Topic:     p.Topic,
-			Partition: p.Partition,
-			Replicas:  stubs,
+		if _, exists := sizes[p.Topic]; !exists {
+			sizes[p.Topic] = map[int]float64{}
 		}
 
-		Stripped.Partitions = append(Stripped.Partitions, part)
+		sizes[p.Topic][p.Partition] = s
 	}
 
-	return Stripped
+	return sizes, nil
 }
 
-// WriteMap takes a *PartitionMap and writes a JSON
-// text file to the provided path.
-func WriteMap(pm *PartitionMap, path string) error {
-	// Marshal.
-	out, err := json.Marshal(pm)
+// WriteMapSizes takes a *PartitionMap, PartitionMetaMap and path and writes
+// the size of each partition in the map as JSON to a sidecar file at the path
+// with a .sizes.json extension. Reassignment maps can't carry additional
+// fields, so the sidecar allows a written map to be reviewed without
+// referencing partition metadata.
+func WriteMapSizes(pm *PartitionMap, pmm PartitionMetaMap, path string) error {
+	sizes, err := pm.PartitionSizes(pmm)
 	if err != nil {
 		return err
 	}
 
-	mapOut := string(out)
-
-	// Write file.
-	err = ioutil.WriteFile(path+".json", []byte(mapOut+"\n"), 0644)
+	out, err := json.Marshal(sizes)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return ioutil.WriteFile(path+".sizes.json", append(out, '\n'), 0644)
 }
 
 // UseStats returns a map of broker IDs to BrokerUseStats; each
@@ -842,3 +3881,38 @@ func (p Partition) Equal(p2 Partition) bool {
 
 	return true
 }
+
+// promote moves the replica at replica set position i to the leader
+// position, preserving the order of the remaining replicas. Any log dirs
+// are moved along with the replicas.
+func (p Partition) promote(i int) {
+	id := p.Replicas[i]
+	copy(p.Replicas[1:i+1], p.Replicas[:i])
+	p.Replicas[0] = id
+
+	if len(p.LogDirs) == len(p.Replicas) {
+		dir := p.LogDirs[i]
+		copy(p.LogDirs[1:i+1], p.LogDirs[:i])
+		p.LogDirs[0] = dir
+	}
+}
+
+// logDir returns the log dir for the replica set position i, or LogDirAny if
+// none is specified.
+func (p Partition) logDir(i int) string {
+	if i < len(p.LogDirs) && p.LogDirs[i] != "" {
+		return p.LogDirs[i]
+	}
+	return LogDirAny
+}
+
+// inReplicaSet returns whether the broker ID is in the replica set.
+func inReplicaSet(id int, rs []int) bool {
+	for _, r := range rs {
+		if r == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,9 +1,17 @@
 package kafkazk
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -151,6 +159,200 @@ func TestSortBySize(t *testing.T) {
 	}
 }
 
+func TestSortBySizeTieBreak(t *testing.T) {
+	pmm := NewPartitionMetaMap()
+	for _, topic := range []string{"a_topic", "b_topic", "c_topic"} {
+		pmm[topic] = map[int]*PartitionMeta{
+			0: {Size: 100},
+			1: {Size: 100},
+			2: {Size: 200},
+		}
+	}
+
+	expected := []string{
+		"a_topic p2", "b_topic p2", "c_topic p2",
+		"a_topic p0", "a_topic p1", "b_topic p0",
+		"b_topic p1", "c_topic p0", "c_topic p1",
+	}
+
+	var pl PartitionList
+	for _, topic := range []string{"c_topic", "a_topic", "b_topic"} {
+		for _, n := range []int{1, 0, 2} {
+			pl = append(pl, Partition{Topic: topic, Partition: n})
+		}
+	}
+
+	// The order is independent of the input order.
+	for i := 0; i < 5; i++ {
+		rand.New(rand.NewSource(int64(i))).Shuffle(len(pl), func(i, j int) {
+			pl[i], pl[j] = pl[j], pl[i]
+		})
+
+		pl.SortBySize(pmm)
+
+		for j, p := range pl {
+			if got := fmt.Sprintf("%s p%d", p.Topic, p.Partition); got != expected[j] {
+				t.Errorf("[shuffle %d] Expected %s at position %d, got %s", i, expected[j], j, got)
+			}
+		}
+	}
+}
+
+func TestPreferLeaders(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+
+	pm.Partitions[0].Replicas = []int{1001, 1002, 1003}
+	pm.Partitions[1].Replicas = []int{1002, 1001}
+	pm.Partitions[2].Replicas = []int{1001, 1004}
+	pm.Partitions[3].Replicas = []int{1003, 1004, 1002}
+
+	pm.PreferLeaders([]int{1003, 1002})
+
+	expected := [][]int{
+		// 1003 is the most preferred broker present.
+		{1003, 1001, 1002},
+		// Already led by a preferred broker.
+		{1002, 1001},
+		// No preferred brokers; unchanged.
+		{1001, 1004},
+		// Already led by the most preferred broker.
+		{1003, 1004, 1002},
+	}
+
+	for i, replicas := range expected {
+		if !intsEqual(pm.Partitions[i].Replicas, replicas) {
+			t.Errorf("Expected replicas %v, got %v", replicas, pm.Partitions[i].Replicas)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	pm := NewPartitionMap()
+	pm.Partitions = PartitionList{
+		{Topic: "test_topic", Partition: 0, Replicas: []int{1001, 1002}},
+		{Topic: "test_topic", Partition: 1, Replicas: []int{1001, 1001, 1002}},
+		{Topic: "test_topic", Partition: 2, Replicas: []int{}},
+		{Topic: "test_topic", Partition: 3, Replicas: []int{1001, StubBrokerID}},
+	}
+
+	errs := pm.Validate()
+
+	expected := []error{
+		ReplicaSetError{"test_topic", 1, ErrDuplicateReplica},
+		ReplicaSetError{"test_topic", 2, ErrEmptyReplicaSet},
+		ReplicaSetError{"test_topic", 3, ErrStubReplica},
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errs)
+	}
+
+	for i := range expected {
+		if errs[i] != expected[i] {
+			t.Errorf("Expected error '%s', got '%s'", expected[i], errs[i])
+		}
+	}
+
+	if !errors.Is(errs[0], ErrDuplicateReplica) {
+		t.Errorf("Expected error to wrap '%s'", ErrDuplicateReplica)
+	}
+}
+
+func TestPartitionMapFromStringDuplicateReplicas(t *testing.T) {
+	_, err := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1001,1001,1002]}]}`)
+
+	expected := "test_topic p1: Duplicate broker ID in replica set"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s', got '%v'", expected, err)
+	}
+}
+
+func TestDrainBrokers(t *testing.T) {
+	bm := BrokerMap{}
+	for i, l := range []string{"a", "b", "c", "d", "e"} {
+		id := 1001 + i
+		bm[id] = &Broker{ID: id, Locality: l, StorageFree: 10000.00}
+	}
+
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1003,1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001,1004]},
+		{"topic":"test_topic","partition":3,"replicas":[1005,1001]},
+		{"topic":"test_topic","partition":4,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":5,"replicas":[1001,1003]}]}`)
+
+	pmm := NewPartitionMetaMap()
+	pmm["test_topic"] = map[int]*PartitionMeta{
+		0: {Size: 1000.00},
+		1: {Size: 1500.00},
+		2: {Size: 2000.00},
+		3: {Size: 2500.00},
+		4: {Size: 2200.00},
+		5: {Size: 4000.00},
+	}
+
+	out, errs := pm.DrainBrokers(bm, pmm, []int{1001})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	// The input BrokerMap is unmodified.
+	if bm[1002].StorageFree != 10000.00 {
+		t.Errorf("Expected BrokerMap to be unmodified")
+	}
+
+	// Tally the storage received per broker.
+	received := map[int]float64{}
+	for i, p := range out.Partitions {
+		for j, id := range p.Replicas {
+			if id == 1001 {
+				t.Errorf("Expected 1001 to be drained from p%d", p.Partition)
+			}
+			if pm.Partitions[i].Replicas[j] == 1001 {
+				s, _ := pmm.Size(p)
+				received[id] += s
+			}
+		}
+
+		if len(p.Replicas) != 2 || p.Replicas[0] == p.Replicas[1] {
+			t.Errorf("Unexpected replica set for p%d: %v", p.Partition, p.Replicas)
+		}
+	}
+
+	// The 13200 drained is spread across the four receivers,
+	// each partition going to the broker with the most storage
+	// free that meets rack constraints.
+	expected := map[int]float64{1002: 4000, 1003: 2500, 1004: 3200, 1005: 3500}
+
+	for id, r := range expected {
+		if received[id] != r {
+			t.Errorf("Expected broker %d to receive %.0f, got %.0f", id, r, received[id])
+		}
+	}
+
+	// The only remaining broker is already in the
+	// replica set, failing rack constraints.
+	bm = BrokerMap{
+		1001: &Broker{ID: 1001, Locality: "a", StorageFree: 10000.00},
+		1002: &Broker{ID: 1002, Locality: "b", StorageFree: 10000.00},
+	}
+
+	pm, _ = PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":4,"replicas":[1001,1002]}]}`)
+
+	out, errs = pm.DrainBrokers(bm, pmm, []int{1001})
+	if len(errs) != 1 {
+		t.Fatalf("Expected a constraints error, got %v", errs)
+	}
+
+	if out.Partitions[0].Replicas[0] != 1001 {
+		t.Errorf("Expected the replica to remain on 1001, got %v", out.Partitions[0].Replicas)
+	}
+}
+
 func TestEqual(t *testing.T) {
 	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
 	pm2, _ := PartitionMapFromString(testGetMapString("test_topic"))
@@ -218,6 +420,34 @@ func TestPartitionMapTopics(t *testing.T) {
 	}
 }
 
+func TestPartitionMapTrim(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString5("test_topic"))
+
+	// test_topic2 no longer exists.
+	existing := map[string]struct{}{"test_topic1": {}}
+
+	removed, trimmed := pm.Trim(existing)
+
+	if len(removed) != 1 || removed[0] != "test_topic2" {
+		t.Errorf("Expected removed topics [test_topic2], got %v", removed)
+	}
+
+	if ts := trimmed.Topics(); len(ts) != 1 || ts[0] != "test_topic1" {
+		t.Errorf("Expected topics [test_topic1], got %v", ts)
+	}
+
+	for _, p := range trimmed.Partitions {
+		if p.Topic != "test_topic1" {
+			t.Errorf("Unexpected topic %s in trimmed map", p.Topic)
+		}
+	}
+
+	// The original map is unmodified.
+	if len(pm.Topics()) != 2 {
+		t.Error("Unexpected modification of the original map")
+	}
+}
+
 func TestPartitionMapReplicaSets(t *testing.T) {
 	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
 	rs := pm.ReplicaSets("test_topic")
@@ -270,6 +500,66 @@ func TestPartitionMapFromString(t *testing.T) {
 	}
 }
 
+func TestPartitionMapFromStringVersion(t *testing.T) {
+	_, err := PartitionMapFromString(`{"version":2,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]}]}`)
+
+	if err == nil || err.Error() != "Unsupported partition map version 2, expected version 1" {
+		t.Errorf("Expected version error, got %v", err)
+	}
+
+	// An unspecified version defaults to 1.
+	pm, err := PartitionMapFromString(`{"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pm.Version != 1 {
+		t.Errorf("Expected version 1, got %d", pm.Version)
+	}
+}
+
+func TestPartitionMapFromStringLogDirs(t *testing.T) {
+	pm, err := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002],"log_dirs":["/data1","any"]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1001]}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := pm.Partitions[0].LogDirs; len(d) != 2 || d[0] != "/data1" || d[1] != "any" {
+		t.Errorf("Unexpected log dirs: %v", d)
+	}
+
+	if d := pm.Partitions[1].LogDirs; d != nil {
+		t.Errorf("Unexpected log dirs: %v", d)
+	}
+
+	// Log dirs are copied and omitted from
+	// the output where unspecified.
+	cpy := pm.Copy()
+	cpy.Partitions[0].LogDirs[0] = "/data2"
+
+	if pm.Partitions[0].LogDirs[0] != "/data1" {
+		t.Error("The copy shares memory with the original")
+	}
+
+	out, _ := json.Marshal(pm)
+	expected := `{"version":1,"partitions":[{"topic":"test_topic","partition":0,"replicas":[1001,1002],"log_dirs":["/data1","any"]},{"topic":"test_topic","partition":1,"replicas":[1002,1001]}]}`
+	if string(out) != expected {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+
+	// Log dirs must correspond to the replicas.
+	_, err = PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002],"log_dirs":["any"]}]}`)
+
+	if err == nil || err.Error() != "test_topic p0: 1 log_dirs specified for 2 replicas" {
+		t.Errorf("Expected log dirs error, got %v", err)
+	}
+}
+
 func TestPartitionMapFromZK(t *testing.T) {
 	zk := NewZooKeeperStub()
 
@@ -307,6 +597,109 @@ func TestPartitionMapFromZK(t *testing.T) {
 
 }
 
+// reversedTopicsStub returns GetTopics
+// results in reverse name order.
+type reversedTopicsStub struct {
+	*Stub
+}
+
+func (zk reversedTopicsStub) GetTopics(ts []*regexp.Regexp) ([]string, error) {
+	topics, err := zk.Stub.GetTopics(ts)
+	sort.Sort(sort.Reverse(sort.StringSlice(topics)))
+	return topics, err
+}
+
+// duplicateTopicsStub returns GetTopics results
+// for each regexp without deduplication.
+type duplicateTopicsStub struct {
+	*Stub
+}
+
+func (zk duplicateTopicsStub) GetTopics(ts []*regexp.Regexp) ([]string, error) {
+	var topics []string
+	for _, r := range ts {
+		t, err := zk.Stub.GetTopics([]*regexp.Regexp{r})
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, t...)
+	}
+	return topics, nil
+}
+
+func TestPartitionMapFromZKOverlapping(t *testing.T) {
+	// Both regexps match test_topic2.
+	r := []*regexp.Regexp{regexp.MustCompile("test"), regexp.MustCompile("topic2")}
+
+	pm, err := PartitionMapFromZK(r, duplicateTopicsStub{NewZooKeeperStub()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := PartitionMapFromZK(r[:1], NewZooKeeperStub())
+
+	if same, err := pm.Equal(expected); !same {
+		t.Errorf("Unexpected inequality: %s", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	pm2, _ := PartitionMapFromString(testGetMapString("test_topic2"))
+
+	// Overlapping, identical partitions are skipped.
+	other := pm.Copy()
+	other.Partitions = append(other.Partitions, pm2.Partitions...)
+
+	if err := pm.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pm.Partitions) != 8 {
+		t.Errorf("Expected 8 partitions, got %d", len(pm.Partitions))
+	}
+
+	for i, p := range pm2.Partitions {
+		if !pm.Partitions[4+i].Equal(p) {
+			t.Errorf("Expected %v at index %d, got %v", p, 4+i, pm.Partitions[4+i])
+		}
+	}
+
+	// Conflicting replica sets error
+	// and leave the map unmodified.
+	conflict, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic3","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1001,1002]}]}`)
+
+	err := pm.Merge(conflict)
+	if err == nil || err.Error() != "test_topic p1: conflicting replica sets [1002 1001] and [1001 1002]" {
+		t.Errorf("Expected conflict error, got %v", err)
+	}
+
+	if len(pm.Partitions) != 8 {
+		t.Errorf("Expected 8 partitions, got %d", len(pm.Partitions))
+	}
+}
+
+func TestPartitionMapFromZKOrder(t *testing.T) {
+	r := []*regexp.Regexp{regexp.MustCompile("test")}
+
+	pm, _ := PartitionMapFromZK(r, NewZooKeeperStub())
+	pm2, _ := PartitionMapFromZK(r, reversedTopicsStub{NewZooKeeperStub()})
+
+	if len(pm.Partitions) != len(pm2.Partitions) {
+		t.Fatalf("Expected %d partitions, got %d", len(pm.Partitions), len(pm2.Partitions))
+	}
+
+	// The merged output should be identical,
+	// including the partition ordering.
+	for i := range pm.Partitions {
+		if !pm.Partitions[i].Equal(pm2.Partitions[i]) {
+			t.Errorf("Expected %v at index %d, got %v", pm.Partitions[i], i, pm2.Partitions[i])
+		}
+	}
+}
+
 func TestSetReplication(t *testing.T) {
 	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
 
@@ -355,6 +748,110 @@ func TestSetReplication(t *testing.T) {
 	}
 }
 
+func TestSetReplicationWithLeaderPreservation(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1002]}]}`)
+
+	// RF 1 -> 3 retains the existing replicas in place.
+	pm.SetReplicationWithLeaderPreservation(3)
+
+	expected, _ := PartitionMapFromString(fmt.Sprintf(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,%d,%d]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,%d,%d]}]}`,
+		StubBrokerID, StubBrokerID, StubBrokerID, StubBrokerID))
+
+	if same, err := pm.Equal(expected); !same {
+		t.Errorf("Unexpected inequality after growing: %s", err)
+	}
+
+	// RF 3 -> 1 retains the leader, including where
+	// the leader position is held by a stub broker.
+	pm, _ = PartitionMapFromString(fmt.Sprintf(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1002,1001,1003]},
+		{"topic":"test_topic","partition":1,"replicas":[%d,1003,1001]},
+		{"topic":"test_topic","partition":2,"replicas":[%d,%d,%d]}]}`,
+		StubBrokerID, StubBrokerID, StubBrokerID, StubBrokerID))
+
+	pm.SetReplicationWithLeaderPreservation(1)
+
+	expected, _ = PartitionMapFromString(fmt.Sprintf(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1003]},
+		{"topic":"test_topic","partition":2,"replicas":[%d]}]}`, StubBrokerID))
+
+	if same, err := pm.Equal(expected); !same {
+		t.Errorf("Unexpected inequality after shrinking: %s", err)
+	}
+
+	// Stub brokers are dropped ahead of other replicas.
+	pm, _ = PartitionMapFromString(fmt.Sprintf(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,%d,1002]}]}`, StubBrokerID))
+
+	pm.SetReplicationWithLeaderPreservation(2)
+
+	if r := pm.Partitions[0].Replicas; len(r) != 2 || r[0] != 1001 || r[1] != 1002 {
+		t.Errorf("Expected replicas [1001 1002], got %v", r)
+	}
+
+	// 0 is a no-op.
+	pm.SetReplicationWithLeaderPreservation(0)
+
+	if r := pm.Partitions[0].Replicas; len(r) != 2 {
+		t.Errorf("Expected 2 replicas, got %v", r)
+	}
+}
+
+func TestSetReplicationWithLeaderPreservationLogDirs(t *testing.T) {
+	pm, _ := PartitionMapFromString(fmt.Sprintf(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[%d,1002,1003],"log_dirs":["any","/d1002","/d1003"]}]}`,
+		StubBrokerID))
+
+	pm.SetReplicationWithLeaderPreservation(2)
+
+	p := pm.Partitions[0]
+	if len(p.Replicas) != 2 || p.Replicas[0] != 1002 || p.Replicas[1] != 1003 {
+		t.Fatalf("Expected replicas [1002 1003], got %v", p.Replicas)
+	}
+
+	if len(p.LogDirs) != 2 || p.LogDirs[0] != "/d1002" || p.LogDirs[1] != "/d1003" {
+		t.Errorf("Expected log dirs [/d1002 /d1003], got %v", p.LogDirs)
+	}
+}
+func TestEqualMembership(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	pm2, _ := PartitionMapFromString(testGetMapString("test_topic"))
+
+	// Reverse replica order and partition order.
+	for _, p := range pm2.Partitions {
+		for i, j := 0, len(p.Replicas)-1; i < j; i, j = i+1, j-1 {
+			p.Replicas[i], p.Replicas[j] = p.Replicas[j], p.Replicas[i]
+		}
+	}
+	pm2.Partitions[0], pm2.Partitions[3] = pm2.Partitions[3], pm2.Partitions[0]
+
+	if same, _ := pm.Equal(pm2); same {
+		t.Error("Unexpected equality")
+	}
+
+	if !pm.EqualMembership(pm2) {
+		t.Error("Unexpected membership inequality")
+	}
+
+	// Replace a replica.
+	pm2.Partitions[0].Replicas[0] = 1005
+	if pm.EqualMembership(pm2) {
+		t.Error("Unexpected membership equality")
+	}
+
+	// Remove a partition.
+	pm2, _ = PartitionMapFromString(testGetMapString("test_topic"))
+	pm2.Partitions = pm2.Partitions[1:]
+	if pm.EqualMembership(pm2) {
+		t.Error("Unexpected membership equality")
+	}
+}
+
 func TestStrip(t *testing.T) {
 	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
 
@@ -643,6 +1140,105 @@ func TestRebuildByStorageStorage(t *testing.T) {
 	}
 }
 
+func TestRebuildMinimalMovement(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001,1003]},
+		{"topic":"test_topic","partition":3,"replicas":[1003,1001]},
+		{"topic":"test_topic","partition":4,"replicas":[1001,1004]},
+		{"topic":"test_topic","partition":5,"replicas":[1004,1001]},
+		{"topic":"test_topic","partition":6,"replicas":[1002,1003]},
+		{"topic":"test_topic","partition":7,"replicas":[1003,1004]}]}`)
+
+	// 1001 is replaced; 1005-1008 don't hold the topic
+	// and are favored by both the count and storage
+	// selectors.
+	newBrokerMap := func() BrokerMap {
+		bm := BrokerMap{StubBrokerID: &Broker{ID: StubBrokerID, Replace: true}}
+		for i, l := range []string{"a", "b", "c", "d", "a", "b", "c", "d"} {
+			id := 1001 + i
+			bm[id] = &Broker{ID: id, Locality: l, Used: 4, StorageFree: 100}
+			if id > 1004 {
+				bm[id].Used, bm[id].StorageFree = 0, 1000
+			}
+		}
+		bm[1001].Replace = true
+		return bm
+	}
+
+	pmm := NewPartitionMetaMap()
+	pmm["test_topic"] = map[int]*PartitionMeta{}
+	for _, p := range pm.Partitions {
+		pmm["test_topic"][p.Partition] = &PartitionMeta{Size: 10}
+	}
+
+	// positionChanges returns the number of replica set
+	// positions holding a different broker after the rebuild.
+	positionChanges := func(out *PartitionMap) int {
+		var n int
+		for i, p := range out.Partitions {
+			for j, id := range p.Replicas {
+				if pm.Partitions[i].Replicas[j] != id {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	// newBrokers returns the number of brokers
+	// in the rebuilt map not in the input map.
+	newBrokers := func(out *PartitionMap) int {
+		var n int
+		for id := range out.UseStats() {
+			if id > 1004 {
+				n++
+			}
+		}
+		return n
+	}
+
+	for _, strategy := range [][2]string{{"count", "distribution"}, {"storage", "distribution"}, {"storage", "storage"}} {
+		run := func(minimal bool) *PartitionMap {
+			params := NewRebuildParams()
+			params.PMM = pmm
+			params.BM = newBrokerMap()
+			params.Strategy, params.Optimization = strategy[0], strategy[1]
+			params.MinimalMovement = minimal
+
+			out, errs := pm.Rebuild(params)
+			if errs != nil {
+				t.Fatalf("%v: Unexpected error(s): %s", strategy, errs)
+			}
+
+			return out
+		}
+
+		def, minimal := run(false), run(true)
+
+		// Only the replaced replicas change
+		// position when minimizing movement.
+		if n := positionChanges(minimal); n != 6 {
+			t.Errorf("%v: Expected 6 replica changes, got %d", strategy, n)
+		}
+
+		if positionChanges(minimal) > positionChanges(def) {
+			t.Errorf("%v: Expected at most %d replica changes, got %d",
+				strategy, positionChanges(def), positionChanges(minimal))
+		}
+
+		// Replacements are made on brokers holding the topic.
+		if n := newBrokers(minimal); n != 0 {
+			t.Errorf("%v: Expected no new brokers, got %d", strategy, n)
+		}
+
+		if newBrokers(def) == 0 {
+			t.Errorf("%v: Expected new brokers in the default rebuild", strategy)
+		}
+	}
+}
+
 func TestLocalitiesAvailable(t *testing.T) {
 	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
 	bm := newStubBrokerMap()
@@ -679,38 +1275,2620 @@ func TestOptimizeLeaderFollower(t *testing.T) {
 	}
 }
 
-func TestShuffle(t *testing.T) {
-	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+func TestOptimizeLeaderFollowerLogDirs(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002],"log_dirs":["/d1001","/d1002"]},
+		{"topic":"test_topic","partition":1,"replicas":[1001,1002],"log_dirs":["/d1001","/d1002"]},
+		{"topic":"test_topic","partition":2,"replicas":[1001,1002],"log_dirs":["/d1001","/d1002"]},
+		{"topic":"test_topic","partition":3,"replicas":[1001,1002],"log_dirs":["/d1001","/d1002"]}]}`)
+
+	pm.OptimizeLeaderFollower()
+
+	// Leadership is rebalanced and each log
+	// dir remains with its broker.
+	var reordered bool
+	for _, p := range pm.Partitions {
+		if p.Replicas[0] != 1001 {
+			reordered = true
+		}
+
+		for i, id := range p.Replicas {
+			if p.LogDirs[i] != fmt.Sprintf("/d%d", id) {
+				t.Errorf("p%d: expected log dir /d%d for replica %d, got %s", p.Partition, id, id, p.LogDirs[i])
+			}
+		}
+	}
+
+	if !reordered {
+		t.Errorf("Expected replica sets to be reordered")
+	}
+}
+
+func TestLeaderOptimization(t *testing.T) {
+	pm := NewPartitionMap()
+	pmm := NewPartitionMetaMap()
+	pmm["test_topic"] = map[int]*PartitionMeta{}
+
+	// One large partition and three small ones.
+	sizes := []float64{300, 100, 100, 100}
+	for i, s := range sizes {
+		pm.Partitions = append(pm.Partitions, Partition{Topic: "test_topic", Partition: i, Replicas: []int{1001, 1002}})
+		pmm["test_topic"][i] = &PartitionMeta{Size: s}
+	}
+
+	byCount := pm.Copy()
+	byCount.OptimizeLeaderFollower()
+
+	if err := pm.LeaderOptimization(pmm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Balancing by count splits leadership two and two, whereas balancing by
+	// size offsets the large partition with all three small ones.
+	countLeaders := map[int]int{}
+	for _, p := range byCount.Partitions {
+		countLeaders[p.Replicas[0]]++
+	}
+
+	if countLeaders[1001] != 2 || countLeaders[1002] != 2 {
+		t.Errorf("Expected leader counts of 2 per broker, got %v", countLeaders)
+	}
+
+	expected := [][]int{
+		{1001, 1002},
+		{1002, 1001},
+		{1002, 1001},
+		{1002, 1001},
+	}
+
+	for i, p := range pm.Partitions {
+		if !intsEqual(p.Replicas, expected[i]) {
+			t.Errorf("p%d: expected replicas %v, got %v", i, expected[i], p.Replicas)
+		}
+	}
+
+	// A missing partition size errors without modifying the map.
+	delete(pmm["test_topic"], 3)
+	before := pm.Copy()
+
+	pm.Partitions[0].Replicas = []int{1002, 1001}
+	before.Partitions[0].Replicas = []int{1002, 1001}
+
+	if err := pm.LeaderOptimization(pmm); err == nil {
+		t.Error("Expected error for missing partition size")
+	}
+
+	if equal, _ := pm.Equal(before); !equal {
+		t.Error("Expected unmodified map on error")
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	pm := NewPartitionMap()
+	for i := 0; i < 20; i++ {
+		p := Partition{Topic: "test_topic", Partition: i, Replicas: []int{1001, 1002, 1003}}
+		pm.Partitions = append(pm.Partitions, p)
+	}
+
+	pm1, pm2, pm3 := pm.Copy(), pm.Copy(), pm.Copy()
+	pm1.Shuffle(1)
+	pm2.Shuffle(1)
+	pm3.Shuffle(2)
+
+	// The same seed yields the same replica orderings.
+	if same, err := pm1.Equal(pm2); !same {
+		t.Errorf("Expected identical shuffles with the same seed: %s", err)
+	}
+
+	// Different seeds diverge.
+	if same, _ := pm1.Equal(pm3); same {
+		t.Error("Expected different shuffles with different seeds")
+	}
+
+	// Replica set membership is unchanged.
+	for _, p := range pm1.Partitions {
+		sorted := append([]int{}, p.Replicas...)
+		sort.Ints(sorted)
+		if sorted[0] != 1001 || sorted[1] != 1002 || sorted[2] != 1003 {
+			t.Errorf("Unexpected replicas for test_topic p%d: %v", p.Partition, p.Replicas)
+		}
+	}
+
+	// A seed of 0 performs the original shuffle.
+	legacy, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	legacy.Shuffle(0)
+
+	expected, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":2,"replicas":[1004,1003,1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1002,1004,1003]}]}`)
+
+	if same, err := legacy.Equal(expected); !same {
+		t.Errorf("Unexpected legacy shuffle results: %s", err)
+	}
+
+	// Only partitions passing the filter are shuffled.
+	pm4 := pm.Copy()
+	pm4.shuffle(1, func(p Partition) bool { return p.Partition%2 == 0 })
+
+	for _, p := range pm4.Partitions {
+		if p.Partition%2 == 1 && (p.Replicas[0] != 1001 || p.Replicas[1] != 1002) {
+			t.Errorf("Unexpected shuffle of test_topic p%d: %v", p.Partition, p.Replicas)
+		}
+	}
+}
+
+func TestRebalanceTopicLeaders(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+    {"topic":"test_topic","partition":0,"replicas":[1001,1002,1003]},
+    {"topic":"test_topic","partition":1,"replicas":[1001,1003,1002]},
+    {"topic":"test_topic","partition":2,"replicas":[1001,1002,1003]},
+    {"topic":"test_topic2","partition":0,"replicas":[1001,1002]},
+    {"topic":"test_topic2","partition":1,"replicas":[1001,1003]}]}`)
+
+	out := pm.RebalanceTopicLeaders("test_topic")
+
+	expected, _ := PartitionMapFromString(`{"version":1,"partitions":[
+    {"topic":"test_topic","partition":0,"replicas":[1001,1002,1003]},
+    {"topic":"test_topic","partition":1,"replicas":[1003,1001,1002]},
+    {"topic":"test_topic","partition":2,"replicas":[1002,1001,1003]},
+    {"topic":"test_topic2","partition":0,"replicas":[1001,1002]},
+    {"topic":"test_topic2","partition":1,"replicas":[1001,1003]}]}`)
+
+	if same, err := out.Equal(expected); !same {
+		t.Errorf("Unexpected inequality: %s", err)
+	}
+
+	// The input map should be unmodified.
+	if pm.Partitions[1].Replicas[0] != 1001 {
+		t.Errorf("Unexpected modification of input map")
+	}
+}
+
+func TestSplitByDestinationLocality(t *testing.T) {
+	bm := newStubBrokerMap2()
+
+	before, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1003]},
+		{"topic":"test_topic","partition":2,"replicas":[1003,1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1004,1005]}]}`)
+
+	after, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1004,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1006]},
+		{"topic":"test_topic","partition":2,"replicas":[1005,1007]},
+		{"topic":"test_topic","partition":3,"replicas":[1004,1005]}]}`)
+
+	split := after.SplitByDestinationLocality(before, bm)
+
+	expected := map[string]map[int][]int{
+		"a": {0: {1004, 1002}, 2: {1003, 1007}},
+		"b": {2: {1005, 1007}},
+		"c": {1: {1002, 1006}},
+	}
+
+	if len(split) != len(expected) {
+		t.Fatalf("Expected %d localities, got %d", len(expected), len(split))
+	}
+
+	for l, partns := range expected {
+		pm, exists := split[l]
+		if !exists {
+			t.Fatalf("Expected locality %s in output", l)
+		}
+
+		if len(pm.Partitions) != len(partns) {
+			t.Errorf("Expected %d partitions for locality %s, got %d", len(partns), l, len(pm.Partitions))
+		}
+
+		for _, p := range pm.Partitions {
+			if !intsEqual(p.Replicas, partns[p.Partition]) {
+				t.Errorf("Expected replicas %v for locality %s partition %d, got %v",
+					partns[p.Partition], l, p.Partition, p.Replicas)
+			}
+		}
+	}
+}
+
+func TestRebuildForbidLeaderTags(t *testing.T) {
+	zk := NewZooKeeperStub()
+	bm, _ := zk.GetAllBrokerMeta(false)
+	pm, _ := PartitionMapFromString(testGetMapString2("test_topic"))
+	brokers := BrokerMapFromPartitionMap(pm, bm, true)
+
+	// Tag 1003 as running a version that shouldn't lead.
+	brokers[1003].Tags = map[string]string{"version": "2.0.0"}
+
+	rebuildParams := RebuildParams{
+		PMM:              NewPartitionMetaMap(),
+		BM:               brokers,
+		Strategy:         "count",
+		Optimization:     "distribution",
+		ForbidLeaderTags: map[string]string{"version": "2.0.0"},
+	}
+
+	out, errs := pm.Strip().Rebuild(rebuildParams)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	var follower bool
+	for _, p := range out.Partitions {
+		if p.Replicas[0] == 1003 {
+			t.Errorf("Unexpected leader 1003 for p%d", p.Partition)
+		}
+		for _, id := range p.Replicas[1:] {
+			if id == 1003 {
+				follower = true
+			}
+		}
+	}
+
+	if !follower {
+		t.Error("Expected broker 1003 to hold follower replicas")
+	}
+
+	// An existing leader with a forbidden tag is demoted.
+	pm, _ = PartitionMapFromString(testGetMapString("test_topic"))
+	rebuildParams.BM = BrokerMapFromPartitionMap(pm, bm, false)
+	rebuildParams.BM[1003].Tags = map[string]string{"version": "2.0.0"}
+
+	out, errs = pm.Rebuild(rebuildParams)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	expected, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	expected.Partitions[2].Replicas = []int{1004, 1003, 1001}
+
+	if same, err := out.Equal(expected); !same {
+		t.Errorf("Unexpected inequality after leader demotion: %s", err)
+	}
+}
+
+func TestRebuildLeaderPools(t *testing.T) {
+	zk := NewZooKeeperStub()
+	bm, _ := zk.GetAllBrokerMeta(false)
+
+	pm, _ := PartitionMapFromString(testGetMapString2("test_topic"))
+	pm2, _ := PartitionMapFromString(testGetMapString2("other_topic"))
+	pm.Partitions = append(pm.Partitions, pm2.Partitions...)
+
+	rebuildParams := RebuildParams{
+		PMM:          NewPartitionMetaMap(),
+		BM:           BrokerMapFromPartitionMap(pm, bm, true),
+		Strategy:     "count",
+		Optimization: "distribution",
+		LeaderPools:  map[string][]int{"test_topic": {1001, 1002}},
+	}
+
+	out, errs := pm.Strip().Rebuild(rebuildParams)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	followers := map[int]bool{}
+	for _, p := range out.Partitions {
+		if p.Topic != "test_topic" {
+			continue
+		}
+
+		if l := p.Replicas[0]; l != 1001 && l != 1002 {
+			t.Errorf("Expected test_topic p%d leader in pool [1001 1002], got %d", p.Partition, l)
+		}
+
+		for _, id := range p.Replicas[1:] {
+			followers[id] = true
+		}
+	}
+
+	// Followers are placed normally.
+	if !followers[1003] || !followers[1004] {
+		t.Errorf("Expected followers on brokers outside of the pool, got %v", followers)
+	}
+
+	// A pool that can't satisfy leader placements is an error.
+	rebuildParams.BM = BrokerMapFromPartitionMap(pm, bm, true)
+	rebuildParams.LeaderPools = map[string][]int{"test_topic": {1010}}
+
+	_, errs = pm.Strip().Rebuild(rebuildParams)
+	if len(errs) == 0 {
+		t.Error("Expected leader pool errors")
+	}
+
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "test_topic") || !strings.Contains(err.Error(), "leader pool") {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	}
+}
+
+func TestNextStep(t *testing.T) {
+	current, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	target, _ := PartitionMapFromString(testGetMapString4("test_topic"))
+
+	var steps int
+	for reached := false; !reached; steps++ {
+		var next *PartitionMap
+		next, reached = NextStep(current, target, 2)
+
+		// Each step changes at most 2 partitions.
+		var changed int
+		for _, p := range next.Partitions {
+			var found bool
+			for _, c := range current.Partitions {
+				if c.Topic == p.Topic && c.Partition == p.Partition {
+					found = true
+					if !c.Equal(p) {
+						changed++
+					}
+				}
+			}
+			if !found {
+				changed++
+			}
+		}
+
+		if changed > 2 {
+			t.Fatalf("Expected at most 2 changes in step %d, got %d", steps, changed)
+		}
+
+		current = next
+
+		if steps > 10 {
+			t.Fatal("NextStep failed to converge")
+		}
+	}
+
+	// testGetMapString4 has 6 partitions, all differing from or
+	// missing in testGetMapString; this requires 3 steps.
+	if steps != 3 {
+		t.Errorf("Expected 3 steps, got %d", steps)
+	}
+
+	if same, err := current.Equal(target); !same {
+		t.Errorf("Unexpected inequality after convergence: %s", err)
+	}
+
+	// A reached target is a no-op.
+	next, reached := NextStep(target, target, 2)
+	if same, _ := next.Equal(target); !same || !reached {
+		t.Error("Expected no-op for a reached target")
+	}
+}
+
+func TestNextStepDropsPartitions(t *testing.T) {
+	current, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1003]},
+		{"topic":"test_topic","partition":2,"replicas":[1003,1004]}]}`)
+	target, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]}]}`)
+
+	// Partitions only in the current map
+	// count against the change budget.
+	next, reached := NextStep(current, target, 1)
+	if reached || len(next.Partitions) != 2 {
+		t.Fatalf("Expected 1 partition dropped, got %v (reached: %v)", next.Partitions, reached)
+	}
+
+	next, reached = NextStep(next, target, 1)
+	if !reached {
+		t.Error("Expected the target to be reached")
+	}
+
+	if same, err := next.Equal(target); !same {
+		t.Errorf("Unexpected inequality after convergence: %s", err)
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	bm := newStubBrokerMap()
+
+	// Brokers 1003 and 1004 may not share a replica set.
+	policy := func(p Partition, bm BrokerMap) error {
+		var has1003, has1004 bool
+		for _, id := range p.Replicas {
+			has1003 = has1003 || id == 1003
+			has1004 = has1004 || id == 1004
+		}
+
+		if has1003 && has1004 {
+			return errors.New("brokers 1003 and 1004 co-located")
+		}
+
+		return nil
+	}
+
+	errs := pm.CheckPolicy(bm, policy)
+
+	expected := []string{
+		"test_topic p2: brokers 1003 and 1004 co-located",
+		"test_topic p3: brokers 1003 and 1004 co-located",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d violations, got %d", len(expected), len(errs))
+	}
+
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("Expected violation '%s', got '%s'", expected[i], err)
+		}
+	}
+}
+
+func TestPreserveOrder(t *testing.T) {
+	s := `{"version":1,"partitions":[
+		{"topic":"test_topic","partition":2,"replicas":[1003,1004]},
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1001]}]}`
+
+	expected := []int{2, 0, 1}
+
+	checkOrder := func(pm *PartitionMap, expected []int) {
+		for i, p := range pm.Partitions {
+			if p.Partition != expected[i] {
+				t.Errorf("Expected partition %d at position %d, got %d", expected[i], i, p.Partition)
+			}
+		}
+	}
+
+	// The default is sorted.
+	pm, _ := PartitionMapFromString(s)
+	checkOrder(pm, []int{0, 1, 2})
+
+	pm, _ = PartitionMapFromString(s, PreserveOrder())
+	checkOrder(pm, expected)
+
+	zk := NewZooKeeperStub()
+	bm, _ := zk.GetAllBrokerMeta(false)
+
+	rebuildParams := RebuildParams{
+		PMM:           NewPartitionMetaMap(),
+		BM:            BrokerMapFromPartitionMap(pm, bm, false),
+		Strategy:      "count",
+		Optimization:  "distribution",
+		PreserveOrder: true,
+	}
+
+	// Replace 1004.
+	rebuildParams.BM[1004].Replace = true
+
+	out, errs := pm.Rebuild(rebuildParams)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	checkOrder(out, expected)
+	checkOrder(pm, expected)
+
+	if out.Partitions[0].Replicas[1] == 1004 {
+		t.Error("Expected broker 1004 to be replaced")
+	}
+}
+
+func TestRebuildLocalityViolations(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	bm := newStubBrokerMap2()
+
+	// Prior to relabeling, 1004 is in rack d; no violations.
+	bm[1004].Locality = "d"
+	if v := pm.LocalityViolations(bm, 0); len(v) != 0 {
+		t.Fatalf("Expected no violations, got %v", v)
+	}
+
+	// Relabel 1004 to rack a; p2 ([1003 c, 1004 a, 1001 a]) is now in violation.
+	bm[1004].Locality = "a"
+
+	v := pm.LocalityViolations(bm, 0)
+	if len(v) != 1 || v[0].Partition != 2 {
+		t.Fatalf("Expected violation for p2, got %v", v)
+	}
+
+	params := RebuildParams{
+		BM:       bm,
+		Strategy: "count",
+	}
+
+	out, errs := pm.RebuildLocalityViolations(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	// Only the 1001 replica in p2 should have been replaced.
+	for i, p := range out.Partitions {
+		if i == 2 {
+			continue
+		}
+		if !p.Equal(pm.Partitions[i]) {
+			t.Errorf("Unexpected change to p%d: %v", p.Partition, p.Replicas)
+		}
+	}
+
+	p2 := out.Partitions[2].Replicas
+	if p2[0] != 1003 || p2[1] != 1004 || p2[2] == 1001 {
+		t.Errorf("Unexpected replica set for p2: %v", p2)
+	}
+
+	if bm[p2[2]].Locality != "b" {
+		t.Errorf("Expected replacement in rack b, got %s", bm[p2[2]].Locality)
+	}
+
+	if v := out.LocalityViolations(bm, 0); len(v) != 0 {
+		t.Errorf("Expected no violations after rebuild, got %v", v)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	live, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	desired := live.Copy()
+
+	// No-op.
+	changes, changed := Reconcile(live, desired)
+	if changed || len(changes.Partitions) != 0 {
+		t.Errorf("Expected no-op, got changes %v", changes.Partitions)
+	}
+
+	// Change p1 and p3.
+	desired.Partitions[1].Replicas = []int{1003, 1001}
+	desired.Partitions[3].Replicas = []int{1004, 1001, 1002}
+
+	changes, changed = Reconcile(live, desired)
+	if !changed {
+		t.Fatal("Expected changes")
+	}
+
+	expected := NewPartitionMap()
+	expected.Partitions = PartitionList{desired.Partitions[1], desired.Partitions[3]}
+
+	if same, err := changes.Equal(expected); !same {
+		t.Errorf("Unexpected reconcile output: %s", err)
+	}
+
+	// Applying the changes converges on desired.
+	applied := live.Copy()
+	for _, c := range changes.Partitions {
+		for i, p := range applied.Partitions {
+			if p.Topic == c.Topic && p.Partition == c.Partition {
+				applied.Partitions[i] = c
+			}
+		}
+	}
+
+	if same, _ := applied.Equal(desired); !same {
+		t.Error("Expected the reconciled map to transform live to desired")
+	}
+}
+
+func TestRebuildRackPinnedTopics(t *testing.T) {
+	pm := NewPartitionMap(Populate("pinned", 4, 3))
+	bm := newStubBrokerMap2()
+
+	rebuildParams := RebuildParams{
+		PMM:              NewPartitionMetaMap(),
+		BM:               bm,
+		Strategy:         "count",
+		Optimization:     "distribution",
+		RackPinnedTopics: map[string]struct{}{"pinned": {}},
+	}
+
+	out, errs := pm.Rebuild(rebuildParams)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	// Rack a is the only locality with 3 brokers.
+	for _, p := range out.Partitions {
+		if len(p.Replicas) != 3 {
+			t.Fatalf("Expected 3 replicas for p%d, got %v", p.Partition, p.Replicas)
+		}
+
+		seen := map[int]bool{}
+		for _, id := range p.Replicas {
+			if bm[id].Locality != "a" {
+				t.Errorf("Expected p%d replicas in rack a, got %v", p.Partition, p.Replicas)
+			}
+			if seen[id] {
+				t.Errorf("Duplicate broker in p%d: %v", p.Partition, p.Replicas)
+			}
+			seen[id] = true
+		}
+	}
+
+	// No single rack has 4 brokers.
+	pm = NewPartitionMap(Populate("pinned", 1, 4))
+	rebuildParams.BM = newStubBrokerMap2()
+
+	if _, errs = pm.Rebuild(rebuildParams); len(errs) == 0 {
+		t.Error("Expected rack-pinned placement errors")
+	}
+}
+
+func TestLeaderlessOnLoss(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1004]},
+		{"topic":"test_topic","partition":1,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":2,"replicas":[1004,1003]},
+		{"topic":"test_topic","partition":3,"replicas":[1002,1001,1004]}]}`)
+
+	bm := newStubBrokerMap()
+
+	// 1001 and 1004 share rack a; p0 has its leader
+	// and only follower on the failing brokers.
+	l := pm.LeaderlessOnLoss([]int{1001, 1004}, bm)
+
+	if len(l) != 1 || l[0].Partition != 0 {
+		t.Errorf("Expected p0 to be leaderless, got %v", l)
+	}
+
+	// A missing broker isn't able to assume leadership.
+	bm[1003].Missing = true
+	l = pm.LeaderlessOnLoss([]int{1001, 1004}, bm)
+
+	if len(l) != 2 || l[0].Partition != 0 || l[1].Partition != 2 {
+		t.Errorf("Expected p0 and p2 to be leaderless, got %v", l)
+	}
+}
+
+func TestRebuildMinimizeCrossRack(t *testing.T) {
+	crossRack := func(pm *PartitionMap, bm BrokerMap) int {
+		var n int
+		for _, p := range pm.Partitions {
+			for _, id := range p.Replicas[1:] {
+				if bm[id].Locality != bm[p.Replicas[0]].Locality {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	rebuild := func(minimize bool) (*PartitionMap, BrokerMap) {
+		pm := NewPartitionMap(Populate("test_topic", 6, 3))
+		bm := newStubBrokerMap2()
+
+		params := RebuildParams{
+			PMM:               NewPartitionMetaMap(),
+			BM:                bm,
+			Strategy:          "count",
+			Optimization:      "distribution",
+			MinUniqueRackIDs:  2,
+			MinimizeCrossRack: minimize,
+		}
+
+		out, errs := pm.Rebuild(params)
+		if errs != nil {
+			t.Fatalf("Unexpected error(s): %s", errs)
+		}
+
+		return out, bm
+	}
+
+	defaultOut, bm := rebuild(false)
+	defaultCrossRack := crossRack(defaultOut, bm)
+
+	minimizedOut, bm := rebuild(true)
+	minimizedCrossRack := crossRack(minimizedOut, bm)
+
+	if minimizedCrossRack >= defaultCrossRack {
+		t.Errorf("Expected fewer than %d cross-rack replicas, got %d", defaultCrossRack, minimizedCrossRack)
+	}
+
+	// With a RF of 3 and MinUniqueRackIDs of 2, each
+	// partition has exactly one cross-rack replica.
+	if minimizedCrossRack != len(minimizedOut.Partitions) {
+		t.Errorf("Expected %d cross-rack replicas, got %d", len(minimizedOut.Partitions), minimizedCrossRack)
+	}
+
+	// The minimum spread is still met.
+	if v := minimizedOut.LocalityViolations(bm, 2); len(v) != 0 {
+		t.Errorf("Unexpected locality violations: %v", v)
+	}
+
+	for _, p := range minimizedOut.Partitions {
+		localities := map[string]bool{}
+		for _, id := range p.Replicas {
+			localities[bm[id].Locality] = true
+		}
+		if len(localities) < 2 {
+			t.Errorf("Expected at least 2 localities for p%d, got %v", p.Partition, p.Replicas)
+		}
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) { return 0, errors.New("write error") }
+
+func TestWriteMapTo(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+
+	buf := &bytes.Buffer{}
+	if err := WriteMapTo(pm, buf, true); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "kafkazk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test_topic")
+	if err := WriteMap(pm, path); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.ReadFile(path + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), f) {
+		t.Errorf("Expected identical output, got '%s' and '%s'", buf.Bytes(), f)
+	}
+
+	// Writer errors are returned.
+	if err := WriteMapTo(pm, errWriter{}, true); err == nil || err.Error() != "write error" {
+		t.Errorf("Expected write error, got %v", err)
+	}
+}
+
+func TestWriteMapToCompact(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+
+	for _, compact := range []bool{true, false} {
+		buf := &bytes.Buffer{}
+		if err := WriteMapTo(pm, buf, compact); err != nil {
+			t.Fatal(err)
+		}
+
+		out := buf.String()
+
+		if !strings.HasSuffix(out, "\n") {
+			t.Errorf("[compact=%v] Expected a trailing newline", compact)
+		}
+
+		lines := strings.Count(out, "\n")
+		switch {
+		case compact && lines != 1:
+			t.Errorf("Expected single line output, got %d lines", lines)
+		case !compact && !strings.Contains(out, "\n  \"partitions\": ["):
+			t.Errorf("Expected indented output, got '%s'", out)
+		}
+
+		pm2, err := PartitionMapFromString(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if same, err := pm.Equal(pm2); !same {
+			t.Errorf("[compact=%v] Unexpected output: %s", compact, err)
+		}
+	}
+}
+
+func TestWriteMapBatched(t *testing.T) {
+	pm := NewPartitionMap()
+	for _, topic := range []struct {
+		name  string
+		count int
+	}{{"a", 3}, {"b", 2}, {"c", 5}, {"d", 1}} {
+		for i := 0; i < topic.count; i++ {
+			pm.Partitions = append(pm.Partitions, Partition{Topic: topic.name, Partition: i, Replicas: []int{1001, 1002}})
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "kafkazk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "batch")
+	if err := WriteMapBatched(pm, path, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// Topic b doesn't fit alongside a and starts a new batch; topic c is
+	// larger than the batch size and is split.
+	expectedCounts := []int{3, 4, 4}
+
+	files, _ := filepath.Glob(path + ".*.json")
+	if len(files) != len(expectedCounts) {
+		t.Fatalf("Expected %d files, got %d", len(expectedCounts), len(files))
+	}
+
+	combined := NewPartitionMap()
+	for i, n := range expectedCounts {
+		f, err := ioutil.ReadFile(fmt.Sprintf("%s.%d.json", path, i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		batch, err := PartitionMapFromString(string(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(batch.Partitions) != n {
+			t.Errorf("Batch %d: expected %d partitions, got %d", i+1, n, len(batch.Partitions))
+		}
+
+		combined.Partitions = append(combined.Partitions, batch.Partitions...)
+	}
+
+	if equal, err := combined.Equal(pm); !equal {
+		t.Errorf("Expected concatenated batches to equal the original map: %s", err)
+	}
+
+	if err := WriteMapBatched(pm, path, 0); err == nil {
+		t.Error("Expected error for batch size 0")
+	}
+}
+
+func TestWriteMapLogDirs(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002,1003],"log_dirs":["/data1","/data2","/data3"]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1003,1001],"log_dirs":["/data2","any","/data1"]},
+		{"topic":"test_topic","partition":2,"replicas":[1003,1001,1002]}]}`)
+
+	bm := BrokerMap{
+		StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+		1001:         &Broker{ID: 1001, Locality: "a"},
+		1002:         &Broker{ID: 1002, Locality: "b", Replace: true},
+		1003:         &Broker{ID: 1003, Locality: "c"},
+		1004:         &Broker{ID: 1004, Locality: "b"},
+	}
+
+	// Log dirs for retained replicas are passed through;
+	// replacements are placed in any log dir.
+	expected := `{"version":1,"partitions":[` +
+		`{"topic":"test_topic","partition":0,"replicas":[1001,1004,1003],"log_dirs":["/data1","any","/data3"]},` +
+		`{"topic":"test_topic","partition":1,"replicas":[1004,1003,1001],"log_dirs":["any","any","/data1"]},` +
+		`{"topic":"test_topic","partition":2,"replicas":[1003,1001,1004]}]}` + "\n"
+
+	for _, opt := range [][2]string{{"count", ""}, {"storage", "distribution"}} {
+		for _, b := range bm {
+			b.Used, b.StorageFree = 0, 1000
+		}
+
+		params := NewRebuildParams()
+		params.BM, params.PMM = bm, NewPartitionMetaMap()
+		params.Strategy, params.Optimization = opt[0], opt[1]
+		params.PMM["test_topic"] = map[int]*PartitionMeta{}
+		for _, p := range pm.Partitions {
+			params.PMM[p.Topic][p.Partition] = &PartitionMeta{Size: 1}
+		}
+
+		out, errs := pm.Rebuild(params)
+		if errs != nil {
+			t.Fatalf("[%v] %s", opt, errs)
+		}
+
+		buf := &bytes.Buffer{}
+		if err := WriteMapTo(out, buf, true); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != expected {
+			t.Errorf("[%v] Expected %s, got %s", opt, expected, buf.String())
+		}
+
+		// Round trip.
+		parsed, err := PartitionMapFromString(buf.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, p := range parsed.Partitions {
+			if !p.Equal(out.Partitions[i]) || len(p.LogDirs) != len(out.Partitions[i].LogDirs) {
+				t.Errorf("[%v] Expected %v, got %v", opt, out.Partitions[i], p)
+			}
+		}
+	}
+
+	// Stripped log dirs are reset.
+	stripped := pm.Strip()
+	if d := stripped.Partitions[0].LogDirs; len(d) != 3 || d[0] != LogDirAny || d[2] != LogDirAny {
+		t.Errorf("Unexpected log dirs: %v", d)
+	}
+
+	if stripped.Partitions[2].LogDirs != nil {
+		t.Errorf("Unexpected log dirs: %v", stripped.Partitions[2].LogDirs)
+	}
+
+	// Log dirs are aligned with the replicas on write.
+	pm.SetReplication(4)
+
+	buf := &bytes.Buffer{}
+	if err := WriteMapTo(pm, buf, true); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := PartitionMapFromString(buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := parsed.Partitions[0].LogDirs; len(d) != 4 || d[0] != "/data1" || d[3] != LogDirAny {
+		t.Errorf("Unexpected log dirs: %v", d)
+	}
+
+	// The input map isn't modified.
+	if len(pm.Partitions[0].LogDirs) != 3 {
+		t.Errorf("Unexpected log dirs: %v", pm.Partitions[0].LogDirs)
+	}
+}
+
+func TestWriteMapSizes(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	zk := NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	dir, err := ioutil.TempDir("", "kafkazk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test_topic")
+	if err := WriteMapSizes(pm, pmm, path); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.ReadFile(path + ".sizes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes map[string]map[int]float64
+	if err := json.Unmarshal(f, &sizes); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[int]float64{0: 1000, 1: 1500, 2: 2000, 3: 2500}
+
+	if len(sizes["test_topic"]) != len(pm.Partitions) {
+		t.Fatalf("Expected %d partition sizes, got %d", len(pm.Partitions), len(sizes["test_topic"]))
+	}
+
+	for p, s := range expected {
+		if sizes["test_topic"][p] != s {
+			t.Errorf("Expected size %f for p%d, got %f", s, p, sizes["test_topic"][p])
+		}
+	}
+
+	// Partitions missing metadata are an error.
+	pm.Partitions[0].Partition = 9
+	if err := WriteMapSizes(pm, pmm, path); err == nil {
+		t.Error("Expected error for missing partition metadata")
+	}
+}
+
+func TestRebuildDataRoleTag(t *testing.T) {
+	zk := NewZooKeeperStub()
+	bm, _ := zk.GetAllBrokerMeta(false)
+	pm, _ := PartitionMapFromString(testGetMapString2("test_topic"))
+	brokers := BrokerMapFromPartitionMap(pm, bm, true)
+
+	// 1003 is a dedicated controller.
+	for id, b := range brokers {
+		if id == StubBrokerID {
+			continue
+		}
+		b.Tags = map[string]string{"role": "data"}
+	}
+	brokers[1003].Tags = map[string]string{"role": "controller"}
+
+	rebuildParams := RebuildParams{
+		PMM:          NewPartitionMetaMap(),
+		BM:           brokers,
+		Strategy:     "count",
+		Optimization: "distribution",
+		DataRoleTag:  "role=data",
+	}
+
+	out, errs := pm.Strip().Rebuild(rebuildParams)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	for _, p := range out.Partitions {
+		for _, id := range p.Replicas {
+			if id == 1003 {
+				t.Errorf("Unexpected placement on controller broker 1003 for p%d", p.Partition)
+			}
+		}
+	}
+
+	// The controller is still in the inventory.
+	if _, exists := brokers[1003]; !exists {
+		t.Error("Expected broker 1003 in the BrokerMap")
+	}
+}
+
+func TestRebuildLeaderFirstBalance(t *testing.T) {
+	rebuild := func(balance bool) (*PartitionMap, BrokerMap) {
+		pm := NewPartitionMap(Populate("test_topic", 6, 2))
+
+		pmm := NewPartitionMetaMap()
+		pmm["test_topic"] = map[int]*PartitionMeta{}
+		for i := 0; i < 6; i++ {
+			pmm["test_topic"][i] = &PartitionMeta{Size: 100}
+		}
+
+		// 1001 has far more storage free than all others.
+		bm := BrokerMap{
+			StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+			1001:         &Broker{ID: 1001, Locality: "a", StorageFree: 100000},
+			1002:         &Broker{ID: 1002, Locality: "b", StorageFree: 1000},
+			1003:         &Broker{ID: 1003, Locality: "a", StorageFree: 1000},
+			1004:         &Broker{ID: 1004, Locality: "b", StorageFree: 1000},
+		}
+
+		params := RebuildParams{
+			PMM:                pmm,
+			BM:                 bm,
+			Strategy:           "storage",
+			Optimization:       "distribution",
+			PartnSzFactor:      1.00,
+			LeaderFirstBalance: balance,
+		}
+
+		out, errs := pm.Rebuild(params)
+		if errs != nil {
+			t.Fatalf("Unexpected error(s): %s", errs)
+		}
+
+		return out, bm
+	}
+
+	// By default, 1001 wins every leadership.
+	out, _ := rebuild(false)
+	if n := out.UseStats()[1001].Leader; n != 6 {
+		t.Errorf("Expected 6 leaderships for 1001, got %d", n)
+	}
+
+	out, bm := rebuild(true)
+	stats := out.UseStats()
+
+	// Leaders are spread evenly among localities and brokers.
+	localityLeaders := map[string]int{}
+	for _, id := range []int{1001, 1002, 1003, 1004} {
+		n := stats[id].Leader
+		if n < 1 || n > 2 {
+			t.Errorf("Expected 1-2 leaderships for %d, got %d", id, n)
+		}
+		localityLeaders[bm[id].Locality] += n
+	}
+
+	for l, n := range localityLeaders {
+		if n != 3 {
+			t.Errorf("Expected 3 leaderships in locality %s, got %d", l, n)
+		}
+	}
+
+	// Followers are still placed by storage; 1001 is
+	// the follower for every partition led from rack b.
+	for _, p := range out.Partitions {
+		if bm[p.Replicas[0]].Locality == "b" && p.Replicas[1] != 1001 {
+			t.Errorf("Expected follower 1001 for p%d, got %d", p.Partition, p.Replicas[1])
+		}
+	}
+}
+
+func TestRebuildSpreadGroups(t *testing.T) {
+	spread := func(pm *PartitionMap) int {
+		brokers := map[int]struct{}{}
+		for _, p := range pm.Partitions {
+			for _, id := range p.Replicas {
+				brokers[id] = struct{}{}
+			}
+		}
+		return len(brokers)
+	}
+
+	rebuild := func(optimization string, groups map[string][]string) *PartitionMap {
+		pm := NewPartitionMap(Populate("service_a", 1, 2), Populate("service_b", 1, 2))
+
+		pmm := NewPartitionMetaMap()
+		for _, topic := range []string{"service_a", "service_b"} {
+			pmm[topic] = map[int]*PartitionMeta{0: {Size: 100}}
+		}
+
+		// 1001 and 1002 have far more storage free than all others.
+		bm := BrokerMap{
+			StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+			1001:         &Broker{ID: 1001, Locality: "a", StorageFree: 10000},
+			1002:         &Broker{ID: 1002, Locality: "b", StorageFree: 9000},
+			1003:         &Broker{ID: 1003, Locality: "c", StorageFree: 1000},
+			1004:         &Broker{ID: 1004, Locality: "d", StorageFree: 1000},
+		}
+
+		params := RebuildParams{
+			PMM:           pmm,
+			BM:            bm,
+			Strategy:      "storage",
+			Optimization:  optimization,
+			PartnSzFactor: 1.00,
+			SpreadGroups:  groups,
+		}
+
+		out, errs := pm.Rebuild(params)
+		if errs != nil {
+			t.Fatalf("Unexpected error(s): %s", errs)
+		}
+
+		return out
+	}
+
+	groups := map[string][]string{"service": {"service_a", "service_b"}}
+
+	for _, o := range []string{"distribution", "storage"} {
+		// Independently, both topics are placed on 1001 and 1002.
+		if n := spread(rebuild(o, nil)); n != 2 {
+			t.Errorf("[%s] Expected a spread of 2 brokers, got %d", o, n)
+		}
+
+		// Grouped, the topics are spread across all brokers.
+		if n := spread(rebuild(o, groups)); n != 4 {
+			t.Errorf("[%s] Expected a spread of 4 brokers, got %d", o, n)
+		}
+	}
+}
+
+func TestRebuildInstanceGroups(t *testing.T) {
+	newBrokerMap := func() BrokerMap {
+		// 1001 and 1002 share an instance group
+		// in the same rack.
+		return BrokerMap{
+			StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+			1001:         &Broker{ID: 1001, Locality: "a", InstanceGroup: "ig-1"},
+			1002:         &Broker{ID: 1002, Locality: "a", InstanceGroup: "ig-1"},
+			1003:         &Broker{ID: 1003, Locality: "a", InstanceGroup: "ig-2"},
+		}
+	}
+
+	params := RebuildParams{
+		PMM:              NewPartitionMetaMap(),
+		BM:               newBrokerMap(),
+		Strategy:         "count",
+		Optimization:     "distribution",
+		MinUniqueRackIDs: 1,
+		InstanceGroups:   true,
+	}
+
+	pm := NewPartitionMap(Populate("test_topic", 6, 2))
+
+	out, errs := pm.Rebuild(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	// Every replica set spans both instance groups.
+	for _, p := range out.Partitions {
+		if !inReplicaSet(1003, p.Replicas) {
+			t.Errorf("Expected 1003 in replica set for p%d, got %v", p.Partition, p.Replicas)
+		}
+	}
+
+	// Two instance groups can't hold three replicas.
+	params.BM = newBrokerMap()
+	pm = NewPartitionMap(Populate("test_topic", 1, 3))
+
+	if _, errs := pm.Rebuild(params); errs == nil {
+		t.Error("Expected placement errors")
+	}
+}
+
+func TestRebuildAntiAffinityTag(t *testing.T) {
+	newBrokerMap := func() BrokerMap {
+		// Each broker is in a unique rack; 1001 and 1002
+		// share a power zone, as do 1003 and 1004.
+		return BrokerMap{
+			StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+			1001:         &Broker{ID: 1001, Locality: "a", Tags: map[string]string{"power": "p1", "type": "i3"}},
+			1002:         &Broker{ID: 1002, Locality: "b", Tags: map[string]string{"power": "p1", "type": "d2"}},
+			1003:         &Broker{ID: 1003, Locality: "c", Tags: map[string]string{"power": "p2", "type": "i3"}},
+			1004:         &Broker{ID: 1004, Locality: "d", Tags: map[string]string{"power": "p2", "type": "d2"}},
+		}
+	}
+
+	params := RebuildParams{
+		PMM:          NewPartitionMetaMap(),
+		BM:           newBrokerMap(),
+		Strategy:     "count",
+		Optimization: "distribution",
+	}
+
+	pm := NewPartitionMap(Populate("test_topic", 8, 2))
+
+	// powerZones returns the number of replica sets
+	// with replicas sharing a power zone.
+	powerZones := func(out *PartitionMap, bm BrokerMap) int {
+		var shared int
+		for _, p := range out.Partitions {
+			if bm[p.Replicas[0]].Tags["power"] == bm[p.Replicas[1]].Tags["power"] {
+				shared++
+			}
+		}
+		return shared
+	}
+
+	// Rack spread alone permits shared power zones.
+	out, errs := pm.Rebuild(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	if powerZones(out, params.BM) == 0 {
+		t.Fatal("Expected replica sets sharing a power zone without an anti-affinity tag")
+	}
+
+	// With the anti-affinity tag, every replica set spans both power zones.
+	params.BM = newBrokerMap()
+	params.AntiAffinityTag = "power"
+
+	out, errs = pm.Rebuild(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	if n := powerZones(out, params.BM); n != 0 {
+		t.Errorf("Expected no replica sets sharing a power zone, got %d", n)
+	}
+
+	// Two power zones can't hold three replicas.
+	params.BM = newBrokerMap()
+	pm = NewPartitionMap(Populate("test_topic", 1, 3))
+
+	if _, errs := pm.Rebuild(params); errs == nil {
+		t.Error("Expected placement errors")
+	}
+}
+
+func TestRebuildFrozenPartitions(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001,1002]},
+		{"topic":"test_topic2","partition":0,"replicas":[1002,1001]}]}`)
+
+	bm := BrokerMap{
+		StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+		1001:         &Broker{ID: 1001, Locality: "a"},
+		1002:         &Broker{ID: 1002, Locality: "b", Replace: true},
+		1003:         &Broker{ID: 1003, Locality: "b"},
+	}
+
+	params := NewRebuildParams()
+	params.BM, params.Strategy = bm, "count"
+	params.FrozenPartitions = map[string][]int{"test_topic": {1}, "test_topic2": {0}}
+
+	out, errs := pm.Rebuild(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	// Frozen partitions keep the broker marked for
+	// replacement; all others are rebuilt.
+	expected, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1003]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1001,1003]},
+		{"topic":"test_topic2","partition":0,"replicas":[1002,1001]}]}`)
+
+	if same, err := out.Equal(expected); !same {
+		t.Errorf("Unexpected rebuild result: %s", err)
+	}
+}
+
+func TestRebuildByTopic(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"rf3","partition":0,"replicas":[1001,1002,1003]},
+		{"topic":"rf3","partition":1,"replicas":[1002,1003,1001]},
+		{"topic":"rf3","partition":2,"replicas":[1003,1001,1002]},
+		{"topic":"rf2","partition":0,"replicas":[1001,1004]},
+		{"topic":"rf2","partition":1,"replicas":[1004,1001]}]}`)
+
+	// newBrokerMap returns a BrokerMap with Used
+	// counts from the provided PartitionMap.
+	newBrokerMap := func(pm *PartitionMap) BrokerMap {
+		bm := BrokerMap{
+			StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+			1001:         &Broker{ID: 1001, Locality: "a", Replace: true},
+			1002:         &Broker{ID: 1002, Locality: "b"},
+			1003:         &Broker{ID: 1003, Locality: "c"},
+			1004:         &Broker{ID: 1004, Locality: "d"},
+			1005:         &Broker{ID: 1005, Locality: "e"},
+		}
+
+		for _, p := range pm.Partitions {
+			for _, id := range p.Replicas {
+				bm[id].Used++
+			}
+		}
+
+		return bm
+	}
+
+	bm := newBrokerMap(pm)
+
+	maps, errs := pm.RebuildByTopic(bm, nil, "count")
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected error(s): %v", errs)
+	}
+
+	if len(maps) != 2 {
+		t.Fatalf("Expected 2 topic maps, got %d", len(maps))
+	}
+
+	// Each topic is rebuilt as if it were rebuilt alone.
+	for topic, rf := range map[string]int{"rf3": 3, "rf2": 2} {
+		alone := NewPartitionMap()
+		for _, p := range pm.Copy().Partitions {
+			if p.Topic == topic {
+				alone.Partitions = append(alone.Partitions, p)
+			}
+		}
+
+		aloneParams := NewRebuildParams()
+		aloneParams.BM, aloneParams.Strategy = newBrokerMap(alone), "count"
+
+		expected, _ := alone.Rebuild(aloneParams)
+
+		if same, err := maps[topic].Equal(expected); !same {
+			t.Errorf("[%s] Expected the result of an isolated rebuild: %s", topic, err)
+		}
+
+		for _, p := range maps[topic].Partitions {
+			if p.Topic != topic {
+				t.Errorf("[%s] Unexpected topic %s in map", topic, p.Topic)
+			}
+			if len(p.Replicas) != rf || inReplicaSet(1001, p.Replicas) {
+				t.Errorf("[%s] Unexpected replicas %v for p%d", topic, p.Replicas, p.Partition)
+			}
+		}
+	}
+
+	// The input BrokerMap is unmodified.
+	if bm[1002].Used != 3 {
+		t.Errorf("Expected 1002 Used count of 3, got %d", bm[1002].Used)
+	}
+}
+
+func TestRebuildMaxLeaderBytesPerBroker(t *testing.T) {
+	zk := NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	// p0 (1000B) is led by 1001; p3 (2500B)
+	// needs a new leader.
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":3,"replicas":[1004,1002]}]}`)
+
+	newBrokerMap := func() BrokerMap {
+		return BrokerMap{
+			StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+			1001:         &Broker{ID: 1001, Locality: "a", StorageFree: 10000},
+			1002:         &Broker{ID: 1002, Locality: "b", StorageFree: 10000},
+			1003:         &Broker{ID: 1003, Locality: "c", StorageFree: 5000},
+			1004:         &Broker{ID: 1004, Locality: "d", Replace: true},
+		}
+	}
+
+	params := NewRebuildParams()
+	params.PMM = pmm
+	params.BM = newBrokerMap()
+	params.Strategy = "storage"
+	params.Optimization = "distribution"
+
+	// Uncapped, 1001 has the most storage free.
+	out, errs := pm.Rebuild(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	if leader := out.Partitions[1].Replicas[0]; leader != 1001 {
+		t.Errorf("Expected leader 1001 for p3, got %d", leader)
+	}
+
+	// Leading p3 would put 1001 at 3500B.
+	params.BM = newBrokerMap()
+	params.MaxLeaderBytesPerBroker = 3000
+
+	out, errs = pm.Rebuild(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	if leader := out.Partitions[1].Replicas[0]; leader != 1003 {
+		t.Errorf("Expected leader 1003 for p3, got %d", leader)
+	}
+
+	// No broker can lead p3.
+	params.BM = newBrokerMap()
+	params.MaxLeaderBytesPerBroker = 2000
+
+	if _, errs := pm.Rebuild(params); errs == nil {
+		t.Error("Expected placement errors")
+	}
+}
+
+func TestPartitionMapFromFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkazk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base.json")
+	overlay := filepath.Join(dir, "overlay.json")
+
+	ioutil.WriteFile(base, []byte(testGetMapString("test_topic")), 0644)
+	ioutil.WriteFile(overlay, []byte(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":1,"replicas":[1003,1004]}]}`), 0644)
+
+	pm, err := PartitionMapFromFiles(base, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// p1 comes from the overlay, all else from the base.
+	expected, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	expected.Partitions[1].Replicas = []int{1003, 1004}
+
+	if same, err := pm.Equal(expected); !same {
+		t.Errorf("Unexpected inequality: %s", err)
+	}
+
+	// Missing files are an error.
+	if _, err := PartitionMapFromFiles(base, filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestPartitionMetaMapFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkazk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "partitionmeta.json")
+	ioutil.WriteFile(path, []byte(`{"test_topic":{
+		"0":{"Size":1000},"1":{"Size":1500},"2":{"Size":2000},"3":{"Size":2500}}}`), 0644)
+
+	pmm, err := PartitionMetaMapFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[int]float64{0: 1000, 1: 1500, 2: 2000, 3: 2500}
+	for n, size := range expected {
+		s, err := pmm.Size(Partition{Topic: "test_topic", Partition: n})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != size {
+			t.Errorf("Expected size %.0f for p%d, got %.0f", size, n, s)
+		}
+	}
+
+	// The file sourced PartitionMetaMap
+	// is usable in storage rebuilds.
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	bm := BrokerMap{StubBrokerID: &Broker{ID: StubBrokerID, Replace: true}}
+	for id := 1001; id <= 1004; id++ {
+		bm[id] = &Broker{ID: id, Locality: fmt.Sprint(id), StorageFree: 10000.00}
+	}
+
+	params := NewRebuildParams()
+	params.PMM = pmm
+	params.BM = bm
+	params.Strategy = "storage"
+	params.Optimization = "distribution"
+
+	out, errs := pm.Strip().Rebuild(params)
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	for _, p := range out.Partitions {
+		for _, id := range p.Replicas {
+			if id == StubBrokerID {
+				t.Errorf("Expected p%d to be placed, got %v", p.Partition, p.Replicas)
+			}
+		}
+	}
+
+	// Malformed and missing files are an error.
+	ioutil.WriteFile(path, []byte(`{"test_topic":[]}`), 0644)
+	if _, err := PartitionMetaMapFromFile(path); err == nil {
+		t.Error("Expected error for malformed file")
+	}
+
+	if _, err := PartitionMetaMapFromFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestRebuildRackBalanceByPosition(t *testing.T) {
+	bm := BrokerMap{StubBrokerID: &Broker{ID: StubBrokerID, Replace: true}}
+	// Rack a holds most brokers; balancing by broker
+	// alone would concentrate replicas there.
+	for i, l := range []string{"a", "a", "a", "a", "b", "c"} {
+		id := 1001 + i
+		bm[id] = &Broker{ID: id, Locality: l}
+	}
+
+	params := NewRebuildParams()
+	params.BM = bm
+	params.Strategy = "count"
+	params.RackBalanceByPosition = true
+
+	pm := NewPartitionMap(Populate("test_topic", 12, 2))
+
+	out, errs := pm.Rebuild(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	leaders := map[string]int{}
+	followers := map[string]int{}
+
+	for _, p := range out.Partitions {
+		for pos, id := range p.Replicas {
+			if pos == 0 {
+				leaders[bm[id].Locality]++
+			} else {
+				followers[bm[id].Locality]++
+			}
+		}
+	}
+
+	// 12 leaders and 12 followers over 3 racks.
+	for _, l := range []string{"a", "b", "c"} {
+		if leaders[l] != 4 {
+			t.Errorf("Expected 4 leaders in rack %s, got %d", l, leaders[l])
+		}
+		if followers[l] != 4 {
+			t.Errorf("Expected 4 followers in rack %s, got %d", l, followers[l])
+		}
+	}
+}
+
+func TestAddPartitions(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"other_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1003]},
+		{"topic":"test_topic","partition":2,"replicas":[1003,1004]},
+		{"topic":"test_topic","partition":3,"replicas":[1004,1005]}]}`)
+
+	bm := newStubBrokerMap2()
+	pmm := NewPartitionMetaMap()
+
+	out, errs := pm.AddPartitions("test_topic", 8, bm, pmm, "count")
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	if len(out.Partitions) != 9 {
+		t.Fatalf("Expected 9 partitions, got %d", len(out.Partitions))
+	}
+
+	// The original partitions are unchanged.
+	for i, p := range pm.Partitions {
+		if !p.Equal(out.Partitions[i]) {
+			t.Errorf("Expected %v unchanged, got %v", p, out.Partitions[i])
+		}
+	}
+
+	// The new partitions are fully placed with
+	// unique brokers and rack IDs.
+	for i, p := range out.Partitions[5:] {
+		if p.Topic != "test_topic" || p.Partition != 4+i {
+			t.Errorf("Expected test_topic p%d, got %s p%d", 4+i, p.Topic, p.Partition)
+		}
+
+		if len(p.Replicas) != 2 {
+			t.Errorf("Expected 2 replicas for p%d, got %v", p.Partition, p.Replicas)
+			continue
+		}
+
+		b1, b2 := bm[p.Replicas[0]], bm[p.Replicas[1]]
+		if b1 == nil || b2 == nil || b1.Replace || b2.Replace {
+			t.Errorf("Unexpected brokers for p%d: %v", p.Partition, p.Replicas)
+			continue
+		}
+
+		if b1.Locality == b2.Locality {
+			t.Errorf("Expected unique rack IDs for p%d: %v", p.Partition, p.Replicas)
+		}
+	}
+
+	// The input map isn't modified.
+	if len(pm.Partitions) != 5 {
+		t.Errorf("Expected the input map to retain 5 partitions, got %d", len(pm.Partitions))
+	}
+
+	// The partition count must increase.
+	if _, errs := pm.AddPartitions("test_topic", 4, bm, pmm, "count"); len(errs) != 1 {
+		t.Errorf("Expected 1 error, got %v", errs)
+	}
+
+	if _, errs := pm.AddPartitions("missing_topic", 4, bm, pmm, "count"); len(errs) != 1 {
+		t.Errorf("Expected 1 error, got %v", errs)
+	}
+}
+
+func TestAddPartitionsStorage(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1003]}]}`)
+
+	bm := newStubBrokerMap2()
+	pmm := NewPartitionMetaMap()
+	pmm["test_topic"] = map[int]*PartitionMeta{
+		2: {Size: 30.00},
+		3: {Size: 50.00},
+	}
+
+	before := map[int]float64{}
+	for id, b := range bm {
+		before[id] = b.StorageFree
+	}
+
+	out, errs := pm.AddPartitions("test_topic", 4, bm, pmm, "storage")
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	// Each broker's StorageFree is reduced once
+	// by the size of each new partition it holds.
+	expected := map[int]float64{}
+	for id, sf := range before {
+		expected[id] = sf
+	}
+
+	for _, p := range out.Partitions[2:] {
+		size, _ := pmm.Size(p)
+		for _, id := range p.Replicas {
+			expected[id] -= size
+		}
+	}
+
+	for id, b := range bm {
+		if b.StorageFree != expected[id] {
+			t.Errorf("Expected StorageFree %.2f for broker %d, got %.2f", expected[id], id, b.StorageFree)
+		}
+	}
+}
+
+func TestVerifyReproducible(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	zk := NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	bm := newStubBrokerMap2()
+	bm[1001].Replace = true
+
+	for _, strategy := range []string{"count", "storage"} {
+		params := NewRebuildParams()
+		params.Strategy = strategy
+		params.Optimization = "distribution"
+
+		if err := VerifyReproducible(pm, bm, pmm, params, 5); err != nil {
+			t.Errorf("[%s] Unexpected error: %s", strategy, err)
+		}
+	}
+
+	// The inputs aren't modified.
+	if bm[1002].Used != 2 {
+		t.Errorf("Expected broker 1002 Used of 2, got %d", bm[1002].Used)
+	}
+
+	// Divergence descriptions.
+	pm2 := pm.Copy()
+	pm2.Partitions[1].Replicas = []int{1003, 1001}
+
+	expected := "test_topic p1 [1002 1001] -> test_topic p1 [1003 1001]"
+	if d := firstDifference(pm, pm2); d != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, d)
+	}
+}
+
+func TestRebuildIdempotent(t *testing.T) {
+	zk := NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+
+	bm := newStubBrokerMap2()
+	bm[1001].Replace = true
+	for _, b := range bm {
+		b.StorageFree *= 1000
+	}
+
+	tests := []struct{ strategy, optimization string }{
+		{"count", "distribution"},
+		{"storage", "distribution"},
+		{"storage", "storage"},
+	}
+
+	for _, test := range tests {
+		params := NewRebuildParams()
+		params.PMM = pmm
+		params.Strategy = test.strategy
+		params.Optimization = test.optimization
+		params.ShuffleSeed = 1
+
+		params.BM = bm.Copy()
+		out, errs := pm.Copy().Rebuild(params)
+		if errs != nil {
+			t.Fatalf("[%s/%s] Unexpected error(s): %s", test.strategy, test.optimization, errs)
+		}
+
+		// The first run replaces 1001.
+		if same, _ := pm.Equal(out); same {
+			t.Fatalf("[%s/%s] Expected replacements", test.strategy, test.optimization)
+		}
+
+		// Re-applying the rebuild is a no-op. The input replica sets are
+		// reversed for the storage optimization; these are only restored if
+		// the second run shuffles every replica set again.
+		in := out.Copy()
+		if test.optimization == "storage" {
+			for _, p := range in.Partitions {
+				for i, j := 0, len(p.Replicas)-1; i < j; i, j = i+1, j-1 {
+					p.Replicas[i], p.Replicas[j] = p.Replicas[j], p.Replicas[i]
+				}
+			}
+		}
+
+		params.BM = bm.Copy()
+		out2, errs := in.Rebuild(params)
+		if errs != nil {
+			t.Fatalf("[%s/%s] Unexpected error(s): %s", test.strategy, test.optimization, errs)
+		}
+
+		if same, err := out.Equal(out2); !same {
+			t.Errorf("[%s/%s] Expected identical maps: %s: %s", test.strategy, test.optimization,
+				err, firstDifference(out, out2))
+		}
+
+		// Rebuilding the original map again, with
+		// placements required, yields the same placements.
+		params.BM = bm.Copy()
+		out3, errs := pm.Copy().Rebuild(params)
+		if errs != nil {
+			t.Fatalf("[%s/%s] Unexpected error(s): %s", test.strategy, test.optimization, errs)
+		}
+
+		if same, err := out.Equal(out3); !same {
+			t.Errorf("[%s/%s] Expected identical maps: %s: %s", test.strategy, test.optimization,
+				err, firstDifference(out, out3))
+		}
+	}
+}
+
+func TestRebuildTenantTags(t *testing.T) {
+	newBrokerMap := func() BrokerMap {
+		bm := BrokerMap{StubBrokerID: &Broker{ID: StubBrokerID, Replace: true}}
+		for i, l := range []string{"a", "b", "c", "a", "b", "c"} {
+			id := 1001 + i
+			bm[id] = &Broker{ID: id, Locality: l, StorageFree: 1000}
+			// 1004-1006 are dedicated to team a.
+			if id > 1003 {
+				bm[id].Tags = map[string]string{"tenant": "team_a"}
+			}
+		}
+		return bm
+	}
+
+	pm := NewPartitionMap(Populate("team_a.events", 6, 3), Populate("shared", 6, 3))
+
+	for _, strategy := range []string{"count", "storage"} {
+		params := NewRebuildParams()
+		params.PMM = NewPartitionMetaMap()
+		params.BM = newBrokerMap()
+		params.Strategy = strategy
+		params.Optimization = "storage"
+		params.TenantTags = map[string]string{"team_a.": "tenant=team_a"}
+
+		// Populate sizes for the storage strategy.
+		for _, p := range pm.Partitions {
+			if _, exists := params.PMM[p.Topic]; !exists {
+				params.PMM[p.Topic] = map[int]*PartitionMeta{}
+			}
+			params.PMM[p.Topic][p.Partition] = &PartitionMeta{Size: 1}
+		}
+
+		out, errs := pm.Copy().Rebuild(params)
+		if errs != nil {
+			t.Fatalf("[%s] Unexpected error(s): %s", strategy, errs)
+		}
+
+		shared := map[int]struct{}{}
+		for _, p := range out.Partitions {
+			for _, id := range p.Replicas {
+				if p.Topic == "team_a.events" && id < 1004 {
+					t.Errorf("[%s] Unexpected non-tenant broker %d for %s p%d", strategy, id, p.Topic, p.Partition)
+				}
+				if p.Topic == "shared" {
+					shared[id] = struct{}{}
+				}
+			}
+		}
+
+		// Untagged topics use the full inventory.
+		if strategy == "count" && len(shared) != 6 {
+			t.Errorf("[%s] Expected shared topic across 6 brokers, got %d", strategy, len(shared))
+		}
+	}
+
+	// The tenant brokers can't satisfy a replication factor of 4.
+	params := NewRebuildParams()
+	params.BM = newBrokerMap()
+	params.Strategy = "count"
+	params.MinUniqueRackIDs = 1
+	params.TenantTags = map[string]string{"team_a.": "tenant=team_a"}
+
+	_, errs := NewPartitionMap(Populate("team_a.events", 1, 4)).Rebuild(params)
+	if len(errs) == 0 || !strings.Contains(errs[0].Error(), "tenant tag tenant=team_a") {
+		t.Errorf("Expected tenant tag error, got %v", errs)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1003,1004]},
+		{"topic":"test_topic","partition":3,"replicas":[1004,1003]},
+		{"topic":"test_topic","partition":4,"replicas":[1001,1003]}]}`)
+
+	// p0 is unchanged, p1 has a leader change only, p2 has a
+	// follower change, p3 has a full replica set change, p4
+	// is only in the before map and p5 is only in the after map.
+	after, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":5,"replicas":[1002,1003]},
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":2,"replicas":[1003,1005]},
+		{"topic":"test_topic","partition":3,"replicas":[1005,1006]}]}`, PreserveOrder())
+
+	changes := before.Diff(after)
+
+	expected := []PartitionChange{
+		{"test_topic", 1, []int{1002, 1001}, []int{1001, 1002}, true},
+		{"test_topic", 2, []int{1003, 1004}, []int{1003, 1005}, false},
+		{"test_topic", 3, []int{1004, 1003}, []int{1005, 1006}, true},
+		{"test_topic", 4, []int{1001, 1003}, nil, true},
+		{"test_topic", 5, nil, []int{1002, 1003}, true},
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %v", len(expected), changes)
+	}
+
+	for i, c := range changes {
+		e := expected[i]
+		if c.Topic != e.Topic || c.Partition != e.Partition || c.LeaderChange != e.LeaderChange ||
+			fmt.Sprint(c.OldReplicas) != fmt.Sprint(e.OldReplicas) || fmt.Sprint(c.NewReplicas) != fmt.Sprint(e.NewReplicas) {
+			t.Errorf("Expected %v, got %v", e, c)
+		}
+	}
+
+	// Leader-only changes don't add or remove brokers.
+	if len(changes[0].Added()) != 0 || len(changes[0].Removed()) != 0 {
+		t.Errorf("Unexpected movement for %v", changes[0])
+	}
+
+	if a, r := changes[2].Added(), changes[2].Removed(); fmt.Sprint(a) != "[1005 1006]" || fmt.Sprint(r) != "[1004 1003]" {
+		t.Errorf("Unexpected added %v and removed %v", a, r)
+	}
+
+	// Identical maps have no changes.
+	if changes := before.Diff(before.Copy()); len(changes) != 0 {
+		t.Errorf("Unexpected changes: %v", changes)
+	}
+}
+
+func TestThrottledReplicas(t *testing.T) {
+	before, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	after := before.Copy()
+
+	// p0 [1001,1002] -> [1005,1002]; p2 [1003,1004,1001] -> [1003,1006,1007];
+	// p1 is only reordered.
+	after.Partitions[0].Replicas = []int{1005, 1002}
+	after.Partitions[1].Replicas = []int{1001, 1002}
+	after.Partitions[2].Replicas = []int{1003, 1006, 1007}
+
+	leader, follower := ThrottledReplicas(before, after)
+
+	expectedLeader := "0:1001,0:1002,2:1003,2:1004,2:1001"
+	if leader["test_topic"] != expectedLeader {
+		t.Errorf("Expected leader throttled replicas '%s', got '%s'", expectedLeader, leader["test_topic"])
+	}
+
+	expectedFollower := "0:1005,2:1006,2:1007"
+	if follower["test_topic"] != expectedFollower {
+		t.Errorf("Expected follower throttled replicas '%s', got '%s'", expectedFollower, follower["test_topic"])
+	}
+
+	// No changes.
+	leader, follower = ThrottledReplicas(before, before)
+	if len(leader) != 0 || len(follower) != 0 {
+		t.Errorf("Expected no throttled replicas, got %v, %v", leader, follower)
+	}
+}
+
+func TestRebuildCountLeaderBalance(t *testing.T) {
+	// 1001, 1002 and 1003 hold 5 replicas each while
+	// 1001 leads 5 partitions and 1002 and 1003 lead 1.
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1001,1003]},
+		{"topic":"test_topic","partition":2,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":3,"replicas":[1001,1003]},
+		{"topic":"test_topic","partition":4,"replicas":[1002,1003]},
+		{"topic":"test_topic","partition":5,"replicas":[1003,1002]},
+		{"topic":"test_topic","partition":6,"replicas":[1004,1002]},
+		{"topic":"test_topic","partition":7,"replicas":[1004,1003]},
+		{"topic":"test_topic","partition":8,"replicas":[1001,1004]}]}`)
+
+	bmm := BrokerMetaMap{}
+	for i, r := range []string{"a", "b", "c", "d"} {
+		bmm[1001+i] = &BrokerMeta{Rack: r}
+	}
+
+	bm := BrokerMapFromPartitionMap(pm, bmm, false)
+	bm[1004].Replace = true
+
+	params := NewRebuildParams()
+	params.BM = bm
+	params.Strategy = "count"
+
+	out, errs := pm.Rebuild(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	// With equal Used values, the leaderships of
+	// 1004 go to the brokers holding the fewest.
+	for _, p := range out.Partitions[6:8] {
+		if p.Replicas[0] == 1001 {
+			t.Errorf("Expected p%d leader other than 1001, got %v", p.Partition, p.Replicas)
+		}
+	}
+}
+
+func TestRebuildReuseFreedSlots(t *testing.T) {
+	original, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1002,1003,1001]},
+		{"topic":"test_topic","partition":1,"replicas":[1003,1002,1001]},
+		{"topic":"test_topic","partition":2,"replicas":[1004,1002,1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1004,1003,1001]},
+		{"topic":"test_topic","partition":4,"replicas":[1005,1002,1003]}]}`)
+
+	bmm := BrokerMetaMap{}
+	for i, r := range []string{"a", "b", "c", "d", "e"} {
+		bmm[1001+i] = &BrokerMeta{Rack: r}
+	}
+
+	// 1004 is replaced and a replication factor decrease
+	// to 2 drops all replicas held by 1001.
+	newInput := func() (*PartitionMap, BrokerMap) {
+		bm := BrokerMapFromPartitionMap(original, bmm, false)
+		bm[1004].Replace = true
+		pm := original.Copy()
+		pm.SetReplication(2)
+		return pm, bm
+	}
+
+	received := func(reuse bool) int {
+		pm, bm := newInput()
+
+		params := NewRebuildParams()
+		params.BM = bm
+		params.Strategy = "count"
+		params.ReuseFreedSlots = reuse
+
+		out, errs := pm.Rebuild(params)
+		if errs != nil {
+			t.Fatalf("Unexpected error(s): %s", errs)
+		}
+
+		var n int
+		for _, p := range out.Partitions {
+			if inReplicaSet(1001, p.Replicas) {
+				n++
+			}
+		}
+		return n
+	}
+
+	// The stale usage of 1001 directs new
+	// replicas to other brokers.
+	if n := received(false); n != 0 {
+		t.Errorf("Expected 1001 to receive no replicas, got %d", n)
+	}
+
+	if n := received(true); n == 0 {
+		t.Error("Expected 1001 to receive replicas")
+	}
+}
+
+func TestRebuildMaxRackSpread(t *testing.T) {
+	bm := BrokerMap{StubBrokerID: &Broker{ID: StubBrokerID, Replace: true}}
+	for i, l := range []string{"a", "b", "c", "d", "a", "b", "c", "d"} {
+		id := 1001 + i
+		bm[id] = &Broker{ID: id, Locality: l}
+	}
+
+	params := NewRebuildParams()
+	params.BM = bm
+	params.Strategy = "count"
+	params.MaxRackSpread = 2
+
+	pm := NewPartitionMap(Populate("test_topic", 8, 3))
+
+	out, errs := pm.Rebuild(params)
+	if errs != nil {
+		t.Fatalf("Unexpected error(s): %s", errs)
+	}
+
+	for _, p := range out.Partitions {
+		racks := map[string]struct{}{}
+		for _, id := range p.Replicas {
+			racks[bm[id].Locality] = struct{}{}
+		}
+
+		if len(p.Replicas) != 3 || len(racks) != 2 {
+			t.Errorf("Expected 3 replicas spanning 2 racks for p%d, got %v", p.Partition, p.Replicas)
+		}
+	}
+
+	// A minimum spread above the max is invalid.
+	params.MinUniqueRackIDs = 3
+	if _, errs := pm.Rebuild(params); len(errs) != 1 {
+		t.Errorf("Expected 1 error, got %v", errs)
+	}
+}
+
+func TestRebuildToTargets(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1003]},
+		{"topic":"test_topic","partition":2,"replicas":[1003,1001]},
+		{"topic":"test_topic","partition":3,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":4,"replicas":[1002,1003]},
+		{"topic":"test_topic","partition":5,"replicas":[1003,1001]}]}`)
+
+	bm := newStubBrokerMap2()
+
+	// 12 replicas over 6 brokers.
+	targets := map[int]int{1001: 2, 1002: 2, 1003: 2, 1004: 2, 1005: 2, 1006: 2}
+
+	out, unmet := pm.RebuildToTargets(targets, bm)
+	if len(unmet) != 0 {
+		t.Errorf("Unexpected unmet targets: %v", unmet)
+	}
+
+	counts := map[int]int{}
+	for _, p := range out.Partitions {
+		racks := map[string]struct{}{}
+		for _, id := range p.Replicas {
+			counts[id]++
+			racks[bm[id].Locality] = struct{}{}
+		}
+
+		if len(racks) != len(p.Replicas) {
+			t.Errorf("Expected unique rack IDs for p%d, got %v", p.Partition, p.Replicas)
+		}
+	}
+
+	for id, n := range targets {
+		if counts[id] != n {
+			t.Errorf("Expected %d replicas on %d, got %d", n, id, counts[id])
+		}
+	}
+
+	// The input map is unchanged.
+	if pm.Partitions[0].Replicas[0] != 1001 {
+		t.Error("Unexpected change to the input map")
+	}
+
+	// 1004 can't reach its target with
+	// no other brokers above target.
+	targets = map[int]int{1001: 4, 1002: 4, 1003: 4, 1004: 1}
+
+	_, unmet = pm.RebuildToTargets(targets, bm)
+	if len(unmet) != 1 || unmet[0] != "broker 1004: target 1, got 0" {
+		t.Errorf("Expected an unmet target for 1004, got %v", unmet)
+	}
+}
+
+func TestDecommissionOrder(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]}]}`)
+
+	bm := BrokerMap{
+		1001: &Broker{ID: 1001, Locality: "a", Used: 1},
+		1002: &Broker{ID: 1002, Locality: "b", Used: 1},
+		1003: &Broker{ID: 1003, Locality: "c"},
+		1004: &Broker{ID: 1004, Locality: "a"},
+	}
+
+	pending := bm.Copy()
+	pending[1001].Replace = true
+	pending[1002].Replace = true
+
+	// Removing 1002 first places c in p0, leaving 1001
+	// without a replacement outside of a and c.
+	next, err := decommissionStep(pm, pending.Copy(), 1002, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decommissionStep(next, pending.Copy(), 1001, 0); err == nil {
+		t.Fatal("Expected the naive order to fail")
+	}
+
+	order, err := DecommissionOrder(pm, bm, []int{1002, 1001}, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(order) != 2 || order[0] != 1001 || order[1] != 1002 {
+		t.Errorf("Expected order [1001 1002], got %v", order)
+	}
+
+	// The input BrokerMap isn't modified.
+	if bm[1001].Replace || bm[1002].Replace {
+		t.Error("Unexpected modification of the input BrokerMap")
+	}
+
+	// Without 1004, the remaining brokers can't support placement.
+	delete(bm, 1004)
+
+	if _, err := DecommissionOrder(pm, bm, []int{1002, 1001}, 2, 0); err == nil {
+		t.Error("Expected error")
+	}
+
+	if _, err := DecommissionOrder(pm, bm, []int{1005}, 2, 0); err == nil {
+		t.Error("Expected error for unknown broker")
+	}
+}
+
+func TestMinimizeMovement(t *testing.T) {
+	pm := NewPartitionMap(Populate("test_topic", 12, 2))
+	pmm := NewPartitionMetaMap()
+	pmm["test_topic"] = map[int]*PartitionMeta{}
+
+	// All partitions are on 1001 and 1002; 1003 and
+	// 1004 are new and empty.
+	bm := BrokerMap{StubBrokerID: &Broker{ID: StubBrokerID, Replace: true}}
+	for i := 0; i < 4; i++ {
+		id := 1001 + i
+		bm[id] = &Broker{ID: id, Locality: string('a' + rune(i%2)), StorageFree: 20000}
+	}
+
+	for i := range pm.Partitions {
+		pm.Partitions[i].Replicas = []int{1001 + i%2, 1002 - i%2}
+		pmm["test_topic"][i] = &PartitionMeta{Size: float64(100 * (i + 1))}
+		bm[1001].StorageFree -= pmm["test_topic"][i].Size
+		bm[1002].StorageFree -= pmm["test_topic"][i].Size
+	}
+
+	// A storage force rebuild.
+	params := NewRebuildParams()
+	params.PMM, params.Strategy, params.Optimization = pmm, "storage", "distribution"
+	params.BM = bm.Copy()
+	params.BM.SubStorage(pm, pmm, func(*Broker) bool { return true })
+
+	after, errs := pm.Strip().Rebuild(params)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	var prevMoves int
+	var prevImbalance float64
+
+	for i, w := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		out, projected, err := MinimizeMovement(pm, after, bm, pmm, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var moves int
+		for n, p := range out.Partitions {
+			for _, id := range p.Replicas {
+				if !inReplicaSet(id, pm.Partitions[n].Replicas) {
+					moves++
+				}
+			}
+		}
+
+		imbalance := projected.Filter(AllBrokersFn).StorageStdDev()
+
+		if i > 0 && moves > prevMoves {
+			t.Errorf("[weight %.2f] Expected at most %d moves, got %d", w, prevMoves, moves)
+		}
+
+		if i > 0 && imbalance < prevImbalance {
+			t.Errorf("[weight %.2f] Expected imbalance of at least %.2f, got %.2f", w, prevImbalance, imbalance)
+		}
+
+		prevMoves, prevImbalance = moves, imbalance
+
+		switch w {
+		case 0:
+			if moves == 0 {
+				t.Error("[weight 0.00] Expected moves")
+			}
+		case 1:
+			if moves != 0 {
+				t.Errorf("[weight 1.00] Expected 0 moves, got %d", moves)
+			}
+		}
+	}
+
+	if _, _, err := MinimizeMovement(pm, after, bm, pmm, 1.5); err == nil {
+		t.Error("Expected error for invalid weight")
+	}
+}
+
+func TestReplicaFactorHistogram(t *testing.T) {
+	pm, _ := PartitionMapFromString(fmt.Sprintf(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002,1003]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1003,1004]},
+		{"topic":"test_topic","partition":2,"replicas":[1003,1004]},
+		{"topic":"test_topic2","partition":0,"replicas":[1001]},
+		{"topic":"test_topic2","partition":1,"replicas":[1001,%d,1003]}]}`, StubBrokerID))
+
+	// The stub padded replica set is counted under 0.
+	expected := map[int]int{3: 2, 2: 1, 1: 1, 0: 1}
+	h := pm.ReplicaFactorHistogram()
+
+	if len(h) != len(expected) {
+		t.Fatalf("Expected histogram %v, got %v", expected, h)
+	}
+
+	for rf, n := range expected {
+		if h[rf] != n {
+			t.Errorf("Expected %d partitions with replication factor %d, got %d", n, rf, h[rf])
+		}
+	}
+}
+
+func TestDegradedPartitions(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	pm.SetReplication(3)
+
+	// Two brokers can't satisfy a replication factor of 3.
+	bm := BrokerMap{
+		StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+		1001:         &Broker{ID: 1001, Locality: "a"},
+		1002:         &Broker{ID: 1002, Locality: "b"},
+	}
+
+	params := NewRebuildParams()
+	params.BM, params.Strategy = bm, "count"
+	params.MinUniqueRackIDs = 1
+
+	out, errs := pm.Strip().Rebuild(params)
+	if len(errs) != len(pm.Partitions) {
+		t.Errorf("Expected %d errors, got %v", len(pm.Partitions), errs)
+	}
+
+	degraded := out.DegradedPartitions(3)
+	if len(degraded) != len(pm.Partitions) {
+		t.Fatalf("Expected %d degraded partitions, got %v", len(pm.Partitions), degraded)
+	}
+
+	for _, p := range degraded {
+		if len(p.Replicas) != 2 {
+			t.Errorf("Expected 2 replicas for %s p%d, got %v", p.Topic, p.Partition, p.Replicas)
+		}
+	}
+
+	// Stub brokers are degraded regardless of length;
+	// p0 and p1 were extended with stub brokers.
+	if degraded := pm.DegradedPartitions(0); len(degraded) != 2 {
+		t.Errorf("Expected 2 degraded partitions, got %v", degraded)
+	}
+
+	pm, _ = PartitionMapFromString(testGetMapString("test_topic"))
+	if degraded := pm.DegradedPartitions(2); len(degraded) != 0 {
+		t.Errorf("Unexpected degraded partitions: %v", degraded)
+	}
+}
+
+func TestRetainExistingReplicas(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1001]}]}`)
+	pmm := NewPartitionMetaMap()
+	pmm["test_topic"] = map[int]*PartitionMeta{0: {Size: 1000}, 1: {Size: 1000}}
+
+	bmm := BrokerMetaMap{
+		1001: &BrokerMeta{Rack: "a", StorageFree: 1000},
+		1002: &BrokerMeta{Rack: "b", StorageFree: 1000},
+		1003: &BrokerMeta{Rack: "a", StorageFree: 100000},
+		1004: &BrokerMeta{Rack: "b", StorageFree: 100000},
+	}
+
+	bm := BrokerMapFromPartitionMap(pm, bmm, true)
+	bm.Update([]int{1001, 1002, 1003, 1004}, bmm)
+
+	// A storage force rebuild places all
+	// replicas on the new brokers.
+	params := NewRebuildParams()
+	params.PMM, params.BM = pmm, bm
+	params.Strategy, params.Optimization = "storage", "distribution"
+	params.BM.SubStorage(pm, pmm, func(*Broker) bool { return true })
+
+	rebuilt, errs := pm.Strip().Rebuild(params)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	if n := len(rebuilt.NewBrokerReplicaSets(bm)); n != 2 {
+		t.Fatalf("Expected 2 partitions on new brokers, got %d", n)
+	}
+
+	out, errs := pm.RetainExistingReplicas(rebuilt, params)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	if n := len(out.NewBrokerReplicaSets(bm)); n != 0 {
+		t.Errorf("Expected 0 partitions on new brokers, got %d", n)
+	}
+
+	for i, p := range out.Partitions {
+		// The original leader is retained in place
+		// of the new broker in the same rack.
+		orig := pm.Partitions[i].Replicas[0]
+		if !inReplicaSet(orig, p.Replicas) {
+			t.Errorf("Expected %s p%d to retain broker %d, got %v", p.Topic, p.Partition, orig, p.Replicas)
+		}
+
+		if localityViolation(p.Replicas, bm, 0) {
+			t.Errorf("Unexpected locality violation for %s p%d: %v", p.Topic, p.Partition, p.Replicas)
+		}
+	}
+
+	// Usage and storage reflect the retained replicas.
+	if bm[1001].Used != 1 || bm[1002].Used != 1 || bm[1003].Used != 1 || bm[1004].Used != 1 {
+		t.Errorf("Unexpected broker usage: %d %d %d %d",
+			bm[1001].Used, bm[1002].Used, bm[1003].Used, bm[1004].Used)
+	}
+
+	if bm[1001].StorageFree != 2000 {
+		t.Errorf("Expected storage free 2000 for broker 1001, got %.0f", bm[1001].StorageFree)
+	}
+
+	// Without existing brokers, nothing can be retained.
+	bm[1001].Replace = true
+	bm[1002].Replace = true
+
+	if _, errs := pm.RetainExistingReplicas(rebuilt, params); len(errs) != 2 {
+		t.Errorf("Expected 2 errors, got %v", errs)
+	}
+}
+
+func TestStepPlan(t *testing.T) {
+	before, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	target, _ := PartitionMapFromString(testGetMapString4("test_topic"))
+
+	steps := target.StepPlan(before)
+
+	// testGetMapString4 has 6 partitions, all differing
+	// from or missing in testGetMapString.
+	if len(steps) != 6 {
+		t.Fatalf("Expected 6 steps, got %d", len(steps))
+	}
+
+	previous := before
+	for i, step := range steps {
+		var changed int
+		for _, p := range step.Partitions {
+			var found bool
+			for _, c := range previous.Partitions {
+				if c.Topic == p.Topic && c.Partition == p.Partition {
+					found = true
+					if !c.Equal(p) {
+						changed++
+					}
+				}
+			}
+			if !found {
+				changed++
+			}
+		}
+
+		if changed != 1 {
+			t.Errorf("Expected 1 change in step %d, got %d", i, changed)
+		}
+
+		previous = step
+	}
+
+	if same, err := steps[len(steps)-1].Equal(target); !same {
+		t.Errorf("Unexpected inequality of the final step: %s", err)
+	}
+
+	// No changes, no steps.
+	if n := len(target.StepPlan(target)); n != 0 {
+		t.Errorf("Expected 0 steps, got %d", n)
+	}
+}
+
+func TestRebuildMaxPartitionsPerBroker(t *testing.T) {
+	zk := NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	// 10 replicas.
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+
+	newBrokerMap := func() BrokerMap {
+		bm := BrokerMap{StubBrokerID: &Broker{ID: StubBrokerID, Replace: true}}
+		for i, l := range []string{"a", "b", "c", "d"} {
+			id := 1001 + i
+			bm[id] = &Broker{ID: id, Locality: l, StorageFree: 100000}
+		}
+		// 1001 has the most storage free.
+		bm[1001].StorageFree = 200000
+		return bm
+	}
+
+	// 1001 holds a replica for another topic, leaving
+	// room for 2 under the limit.
+	held := map[int]int{1001: 1}
+
+	for _, opt := range [][2]string{{"count", ""}, {"storage", "distribution"}, {"storage", "storage"}} {
+		params := NewRebuildParams()
+		params.PMM, params.BM = pmm, newBrokerMap()
+		params.Strategy, params.Optimization = opt[0], opt[1]
+		params.MaxPartitionsPerBroker = 3
+		params.HeldPartitions = held
+
+		out, errs := pm.Strip().Rebuild(params)
+		if errs != nil {
+			t.Fatalf("[%v] %s", opt, errs)
+		}
+
+		counts := map[int]int{}
+		for _, p := range out.Partitions {
+			for _, id := range p.Replicas {
+				counts[id]++
+			}
+		}
+
+		if counts[1001] > 2 {
+			t.Errorf("[%v] Expected at most 2 replicas on broker 1001, got %d", opt, counts[1001])
+		}
+
+		for id, c := range counts {
+			if c+held[id] > 3 {
+				t.Errorf("[%v] Broker %d exceeds the limit with %d replicas", opt, id, c+held[id])
+			}
+		}
+
+		// A lower limit can't fit all replicas.
+		params.BM = newBrokerMap()
+		params.MaxPartitionsPerBroker = 2
+
+		_, errs = pm.Strip().Rebuild(params)
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), "short by 3") {
+			t.Errorf("[%v] Expected capacity error, got %v", opt, errs)
+		}
+	}
+}
+
+func TestRebuildRackAware(t *testing.T) {
+	zk := NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+	pm.SetReplication(3)
+
+	newBrokerMap := func(localities ...string) BrokerMap {
+		bm := BrokerMap{StubBrokerID: &Broker{ID: StubBrokerID, Replace: true}}
+		for i, l := range localities {
+			id := 1001 + i
+			bm[id] = &Broker{ID: id, Locality: l, StorageFree: 100000}
+		}
+		return bm
+	}
+
+	spans := func(replicas []int, bm BrokerMap) int {
+		localities := map[string]struct{}{}
+		for _, id := range replicas {
+			localities[bm[id].Locality] = struct{}{}
+		}
+		return len(localities)
+	}
+
+	for _, opt := range [][2]string{{"count-rackaware", ""}, {"storage", "distribution"}, {"storage", "storage"}} {
+		// Replica sets span 3 localities. Two brokers per
+		// locality allows the count strategy to stack a locality.
+		params := NewRebuildParams()
+		params.PMM, params.BM = pmm, newBrokerMap("a", "a", "b", "b", "c", "c")
+		params.Strategy, params.Optimization = opt[0], opt[1]
+		params.RackAware = true
+
+		out, errs := pm.Strip().Rebuild(params)
+		if errs != nil {
+			t.Fatalf("[%v] %s", opt, errs)
+		}
+
+		for _, p := range out.Partitions {
+			if n := spans(p.Replicas, params.BM); n != 3 {
+				t.Errorf("[%v] %s p%d: expected 3 localities, got %d", opt, p.Topic, p.Partition, n)
+			}
+		}
+
+		// With fewer localities than the replication
+		// factor, replica sets span all localities.
+		params.BM = newBrokerMap("a", "a", "b", "b")
+
+		out, errs = pm.Strip().Rebuild(params)
+		if errs != nil {
+			t.Fatalf("[%v] %s", opt, errs)
+		}
+
+		for _, p := range out.Partitions {
+			if len(p.Replicas) != 3 || spans(p.Replicas, params.BM) != 2 {
+				t.Errorf("[%v] %s p%d: expected 3 replicas in 2 localities, got %v", opt, p.Topic, p.Partition, p.Replicas)
+			}
+		}
+	}
+
+	// The only broker in locality c can't hold any partitions.
+	params := NewRebuildParams()
+	params.PMM, params.BM = pmm, newBrokerMap("a", "a", "b", "b", "c")
+	params.BM[1005].StorageFree = 0
+	params.Strategy, params.Optimization = "storage", "distribution"
+	params.RackAware = true
+
+	_, errs := pm.Strip().Rebuild(params)
+	if len(errs) != len(pm.Partitions) {
+		t.Fatalf("Expected %d errors, got %v", len(pm.Partitions), errs)
+	}
+
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "no eligible brokers in localities [c]") {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	}
+
+	// Retained replicas sharing a locality
+	// prevent the spread; this is reported.
+	pm2, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002,1003]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1001,1004]}]}`)
+
+	params = NewRebuildParams()
+	params.BM = newBrokerMap("a", "a", "b", "b", "c")
+	params.BM[1003].Replace = true
+	params.Strategy = "count-rackaware"
+
+	out, errs := pm2.Rebuild(params)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "test_topic p0: rack spread of 3 unsatisfiable: replica set spans localities") {
+		t.Errorf("Expected rack spread error, got %v", errs)
+	}
 
-	expected := &PartitionMap{
-		Version: 1,
-		Partitions: PartitionList{
-			Partition{
-				Topic:     "test_topic",
-				Partition: 0,
-				Replicas:  []int{1001, 1002},
-			},
-			Partition{
-				Topic:     "test_topic",
-				Partition: 1,
-				Replicas:  []int{1001, 1002},
-			},
-			Partition{
-				Topic:     "test_topic",
-				Partition: 2,
-				Replicas:  []int{1004, 1003, 1001},
-			},
-			Partition{
-				Topic:     "test_topic",
-				Partition: 3,
-				Replicas:  []int{1002, 1004, 1003},
-			},
-		},
-	}
-
-	pm.shuffle((func(_ Partition) bool { return true }))
-
-	if same, _ := pm.Equal(expected); !same {
-		t.Errorf("Unexpected shuffle results")
+	if r := out.Partitions[0].Replicas; r[0] != 1001 || r[1] != 1002 || params.BM[r[2]].Locality == "a" {
+		t.Errorf("Unexpected replicas for test_topic p0: %v", r)
 	}
 }
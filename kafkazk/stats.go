@@ -94,6 +94,165 @@ func (pm *PartitionMap) DegreeDistribution() DegreeDistribution {
 	return d
 }
 
+// PositionDistribution returns a mapping of broker ID to the number of
+// replicas held by the broker at each replica set position, where position 0
+// is the leader, 1 is the first follower, and so on.
+func (pm *PartitionMap) PositionDistribution() map[int]map[int]int {
+	dist := map[int]map[int]int{}
+
+	for _, partn := range pm.Partitions {
+		for pos, id := range partn.Replicas {
+			if _, exists := dist[id]; !exists {
+				dist[id] = map[int]int{}
+			}
+			dist[id][pos]++
+		}
+	}
+
+	return dist
+}
+
+// TopicSizes takes a PartitionMetaMap and returns a mapping of topic name to
+// the total storage consumed by the topic across all replicas; each partition
+// size is multiplied by the partition's replica count. Any partitions missing
+// from the PartitionMetaMap aren't included in the totals and are returned as
+// a PartitionList.
+func (pm *PartitionMap) TopicSizes(pmm PartitionMetaMap) (map[string]float64, PartitionList) {
+	sizes := map[string]float64{}
+	var missing PartitionList
+
+	for _, partn := range pm.Partitions {
+		s, err := pmm.Size(partn)
+		if err != nil {
+			missing = append(missing, partn)
+			continue
+		}
+
+		sizes[partn.Topic] += s * float64(len(partn.Replicas))
+	}
+
+	return sizes, missing
+}
+
+// TopicBrokerSpread returns a mapping of topic name to the number of distinct
+// brokers holding replicas for the topic.
+func (pm *PartitionMap) TopicBrokerSpread() map[string]int {
+	brokers := map[string]map[int]struct{}{}
+
+	for _, partn := range pm.Partitions {
+		if _, exists := brokers[partn.Topic]; !exists {
+			brokers[partn.Topic] = map[int]struct{}{}
+		}
+		for _, id := range partn.Replicas {
+			brokers[partn.Topic][id] = struct{}{}
+		}
+	}
+
+	spread := map[string]int{}
+	for t, ids := range brokers {
+		spread[t] = len(ids)
+	}
+
+	return spread
+}
+
+// TopicsBelowBrokerSpread takes a minimum distinct broker count and returns
+// a sorted []string of topic names with replicas spanning fewer brokers.
+func (pm *PartitionMap) TopicsBelowBrokerSpread(min int) []string {
+	var below []string
+
+	for t, n := range pm.TopicBrokerSpread() {
+		if n < min {
+			below = append(below, t)
+		}
+	}
+
+	sort.Strings(below)
+
+	return below
+}
+
+// UnevenLocalityTopics takes a BrokerMap and returns a sorted []string of
+// topic names with a partition count that isn't a multiple of the number of
+// localities among brokers in the BrokerMap. Partitions for these topics can't
+// be distributed evenly across localities.
+func (pm *PartitionMap) UnevenLocalityTopics(bm BrokerMap) []string {
+	localities := map[string]struct{}{}
+	for id, b := range bm {
+		if id != StubBrokerID && b.Locality != "" {
+			localities[b.Locality] = struct{}{}
+		}
+	}
+
+	var uneven []string
+
+	if len(localities) == 0 {
+		return uneven
+	}
+
+	counts := map[string]int{}
+	for _, p := range pm.Partitions {
+		counts[p.Topic]++
+	}
+
+	for t, n := range counts {
+		if n%len(localities) != 0 {
+			uneven = append(uneven, t)
+		}
+	}
+
+	sort.Strings(uneven)
+
+	return uneven
+}
+
+// MinorityRackLeaders takes a BrokerMap and an optional mapping of topic
+// names to a primary rack ID and returns a PartitionList of partitions with a
+// leader in a suboptimal locality. For topics with a primary rack, partitions
+// are included where the leader isn't in the primary rack but another replica
+// is. For all other topics, partitions are included where another locality
+// holds more of the partition's replicas than the leader's locality. Replicas
+// on brokers missing from the BrokerMap or without a locality are ignored.
+// Partitions can be corrected with a preferred leader election following a
+// reordering of the replica set.
+func (pm *PartitionMap) MinorityRackLeaders(bm BrokerMap, primary map[string]string) PartitionList {
+	var flagged PartitionList
+
+	for _, partn := range pm.Partitions {
+		if len(partn.Replicas) == 0 {
+			continue
+		}
+
+		leader, exists := bm[partn.Replicas[0]]
+		if !exists || leader.Locality == "" {
+			continue
+		}
+
+		counts := map[string]int{}
+		for _, id := range partn.Replicas {
+			if b, exists := bm[id]; exists && b.Locality != "" {
+				counts[b.Locality]++
+			}
+		}
+
+		if rack, hinted := primary[partn.Topic]; hinted {
+			if leader.Locality != rack && counts[rack] > 0 {
+				flagged = append(flagged, partn)
+			}
+			continue
+		}
+
+		for _, n := range counts {
+			if n > counts[leader.Locality] {
+				flagged = append(flagged, partn)
+				break
+			}
+		}
+	}
+
+	return flagged
+}
+
 // StorageDiff takes two BrokerMaps and returns a per broker ID
 // diff in storage as a [2]float64: [absolute, percentage] diff.
 func (b BrokerMap) StorageDiff(b2 BrokerMap) map[int][2]float64 {
@@ -116,6 +275,23 @@ func (b BrokerMap) StorageDiff(b2 BrokerMap) map[int][2]float64 {
 	return d
 }
 
+// StorageSnapshot returns a mapping of broker ID to the current free storage
+// for all brokers in the BrokerMap. The snapshot is independent of the
+// BrokerMap; subsequent changes to broker StorageFree values, e.g. while
+// planning relocations, aren't reflected.
+func (b BrokerMap) StorageSnapshot() map[int]float64 {
+	s := map[int]float64{}
+
+	for id, br := range b {
+		if id == StubBrokerID {
+			continue
+		}
+		s[id] = br.StorageFree
+	}
+
+	return s
+}
+
 // StorageRangeSpread returns the range spread
 // of free storage for all brokers in the BrokerMap.
 func (b BrokerMap) StorageRangeSpread() float64 {
@@ -156,8 +332,8 @@ func (b BrokerMap) MinMax() (float64, float64) {
 	return l, h
 }
 
-// StorageStdDev returns the standard deviation
-// of free storage for all brokers in the BrokerMap.
+// StorageStdDev returns the standard deviation of free storage for all
+// brokers in the BrokerMap, excluding brokers marked for replacement.
 func (b BrokerMap) StorageStdDev() float64 {
 	var m float64
 	var t float64
@@ -165,17 +341,21 @@ func (b BrokerMap) StorageStdDev() float64 {
 	var l float64
 
 	for id := range b {
-		if id == StubBrokerID {
+		if id == StubBrokerID || b[id].Replace {
 			continue
 		}
 		l++
 		t += b[id].StorageFree
 	}
 
+	if l == 0 {
+		return 0
+	}
+
 	m = t / l
 
 	for id := range b {
-		if id == StubBrokerID {
+		if id == StubBrokerID || b[id].Replace {
 			continue
 		}
 		s += math.Pow(m-b[id].StorageFree, 2)
@@ -186,7 +366,9 @@ func (b BrokerMap) StorageStdDev() float64 {
 	return math.Sqrt(msq)
 }
 
-// HMean returns the harmonic mean of broker storage free.
+// HMean returns the harmonic mean of broker storage free. Brokers with a
+// non-positive storage free, e.g. a full broker or missing metrics, are
+// excluded; 0 is returned if no brokers remain.
 func (b BrokerMap) HMean() float64 {
 	var t float64
 	var c float64
@@ -198,10 +380,15 @@ func (b BrokerMap) HMean() float64 {
 		}
 	}
 
+	if c == 0 {
+		return 0
+	}
+
 	return c / t
 }
 
-// Mean returns the arithmetic mean of broker storage free.
+// Mean returns the arithmetic mean of broker storage free. Brokers with a
+// non-positive storage free are excluded; 0 is returned if no brokers remain.
 func (b BrokerMap) Mean() float64 {
 	var t float64
 	var c float64
@@ -213,16 +400,21 @@ func (b BrokerMap) Mean() float64 {
 		}
 	}
 
+	if c == 0 {
+		return 0
+	}
+
 	return t / c
 }
 
 // AboveMean returns a sorted []int of broker IDs that are above the mean
-// by d percent (0.00 < d). The mean type is provided as a function f.
+// by d percent (0.00 < d). The mean type is provided as a function f. No IDs
+// are returned if the mean is non-positive.
 func (b BrokerMap) AboveMean(d float64, f func() float64) []int {
 	m := f()
 	var ids []int
 
-	if d <= 0.00 {
+	if d <= 0.00 || m <= 0.00 {
 		return ids
 	}
 
@@ -241,13 +433,84 @@ func (b BrokerMap) AboveMean(d float64, f func() float64) []int {
 	return ids
 }
 
+// StorageUsed returns the ratio of storage used to the total storage capacity
+// of the broker (0.00 to 1.00), or 0 if the total isn't known.
+func (b Broker) StorageUsed() float64 {
+	if b.StorageTotal <= 0 {
+		return 0
+	}
+
+	return 1 - b.StorageFree/b.StorageTotal
+}
+
+// MeanStorageUsed returns the arithmetic mean of broker storage used ratios.
+// Brokers without a known StorageTotal are excluded; 0 is returned if no
+// brokers remain.
+func (b BrokerMap) MeanStorageUsed() float64 {
+	var t float64
+	var c float64
+
+	for _, br := range b {
+		if br.ID != StubBrokerID && br.StorageTotal > 0 {
+			c++
+			t += br.StorageUsed()
+		}
+	}
+
+	if c == 0 {
+		return 0
+	}
+
+	return t / c
+}
+
+// AboveMeanStorageUsed returns a sorted []int of broker IDs with a storage
+// used ratio above the mean storage used ratio by d percent (0.00 < d). This
+// is the percent utilization counterpart of BelowMean for brokers of differing
+// storage capacity; brokers without a known StorageTotal are excluded.
+func (b BrokerMap) AboveMeanStorageUsed(d float64) []int {
+	m := b.MeanStorageUsed()
+	var ids []int
+
+	if d <= 0.00 || m <= 0.00 {
+		return ids
+	}
+
+	for _, br := range b {
+		if br.ID == StubBrokerID || br.StorageTotal <= 0 {
+			continue
+		}
+
+		if (br.StorageUsed()-m)/m > d {
+			ids = append(ids, br.ID)
+		}
+	}
+
+	sort.Ints(ids)
+
+	return ids
+}
+
+// StorageFreeTarget returns the StorageFree value at which the broker would
+// be at the mean storage used ratio of the BrokerMap, or 0 if the broker's
+// StorageTotal isn't known.
+func (b BrokerMap) StorageFreeTarget(id int) float64 {
+	br, exists := b[id]
+	if !exists || br.StorageTotal <= 0 {
+		return 0
+	}
+
+	return br.StorageTotal * (1 - b.MeanStorageUsed())
+}
+
 // BelowMean returns a sorted []int of broker IDs that are below the mean
-// by d percent (0.00 < d). The mean type is provided as a function f.
+// by d percent (0.00 < d). The mean type is provided as a function f. No IDs
+// are returned if the mean is non-positive.
 func (b BrokerMap) BelowMean(d float64, f func() float64) []int {
 	m := f()
 	var ids []int
 
-	if d <= 0.00 {
+	if d <= 0.00 || m <= 0.00 {
 		return ids
 	}
 
@@ -265,3 +528,69 @@ func (b BrokerMap) BelowMean(d float64, f func() float64) []int {
 
 	return ids
 }
+
+// HeatWeights holds the weights applied to each component of a broker heat
+// score.
+type HeatWeights struct {
+	Storage    float64
+	Count      float64
+	Leadership float64
+}
+
+// Heat takes a *PartitionMap, PartitionMetaMap and HeatWeights and returns a
+// weighted heat score for each broker in the BrokerMap. The score combines
+// storage utilization, partition count and leader count, each normalized to a
+// 0..1 range. Storage utilization is the size of all partitions held by the
+// broker relative to the sum of that size and the StorageFree. Partition and
+// leader counts are normalized against the maximum observed for any broker.
+// Partitions without size metadata are counted as zero bytes.
+func (b BrokerMap) Heat(pm *PartitionMap, pmm PartitionMetaMap, w HeatWeights) map[int]float64 {
+	heat := map[int]float64{}
+	used := map[int]float64{}
+
+	// Get the bytes held per broker.
+	for _, partn := range pm.Partitions {
+		size, _ := pmm.Size(partn)
+		for _, id := range partn.Replicas {
+			used[id] += size
+		}
+	}
+
+	stats := pm.UseStats()
+
+	// Get the max counts for normalization.
+	var maxCount, maxLeader float64
+	for id, s := range stats {
+		if _, exists := b[id]; !exists || id == StubBrokerID {
+			continue
+		}
+
+		maxCount = math.Max(maxCount, float64(s.Leader+s.Follower))
+		maxLeader = math.Max(maxLeader, float64(s.Leader))
+	}
+
+	for id, br := range b {
+		if id == StubBrokerID {
+			continue
+		}
+
+		var storage, count, leader float64
+
+		if total := used[id] + br.StorageFree; total > 0 {
+			storage = used[id] / total
+		}
+
+		if s, exists := stats[id]; exists {
+			if maxCount > 0 {
+				count = float64(s.Leader+s.Follower) / maxCount
+			}
+			if maxLeader > 0 {
+				leader = float64(s.Leader) / maxLeader
+			}
+		}
+
+		heat[id] = w.Storage*storage + w.Count*count + w.Leadership*leader
+	}
+
+	return heat
+}
@@ -73,6 +73,90 @@ func TestDegreeDistribution(t *testing.T) {
 	}
 }
 
+func TestPositionDistribution(t *testing.T) {
+	pm, _ := PartitionMapFromString(testGetMapString("test_topic"))
+
+	dist := pm.PositionDistribution()
+
+	expected := map[int]map[int]int{
+		1001: {0: 1, 1: 1, 2: 1},
+		1002: {0: 1, 1: 1, 2: 1},
+		1003: {0: 1, 1: 1},
+		1004: {0: 1, 1: 1},
+	}
+
+	if len(dist) != len(expected) {
+		t.Fatalf("Expected %d brokers, got %d", len(expected), len(dist))
+	}
+
+	for id, positions := range expected {
+		if len(dist[id]) != len(positions) {
+			t.Errorf("Expected %d positions for %d, got %d", len(positions), id, len(dist[id]))
+		}
+		for pos, n := range positions {
+			if dist[id][pos] != n {
+				t.Errorf("Expected %d replicas at position %d for %d, got %d", n, pos, id, dist[id][pos])
+			}
+		}
+	}
+}
+
+func TestTopicSizes(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002,1003]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1003,1001]},
+		{"topic":"test_topic","partition":9,"replicas":[1003,1001,1002]}]}`)
+
+	zk := NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	sizes, missing := pm.TopicSizes(pmm)
+
+	// p0 (1000) and p1 (1500) at a RF of 3.
+	if sizes["test_topic"] != 7500 {
+		t.Errorf("Expected size 7500, got %f", sizes["test_topic"])
+	}
+
+	if len(missing) != 1 || missing[0].Partition != 9 {
+		t.Errorf("Expected p9 missing, got %v", missing)
+	}
+}
+
+func TestTopicBrokerSpread(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"concentrated","partition":0,"replicas":[1001,1002,1003]},
+		{"topic":"concentrated","partition":1,"replicas":[1002,1003,1001]},
+		{"topic":"concentrated","partition":2,"replicas":[1003,1001,1002]},
+		{"topic":"spread","partition":0,"replicas":[1001,1002,1003]},
+		{"topic":"spread","partition":1,"replicas":[1004,1005,1006]}]}`)
+
+	spread := pm.TopicBrokerSpread()
+
+	expected := map[string]int{"concentrated": 3, "spread": 6}
+	for topic, n := range expected {
+		if spread[topic] != n {
+			t.Errorf("Expected spread %d for %s, got %d", n, topic, spread[topic])
+		}
+	}
+
+	below := pm.TopicsBelowBrokerSpread(5)
+	if len(below) != 1 || below[0] != "concentrated" {
+		t.Errorf("Expected [concentrated] below the minimum spread, got %v", below)
+	}
+}
+
+func TestUnevenLocalityTopics(t *testing.T) {
+	pm := NewPartitionMap(Populate("uneven", 10, 3), Populate("even", 9, 3))
+
+	// 3 localities.
+	bm := newStubBrokerMap2()
+
+	uneven := pm.UnevenLocalityTopics(bm)
+	if len(uneven) != 1 || uneven[0] != "uneven" {
+		t.Errorf("Expected [uneven], got %v", uneven)
+	}
+}
+
 func TestBrokerMapStorageDiff(t *testing.T) {
 	bm1 := newStubBrokerMap()
 	bm2 := newStubBrokerMap()
@@ -98,6 +182,32 @@ func TestBrokerMapStorageDiff(t *testing.T) {
 	}
 }
 
+func TestBrokerMapStorageSnapshot(t *testing.T) {
+	bm := newStubBrokerMap()
+	snapshot := bm.StorageSnapshot()
+
+	if _, exist := snapshot[StubBrokerID]; exist {
+		t.Error("Unexpected stub broker in snapshot")
+	}
+
+	for id, br := range bm {
+		if id == StubBrokerID {
+			continue
+		}
+		if snapshot[id] != br.StorageFree {
+			t.Errorf("Expected broker %d storage free %.2f, got %.2f", id, br.StorageFree, snapshot[id])
+		}
+	}
+
+	// Subsequent changes aren't reflected.
+	original := bm[1001].StorageFree
+	bm[1001].StorageFree += 100.00
+
+	if snapshot[1001] != original {
+		t.Errorf("Expected snapshot value %.2f, got %.2f", original, snapshot[1001])
+	}
+}
+
 func TestBrokerMapStorageRangeSpread(t *testing.T) {
 	bm := newStubBrokerMap()
 	rs := bm.StorageRangeSpread()
@@ -124,6 +234,24 @@ func TestBrokerMapStorageStdDev(t *testing.T) {
 	if sd != 111.803000 {
 		t.Errorf("Expected storage standard deviation 111.803000, got %f", sd)
 	}
+
+	// Brokers marked for replacement are excluded.
+	bm[1004].Replace = true
+
+	sd = math.Round(bm.StorageStdDev()/0.001) * 0.001
+
+	if sd != 81.65 {
+		t.Errorf("Expected storage standard deviation 81.650000, got %f", sd)
+	}
+
+	// A balanced map has no deviation.
+	for _, b := range bm {
+		b.StorageFree = 100.00
+	}
+
+	if sd := bm.StorageStdDev(); sd != 0 {
+		t.Errorf("Expected storage standard deviation 0, got %f", sd)
+	}
 }
 
 func TestBrokerListSort(t *testing.T) {
@@ -170,6 +298,100 @@ func TestMean(t *testing.T) {
 	}
 }
 
+func TestMeanNonPositiveStorageFree(t *testing.T) {
+	bm := BrokerMap{
+		StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+		1001:         &Broker{ID: 1001, StorageFree: 0},
+		1002:         &Broker{ID: 1002, StorageFree: -100.00},
+		1003:         &Broker{ID: 1003, StorageFree: 200.00},
+		1004:         &Broker{ID: 1004, StorageFree: 400.00},
+	}
+
+	// Non-positive values are excluded.
+	if m := bm.Mean(); m != 300.00 {
+		t.Errorf("Expected mean 300.00, got %.2f", m)
+	}
+
+	if hm := bm.HMean(); math.Abs(hm-266.67) > 0.01 {
+		t.Errorf("Expected harmonic mean 266.67, got %.2f", hm)
+	}
+
+	// The full and negative brokers are below the mean.
+	expected := []int{1001, 1002, 1003}
+	if results := bm.BelowMean(0.10, bm.HMean); !sameIDs(results, expected) {
+		t.Errorf("Expected %v, got %v", expected, results)
+	}
+
+	// With no positive values, the means are 0 and
+	// no brokers are above or below them.
+	for _, id := range []int{1003, 1004} {
+		bm[id].StorageFree = 0
+	}
+
+	if m, hm := bm.Mean(), bm.HMean(); m != 0 || hm != 0 {
+		t.Errorf("Expected means of 0, got %.2f, %.2f", m, hm)
+	}
+
+	if results := bm.BelowMean(0.10, bm.HMean); len(results) != 0 {
+		t.Errorf("Expected no results, got %v", results)
+	}
+
+	if results := bm.AboveMean(0.10, bm.Mean); len(results) != 0 {
+		t.Errorf("Expected no results, got %v", results)
+	}
+}
+
+func TestStorageUsed(t *testing.T) {
+	// 1002 has the most storage free but the highest percent used.
+	bm := BrokerMap{
+		StubBrokerID: &Broker{ID: StubBrokerID, Replace: true},
+		1001:         &Broker{ID: 1001, StorageFree: 500, StorageTotal: 1000},
+		1002:         &Broker{ID: 1002, StorageFree: 1500, StorageTotal: 10000},
+		1003:         &Broker{ID: 1003, StorageFree: 1700, StorageTotal: 2000},
+		1004:         &Broker{ID: 1004, StorageFree: 1000},
+	}
+
+	expected := map[int]float64{1001: 0.50, 1002: 0.85, 1003: 0.15, 1004: 0}
+	for id, v := range expected {
+		if u := bm[id].StorageUsed(); math.Abs(u-v) > 0.0001 {
+			t.Errorf("Expected broker %d storage used %.2f, got %.2f", id, v, u)
+		}
+	}
+
+	// 1004 has no StorageTotal and is excluded.
+	if m := bm.MeanStorageUsed(); math.Abs(m-0.50) > 0.0001 {
+		t.Errorf("Expected mean storage used 0.50, got %.2f", m)
+	}
+
+	// Balancing by percent used and by storage free target different brokers.
+	if results := bm.AboveMeanStorageUsed(0.20); !sameIDs(results, []int{1002}) {
+		t.Errorf("Expected [1002], got %v", results)
+	}
+
+	if results := bm.BelowMean(0.20, bm.Mean); !sameIDs(results, []int{1001}) {
+		t.Errorf("Expected [1001], got %v", results)
+	}
+
+	if target := bm.StorageFreeTarget(1002); math.Abs(target-5000) > 0.0001 {
+		t.Errorf("Expected storage free target 5000, got %.2f", target)
+	}
+
+	if target := bm.StorageFreeTarget(1004); target != 0 {
+		t.Errorf("Expected storage free target 0, got %.2f", target)
+	}
+
+	// Sort by storage used ascending.
+	bl := bm.Filter(AllBrokersFn).List()
+	bl.SortByStorageUsed()
+
+	order := []int{1004, 1003, 1001, 1002}
+	for i, b := range bl {
+		if b.ID != order[i] {
+			t.Errorf("Expected broker %d at position %d, got %d", order[i], i, b.ID)
+		}
+	}
+}
+
 func TestAboveMean(t *testing.T) {
 	bm := newStubBrokerMap2()
 
@@ -228,6 +450,44 @@ func TestBelowMean(t *testing.T) {
 	}
 }
 
+func TestHeat(t *testing.T) {
+	zk := NewZooKeeperStub()
+	pmm, _ := zk.GetAllPartitionMeta()
+
+	// 1001 leads most partitions and holds every partition.
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+    {"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+    {"topic":"test_topic","partition":1,"replicas":[1001,1003]},
+    {"topic":"test_topic","partition":2,"replicas":[1001,1004]},
+    {"topic":"test_topic","partition":3,"replicas":[1002,1001]}]}`)
+
+	bm := newStubBrokerMap()
+
+	weights := HeatWeights{Storage: 1, Count: 1, Leadership: 1}
+	heat := bm.Heat(pm, pmm, weights)
+
+	if len(heat) != 4 {
+		t.Fatalf("Expected heat for 4 brokers, got %d", len(heat))
+	}
+
+	for id, h := range heat {
+		if id != 1001 && h >= heat[1001] {
+			t.Errorf("Expected broker 1001 to be the hottest, broker %d scored %.2f vs %.2f", id, h, heat[1001])
+		}
+	}
+
+	// With all weight on leadership, the
+	// score is the normalized leader count.
+	heat = bm.Heat(pm, pmm, HeatWeights{Leadership: 1})
+
+	expected := map[int]float64{1001: 1, 1002: 1.0 / 3, 1003: 0, 1004: 0}
+	for id, h := range expected {
+		if math.Abs(heat[id]-h) > 0.0001 {
+			t.Errorf("Expected heat %.2f for broker %d, got %.2f", h, id, heat[id])
+		}
+	}
+}
+
 func sameIDs(a, b []int) bool {
 	if len(a) != len(b) {
 		return false
@@ -241,3 +501,31 @@ func sameIDs(a, b []int) bool {
 
 	return true
 }
+
+func TestMinorityRackLeaders(t *testing.T) {
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1002,1001,1004]},
+		{"topic":"test_topic","partition":1,"replicas":[1001,1004,1002]},
+		{"topic":"test_topic","partition":2,"replicas":[1001,1002]},
+		{"topic":"hinted","partition":0,"replicas":[1001,1002]},
+		{"topic":"hinted","partition":1,"replicas":[1002,1001]}]}`)
+
+	// 1001 and 1004 are in rack a, 1002 in rack b.
+	bm := newStubBrokerMap()
+
+	flagged := pm.MinorityRackLeaders(bm, map[string]string{"hinted": "b"})
+
+	// test_topic p0 is led from rack b with two replicas
+	// in rack a; hinted p0 is led outside of rack b.
+	expected := []string{"hinted 0", "test_topic 0"}
+
+	if len(flagged) != len(expected) {
+		t.Fatalf("Expected %d flagged partitions, got %d: %v", len(expected), len(flagged), flagged)
+	}
+
+	for i, p := range flagged {
+		if s := fmt.Sprintf("%s %d", p.Topic, p.Partition); s != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], s)
+		}
+	}
+}
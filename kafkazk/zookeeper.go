@@ -19,6 +19,12 @@ import (
 var (
 	// ErrInvalidKafkaConfigType error.
 	ErrInvalidKafkaConfigType = errors.New("Invalid Kafka config type")
+	// ErrNoReassignment error.
+	ErrNoReassignment = errors.New("No reassignment in progress")
+	// ErrReassignmentInProgress error.
+	ErrReassignmentInProgress = errors.New("Reassignment already in progress")
+	// ErrNoConsumerLagSource error.
+	ErrNoConsumerLagSource = errors.New("No consumer lag source configured")
 	// validKafkaConfigTypes is used as a set
 	// to define valid configuration type names.
 	validKafkaConfigTypes = map[string]struct{}{
@@ -58,12 +64,17 @@ type Handler interface {
 	GetTopicStateISR(string) (TopicStateISR, error)
 	UpdateKafkaConfig(KafkaConfig) ([]bool, error)
 	GetReassignments() Reassignments
+	DeleteReassignment() error
+	SetReassignment(*PartitionMap) error
 	GetUnderReplicated() ([]string, error)
+	GetUnderReplicatedPartitions() ([]Partition, error)
 	GetPendingDeletion() ([]string, error)
 	GetTopics([]*regexp.Regexp) ([]string, error)
 	GetTopicConfig(string) (*TopicConfig, error)
+	GetThrottleRates() (map[int]ThrottleRate, error)
 	GetAllBrokerMeta(bool) (BrokerMetaMap, []error)
 	GetAllPartitionMeta() (PartitionMetaMap, error)
+	GetConsumerLag(string) (map[int]int64, error)
 	MaxMetaAge() (time.Duration, error)
 	GetPartitionMap(string) (*PartitionMap, error)
 }
@@ -103,6 +114,13 @@ type TopicConfig struct {
 	Config  map[string]string `json:"config"`
 }
 
+// ThrottleRate holds the replication throttle rates, in bytes/sec, set on a
+// broker. A zero value indicates that no throttle is set for that direction.
+type ThrottleRate struct {
+	Leader   float64
+	Follower float64
+}
+
 // KafkaConfig is used to issue configuration updates to either
 // topics or brokers in ZooKeeper.
 type KafkaConfig struct {
@@ -136,17 +154,25 @@ type ZKHandler struct {
 	Connect       string
 	Prefix        string
 	MetricsPrefix string
+	consumerLag   ConsumerLagFunc
 }
 
+// ConsumerLagFunc takes a topic name and returns the consumer
+// lag for the topic as a map of partition number to lag.
+type ConsumerLagFunc func(string) (map[int]int64, error)
+
 // Config holds initialization paramaters for a Handler. Connect
 // is a ZooKeeper connect string. Prefix should reflect any prefix
 // used for Kafka on the reference ZooKeeper cluster (excluding slashes).
 // MetricsPrefix is the prefix used for broker metrics metadata persisted
-// in ZooKeeper.
+// in ZooKeeper. ConsumerLag is an optional source of consumer lag; consumer
+// group offsets aren't stored in ZooKeeper and must be fetched from Kafka
+// (e.g. with kafkaadmin.ConsumerLag).
 type Config struct {
 	Connect       string
 	Prefix        string
 	MetricsPrefix string
+	ConsumerLag   ConsumerLagFunc
 }
 
 // NewHandler takes a *Config, performs
@@ -156,6 +182,7 @@ func NewHandler(c *Config) (Handler, error) {
 		Connect:       c.Connect,
 		Prefix:        c.Prefix,
 		MetricsPrefix: c.MetricsPrefix,
+		consumerLag:   c.ConsumerLag,
 	}
 
 	var err error
@@ -330,6 +357,51 @@ func (z *ZKHandler) GetReassignments() Reassignments {
 	return reassigns
 }
 
+// DeleteReassignment deletes the reassign_partitions znode. This does not
+// cancel an in-progress reassignment; the active controller holds the
+// reassignment in memory and continues running it. Deleting the znode only
+// prevents the reassignment from being resumed following a controller
+// failover. An ErrNoReassignment is returned if no reassignment is in
+// progress.
+func (z *ZKHandler) DeleteReassignment() error {
+	if len(z.GetReassignments()) == 0 {
+		return ErrNoReassignment
+	}
+
+	var path string
+	if z.Prefix != "" {
+		path = fmt.Sprintf("/%s/admin/reassign_partitions", z.Prefix)
+	} else {
+		path = "/admin/reassign_partitions"
+	}
+
+	return z.Delete(path)
+}
+
+// SetReassignment writes the provided *PartitionMap to the reassign_partitions
+// znode, which triggers the controller to begin reassigning the included
+// partitions. An ErrReassignmentInProgress is returned if a reassignment is
+// already in progress.
+func (z *ZKHandler) SetReassignment(pm *PartitionMap) error {
+	if len(z.GetReassignments()) > 0 {
+		return ErrReassignmentInProgress
+	}
+
+	data, err := json.Marshal(pm)
+	if err != nil {
+		return err
+	}
+
+	var path string
+	if z.Prefix != "" {
+		path = fmt.Sprintf("/%s/admin/reassign_partitions", z.Prefix)
+	} else {
+		path = "/admin/reassign_partitions"
+	}
+
+	return z.Create(path, string(data))
+}
+
 // GetPendingDeletion returns any topics pending deletion.
 func (z *ZKHandler) GetPendingDeletion() ([]string, error) {
 	var path string
@@ -353,8 +425,8 @@ func (z *ZKHandler) GetPendingDeletion() ([]string, error) {
 	return p, nil
 }
 
-// GetTopics takes a []*regexp.Regexp and returns a []string of all topic
-// names that match any of the provided regex.
+// GetTopics takes a []*regexp.Regexp and returns a sorted []string of all
+// topic names that match any of the provided regex.
 func (z *ZKHandler) GetTopics(ts []*regexp.Regexp) ([]string, error) {
 	matchingTopics := []string{}
 
@@ -387,6 +459,8 @@ func (z *ZKHandler) GetTopics(ts []*regexp.Regexp) ([]string, error) {
 		matchingTopics = append(matchingTopics, topic)
 	}
 
+	sort.Strings(matchingTopics)
+
 	return matchingTopics, nil
 }
 
@@ -413,6 +487,67 @@ func (z *ZKHandler) GetTopicConfig(t string) (*TopicConfig, error) {
 	return config, nil
 }
 
+// GetThrottleRates returns a mapping of broker ID to the leader and follower
+// replication throttle rates currently set in each broker's dynamic config.
+// Brokers without any throttle set are omitted.
+func (z *ZKHandler) GetThrottleRates() (map[int]ThrottleRate, error) {
+	var path string
+	if z.Prefix != "" {
+		path = fmt.Sprintf("/%s/brokers/ids", z.Prefix)
+	} else {
+		path = "/brokers/ids"
+	}
+
+	entries, err := z.Children(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := map[int]ThrottleRate{}
+
+	for _, b := range entries {
+		bid, err := strconv.Atoi(b)
+		if err != nil {
+			continue
+		}
+
+		if z.Prefix != "" {
+			path = fmt.Sprintf("/%s/config/brokers/%d", z.Prefix, bid)
+		} else {
+			path = fmt.Sprintf("/config/brokers/%d", bid)
+		}
+
+		data, err := z.Get(path)
+		if err != nil {
+			// Brokers that have never had a dynamic
+			// config applied won't have a config znode.
+			if _, ok := err.(ErrNoNode); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		config := NewKafkaConfigData()
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("Error unmarshalling config for broker %d: %s", bid, err)
+		}
+
+		var r ThrottleRate
+		if v, exists := config.Config["leader.replication.throttled.rate"]; exists {
+			r.Leader, _ = strconv.ParseFloat(v, 64)
+		}
+		if v, exists := config.Config["follower.replication.throttled.rate"]; exists {
+			r.Follower, _ = strconv.ParseFloat(v, 64)
+		}
+
+		if r.Leader > 0 || r.Follower > 0 {
+			rates[bid] = r
+		}
+	}
+
+	return rates, nil
+}
+
 // GetAllBrokerMeta looks up all registered Kafka brokers and returns their
 // metadata as a BrokerMetaMap. A withMetrics bool param determines whether
 // we additionally want to fetch stored broker metrics.
@@ -476,6 +611,10 @@ func (z *ZKHandler) GetAllBrokerMeta(withMetrics bool) (BrokerMetaMap, []error)
 				bmm[bid].MetricsIncomplete = true
 			} else {
 				bmm[bid].StorageFree = m.StorageFree
+				bmm[bid].StorageTotal = m.StorageTotal
+				bmm[bid].LogDirStorageFree = m.LogDirStorageFree
+				bmm[bid].InstanceGroup = m.InstanceGroup
+				bmm[bid].MetricsTimestamp = m.MetricsTimestamp
 			}
 		}
 
@@ -547,6 +686,18 @@ func (z *ZKHandler) GetAllPartitionMeta() (PartitionMetaMap, error) {
 	return pmm, nil
 }
 
+// GetConsumerLag takes a topic name and returns the consumer lag for the topic
+// as a map of partition number to lag. Lag is fetched with the ConsumerLagFunc
+// provided in the handler Config; an ErrNoConsumerLagSource is returned if none
+// was provided.
+func (z *ZKHandler) GetConsumerLag(t string) (map[int]int64, error) {
+	if z.consumerLag == nil {
+		return nil, ErrNoConsumerLagSource
+	}
+
+	return z.consumerLag(t)
+}
+
 // MaxMetaAge returns the greatest age between the partitionmeta
 // and brokermetrics stuctures.
 func (z *ZKHandler) MaxMetaAge() (time.Duration, error) {
@@ -623,9 +774,37 @@ func (z *ZKHandler) GetTopicState(t string) (*TopicState, error) {
 	return ts, nil
 }
 
-// GetUnderReplicated returns a []string of all under-replicated topics.
+// GetUnderReplicated returns a []string of all under-replicated topics,
+// sorted by name. See GetUnderReplicatedPartitions.
 func (z *ZKHandler) GetUnderReplicated() ([]string, error) {
-	var underReplicated []string
+	partitions, err := z.GetUnderReplicatedPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	return underReplicatedTopics(partitions), nil
+}
+
+// underReplicatedTopics takes a []Partition sorted by topic and returns a
+// []string of the unique topic names.
+func underReplicatedTopics(partitions []Partition) []string {
+	var topics []string
+
+	for _, p := range partitions {
+		if n := len(topics); n == 0 || topics[n-1] != p.Topic {
+			topics = append(topics, p.Topic)
+		}
+	}
+
+	return topics
+}
+
+// GetUnderReplicatedPartitions returns a []Partition of all under-replicated
+// partitions, sorted by topic and partition. A partition is under-replicated
+// if any broker in its configured replica set isn't in the ISR. The Replicas
+// field of each Partition is the configured replica set.
+func (z *ZKHandler) GetUnderReplicatedPartitions() ([]Partition, error) {
+	var underReplicated PartitionList
 
 	// Get a list of all topics.
 	topics, err := z.GetTopics([]*regexp.Regexp{allTopicsRegexp})
@@ -633,7 +812,6 @@ func (z *ZKHandler) GetUnderReplicated() ([]string, error) {
 		return underReplicated, err
 	}
 
-	// For each topic, compare the configured replica set against the ISR.
 	for _, topic := range topics {
 		configuredState, err := z.GetTopicState(topic)
 		if err != nil {
@@ -645,22 +823,28 @@ func (z *ZKHandler) GetUnderReplicated() ([]string, error) {
 			return underReplicated, err
 		}
 
-		// Compare.
 		for partn, replicaSet := range configuredState.Partitions {
 			state, ok := currentState[partn]
 			if !ok {
 				return underReplicated, fmt.Errorf("Inconsistent configuration and ISR state for %s", topic)
 			}
 
-			// It's cheaper/faster to compare the lengths; there's no known scenario
-			// where a configured replica set would be [a,b,c] but the ISR is [a,b,d].
-			if len(replicaSet) != len(state.ISR) {
-				underReplicated = append(underReplicated, topic)
-				break
+			for _, id := range replicaSet {
+				if !inReplicaSet(id, state.ISR) {
+					p, _ := strconv.Atoi(partn)
+					underReplicated = append(underReplicated, Partition{
+						Topic:     topic,
+						Partition: p,
+						Replicas:  replicaSet,
+					})
+					break
+				}
 			}
 		}
 	}
 
+	sort.Sort(underReplicated)
+
 	return underReplicated, nil
 }
 
@@ -365,6 +365,89 @@ func TestGetUnderReplicated(t *testing.T) {
 	}
 }
 
+func TestGetUnderReplicatedPartitions(t *testing.T) {
+	ur, err := zki.GetUnderReplicatedPartitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ur) != 1 {
+		t.Fatalf("Expected 1 under replicated partition, got %d", len(ur))
+	}
+
+	expected := Partition{Topic: "topic2", Partition: 0, Replicas: []int{1001, 1002}}
+	if !ur[0].Equal(expected) {
+		t.Errorf("Expected %v, got %v", expected, ur[0])
+	}
+}
+
+func TestDeleteReassignment(t *testing.T) {
+	path := zkprefix + "/admin/reassign_partitions"
+
+	// Store the current reassignment to restore
+	// it for subsequent tests.
+	data, _, err := zkc.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zki.DeleteReassignment(); err != nil {
+		t.Fatal(err)
+	}
+
+	if re := zki.GetReassignments(); len(re) != 0 {
+		t.Errorf("Expected no reassignments, got %v", re)
+	}
+
+	// A second delete is a no-op.
+	if err := zki.DeleteReassignment(); err != ErrNoReassignment {
+		t.Errorf("Expected ErrNoReassignment, got %v", err)
+	}
+
+	if _, err := zkc.Create(path, data, 0, zkclient.WorldACL(31)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetReassignment(t *testing.T) {
+	path := zkprefix + "/admin/reassign_partitions"
+
+	pm, _ := PartitionMapFromString(`{"version":1,"partitions":[{"topic":"topic0","partition":0,"replicas":[1001,1002]}]}`)
+
+	// A reassignment is already in progress.
+	if err := zki.SetReassignment(pm); err != ErrReassignmentInProgress {
+		t.Fatalf("Expected ErrReassignmentInProgress, got %v", err)
+	}
+
+	// Store the current reassignment to restore
+	// it for subsequent tests.
+	data, _, err := zkc.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zki.DeleteReassignment(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zki.SetReassignment(pm); err != nil {
+		t.Fatal(err)
+	}
+
+	re := zki.GetReassignments()
+	if !intsEqual(re["topic0"][0], []int{1001, 1002}) {
+		t.Errorf("Expected reassignment %v, got %v", pm.Partitions[0].Replicas, re["topic0"][0])
+	}
+
+	if err := zki.DeleteReassignment(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := zkc.Create(path, data, 0, zkclient.WorldACL(31)); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGetReassignments(t *testing.T) {
 	re := zki.GetReassignments()
 
@@ -644,6 +727,33 @@ func TestGetAllPartitionMetaCompressed(t *testing.T) {
 
 }
 
+func TestGetConsumerLag(t *testing.T) {
+	// No lag source was configured.
+	_, err := zki.GetConsumerLag("topic0")
+	if err != ErrNoConsumerLagSource {
+		t.Errorf("Expected error '%s', got '%v'", ErrNoConsumerLagSource, err)
+	}
+
+	zkl := &ZKHandler{
+		consumerLag: func(t string) (map[int]int64, error) {
+			return map[int]int64{0: 0, 1: 100}, nil
+		},
+	}
+
+	lag, err := zkl.GetConsumerLag("topic0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[int]int64{0: 0, 1: 100}
+
+	for partn, l := range expected {
+		if lag[partn] != l {
+			t.Errorf("Expected lag %d for topic0 p%d, got %d", l, partn, lag[partn])
+		}
+	}
+}
+
 func TestOldestMetaTs(t *testing.T) {
 	// Init a ZKHandler.
 	var configPrefix string
@@ -840,6 +950,24 @@ func TestUpdateKafkaConfigBroker(t *testing.T) {
 	}
 }
 
+func TestGetThrottleRates(t *testing.T) {
+	// Depends on the broker 1001 throttle
+	// set in TestUpdateKafkaConfigBroker.
+	rates, err := zki.GetThrottleRates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rates) != 1 {
+		t.Fatalf("Expected throttle rates for 1 broker, got %d", len(rates))
+	}
+
+	expected := ThrottleRate{Leader: 100000, Follower: 100000}
+	if rates[1001] != expected {
+		t.Errorf("Expected rates %v, got %v", expected, rates[1001])
+	}
+}
+
 func TestUpdateKafkaConfigTopic(t *testing.T) {
 	c := KafkaConfig{
 		Type: "topic",
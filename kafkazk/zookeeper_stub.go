@@ -4,8 +4,10 @@
 package kafkazk
 
 import (
+	"encoding/json"
 	"errors"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -16,8 +18,9 @@ var (
 
 // Stub stubs the Handler interface.
 type Stub struct {
-	bmm  BrokerMetaMap
-	data map[string]*StubZnode
+	bmm     BrokerMetaMap
+	data    map[string]*StubZnode
+	deleted bool
 }
 
 // StubZnode stubs a ZooKeeper znode.
@@ -60,8 +63,13 @@ func (zk *Stub) AddBrokers(b map[int]BrokerMeta) {
 
 // Many of these methods aren't complete stubs as they haven't been needed.
 
-// GetReassignments stubs GetReassignments.
+// GetReassignments stubs GetReassignments. No reassignments
+// are returned following a call to DeleteReassignment.
 func (zk *Stub) GetReassignments() Reassignments {
+	if zk.deleted {
+		return Reassignments{}
+	}
+
 	r := Reassignments{
 		"reassigning_topic": map[int][]int{
 			0: {1003, 1000, 1002},
@@ -71,8 +79,43 @@ func (zk *Stub) GetReassignments() Reassignments {
 	return r
 }
 
+// DeleteReassignment stubs DeleteReassignment.
+func (zk *Stub) DeleteReassignment() error {
+	if len(zk.GetReassignments()) == 0 {
+		return ErrNoReassignment
+	}
+
+	zk.deleted = true
+
+	return nil
+}
+
+// SetReassignment stubs SetReassignment.
+func (zk *Stub) SetReassignment(pm *PartitionMap) error {
+	if len(zk.GetReassignments()) > 0 {
+		return ErrReassignmentInProgress
+	}
+
+	data, err := json.Marshal(pm)
+	if err != nil {
+		return err
+	}
+
+	return zk.Create("/admin/reassign_partitions", string(data))
+}
+
+// GetUnderReplicated stubs GetUnderReplicated.
 func (zk *Stub) GetUnderReplicated() ([]string, error) {
-	return []string{"underreplicated_topic"}, nil
+	partitions, _ := zk.GetUnderReplicatedPartitions()
+	return underReplicatedTopics(partitions), nil
+}
+
+// GetUnderReplicatedPartitions stubs GetUnderReplicatedPartitions. The
+// partitions returned are consistent with GetTopicState and GetTopicStateISR.
+func (zk *Stub) GetUnderReplicatedPartitions() ([]Partition, error) {
+	return []Partition{
+		{Topic: "underreplicated_topic", Partition: 0, Replicas: []int{1000, 1001}},
+	}, nil
 }
 
 func (zk *Stub) GetPendingDeletion() ([]string, error) {
@@ -302,6 +345,8 @@ func (zk *Stub) GetTopics(ts []*regexp.Regexp) ([]string, error) {
 		matched = append(matched, topic)
 	}
 
+	sort.Strings(matched)
+
 	return matched, nil
 }
 
@@ -317,6 +362,15 @@ func (zk *Stub) GetTopicConfig(t string) (*TopicConfig, error) {
 	}, nil
 }
 
+// GetThrottleRates stubs GetThrottleRates. Brokers 1001 and 1002 have
+// throttles of 100MB/s set.
+func (zk *Stub) GetThrottleRates() (map[int]ThrottleRate, error) {
+	return map[int]ThrottleRate{
+		1001: {Leader: 100000000.00, Follower: 100000000.00},
+		1002: {Leader: 100000000.00, Follower: 100000000.00},
+	}, nil
+}
+
 // GetAllBrokerMeta stubs GetAllBrokerMeta.
 func (zk *Stub) GetAllBrokerMeta(withMetrics bool) (BrokerMetaMap, []error) {
 	b := zk.bmm.Copy()
@@ -326,6 +380,9 @@ func (zk *Stub) GetAllBrokerMeta(withMetrics bool) (BrokerMetaMap, []error) {
 
 		for bid := range b {
 			b[bid].StorageFree = m[bid].StorageFree
+			b[bid].StorageTotal = m[bid].StorageTotal
+			b[bid].LogDirStorageFree = copyLogDirStorageFree(m[bid].LogDirStorageFree)
+			b[bid].MetricsTimestamp = m[bid].MetricsTimestamp
 		}
 	}
 
@@ -335,8 +392,8 @@ func (zk *Stub) GetAllBrokerMeta(withMetrics bool) (BrokerMetaMap, []error) {
 // GetBrokerMetrics stubs GetBrokerMetrics.
 func (zk *Stub) GetBrokerMetrics() (BrokerMetricsMap, error) {
 	bm := BrokerMetricsMap{
-		1001: &BrokerMetrics{StorageFree: 2000.00},
-		1002: &BrokerMetrics{StorageFree: 4000.00},
+		1001: &BrokerMetrics{StorageFree: 2000.00, StorageTotal: 16000.00},
+		1002: &BrokerMetrics{StorageFree: 4000.00, StorageTotal: 16000.00},
 		1003: &BrokerMetrics{StorageFree: 6000.00},
 		1004: &BrokerMetrics{StorageFree: 8000.00},
 		1005: &BrokerMetrics{StorageFree: 10000.00},
@@ -361,6 +418,22 @@ func (zk *Stub) GetAllPartitionMeta() (PartitionMetaMap, error) {
 	return pm, nil
 }
 
+// GetConsumerLag stubs GetConsumerLag.
+func (zk *Stub) GetConsumerLag(t string) (map[int]int64, error) {
+	lag := map[int]int64{}
+
+	if t == "test_topic" {
+		lag = map[int]int64{
+			0: 0,
+			1: 120000,
+			2: 50,
+			3: 8000,
+		}
+	}
+
+	return lag, nil
+}
+
 // GetPartitionMap stubs GetPartitionMap.
 func (zk *Stub) GetPartitionMap(t string) (*PartitionMap, error) {
 	p := &PartitionMap{
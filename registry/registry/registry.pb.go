@@ -350,6 +350,108 @@ func (x *Broker) GetVersion() uint32 {
 	return 0
 }
 
+type BrokerThrottle struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	LeaderRate   uint64 `protobuf:"varint,1,opt,name=leader_rate,json=leaderRate,proto3" json:"leader_rate,omitempty"`
+	FollowerRate uint64 `protobuf:"varint,2,opt,name=follower_rate,json=followerRate,proto3" json:"follower_rate,omitempty"`
+}
+
+func (x *BrokerThrottle) Reset() {
+	*x = BrokerThrottle{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_registry_registry_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BrokerThrottle) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BrokerThrottle) ProtoMessage() {}
+
+func (x *BrokerThrottle) ProtoReflect() protoreflect.Message {
+	mi := &file_registry_registry_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BrokerThrottle.ProtoReflect.Descriptor instead.
+func (*BrokerThrottle) Descriptor() ([]byte, []int) {
+	return file_registry_registry_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BrokerThrottle) GetLeaderRate() uint64 {
+	if x != nil {
+		return x.LeaderRate
+	}
+	return 0
+}
+
+func (x *BrokerThrottle) GetFollowerRate() uint64 {
+	if x != nil {
+		return x.FollowerRate
+	}
+	return 0
+}
+
+type ThrottleResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Throttles map[uint32]*BrokerThrottle `protobuf:"bytes,1,rep,name=throttles,proto3" json:"throttles,omitempty" protobuf_key:"varint,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *ThrottleResponse) Reset() {
+	*x = ThrottleResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_registry_registry_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ThrottleResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ThrottleResponse) ProtoMessage() {}
+
+func (x *ThrottleResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_registry_registry_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ThrottleResponse.ProtoReflect.Descriptor instead.
+func (*ThrottleResponse) Descriptor() ([]byte, []int) {
+	return file_registry_registry_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ThrottleResponse) GetThrottles() map[uint32]*BrokerThrottle {
+	if x != nil {
+		return x.Throttles
+	}
+	return nil
+}
+
 type TopicRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -363,7 +465,7 @@ type TopicRequest struct {
 func (x *TopicRequest) Reset() {
 	*x = TopicRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_registry_registry_proto_msgTypes[5]
+		mi := &file_registry_registry_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -376,7 +478,7 @@ func (x *TopicRequest) String() string {
 func (*TopicRequest) ProtoMessage() {}
 
 func (x *TopicRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_registry_registry_proto_msgTypes[5]
+	mi := &file_registry_registry_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -389,7 +491,7 @@ func (x *TopicRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TopicRequest.ProtoReflect.Descriptor instead.
 func (*TopicRequest) Descriptor() ([]byte, []int) {
-	return file_registry_registry_proto_rawDescGZIP(), []int{5}
+	return file_registry_registry_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *TopicRequest) GetTag() []string {
@@ -425,7 +527,7 @@ type CreateTopicRequest struct {
 func (x *CreateTopicRequest) Reset() {
 	*x = CreateTopicRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_registry_registry_proto_msgTypes[6]
+		mi := &file_registry_registry_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -438,7 +540,7 @@ func (x *CreateTopicRequest) String() string {
 func (*CreateTopicRequest) ProtoMessage() {}
 
 func (x *CreateTopicRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_registry_registry_proto_msgTypes[6]
+	mi := &file_registry_registry_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -451,7 +553,7 @@ func (x *CreateTopicRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CreateTopicRequest.ProtoReflect.Descriptor instead.
 func (*CreateTopicRequest) Descriptor() ([]byte, []int) {
-	return file_registry_registry_proto_rawDescGZIP(), []int{6}
+	return file_registry_registry_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *CreateTopicRequest) GetTopic() *Topic {
@@ -468,6 +570,53 @@ func (x *CreateTopicRequest) GetTargetBrokerTags() []string {
 	return nil
 }
 
+type ReassignPartitionsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	PartitionMap string `protobuf:"bytes,1,opt,name=partition_map,json=partitionMap,proto3" json:"partition_map,omitempty"`
+}
+
+func (x *ReassignPartitionsRequest) Reset() {
+	*x = ReassignPartitionsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_registry_registry_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReassignPartitionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReassignPartitionsRequest) ProtoMessage() {}
+
+func (x *ReassignPartitionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_registry_registry_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReassignPartitionsRequest.ProtoReflect.Descriptor instead.
+func (*ReassignPartitionsRequest) Descriptor() ([]byte, []int) {
+	return file_registry_registry_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ReassignPartitionsRequest) GetPartitionMap() string {
+	if x != nil {
+		return x.PartitionMap
+	}
+	return ""
+}
+
 type TopicResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -480,7 +629,7 @@ type TopicResponse struct {
 func (x *TopicResponse) Reset() {
 	*x = TopicResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_registry_registry_proto_msgTypes[7]
+		mi := &file_registry_registry_proto_msgTypes[10]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -493,7 +642,7 @@ func (x *TopicResponse) String() string {
 func (*TopicResponse) ProtoMessage() {}
 
 func (x *TopicResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_registry_registry_proto_msgTypes[7]
+	mi := &file_registry_registry_proto_msgTypes[10]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -506,7 +655,7 @@ func (x *TopicResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TopicResponse.ProtoReflect.Descriptor instead.
 func (*TopicResponse) Descriptor() ([]byte, []int) {
-	return file_registry_registry_proto_rawDescGZIP(), []int{7}
+	return file_registry_registry_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *TopicResponse) GetTopics() map[string]*Topic {
@@ -540,7 +689,7 @@ type Topic struct {
 func (x *Topic) Reset() {
 	*x = Topic{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_registry_registry_proto_msgTypes[8]
+		mi := &file_registry_registry_proto_msgTypes[11]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -553,7 +702,7 @@ func (x *Topic) String() string {
 func (*Topic) ProtoMessage() {}
 
 func (x *Topic) ProtoReflect() protoreflect.Message {
-	mi := &file_registry_registry_proto_msgTypes[8]
+	mi := &file_registry_registry_proto_msgTypes[11]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -566,7 +715,7 @@ func (x *Topic) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Topic.ProtoReflect.Descriptor instead.
 func (*Topic) Descriptor() ([]byte, []int) {
-	return file_registry_registry_proto_rawDescGZIP(), []int{8}
+	return file_registry_registry_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *Topic) GetTags() map[string]string {
@@ -616,7 +765,7 @@ type OffsetMapping struct {
 func (x *OffsetMapping) Reset() {
 	*x = OffsetMapping{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_registry_registry_proto_msgTypes[9]
+		mi := &file_registry_registry_proto_msgTypes[12]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -629,7 +778,7 @@ func (x *OffsetMapping) String() string {
 func (*OffsetMapping) ProtoMessage() {}
 
 func (x *OffsetMapping) ProtoReflect() protoreflect.Message {
-	mi := &file_registry_registry_proto_msgTypes[9]
+	mi := &file_registry_registry_proto_msgTypes[12]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -642,7 +791,7 @@ func (x *OffsetMapping) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use OffsetMapping.ProtoReflect.Descriptor instead.
 func (*OffsetMapping) Descriptor() ([]byte, []int) {
-	return file_registry_registry_proto_rawDescGZIP(), []int{9}
+	return file_registry_registry_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *OffsetMapping) GetUpstreamOffset() uint64 {
@@ -671,7 +820,7 @@ type TranslateOffsetRequest struct {
 func (x *TranslateOffsetRequest) Reset() {
 	*x = TranslateOffsetRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_registry_registry_proto_msgTypes[10]
+		mi := &file_registry_registry_proto_msgTypes[13]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -684,7 +833,7 @@ func (x *TranslateOffsetRequest) String() string {
 func (*TranslateOffsetRequest) ProtoMessage() {}
 
 func (x *TranslateOffsetRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_registry_registry_proto_msgTypes[10]
+	mi := &file_registry_registry_proto_msgTypes[13]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -697,7 +846,7 @@ func (x *TranslateOffsetRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TranslateOffsetRequest.ProtoReflect.Descriptor instead.
 func (*TranslateOffsetRequest) Descriptor() ([]byte, []int) {
-	return file_registry_registry_proto_rawDescGZIP(), []int{10}
+	return file_registry_registry_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *TranslateOffsetRequest) GetRemoteClusterAlias() string {
@@ -725,7 +874,7 @@ type TranslateOffsetResponse struct {
 func (x *TranslateOffsetResponse) Reset() {
 	*x = TranslateOffsetResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_registry_registry_proto_msgTypes[11]
+		mi := &file_registry_registry_proto_msgTypes[14]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -738,7 +887,7 @@ func (x *TranslateOffsetResponse) String() string {
 func (*TranslateOffsetResponse) ProtoMessage() {}
 
 func (x *TranslateOffsetResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_registry_registry_proto_msgTypes[11]
+	mi := &file_registry_registry_proto_msgTypes[14]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -751,7 +900,7 @@ func (x *TranslateOffsetResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TranslateOffsetResponse.ProtoReflect.Descriptor instead.
 func (*TranslateOffsetResponse) Descriptor() ([]byte, []int) {
-	return file_registry_registry_proto_rawDescGZIP(), []int{11}
+	return file_registry_registry_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *TranslateOffsetResponse) GetOffsets() map[string]*OffsetMapping {
@@ -770,7 +919,7 @@ type Empty struct {
 func (x *Empty) Reset() {
 	*x = Empty{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_registry_registry_proto_msgTypes[12]
+		mi := &file_registry_registry_proto_msgTypes[15]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -783,7 +932,7 @@ func (x *Empty) String() string {
 func (*Empty) ProtoMessage() {}
 
 func (x *Empty) ProtoReflect() protoreflect.Message {
-	mi := &file_registry_registry_proto_msgTypes[12]
+	mi := &file_registry_registry_proto_msgTypes[15]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -796,7 +945,7 @@ func (x *Empty) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Empty.ProtoReflect.Descriptor instead.
 func (*Empty) Descriptor() ([]byte, []int) {
-	return file_registry_registry_proto_rawDescGZIP(), []int{12}
+	return file_registry_registry_proto_rawDescGZIP(), []int{15}
 }
 
 var File_registry_registry_proto protoreflect.FileDescriptor
@@ -857,19 +1006,40 @@ var file_registry_registry_proto_rawDesc = []byte{
 	0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x6d, 0x61, 0x70, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10,
 	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79,
 	0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x50, 0x0a, 0x0c, 0x54, 0x6f,
-	0x70, 0x69, 0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x74, 0x61,
-	0x67, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x74, 0x61, 0x67, 0x12, 0x12, 0x0a, 0x04,
-	0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65,
-	0x12, 0x1a, 0x0a, 0x08, 0x73, 0x70, 0x61, 0x6e, 0x6e, 0x69, 0x6e, 0x67, 0x18, 0x03, 0x20, 0x01,
-	0x28, 0x08, 0x52, 0x08, 0x73, 0x70, 0x61, 0x6e, 0x6e, 0x69, 0x6e, 0x67, 0x22, 0x69, 0x0a, 0x12,
-	0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x54, 0x6f, 0x70, 0x69, 0x63, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x12, 0x25, 0x0a, 0x05, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x2e, 0x54, 0x6f, 0x70,
-	0x69, 0x63, 0x52, 0x05, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x12, 0x2c, 0x0a, 0x12, 0x74, 0x61, 0x72,
-	0x67, 0x65, 0x74, 0x5f, 0x62, 0x72, 0x6f, 0x6b, 0x65, 0x72, 0x5f, 0x74, 0x61, 0x67, 0x73, 0x18,
-	0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x10, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x42, 0x72, 0x6f,
-	0x6b, 0x65, 0x72, 0x54, 0x61, 0x67, 0x73, 0x22, 0xae, 0x01, 0x0a, 0x0d, 0x54, 0x6f, 0x70, 0x69,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x56, 0x0a, 0x0e, 0x42, 0x72,
+	0x6f, 0x6b, 0x65, 0x72, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x12, 0x1f, 0x0a, 0x0b,
+	0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x0a, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x52, 0x61, 0x74, 0x65, 0x12, 0x23, 0x0a,
+	0x0d, 0x66, 0x6f, 0x6c, 0x6c, 0x6f, 0x77, 0x65, 0x72, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x66, 0x6f, 0x6c, 0x6c, 0x6f, 0x77, 0x65, 0x72, 0x52, 0x61,
+	0x74, 0x65, 0x22, 0xb3, 0x01, 0x0a, 0x10, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x47, 0x0a, 0x09, 0x74, 0x68, 0x72, 0x6f, 0x74,
+	0x74, 0x6c, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x29, 0x2e, 0x72, 0x65, 0x67,
+	0x69, 0x73, 0x74, 0x72, 0x79, 0x2e, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x09, 0x74, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x73,
+	0x1a, 0x56, 0x0a, 0x0e, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x73, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x03, 0x6b, 0x65, 0x79, 0x12, 0x2e, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x2e, 0x42,
+	0x72, 0x6f, 0x6b, 0x65, 0x72, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x52, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x50, 0x0a, 0x0c, 0x54, 0x6f, 0x70, 0x69,
+	0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x74, 0x61, 0x67, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x74, 0x61, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61,
+	0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1a,
+	0x0a, 0x08, 0x73, 0x70, 0x61, 0x6e, 0x6e, 0x69, 0x6e, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x08, 0x73, 0x70, 0x61, 0x6e, 0x6e, 0x69, 0x6e, 0x67, 0x22, 0x69, 0x0a, 0x12, 0x43, 0x72,
+	0x65, 0x61, 0x74, 0x65, 0x54, 0x6f, 0x70, 0x69, 0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x25, 0x0a, 0x05, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x0f, 0x2e, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x2e, 0x54, 0x6f, 0x70, 0x69, 0x63,
+	0x52, 0x05, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x12, 0x2c, 0x0a, 0x12, 0x74, 0x61, 0x72, 0x67, 0x65,
+	0x74, 0x5f, 0x62, 0x72, 0x6f, 0x6b, 0x65, 0x72, 0x5f, 0x74, 0x61, 0x67, 0x73, 0x18, 0x02, 0x20,
+	0x03, 0x28, 0x09, 0x52, 0x10, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x42, 0x72, 0x6f, 0x6b, 0x65,
+	0x72, 0x54, 0x61, 0x67, 0x73, 0x22, 0x40, 0x0a, 0x19, 0x52, 0x65, 0x61, 0x73, 0x73, 0x69, 0x67,
+	0x6e, 0x50, 0x61, 0x72, 0x74, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x23, 0x0a, 0x0d, 0x70, 0x61, 0x72, 0x74, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x5f,
+	0x6d, 0x61, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x70, 0x61, 0x72, 0x74, 0x69,
+	0x74, 0x69, 0x6f, 0x6e, 0x4d, 0x61, 0x70, 0x22, 0xae, 0x01, 0x0a, 0x0d, 0x54, 0x6f, 0x70, 0x69,
 	0x63, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x3b, 0x0a, 0x06, 0x74, 0x6f, 0x70,
 	0x69, 0x63, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x23, 0x2e, 0x72, 0x65, 0x67, 0x69,
 	0x73, 0x74, 0x72, 0x79, 0x2e, 0x54, 0x6f, 0x70, 0x69, 0x63, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
@@ -924,7 +1094,7 @@ var file_registry_registry_proto_rawDesc = []byte{
 	0x12, 0x2d, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32,
 	0x17, 0x2e, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x2e, 0x4f, 0x66, 0x66, 0x73, 0x65,
 	0x74, 0x4d, 0x61, 0x70, 0x70, 0x69, 0x6e, 0x67, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a,
-	0x02, 0x38, 0x01, 0x22, 0x07, 0x0a, 0x05, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x32, 0xa7, 0x0c, 0x0a,
+	0x02, 0x38, 0x01, 0x22, 0x07, 0x0a, 0x05, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x32, 0x85, 0x0e, 0x0a,
 	0x08, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x12, 0x54, 0x0a, 0x0a, 0x47, 0x65, 0x74,
 	0x42, 0x72, 0x6f, 0x6b, 0x65, 0x72, 0x73, 0x12, 0x17, 0x2e, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74,
 	0x72, 0x79, 0x2e, 0x42, 0x72, 0x6f, 0x6b, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
@@ -1023,10 +1193,24 @@ var file_registry_registry_proto_rawDesc = []byte{
 	0x76, 0x31, 0x2f, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6c, 0x61, 0x74, 0x65, 0x2d, 0x6f, 0x66, 0x66,
 	0x73, 0x65, 0x74, 0x73, 0x2f, 0x7b, 0x72, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x5f, 0x63, 0x6c, 0x75,
 	0x73, 0x74, 0x65, 0x72, 0x5f, 0x61, 0x6c, 0x69, 0x61, 0x73, 0x7d, 0x2f, 0x7b, 0x67, 0x72, 0x6f,
-	0x75, 0x70, 0x5f, 0x69, 0x64, 0x7d, 0x42, 0x30, 0x5a, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
-	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x44, 0x61, 0x74, 0x61, 0x44, 0x6f, 0x67, 0x2f, 0x6b, 0x61, 0x66,
-	0x6b, 0x61, 0x2d, 0x6b, 0x69, 0x74, 0x2f, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x2f,
-	0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x75, 0x70, 0x5f, 0x69, 0x64, 0x7d, 0x12, 0x68, 0x0a, 0x12, 0x47, 0x65, 0x74, 0x42, 0x72, 0x6f,
+	0x6b, 0x65, 0x72, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x73, 0x12, 0x17, 0x2e, 0x72,
+	0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x2e, 0x42, 0x72, 0x6f, 0x6b, 0x65, 0x72, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79,
+	0x2e, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x22, 0x1d, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x17, 0x12, 0x15, 0x2f, 0x76, 0x31, 0x2f, 0x62,
+	0x72, 0x6f, 0x6b, 0x65, 0x72, 0x73, 0x2f, 0x74, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x65, 0x73,
+	0x12, 0x72, 0x0a, 0x12, 0x52, 0x65, 0x61, 0x73, 0x73, 0x69, 0x67, 0x6e, 0x50, 0x61, 0x72, 0x74,
+	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x23, 0x2e, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72,
+	0x79, 0x2e, 0x52, 0x65, 0x61, 0x73, 0x73, 0x69, 0x67, 0x6e, 0x50, 0x61, 0x72, 0x74, 0x69, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x72, 0x65,
+	0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x2e, 0x54, 0x6f, 0x70, 0x69, 0x63, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x22, 0x1e, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x18, 0x22, 0x13, 0x2f, 0x76,
+	0x31, 0x2f, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x73, 0x2f, 0x72, 0x65, 0x61, 0x73, 0x73, 0x69, 0x67,
+	0x6e, 0x3a, 0x01, 0x2a, 0x42, 0x30, 0x5a, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
+	0x6f, 0x6d, 0x2f, 0x44, 0x61, 0x74, 0x61, 0x44, 0x6f, 0x67, 0x2f, 0x6b, 0x61, 0x66, 0x6b, 0x61,
+	0x2d, 0x6b, 0x69, 0x74, 0x2f, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x2f, 0x72, 0x65,
+	0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -1041,78 +1225,88 @@ func file_registry_registry_proto_rawDescGZIP() []byte {
 	return file_registry_registry_proto_rawDescData
 }
 
-var file_registry_registry_proto_msgTypes = make([]protoimpl.MessageInfo, 20)
+var file_registry_registry_proto_msgTypes = make([]protoimpl.MessageInfo, 24)
 var file_registry_registry_proto_goTypes = []interface{}{
-	(*TagResponse)(nil),             // 0: registry.TagResponse
-	(*BrokerRequest)(nil),           // 1: registry.BrokerRequest
-	(*BrokerResponse)(nil),          // 2: registry.BrokerResponse
-	(*UnmappedBrokersRequest)(nil),  // 3: registry.UnmappedBrokersRequest
-	(*Broker)(nil),                  // 4: registry.Broker
-	(*TopicRequest)(nil),            // 5: registry.TopicRequest
-	(*CreateTopicRequest)(nil),      // 6: registry.CreateTopicRequest
-	(*TopicResponse)(nil),           // 7: registry.TopicResponse
-	(*Topic)(nil),                   // 8: registry.Topic
-	(*OffsetMapping)(nil),           // 9: registry.OffsetMapping
-	(*TranslateOffsetRequest)(nil),  // 10: registry.TranslateOffsetRequest
-	(*TranslateOffsetResponse)(nil), // 11: registry.TranslateOffsetResponse
-	(*Empty)(nil),                   // 12: registry.Empty
-	nil,                             // 13: registry.BrokerResponse.BrokersEntry
-	nil,                             // 14: registry.Broker.TagsEntry
-	nil,                             // 15: registry.Broker.ListenersecurityprotocolmapEntry
-	nil,                             // 16: registry.TopicResponse.TopicsEntry
-	nil,                             // 17: registry.Topic.TagsEntry
-	nil,                             // 18: registry.Topic.ConfigsEntry
-	nil,                             // 19: registry.TranslateOffsetResponse.OffsetsEntry
+	(*TagResponse)(nil),               // 0: registry.TagResponse
+	(*BrokerRequest)(nil),             // 1: registry.BrokerRequest
+	(*BrokerResponse)(nil),            // 2: registry.BrokerResponse
+	(*UnmappedBrokersRequest)(nil),    // 3: registry.UnmappedBrokersRequest
+	(*Broker)(nil),                    // 4: registry.Broker
+	(*BrokerThrottle)(nil),            // 5: registry.BrokerThrottle
+	(*ThrottleResponse)(nil),          // 6: registry.ThrottleResponse
+	(*TopicRequest)(nil),              // 7: registry.TopicRequest
+	(*CreateTopicRequest)(nil),        // 8: registry.CreateTopicRequest
+	(*ReassignPartitionsRequest)(nil), // 9: registry.ReassignPartitionsRequest
+	(*TopicResponse)(nil),             // 10: registry.TopicResponse
+	(*Topic)(nil),                     // 11: registry.Topic
+	(*OffsetMapping)(nil),             // 12: registry.OffsetMapping
+	(*TranslateOffsetRequest)(nil),    // 13: registry.TranslateOffsetRequest
+	(*TranslateOffsetResponse)(nil),   // 14: registry.TranslateOffsetResponse
+	(*Empty)(nil),                     // 15: registry.Empty
+	nil,                               // 16: registry.BrokerResponse.BrokersEntry
+	nil,                               // 17: registry.Broker.TagsEntry
+	nil,                               // 18: registry.Broker.ListenersecurityprotocolmapEntry
+	nil,                               // 19: registry.ThrottleResponse.ThrottlesEntry
+	nil,                               // 20: registry.TopicResponse.TopicsEntry
+	nil,                               // 21: registry.Topic.TagsEntry
+	nil,                               // 22: registry.Topic.ConfigsEntry
+	nil,                               // 23: registry.TranslateOffsetResponse.OffsetsEntry
 }
 var file_registry_registry_proto_depIdxs = []int32{
-	13, // 0: registry.BrokerResponse.brokers:type_name -> registry.BrokerResponse.BrokersEntry
-	14, // 1: registry.Broker.tags:type_name -> registry.Broker.TagsEntry
-	15, // 2: registry.Broker.listenersecurityprotocolmap:type_name -> registry.Broker.ListenersecurityprotocolmapEntry
-	8,  // 3: registry.CreateTopicRequest.topic:type_name -> registry.Topic
-	16, // 4: registry.TopicResponse.topics:type_name -> registry.TopicResponse.TopicsEntry
-	17, // 5: registry.Topic.tags:type_name -> registry.Topic.TagsEntry
-	18, // 6: registry.Topic.configs:type_name -> registry.Topic.ConfigsEntry
-	19, // 7: registry.TranslateOffsetResponse.offsets:type_name -> registry.TranslateOffsetResponse.OffsetsEntry
-	4,  // 8: registry.BrokerResponse.BrokersEntry.value:type_name -> registry.Broker
-	8,  // 9: registry.TopicResponse.TopicsEntry.value:type_name -> registry.Topic
-	9,  // 10: registry.TranslateOffsetResponse.OffsetsEntry.value:type_name -> registry.OffsetMapping
-	1,  // 11: registry.Registry.GetBrokers:input_type -> registry.BrokerRequest
-	1,  // 12: registry.Registry.ListBrokers:input_type -> registry.BrokerRequest
-	3,  // 13: registry.Registry.UnmappedBrokers:input_type -> registry.UnmappedBrokersRequest
-	5,  // 14: registry.Registry.GetTopics:input_type -> registry.TopicRequest
-	5,  // 15: registry.Registry.ListTopics:input_type -> registry.TopicRequest
-	6,  // 16: registry.Registry.CreateTopic:input_type -> registry.CreateTopicRequest
-	5,  // 17: registry.Registry.DeleteTopic:input_type -> registry.TopicRequest
-	12, // 18: registry.Registry.ReassigningTopics:input_type -> registry.Empty
-	12, // 19: registry.Registry.UnderReplicatedTopics:input_type -> registry.Empty
-	5,  // 20: registry.Registry.TopicMappings:input_type -> registry.TopicRequest
-	1,  // 21: registry.Registry.BrokerMappings:input_type -> registry.BrokerRequest
-	5,  // 22: registry.Registry.TagTopic:input_type -> registry.TopicRequest
-	5,  // 23: registry.Registry.DeleteTopicTags:input_type -> registry.TopicRequest
-	1,  // 24: registry.Registry.TagBroker:input_type -> registry.BrokerRequest
-	1,  // 25: registry.Registry.DeleteBrokerTags:input_type -> registry.BrokerRequest
-	10, // 26: registry.Registry.TranslateOffsets:input_type -> registry.TranslateOffsetRequest
-	2,  // 27: registry.Registry.GetBrokers:output_type -> registry.BrokerResponse
-	2,  // 28: registry.Registry.ListBrokers:output_type -> registry.BrokerResponse
-	2,  // 29: registry.Registry.UnmappedBrokers:output_type -> registry.BrokerResponse
-	7,  // 30: registry.Registry.GetTopics:output_type -> registry.TopicResponse
-	7,  // 31: registry.Registry.ListTopics:output_type -> registry.TopicResponse
-	12, // 32: registry.Registry.CreateTopic:output_type -> registry.Empty
-	12, // 33: registry.Registry.DeleteTopic:output_type -> registry.Empty
-	7,  // 34: registry.Registry.ReassigningTopics:output_type -> registry.TopicResponse
-	7,  // 35: registry.Registry.UnderReplicatedTopics:output_type -> registry.TopicResponse
-	2,  // 36: registry.Registry.TopicMappings:output_type -> registry.BrokerResponse
-	7,  // 37: registry.Registry.BrokerMappings:output_type -> registry.TopicResponse
-	0,  // 38: registry.Registry.TagTopic:output_type -> registry.TagResponse
-	0,  // 39: registry.Registry.DeleteTopicTags:output_type -> registry.TagResponse
-	0,  // 40: registry.Registry.TagBroker:output_type -> registry.TagResponse
-	0,  // 41: registry.Registry.DeleteBrokerTags:output_type -> registry.TagResponse
-	11, // 42: registry.Registry.TranslateOffsets:output_type -> registry.TranslateOffsetResponse
-	27, // [27:43] is the sub-list for method output_type
-	11, // [11:27] is the sub-list for method input_type
-	11, // [11:11] is the sub-list for extension type_name
-	11, // [11:11] is the sub-list for extension extendee
-	0,  // [0:11] is the sub-list for field type_name
+	16, // 0: registry.BrokerResponse.brokers:type_name -> registry.BrokerResponse.BrokersEntry
+	17, // 1: registry.Broker.tags:type_name -> registry.Broker.TagsEntry
+	18, // 2: registry.Broker.listenersecurityprotocolmap:type_name -> registry.Broker.ListenersecurityprotocolmapEntry
+	19, // 3: registry.ThrottleResponse.throttles:type_name -> registry.ThrottleResponse.ThrottlesEntry
+	11, // 4: registry.CreateTopicRequest.topic:type_name -> registry.Topic
+	20, // 5: registry.TopicResponse.topics:type_name -> registry.TopicResponse.TopicsEntry
+	21, // 6: registry.Topic.tags:type_name -> registry.Topic.TagsEntry
+	22, // 7: registry.Topic.configs:type_name -> registry.Topic.ConfigsEntry
+	23, // 8: registry.TranslateOffsetResponse.offsets:type_name -> registry.TranslateOffsetResponse.OffsetsEntry
+	4,  // 9: registry.BrokerResponse.BrokersEntry.value:type_name -> registry.Broker
+	5,  // 10: registry.ThrottleResponse.ThrottlesEntry.value:type_name -> registry.BrokerThrottle
+	11, // 11: registry.TopicResponse.TopicsEntry.value:type_name -> registry.Topic
+	12, // 12: registry.TranslateOffsetResponse.OffsetsEntry.value:type_name -> registry.OffsetMapping
+	1,  // 13: registry.Registry.GetBrokers:input_type -> registry.BrokerRequest
+	1,  // 14: registry.Registry.ListBrokers:input_type -> registry.BrokerRequest
+	3,  // 15: registry.Registry.UnmappedBrokers:input_type -> registry.UnmappedBrokersRequest
+	7,  // 16: registry.Registry.GetTopics:input_type -> registry.TopicRequest
+	7,  // 17: registry.Registry.ListTopics:input_type -> registry.TopicRequest
+	8,  // 18: registry.Registry.CreateTopic:input_type -> registry.CreateTopicRequest
+	7,  // 19: registry.Registry.DeleteTopic:input_type -> registry.TopicRequest
+	15, // 20: registry.Registry.ReassigningTopics:input_type -> registry.Empty
+	15, // 21: registry.Registry.UnderReplicatedTopics:input_type -> registry.Empty
+	7,  // 22: registry.Registry.TopicMappings:input_type -> registry.TopicRequest
+	1,  // 23: registry.Registry.BrokerMappings:input_type -> registry.BrokerRequest
+	7,  // 24: registry.Registry.TagTopic:input_type -> registry.TopicRequest
+	7,  // 25: registry.Registry.DeleteTopicTags:input_type -> registry.TopicRequest
+	1,  // 26: registry.Registry.TagBroker:input_type -> registry.BrokerRequest
+	1,  // 27: registry.Registry.DeleteBrokerTags:input_type -> registry.BrokerRequest
+	13, // 28: registry.Registry.TranslateOffsets:input_type -> registry.TranslateOffsetRequest
+	1,  // 29: registry.Registry.GetBrokerThrottles:input_type -> registry.BrokerRequest
+	9,  // 30: registry.Registry.ReassignPartitions:input_type -> registry.ReassignPartitionsRequest
+	2,  // 31: registry.Registry.GetBrokers:output_type -> registry.BrokerResponse
+	2,  // 32: registry.Registry.ListBrokers:output_type -> registry.BrokerResponse
+	2,  // 33: registry.Registry.UnmappedBrokers:output_type -> registry.BrokerResponse
+	10, // 34: registry.Registry.GetTopics:output_type -> registry.TopicResponse
+	10, // 35: registry.Registry.ListTopics:output_type -> registry.TopicResponse
+	15, // 36: registry.Registry.CreateTopic:output_type -> registry.Empty
+	15, // 37: registry.Registry.DeleteTopic:output_type -> registry.Empty
+	10, // 38: registry.Registry.ReassigningTopics:output_type -> registry.TopicResponse
+	10, // 39: registry.Registry.UnderReplicatedTopics:output_type -> registry.TopicResponse
+	2,  // 40: registry.Registry.TopicMappings:output_type -> registry.BrokerResponse
+	10, // 41: registry.Registry.BrokerMappings:output_type -> registry.TopicResponse
+	0,  // 42: registry.Registry.TagTopic:output_type -> registry.TagResponse
+	0,  // 43: registry.Registry.DeleteTopicTags:output_type -> registry.TagResponse
+	0,  // 44: registry.Registry.TagBroker:output_type -> registry.TagResponse
+	0,  // 45: registry.Registry.DeleteBrokerTags:output_type -> registry.TagResponse
+	14, // 46: registry.Registry.TranslateOffsets:output_type -> registry.TranslateOffsetResponse
+	6,  // 47: registry.Registry.GetBrokerThrottles:output_type -> registry.ThrottleResponse
+	10, // 48: registry.Registry.ReassignPartitions:output_type -> registry.TopicResponse
+	31, // [31:49] is the sub-list for method output_type
+	13, // [13:31] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
 }
 
 func init() { file_registry_registry_proto_init() }
@@ -1182,7 +1376,7 @@ func file_registry_registry_proto_init() {
 			}
 		}
 		file_registry_registry_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*TopicRequest); i {
+			switch v := v.(*BrokerThrottle); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1194,7 +1388,7 @@ func file_registry_registry_proto_init() {
 			}
 		}
 		file_registry_registry_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CreateTopicRequest); i {
+			switch v := v.(*ThrottleResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1206,7 +1400,7 @@ func file_registry_registry_proto_init() {
 			}
 		}
 		file_registry_registry_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*TopicResponse); i {
+			switch v := v.(*TopicRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1218,7 +1412,7 @@ func file_registry_registry_proto_init() {
 			}
 		}
 		file_registry_registry_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Topic); i {
+			switch v := v.(*CreateTopicRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1230,7 +1424,7 @@ func file_registry_registry_proto_init() {
 			}
 		}
 		file_registry_registry_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*OffsetMapping); i {
+			switch v := v.(*ReassignPartitionsRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1242,7 +1436,7 @@ func file_registry_registry_proto_init() {
 			}
 		}
 		file_registry_registry_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*TranslateOffsetRequest); i {
+			switch v := v.(*TopicResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1254,7 +1448,7 @@ func file_registry_registry_proto_init() {
 			}
 		}
 		file_registry_registry_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*TranslateOffsetResponse); i {
+			switch v := v.(*Topic); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1266,6 +1460,42 @@ func file_registry_registry_proto_init() {
 			}
 		}
 		file_registry_registry_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*OffsetMapping); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_registry_registry_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TranslateOffsetRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_registry_registry_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TranslateOffsetResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_registry_registry_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*Empty); i {
 			case 0:
 				return &v.state
@@ -1284,7 +1514,7 @@ func file_registry_registry_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_registry_registry_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   20,
+			NumMessages:   24,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
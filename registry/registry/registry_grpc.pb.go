@@ -107,6 +107,27 @@ type RegistryClient interface {
 	// in the TranslateOffsetRequest.remote_cluster_alias and
 	// TranslateOffsetRequest.group_id respectively.
 	TranslateOffsets(ctx context.Context, in *TranslateOffsetRequest, opts ...grpc.CallOption) (*TranslateOffsetResponse, error)
+	// GetBrokerThrottles returns a ThrottleResponse with the throttles field
+	// populated with the leader and follower replication throttle rates, in
+	// bytes/sec, configured for each broker. Brokers without any throttle
+	// configured are omitted. If the input BrokerRequest.id field is non-nil,
+	// only the broker matching the ID is considered. Otherwise all brokers are
+	// considered, optionally filtered by any provided BrokerRequest.tags
+	// parameters.
+	GetBrokerThrottles(ctx context.Context, in *BrokerRequest, opts ...grpc.CallOption) (*ThrottleResponse, error)
+	//
+	//ReassignPartitions takes a ReassignPartitionsRequest holding a partition map
+	//in the kafka-reassign-partitions JSON format and writes it to ZooKeeper as a
+	//new partition reassignment. The partition map is first validated against the
+	//current cluster state; all referenced topics, partitions and brokers must
+	//exist, each topic must have a consistent replication factor, and replica
+	//sets must not include duplicate or stub broker IDs. A TopicResponse is
+	//returned with the names field populated with the topics being reassigned.
+	//Example:
+	//$ curl -XPOST "localhost:8080/v1/topics/reassign" -d '{
+	//"partition_map": "{\"version\":1,\"partitions\":[{\"topic\":\"mytopic\",\"partition\":0,\"replicas\":[1001,1002]}]}"
+	//}'
+	ReassignPartitions(ctx context.Context, in *ReassignPartitionsRequest, opts ...grpc.CallOption) (*TopicResponse, error)
 }
 
 type registryClient struct {
@@ -261,6 +282,24 @@ func (c *registryClient) TranslateOffsets(ctx context.Context, in *TranslateOffs
 	return out, nil
 }
 
+func (c *registryClient) GetBrokerThrottles(ctx context.Context, in *BrokerRequest, opts ...grpc.CallOption) (*ThrottleResponse, error) {
+	out := new(ThrottleResponse)
+	err := c.cc.Invoke(ctx, "/registry.Registry/GetBrokerThrottles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) ReassignPartitions(ctx context.Context, in *ReassignPartitionsRequest, opts ...grpc.CallOption) (*TopicResponse, error) {
+	out := new(TopicResponse)
+	err := c.cc.Invoke(ctx, "/registry.Registry/ReassignPartitions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RegistryServer is the server API for Registry service.
 // All implementations must embed UnimplementedRegistryServer
 // for forward compatibility
@@ -355,6 +394,27 @@ type RegistryServer interface {
 	// in the TranslateOffsetRequest.remote_cluster_alias and
 	// TranslateOffsetRequest.group_id respectively.
 	TranslateOffsets(context.Context, *TranslateOffsetRequest) (*TranslateOffsetResponse, error)
+	// GetBrokerThrottles returns a ThrottleResponse with the throttles field
+	// populated with the leader and follower replication throttle rates, in
+	// bytes/sec, configured for each broker. Brokers without any throttle
+	// configured are omitted. If the input BrokerRequest.id field is non-nil,
+	// only the broker matching the ID is considered. Otherwise all brokers are
+	// considered, optionally filtered by any provided BrokerRequest.tags
+	// parameters.
+	GetBrokerThrottles(context.Context, *BrokerRequest) (*ThrottleResponse, error)
+	//
+	//ReassignPartitions takes a ReassignPartitionsRequest holding a partition map
+	//in the kafka-reassign-partitions JSON format and writes it to ZooKeeper as a
+	//new partition reassignment. The partition map is first validated against the
+	//current cluster state; all referenced topics, partitions and brokers must
+	//exist, each topic must have a consistent replication factor, and replica
+	//sets must not include duplicate or stub broker IDs. A TopicResponse is
+	//returned with the names field populated with the topics being reassigned.
+	//Example:
+	//$ curl -XPOST "localhost:8080/v1/topics/reassign" -d '{
+	//"partition_map": "{\"version\":1,\"partitions\":[{\"topic\":\"mytopic\",\"partition\":0,\"replicas\":[1001,1002]}]}"
+	//}'
+	ReassignPartitions(context.Context, *ReassignPartitionsRequest) (*TopicResponse, error)
 	mustEmbedUnimplementedRegistryServer()
 }
 
@@ -410,6 +470,12 @@ func (UnimplementedRegistryServer) DeleteBrokerTags(context.Context, *BrokerRequ
 func (UnimplementedRegistryServer) TranslateOffsets(context.Context, *TranslateOffsetRequest) (*TranslateOffsetResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method TranslateOffsets not implemented")
 }
+func (UnimplementedRegistryServer) GetBrokerThrottles(context.Context, *BrokerRequest) (*ThrottleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBrokerThrottles not implemented")
+}
+func (UnimplementedRegistryServer) ReassignPartitions(context.Context, *ReassignPartitionsRequest) (*TopicResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReassignPartitions not implemented")
+}
 func (UnimplementedRegistryServer) mustEmbedUnimplementedRegistryServer() {}
 
 // UnsafeRegistryServer may be embedded to opt out of forward compatibility for this service.
@@ -711,6 +777,42 @@ func _Registry_TranslateOffsets_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Registry_GetBrokerThrottles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BrokerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).GetBrokerThrottles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/registry.Registry/GetBrokerThrottles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).GetBrokerThrottles(ctx, req.(*BrokerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_ReassignPartitions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReassignPartitionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).ReassignPartitions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/registry.Registry/ReassignPartitions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).ReassignPartitions(ctx, req.(*ReassignPartitionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Registry_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "registry.Registry",
 	HandlerType: (*RegistryServer)(nil),
@@ -779,6 +881,14 @@ var _Registry_serviceDesc = grpc.ServiceDesc{
 			MethodName: "TranslateOffsets",
 			Handler:    _Registry_TranslateOffsets_Handler,
 		},
+		{
+			MethodName: "GetBrokerThrottles",
+			Handler:    _Registry_GetBrokerThrottles_Handler,
+		},
+		{
+			MethodName: "ReassignPartitions",
+			Handler:    _Registry_ReassignPartitions_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "registry/registry.proto",
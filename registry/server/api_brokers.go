@@ -19,6 +19,8 @@ var (
 	ErrBrokerNotExist = errors.New("broker does not exist")
 	// ErrBrokerIDEmpty error.
 	ErrBrokerIDEmpty = errors.New("broker Id field must be specified")
+	// ErrFetchingThrottles error.
+	ErrFetchingThrottles = errors.New("error fetching throttles")
 )
 
 // BrokerSet is a mapping of broker IDs to *pb.Broker.
@@ -237,6 +239,48 @@ func (s *Server) BrokerMappings(ctx context.Context, req *pb.BrokerRequest) (*pb
 	return &pb.TopicResponse{Names: names}, nil
 }
 
+// GetBrokerThrottles returns the leader and follower replication throttle
+// rates, in bytes/sec, configured for brokers. If the input *pb.BrokerRequest
+// Id field is non-zero, the specified broker is matched if it exists.
+// Otherwise, all brokers found in ZooKeeper are matched. Matched brokers are
+// then filtered by all tags specified, if specified, in the *pb.BrokerRequest
+// tag field. Matched brokers without any throttle configured are omitted.
+func (s *Server) GetBrokerThrottles(ctx context.Context, req *pb.BrokerRequest) (*pb.ThrottleResponse, error) {
+	ctx, cancel, err := s.ValidateRequest(ctx, req, readRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if cancel != nil {
+		defer cancel()
+	}
+
+	// Get brokers.
+	brokers, err := s.fetchBrokerSet(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get throttles.
+	rates, err := s.ZK.GetThrottleRates()
+	if err != nil {
+		return nil, ErrFetchingThrottles
+	}
+
+	resp := &pb.ThrottleResponse{Throttles: map[uint32]*pb.BrokerThrottle{}}
+
+	for id := range brokers {
+		if r, exists := rates[int(id)]; exists {
+			resp.Throttles[id] = &pb.BrokerThrottle{
+				LeaderRate:   uint64(r.Leader),
+				FollowerRate: uint64(r.Follower),
+			}
+		}
+	}
+
+	return resp, nil
+}
+
 // fetchBrokerSet fetches metadata for all brokers.
 func (s *Server) fetchBrokerSet(req *pb.BrokerRequest) (BrokerSet, error) {
 	// Get brokers from ZK.
@@ -285,3 +285,46 @@ func TestBrokerMappings(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestGetBrokerThrottles(t *testing.T) {
+	s := testServer()
+
+	tests := map[int]*pb.BrokerRequest{
+		0: {},
+		1: {Id: 1001},
+		2: {Id: 1003},
+	}
+
+	expected := map[int][]uint32{
+		0: {1001, 1002},
+		1: {1001},
+		2: {},
+	}
+
+	for i, req := range tests {
+		resp, err := s.GetBrokerThrottles(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Throttles == nil {
+			t.Errorf("Expected a non-nil ThrottleResponse.Throttles field")
+		}
+
+		if len(resp.Throttles) != len(expected[i]) {
+			t.Errorf("Expected %d throttles, got %d", len(expected[i]), len(resp.Throttles))
+		}
+
+		for _, id := range expected[i] {
+			r, exists := resp.Throttles[id]
+			if !exists {
+				t.Errorf("Expected throttle for broker %d", id)
+				continue
+			}
+
+			if r.LeaderRate != 100000000 || r.FollowerRate != 100000000 {
+				t.Errorf("Unexpected rates for broker %d: %v", id, r)
+			}
+		}
+	}
+}
@@ -25,6 +25,12 @@ var (
 	ErrTopicAlreadyExists = errors.New("topic already exists")
 	// ErrInsufficientBrokers error.
 	ErrInsufficientBrokers = errors.New("insufficient number of brokers")
+	// ErrPartitionMapEmpty error.
+	ErrPartitionMapEmpty = errors.New("partition_map field must be specified")
+	// ErrPartitionNotExist error.
+	ErrPartitionNotExist = errors.New("partition does not exist")
+	// ErrInconsistentReplication error.
+	ErrInconsistentReplication = errors.New("inconsistent replication factor")
 	// Misc.
 	allTopicsRegex = regexp.MustCompile(".*")
 )
@@ -218,6 +224,11 @@ func (s *Server) CreateTopic(ctx context.Context, req *pb.CreateTopicRequest) (*
 		// may need to be covered, however.
 		bMap.Update(targetBrokerIDs, brokerState)
 
+		// Reject requests that can't be placed on the target brokers.
+		if err := bMap.SupportsPlacement(int(req.Topic.Replication), 0); err != nil {
+			return empty, err
+		}
+
 		// Rebuild the stub map with the discovered target broker list.
 		rebuildParams := kafkazk.RebuildParams{
 			BM:       bMap,
@@ -291,6 +302,116 @@ func (s *Server) DeleteTopic(ctx context.Context, req *pb.TopicRequest) (*pb.Emp
 	return empty, s.kafkaadmin.DeleteTopic(ctx, req.Name)
 }
 
+// ReassignPartitions takes a partition map in the kafka-reassign-partitions
+// JSON format, validates it against the current cluster state and writes it to
+// ZooKeeper as a new partition reassignment. The names of all topics being
+// reassigned are returned in the *pb.TopicResponse Names field.
+func (s *Server) ReassignPartitions(ctx context.Context, req *pb.ReassignPartitionsRequest) (*pb.TopicResponse, error) {
+	ctx, cancel, err := s.ValidateRequest(ctx, req, writeRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if cancel != nil {
+		defer cancel()
+	}
+
+	if req.PartitionMap == "" {
+		return nil, ErrPartitionMapEmpty
+	}
+
+	pm, err := kafkazk.PartitionMapFromString(req.PartitionMap)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pm.Partitions) == 0 {
+		return nil, ErrPartitionMapEmpty
+	}
+
+	if err := s.validateReassignment(pm); err != nil {
+		return nil, err
+	}
+
+	if err := s.ZK.SetReassignment(pm); err != nil {
+		return nil, err
+	}
+
+	return &pb.TopicResponse{Names: pm.Topics()}, nil
+}
+
+// validateReassignment checks that the provided *kafkazk.PartitionMap can be
+// applied to the current cluster state; replica sets must be free of duplicate
+// and stub broker IDs, all referenced brokers, topics and partitions must
+// exist, and each topic must have a consistent replication factor.
+func (s *Server) validateReassignment(pm *kafkazk.PartitionMap) error {
+	if errs := pm.Validate(); len(errs) > 0 {
+		return errs[0]
+	}
+
+	// Check that all referenced brokers exist.
+	bmm, errs := s.ZK.GetAllBrokerMeta(false)
+	if errs != nil {
+		return ErrFetchingBrokers
+	}
+
+	var missing []int
+	seen := map[int]struct{}{}
+
+	for _, p := range pm.Partitions {
+		for _, id := range p.Replicas {
+			if _, exists := bmm[id]; exists {
+				continue
+			}
+			if _, dupe := seen[id]; !dupe {
+				seen[id] = struct{}{}
+				missing = append(missing, id)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Ints(missing)
+		return fmt.Errorf("%w: %v", ErrBrokerNotExist, missing)
+	}
+
+	// Check that all referenced topics and partitions exist and that the
+	// replication factor is consistent per topic.
+	current := map[string]map[int]struct{}{}
+	replication := map[string]int{}
+
+	for _, p := range pm.Partitions {
+		if _, exists := current[p.Topic]; !exists {
+			tpm, err := s.ZK.GetPartitionMap(p.Topic)
+			if err != nil {
+				switch err.(type) {
+				case kafkazk.ErrNoNode:
+					return fmt.Errorf("%w: %s", ErrTopicNotExist, p.Topic)
+				default:
+					return err
+				}
+			}
+
+			current[p.Topic] = map[int]struct{}{}
+			for _, cp := range tpm.Partitions {
+				current[p.Topic][cp.Partition] = struct{}{}
+			}
+		}
+
+		if _, exists := current[p.Topic][p.Partition]; !exists {
+			return fmt.Errorf("%w: %s p%d", ErrPartitionNotExist, p.Topic, p.Partition)
+		}
+
+		if rf, exists := replication[p.Topic]; exists && rf != len(p.Replicas) {
+			return fmt.Errorf("%w: %s", ErrInconsistentReplication, p.Topic)
+		}
+
+		replication[p.Topic] = len(p.Replicas)
+	}
+
+	return nil
+}
+
 // TopicMappings returns all broker IDs that hold at least one partition for
 // the requested topic. The topic is specified in the TopicRequest.Name
 // field.
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/DataDog/kafka-kit/v3/kafkazk"
@@ -155,12 +156,17 @@ func TestCustomTagTopicFilter(t *testing.T) {
 		0: {Tag: []string{"customtag:customvalue"}},
 		1: {Tag: []string{"customtag2:customvalue2"}},
 		2: {Tag: []string{"nomatches:forthistag"}},
+		// Multiple tags must all match.
+		3: {Tag: []string{"customtag:customvalue", "customtag2:customvalue2"}},
+		4: {Tag: []string{"customtag:customvalue", "nomatches:forthistag"}},
 	}
 
 	expected := map[int][]string{
 		0: {"test_topic", "test_topic2"},
 		1: {"test_topic2"},
 		2: {},
+		3: {"test_topic2"},
+		4: {},
 	}
 
 	for i, req := range tests {
@@ -272,6 +278,79 @@ func TestDeleteTopicTagsFailures(t *testing.T) {
 	}
 }
 
+func TestReassignPartitions(t *testing.T) {
+	s := testServer()
+
+	valid := `{"version":1,"partitions":[
+		{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+		{"topic":"test_topic","partition":1,"replicas":[1002,1003]}]}`
+
+	// The stub starts with a reassignment in progress.
+	req := &pb.ReassignPartitionsRequest{PartitionMap: valid}
+	if _, err := s.ReassignPartitions(context.Background(), req); err != kafkazk.ErrReassignmentInProgress {
+		t.Fatalf("Expected error '%v', got '%v'", kafkazk.ErrReassignmentInProgress, err)
+	}
+
+	if err := s.ZK.DeleteReassignment(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid maps.
+	tests := map[int]string{
+		0: ``,
+		1: `{"version":1,"partitions":[]}`,
+		2: `{"version":1,"partitions":[{"topic":"test_topic","partition":0,"replicas":[1001,1010]}]}`,
+		3: `{"version":1,"partitions":[{"topic":"test_topic","partition":0,"replicas":[1001,9223372036854775807]}]}`,
+		4: `{"version":1,"partitions":[{"topic":"test_topic","partition":10,"replicas":[1001,1002]}]}`,
+		5: `{"version":1,"partitions":[
+			{"topic":"test_topic","partition":0,"replicas":[1001,1002]},
+			{"topic":"test_topic","partition":1,"replicas":[1001,1002,1003]}]}`,
+	}
+
+	expected := map[int]error{
+		0: ErrPartitionMapEmpty,
+		1: ErrPartitionMapEmpty,
+		2: ErrBrokerNotExist,
+		3: kafkazk.ErrStubReplica,
+		4: ErrPartitionNotExist,
+		5: ErrInconsistentReplication,
+	}
+
+	for i := 0; i < len(tests); i++ {
+		req := &pb.ReassignPartitionsRequest{PartitionMap: tests[i]}
+		_, err := s.ReassignPartitions(context.Background(), req)
+		if !errors.Is(err, expected[i]) {
+			t.Errorf("[case %d] Expected error '%v', got '%v'", i, expected[i], err)
+		}
+	}
+
+	if r := s.ZK.GetReassignments(); len(r) != 0 {
+		t.Fatalf("Expected no reassignment, got %v", r)
+	}
+
+	// Valid map.
+	resp, err := s.ReassignPartitions(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stringsEqual(resp.Names, []string{"test_topic"}) {
+		t.Errorf("Expected topic list %s, got %s", []string{"test_topic"}, resp.Names)
+	}
+
+	data, err := s.ZK.Get("/admin/reassign_partitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pm, _ := kafkazk.PartitionMapFromString(string(data))
+	expectedPM, _ := kafkazk.PartitionMapFromString(valid)
+
+	if same, _ := pm.Equal(expectedPM); !same {
+		t.Errorf("Expected reassignment %v, got %v", expectedPM, pm)
+	}
+}
+
 func TestTopicMappings(t *testing.T) {
 	s := testServer()
 
@@ -28,6 +28,10 @@ const (
 	writeRequest
 )
 
+const (
+	defaultReqBurst = 10
+)
+
 // Server implements the registry APIs.
 type Server struct {
 	pb.UnimplementedRegistryServer
@@ -47,10 +51,14 @@ type Server struct {
 
 // Config holds Server configurations.
 type Config struct {
-	HTTPListen                 string
-	GRPCListen                 string
-	ReadReqRate                int
-	WriteReqRate               int
+	HTTPListen   string
+	GRPCListen   string
+	ReadReqRate  int
+	WriteReqRate int
+	// ReadReqBurst and WriteReqBurst set the request throttle burst
+	// capacities. If unset, defaultReqBurst is used.
+	ReadReqBurst               int
+	WriteReqBurst              int
 	ZKTagsPrefix               string
 	TagCleanupFrequencyMinutes int
 	TagAllowedStalenessMinutes int
@@ -63,17 +71,27 @@ func NewServer(c Config) (*Server, error) {
 	switch {
 	case c.ZKTagsPrefix == "",
 		c.ReadReqRate < 1,
-		c.WriteReqRate < 1:
+		c.WriteReqRate < 1,
+		c.ReadReqBurst < 0,
+		c.WriteReqBurst < 0:
 		return nil, errors.New("invalid configuration parameter(s)")
 	}
 
+	if c.ReadReqBurst == 0 {
+		c.ReadReqBurst = defaultReqBurst
+	}
+
+	if c.WriteReqBurst == 0 {
+		c.WriteReqBurst = defaultReqBurst
+	}
+
 	rrt, _ := NewRequestThrottle(RequestThrottleConfig{
-		Capacity: 10,
+		Capacity: c.ReadReqBurst,
 		Rate:     c.ReadReqRate,
 	})
 
 	wrt, _ := NewRequestThrottle(RequestThrottleConfig{
-		Capacity: 10,
+		Capacity: c.WriteReqBurst,
 		Rate:     c.WriteReqRate,
 	})
 
@@ -347,7 +365,9 @@ func (s *Server) ValidateRequest(ctx context.Context, req interface{}, kind int)
 	defer func() {
 		if err != nil {
 			log.Printf("[request %d] timed out", reqID)
-			cancel()
+			if cancel != nil {
+				cancel()
+			}
 		}
 	}()
 
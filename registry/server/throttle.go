@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
-	// ErrRequestThrottleTimeout error.
-	ErrRequestThrottleTimeout = errors.New("wait time exceeded")
+	// ErrRequestThrottleTimeout error. This is a gRPC status error with a
+	// ResourceExhausted code so that rate limited callers can distinguish
+	// it from other failures.
+	ErrRequestThrottleTimeout = status.Error(codes.ResourceExhausted, "wait time exceeded")
 )
 
 // RequestThrottle controls request rates with
@@ -4,6 +4,12 @@ import (
 	"context"
 	"testing"
 	"time"
+
+	"github.com/DataDog/kafka-kit/v3/kafkazk"
+	pb "github.com/DataDog/kafka-kit/v3/registry/registry"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestRequestThrottle(t *testing.T) {
@@ -39,3 +45,43 @@ func TestRequestThrottle(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteRequestThrottle(t *testing.T) {
+	s, _ := NewServer(Config{
+		ReadReqRate:   10,
+		WriteReqRate:  1,
+		WriteReqBurst: 2,
+		ZKTagsPrefix:  testConfig.Prefix,
+		test:          true,
+	})
+
+	s.ZK = kafkazk.NewZooKeeperStub()
+	s.Tags.Store = newzkTagStorageStub()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	req := &pb.TopicRequest{Name: "test_topic", Tag: []string{"k:v"}}
+
+	// The first two writes should be served from the burst capacity.
+	for i := 0; i < 2; i++ {
+		if _, err := s.TagTopic(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The bucket is drained; the next write should be rejected.
+	_, err := s.TagTopic(ctx, req)
+	if err != ErrRequestThrottleTimeout {
+		t.Fatalf("Expected error '%v', got '%v'", ErrRequestThrottleTimeout, err)
+	}
+
+	if code := status.Code(err); code != codes.ResourceExhausted {
+		t.Errorf("Expected code %s, got %s", codes.ResourceExhausted, code)
+	}
+
+	// Reads are throttled independently.
+	if _, err := s.ListTopics(context.Background(), &pb.TopicRequest{}); err != nil {
+		t.Error(err)
+	}
+}